@@ -3,3 +3,11 @@ package main
 import "errors"
 
 var invalidDBDriver error = errors.New("invalid db driver")
+
+var invalidDKIMFormat error = errors.New("invalid DKIM format")
+
+var invalidSMSProvider error = errors.New("invalid SMS provider")
+
+var invalidPhoneProvider error = errors.New("invalid phone provider")
+
+var missingHTTPListener error = errors.New("missing HTTP listener")
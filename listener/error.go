@@ -0,0 +1,5 @@
+package listener
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
@@ -0,0 +1,90 @@
+package listener
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+)
+
+const (
+	readTimeout  = time.Second * 10
+	writeTimeout = time.Second * 10
+	// provider callbacks are small form posts
+	maxHeaderBytes = 64 * 1024
+)
+
+type Config struct {
+	// host:port where firefly receives callbacks of notification providers
+	Address string
+
+	Logger *exlogger.Logger
+}
+
+func New(conf Config) (*Listener, error) {
+	if conf.Address == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.Address must not be empty")
+	}
+	if _, _, err := net.SplitHostPort(conf.Address); err != nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Address must be in host:port format")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+
+	mux := http.NewServeMux()
+	newListener := &Listener{
+		mux: mux,
+		server: &http.Server{
+			Addr:           conf.Address,
+			Handler:        mux,
+			ReadTimeout:    readTimeout,
+			WriteTimeout:   writeTimeout,
+			MaxHeaderBytes: maxHeaderBytes,
+		},
+		logger: conf.Logger,
+	}
+	return newListener, nil
+}
+
+// Listener is HTTP server receiving callbacks of notification providers, e.g. call status updates
+type Listener struct {
+	mux    *http.ServeMux
+	server *http.Server
+
+	logger *exlogger.Logger
+}
+
+// Handle registers handler for the path, all handlers must be registered before Start
+func (l *Listener) Handle(pattern string, handler http.Handler) {
+	l.mux.Handle(pattern, l.logRequest(handler))
+}
+
+// Start binds the address synchronously, so misconfiguration is found at startup, and serves in background
+func (l *Listener) Start() error {
+	ln, err := net.Listen("tcp", l.server.Addr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on %s", l.server.Addr)
+	}
+	l.logger.Log("HTTP listener started on %s", ln.Addr().String())
+	go func() {
+		err := l.server.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			l.logger.LogError(err, "HTTP listener on %s failed", l.server.Addr)
+		}
+	}()
+	return nil
+}
+
+func (l *Listener) Stop() error {
+	return l.server.Close()
+}
+
+func (l *Listener) logRequest(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.logger.LogDebug("HTTP listener received %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,9 +16,24 @@ import (
 	"github.com/exmonitor/exclient"
 	"github.com/exmonitor/exclient/database"
 	"github.com/exmonitor/exlogger"
+	"github.com/exmonitor/firefly/listener"
+	"github.com/exmonitor/firefly/notification/action"
+	"github.com/exmonitor/firefly/notification/bounce"
+	"github.com/exmonitor/firefly/notification/branding"
+	"github.com/exmonitor/firefly/notification/discord"
 	"github.com/exmonitor/firefly/notification/email"
+	"github.com/exmonitor/firefly/notification/matrix"
+	"github.com/exmonitor/firefly/notification/opsgenie"
+	"github.com/exmonitor/firefly/notification/pagerduty"
+	"github.com/exmonitor/firefly/notification/phone"
+	"github.com/exmonitor/firefly/notification/phonenumber"
+	"github.com/exmonitor/firefly/notification/slack"
+	"github.com/exmonitor/firefly/notification/sms"
+	"github.com/exmonitor/firefly/notification/teams"
+	"github.com/exmonitor/firefly/notification/telegram"
+	"github.com/exmonitor/firefly/notification/webhook"
 	"github.com/exmonitor/firefly/service"
-	"gopkg.in/gomail.v2"
+	"github.com/exmonitor/firefly/service/state"
 )
 
 var Flags struct {
@@ -39,17 +57,169 @@ var Flags struct {
 
 	// smtp
 	SMTPEnabled  bool
+	Transport    string
+	SendmailPath string
+	MaildirPath  string
+	EmlDir       string
 	EmailFrom    string
 	SMTPServer   string
 	SMTPPort     int
 	SMTPUser     string
 	SMTPPassword string
 
+	SMTPTLSMode        string
+	SMTPAuth           string
+	SMTPCAFile         string
+	SMTPTLSFingerprint string
+	SMTPTLSSkipVerify  bool
+	SMTPClientCert     string
+	SMTPClientKey      string
+	SMTPFailover       []string
+	SMTPDeadLetterDir  string
+	SMTPWorkers        int
+	SMTPQueueSize      int
+	DKIM               []string
+	EmailHistoryChecks int
+	EmailHistoryWindow time.Duration
+	EmailSubject       string
+	EmailSubjectFile   string
+	BrandingFile       string
+
+	// sms
+	SMSProvider         string
+	SMSMaxParts         int
+	SMSRoutes           map[string]string
+	SMPPServer          string
+	SMPPTLS             bool
+	SMPPSystemID        string
+	SMPPPassword        string
+	SMPPSystemType      string
+	SMPPSourceAddr      string
+	SMPPSourceTON       int
+	SMPPSourceNPI       int
+	SMPPEnquireLink     time.Duration
+	SMPPRequestTimeout  time.Duration
+	SMPPDeliveryReceipt bool
+	TwilioAPIURL        string
+	TwilioAccountSID    string
+	TwilioAuthToken     string
+	TwilioSMSFrom       string
+	TwilioMessagingSID  string
+	SMSHTTPURL          string
+	SMSHTTPMethod       string
+	SMSHTTPBodyFormat   string
+	SMSHTTPBody         string
+	SMSHTTPHeaders      map[string]string
+	SMSHTTPUsername     string
+	SMSHTTPPassword     string
+	SMSHTTPFrom         string
+	SMSHTTPMessageID    string
+	SMSHTTPErrorCode    string
+	SMSHTTPErrorMessage string
+	SMSHTTPSuccessCodes []string
+	SMSHTTPRetryable    []string
+	SMSHTTPTimeout      time.Duration
+	SMSHTTPRetries      int
+
+	// phone
+	PhoneProvider       string
+	TwilioCallFrom      string
+	PhoneRingTimeout    time.Duration
+	PhoneRetries        int
+	PhoneRetryInterval  time.Duration
+	PhoneVoice          string
+	PhoneLanguage       string
+	PhoneRepeat         int
+	PhoneSilence        time.Duration
+	PhoneRequestTimeout time.Duration
+	PhoneDefaultCountry string
+	PhoneCountryFile    string
+
+	// webhook
+	WebhookTimeout    time.Duration
+	WebhookRetries    int
+	WebhookSecret     string
+	WebhookBody       string
+	WebhookHeaders    map[string]string
+	WebhookClientCert string
+	WebhookClientKey  string
+	WebhookCAFile     string
+	WebhookFile       string
+
+	// slack
+	SlackAPIURL  string
+	SlackToken   string
+	SlackStore   string
+	SlackTimeout time.Duration
+	SlackRetries int
+
+	// teams
+	TeamsTimeout time.Duration
+	TeamsRetries int
+
+	// telegram
+	TelegramAPIURL      string
+	TelegramToken       string
+	TelegramTimeout     time.Duration
+	TelegramRetries     int
+	TelegramButtons     bool
+	TelegramPollTimeout time.Duration
+
+	// pagerduty
+	PagerDutyURL      string
+	PagerDutySeverity string
+	PagerDutyTimeout  time.Duration
+	PagerDutyRetries  int
+
+	// opsgenie
+	OpsgenieAPIURL       string
+	OpsgeniePriority     string
+	OpsgenieTags         []string
+	OpsgenieTimeout      time.Duration
+	OpsgenieRetries      int
+	OpsgeniePollInterval time.Duration
+
+	// discord
+	DiscordTimeout time.Duration
+	DiscordRetries int
+
+	// matrix
+	MatrixHomeserver  string
+	MatrixAccessToken string
+	MatrixTimeout     time.Duration
+	MatrixRetries     int
+
+	// http listener
+	HTTPListenAddress string
+	HTTPPublicURL     string
+	ActionSecret      string
+	ActionSilence     time.Duration
+
+	// bounces
+	BounceSource       string
+	BounceMaildir      string
+	BounceIMAPServer   string
+	BounceIMAPUser     string
+	BounceIMAPPassword string
+	BounceIMAPMailbox  string
+	BounceIMAPTLS      bool
+	BounceStore        string
+	BounceThreshold    int
+	BouncePollInterval time.Duration
+
 	// other
 	TimeProfiling bool
 	Debug         bool
 }
 
+const (
+	smsProviderSMPP   = "smpp"
+	smsProviderTwilio = "twilio"
+	smsProviderHTTP   = "http"
+
+	phoneProviderTwilio = "twilio"
+)
+
 var flags = Flags
 var rootCmd = &cobra.Command{
 	Use:   "firefly",
@@ -76,12 +246,155 @@ func main() {
 	rootCmd.PersistentFlags().StringVarP(&flags.MariaPassword, "maria-password", "", "", "Set Maria database password that will be used for connection.")
 
 	// smtp
-	rootCmd.PersistentFlags().BoolVarP(&flags.SMTPEnabled, "smtp", "", true, "Enable or disable using the real SMTP server. If false, sending email is mocked.")
+	rootCmd.PersistentFlags().BoolVarP(&flags.SMTPEnabled, "smtp", "", true, "Enable or disable email delivery via configured transport. If false, sending email is mocked.")
+	rootCmd.PersistentFlags().StringVarP(&flags.Transport, "email-transport", "", "smtp", "Set email transport. Allowed values: smtp, sendmail, maildir, file.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SendmailPath, "sendmail-path", "", "/usr/sbin/sendmail", "Set path to sendmail compatible binary. Used only with sendmail transport.")
+	rootCmd.PersistentFlags().StringVarP(&flags.MaildirPath, "maildir-path", "", "./maildir", "Set maildir where emails are delivered. Used only with maildir transport.")
+	rootCmd.PersistentFlags().StringVarP(&flags.EmlDir, "eml-dir", "", "./eml", "Set directory where emails are written as .eml files. Used only with file transport.")
 	rootCmd.PersistentFlags().StringVarP(&flags.EmailFrom, "smtp-email-from", "", "alert@alertea.com", "Default email that will be used in 'From'.")
 	rootCmd.PersistentFlags().StringVarP(&flags.SMTPServer, "smtp-server", "", "127.0.0.1", "Hostname for SMTP server.")
-	rootCmd.PersistentFlags().IntVarP(&flags.SMTPPort, "smtp-port", "", 465, "Port for SMTP server. Only TLS ports are allowed.")
+	rootCmd.PersistentFlags().IntVarP(&flags.SMTPPort, "smtp-port", "", 465, "Port for SMTP server. Usually 465 for tls, 587 for starttls and 25 for none.")
 	rootCmd.PersistentFlags().StringVarP(&flags.SMTPUser, "smtp-user", "", "alert@alertea.com", "Username for SMTP server.")
 	rootCmd.PersistentFlags().StringVarP(&flags.SMTPPassword, "smtp-password", "", "", "Password for SMTP server.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMTPTLSMode, "smtp-tls-mode", "", "tls", "Set SMTP connection security. Allowed values: tls (implicit TLS), starttls, none (only for local relays).")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMTPAuth, "smtp-auth", "", "plain", "Set SMTP auth mechanism. Allowed values: plain, login, cram-md5, none.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMTPCAFile, "smtp-ca-file", "", "", "Set PEM CA bundle used for SMTP server certificate verification. System CA pool is used when empty.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMTPTLSFingerprint, "smtp-tls-fingerprint", "", "", "Set pinned sha256 fingerprint of SMTP server certificate. Replaces CA verification, useful for self signed certificates.")
+	rootCmd.PersistentFlags().BoolVarP(&flags.SMTPTLSSkipVerify, "smtp-tls-skip-verify", "", false, "Disable SMTP server certificate verification. Insecure, use only for testing.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMTPClientCert, "smtp-client-cert", "", "", "Set PEM client certificate for SMTP server.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMTPClientKey, "smtp-client-key", "", "", "Set PEM client key for SMTP server.")
+	rootCmd.PersistentFlags().StringSliceVarP(&flags.SMTPFailover, "smtp-failover-servers", "", []string{}, "Set ordered list of failover SMTP servers in host:port format. Credentials and TLS settings are shared with the main SMTP server.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMTPDeadLetterDir, "smtp-dead-letter-dir", "", "./email-dead-letter", "Set directory where undeliverable emails are stored.")
+	rootCmd.PersistentFlags().IntVarP(&flags.SMTPWorkers, "smtp-workers", "", 4, "Set amount of concurrent SMTP workers. Each worker keeps its own connection to SMTP server.")
+	rootCmd.PersistentFlags().IntVarP(&flags.SMTPQueueSize, "smtp-queue-size", "", 1000, "Set maximum amount of emails waiting for delivery.")
+	rootCmd.PersistentFlags().StringArrayVarP(&flags.DKIM, "dkim", "", []string{}, "Enable DKIM signing for 'From' address or domain. Format is from,domain,selector,keyfile. Can be used multiple times.")
+	rootCmd.PersistentFlags().IntVarP(&flags.EmailHistoryChecks, "email-history-checks", "", 10, "Set amount of recent check results shown in alert emails. 0 disables check history in emails.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.EmailHistoryWindow, "email-history-window", "", time.Hour, "Set time window of response time chart in alert emails. Longer windows are drawn from aggregated statuses.")
+	rootCmd.PersistentFlags().StringVarP(&flags.EmailSubject, "email-subject-template", "", email.DefaultSubjectTemplate, "Set Go template of email subject. Available fields: .Status, .Host, .Target, .ServiceType, .Port, .IncidentDuration, .Resend, .Reminder.")
+	rootCmd.PersistentFlags().StringVarP(&flags.EmailSubjectFile, "email-subject-template-file", "", "", "Set JSON file with subject templates for specific contacts, keyed by notification ID or email address.")
+	rootCmd.PersistentFlags().StringVarP(&flags.BrandingFile, "branding-file", "", "", "Set JSON file with branding profiles of partners and their assignment to services and contacts. Default AlerTea branding is used when empty.")
+
+	// sms
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSProvider, "sms-provider", "", "", "Set SMS provider. Allowed values: smpp, twilio, http. If empty, sending SMS is mocked.")
+	rootCmd.PersistentFlags().IntVarP(&flags.SMSMaxParts, "sms-max-parts", "", 1, "Set maximum amount of concatenated SMS parts of single notification. Longer texts are shortened.")
+	rootCmd.PersistentFlags().StringToStringVarP(&flags.SMSRoutes, "sms-route", "", nil, "Set SMS provider for numbers of the country in COUNTRY=provider format, e.g. CZ=smpp. Other countries use sms-provider.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMPPServer, "smpp-server", "", "", "Set SMSC address in host:port format.")
+	rootCmd.PersistentFlags().BoolVarP(&flags.SMPPTLS, "smpp-tls", "", false, "Enable or disable TLS for SMPP connection.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMPPSystemID, "smpp-system-id", "", "", "Set SMPP system_id used for bind.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMPPPassword, "smpp-password", "", "", "Set SMPP password used for bind.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMPPSystemType, "smpp-system-type", "", "", "Set SMPP system_type used for bind.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMPPSourceAddr, "smpp-source-addr", "", "AlerTea", "Set SMS sender shown to the recipient.")
+	rootCmd.PersistentFlags().IntVarP(&flags.SMPPSourceTON, "smpp-source-ton", "", 5, "Set type of number of the SMS sender. 5 is alphanumeric, 1 is international.")
+	rootCmd.PersistentFlags().IntVarP(&flags.SMPPSourceNPI, "smpp-source-npi", "", 0, "Set numbering plan indicator of the SMS sender. 0 is unknown, 1 is ISDN.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.SMPPEnquireLink, "smpp-enquire-link-interval", "", time.Second*30, "Set how often is SMPP session checked with enquire_link.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.SMPPRequestTimeout, "smpp-request-timeout", "", time.Second*10, "Set how long to wait for SMSC response.")
+	rootCmd.PersistentFlags().BoolVarP(&flags.SMPPDeliveryReceipt, "smpp-delivery-receipts", "", true, "Enable or disable SMS delivery receipts.")
+	rootCmd.PersistentFlags().StringVarP(&flags.TwilioAPIURL, "twilio-api-url", "", sms.DefaultTwilioAPIURL, "Set base URL of Twilio compatible API.")
+	rootCmd.PersistentFlags().StringVarP(&flags.TwilioAccountSID, "twilio-account-sid", "", "", "Set Twilio account SID.")
+	rootCmd.PersistentFlags().StringVarP(&flags.TwilioAuthToken, "twilio-auth-token", "", "", "Set Twilio auth token.")
+	rootCmd.PersistentFlags().StringVarP(&flags.TwilioSMSFrom, "twilio-sms-from", "", "", "Set sender number or alphanumeric sender of SMS sent via Twilio.")
+	rootCmd.PersistentFlags().StringVarP(&flags.TwilioMessagingSID, "twilio-messaging-service-sid", "", "", "Set Twilio messaging service used instead of the sender.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSHTTPURL, "sms-http-url", "", "", "Set URL template of SMS gateway. Fields .Number, .Message and .From are available.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSHTTPMethod, "sms-http-method", "", "POST", "Set HTTP method used for SMS gateway requests.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSHTTPBodyFormat, "sms-http-body-format", "", sms.BodyFormatForm, "Set body format of SMS gateway requests. Allowed values: form, json.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSHTTPBody, "sms-http-body", "", "", "Set body template of SMS gateway requests. Fields are escaped according to the body format.")
+	rootCmd.PersistentFlags().StringToStringVarP(&flags.SMSHTTPHeaders, "sms-http-header", "", nil, "Set header templates of SMS gateway requests in Name=value format.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSHTTPUsername, "sms-http-username", "", "", "Set basic auth username for SMS gateway.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSHTTPPassword, "sms-http-password", "", "", "Set basic auth password for SMS gateway.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSHTTPFrom, "sms-http-from", "", "AlerTea", "Set SMS sender available in SMS gateway templates.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSHTTPMessageID, "sms-http-message-id-field", "", "", "Set dot separated path to message ID in JSON response of SMS gateway.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSHTTPErrorCode, "sms-http-error-code-field", "", "", "Set dot separated path to error code in JSON response of SMS gateway.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SMSHTTPErrorMessage, "sms-http-error-message-field", "", "", "Set dot separated path to error message in JSON response of SMS gateway.")
+	rootCmd.PersistentFlags().StringSliceVarP(&flags.SMSHTTPSuccessCodes, "sms-http-success-codes", "", []string{"0"}, "Set error codes of SMS gateway which mean the SMS was accepted.")
+	rootCmd.PersistentFlags().StringSliceVarP(&flags.SMSHTTPRetryable, "sms-http-retryable-codes", "", nil, "Set error codes of SMS gateway which are retried. HTTP 408, 429 and 5xx are always retried.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.SMSHTTPTimeout, "sms-http-timeout", "", time.Second*10, "Set timeout of single request to HTTP SMS provider. Used for twilio and http providers.")
+	rootCmd.PersistentFlags().IntVarP(&flags.SMSHTTPRetries, "sms-http-retries", "", 3, "Set how many times is temporary failure of HTTP SMS provider retried. Used for twilio and http providers.")
+
+	// phone
+	rootCmd.PersistentFlags().StringVarP(&flags.PhoneProvider, "phone-provider", "", "", "Set voice call provider. Allowed values: twilio. If empty, phone calls are mocked.")
+	rootCmd.PersistentFlags().StringVarP(&flags.TwilioCallFrom, "twilio-call-from", "", "", "Set caller number of phone calls placed via Twilio.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.PhoneRingTimeout, "phone-ring-timeout", "", time.Second*30, "Set how long the phone rings before the call counts as not answered.")
+	rootCmd.PersistentFlags().IntVarP(&flags.PhoneRetries, "phone-retries", "", 2, "Set how many times is busy or not answered call placed again. Retries need status callbacks on the HTTP listener.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.PhoneRetryInterval, "phone-retry-interval", "", time.Minute, "Set how long to wait before not answered call is placed again.")
+	rootCmd.PersistentFlags().StringVarP(&flags.PhoneVoice, "phone-voice", "", "", "Set text to speech voice of phone calls. Provider default is used when empty.")
+	rootCmd.PersistentFlags().StringVarP(&flags.PhoneLanguage, "phone-language", "", "en-US", "Set text to speech language of phone calls.")
+	rootCmd.PersistentFlags().IntVarP(&flags.PhoneRepeat, "phone-repeat", "", 2, "Set how many times is the message read during the phone call.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.PhoneSilence, "phone-silence-duration", "", time.Hour, "Set how long are alerts silenced when callee presses 2 during phone call.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.PhoneRequestTimeout, "phone-request-timeout", "", time.Second*10, "Set timeout of single request to voice call provider.")
+	rootCmd.PersistentFlags().StringVarP(&flags.PhoneDefaultCountry, "phone-default-country", "", "", "Set ISO country code used for SMS and phone numbers without international prefix, e.g. CZ. If empty, only numbers in international format are accepted.")
+	rootCmd.PersistentFlags().StringVarP(&flags.PhoneCountryFile, "phone-country-file", "", "", "Set JSON file with default countries of services and contacts. Overrides phone-default-country.")
+
+	// webhook
+	rootCmd.PersistentFlags().DurationVarP(&flags.WebhookTimeout, "webhook-timeout", "", time.Second*10, "Set timeout of single webhook request.")
+	rootCmd.PersistentFlags().IntVarP(&flags.WebhookRetries, "webhook-retries", "", 3, "Set how many times is temporary webhook failure retried. HTTP 408, 429, 5xx and network errors are retried.")
+	rootCmd.PersistentFlags().StringVarP(&flags.WebhookSecret, "webhook-secret", "", "", "Set key of HMAC-SHA256 signature of webhook requests. Requests are not signed when empty.")
+	rootCmd.PersistentFlags().StringVarP(&flags.WebhookBody, "webhook-body", "", "", "Set body template of webhook requests, the event is available as template data. JSON event is sent when empty.")
+	rootCmd.PersistentFlags().StringToStringVarP(&flags.WebhookHeaders, "webhook-header", "", nil, "Set headers of webhook requests in Name=value format.")
+	rootCmd.PersistentFlags().StringVarP(&flags.WebhookClientCert, "webhook-client-cert", "", "", "Set client certificate file used for mTLS with webhook receivers.")
+	rootCmd.PersistentFlags().StringVarP(&flags.WebhookClientKey, "webhook-client-key", "", "", "Set client key file used for mTLS with webhook receivers.")
+	rootCmd.PersistentFlags().StringVarP(&flags.WebhookCAFile, "webhook-ca-file", "", "", "Set CA file used to verify webhook receivers. System CA pool is used when empty.")
+	rootCmd.PersistentFlags().StringVarP(&flags.WebhookFile, "webhook-file", "", "", "Set JSON file with secrets, headers and body templates of single webhook contacts.")
+
+	// slack
+	rootCmd.PersistentFlags().StringVarP(&flags.SlackAPIURL, "slack-api-url", "", slack.DefaultAPIURL, "Set base URL of Slack Web API.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SlackToken, "slack-token", "", "", "Set Slack bot token used to post to channels. Contacts with incoming webhook URL do not need it.")
+	rootCmd.PersistentFlags().StringVarP(&flags.SlackStore, "slack-store", "", "", "Set JSON file where posted Slack messages are kept, so they are updated on recovery also after restart. Kept only in memory when empty.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.SlackTimeout, "slack-timeout", "", time.Second*10, "Set timeout of single Slack request.")
+	rootCmd.PersistentFlags().IntVarP(&flags.SlackRetries, "slack-retries", "", 3, "Set how many times is temporary Slack failure retried.")
+
+	// teams
+	rootCmd.PersistentFlags().DurationVarP(&flags.TeamsTimeout, "teams-timeout", "", time.Second*10, "Set timeout of single Teams request.")
+	rootCmd.PersistentFlags().IntVarP(&flags.TeamsRetries, "teams-retries", "", 3, "Set how many times is temporary Teams failure retried.")
+
+	// telegram
+	rootCmd.PersistentFlags().StringVarP(&flags.TelegramAPIURL, "telegram-api-url", "", telegram.DefaultAPIURL, "Set base URL of Telegram Bot API.")
+	rootCmd.PersistentFlags().StringVarP(&flags.TelegramToken, "telegram-token", "", "", "Set Telegram bot token. Telegram contacts are disabled when empty.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.TelegramTimeout, "telegram-timeout", "", time.Second*10, "Set timeout of single Telegram request.")
+	rootCmd.PersistentFlags().IntVarP(&flags.TelegramRetries, "telegram-retries", "", 3, "Set how many times is temporary Telegram failure retried.")
+	rootCmd.PersistentFlags().BoolVarP(&flags.TelegramButtons, "telegram-buttons", "", true, "Add acknowledge and silence buttons to Telegram alerts and poll their presses. Disable when the bot token is used with webhook elsewhere.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.TelegramPollTimeout, "telegram-poll-timeout", "", time.Second*30, "Set how long is Telegram getUpdates request held open when no button is pressed.")
+
+	// pagerduty
+	rootCmd.PersistentFlags().StringVarP(&flags.PagerDutyURL, "pagerduty-url", "", pagerduty.DefaultURL, "Set PagerDuty Events API v2 enqueue URL.")
+	rootCmd.PersistentFlags().StringVarP(&flags.PagerDutySeverity, "pagerduty-severity", "", "critical", "Set severity of triggered PagerDuty alerts. Allowed values: critical, error, warning, info.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.PagerDutyTimeout, "pagerduty-timeout", "", time.Second*10, "Set timeout of single PagerDuty request.")
+	rootCmd.PersistentFlags().IntVarP(&flags.PagerDutyRetries, "pagerduty-retries", "", 3, "Set how many times is temporary PagerDuty failure retried.")
+
+	// opsgenie
+	rootCmd.PersistentFlags().StringVarP(&flags.OpsgenieAPIURL, "opsgenie-api-url", "", opsgenie.DefaultAPIURL, "Set base URL of Opsgenie Alert API. EU accounts use https://api.eu.opsgenie.com.")
+	rootCmd.PersistentFlags().StringVarP(&flags.OpsgeniePriority, "opsgenie-priority", "", "P1", "Set priority of created Opsgenie alerts. Allowed values: P1, P2, P3, P4, P5.")
+	rootCmd.PersistentFlags().StringSliceVarP(&flags.OpsgenieTags, "opsgenie-tag", "", nil, "Add tag to every Opsgenie alert. Can be repeated.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.OpsgenieTimeout, "opsgenie-timeout", "", time.Second*10, "Set timeout of single Opsgenie request.")
+	rootCmd.PersistentFlags().IntVarP(&flags.OpsgenieRetries, "opsgenie-retries", "", 3, "Set how many times is temporary Opsgenie failure retried.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.OpsgeniePollInterval, "opsgenie-poll-interval", "", time.Minute, "Set how often are open Opsgenie alerts checked for acknowledgement, which stops resending of the incident. Disabled when 0.")
+
+	// discord
+	rootCmd.PersistentFlags().DurationVarP(&flags.DiscordTimeout, "discord-timeout", "", time.Second*10, "Set timeout of single Discord request.")
+	rootCmd.PersistentFlags().IntVarP(&flags.DiscordRetries, "discord-retries", "", 3, "Set how many times is temporary Discord failure retried.")
+
+	// matrix
+	rootCmd.PersistentFlags().StringVarP(&flags.MatrixHomeserver, "matrix-homeserver", "", "", "Set base URL of Matrix homeserver, e.g. https://matrix.example.org.")
+	rootCmd.PersistentFlags().StringVarP(&flags.MatrixAccessToken, "matrix-access-token", "", "", "Set access token of Matrix user sending alerts, the user must be joined to the rooms. Matrix contacts are disabled when empty.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.MatrixTimeout, "matrix-timeout", "", time.Second*10, "Set timeout of single Matrix request.")
+	rootCmd.PersistentFlags().IntVarP(&flags.MatrixRetries, "matrix-retries", "", 3, "Set how many times is temporary Matrix failure retried.")
+
+	// http listener
+	rootCmd.PersistentFlags().StringVarP(&flags.HTTPListenAddress, "http-listen-address", "", "", "Set host:port of HTTP listener receiving callbacks of notification providers and serving email health on /health/email. Disabled when empty.")
+	rootCmd.PersistentFlags().StringVarP(&flags.HTTPPublicURL, "http-public-url", "", "", "Set public URL under which notification providers reach the HTTP listener, e.g. https://firefly.example.com.")
+	rootCmd.PersistentFlags().StringVarP(&flags.ActionSecret, "action-secret", "", "", "Set key used to sign acknowledge and silence links in chat notifications. Links are disabled when empty.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.ActionSilence, "action-silence-duration", "", time.Hour, "Set how long are FAIL notifications suppressed after silence link or button is used.")
+
+	// bounces
+	rootCmd.PersistentFlags().StringVarP(&flags.BounceSource, "bounce-source", "", "", "Enable bounce processing and set where bounces are read from. Allowed values: maildir, imap. Disabled when empty.")
+	rootCmd.PersistentFlags().StringVarP(&flags.BounceMaildir, "bounce-maildir", "", "./bounces", "Set maildir with bounces. Used only with maildir bounce source.")
+	rootCmd.PersistentFlags().StringVarP(&flags.BounceIMAPServer, "bounce-imap-server", "", "", "Set IMAP server with bounces in host:port format. Used only with imap bounce source.")
+	rootCmd.PersistentFlags().StringVarP(&flags.BounceIMAPUser, "bounce-imap-user", "", "", "Username for IMAP server with bounces.")
+	rootCmd.PersistentFlags().StringVarP(&flags.BounceIMAPPassword, "bounce-imap-password", "", "", "Password for IMAP server with bounces.")
+	rootCmd.PersistentFlags().StringVarP(&flags.BounceIMAPMailbox, "bounce-imap-mailbox", "", "INBOX", "Set IMAP mailbox with bounces.")
+	rootCmd.PersistentFlags().BoolVarP(&flags.BounceIMAPTLS, "bounce-imap-tls", "", true, "Enable or disable implicit TLS for IMAP server with bounces.")
+	rootCmd.PersistentFlags().StringVarP(&flags.BounceStore, "bounce-store", "", "./bounces.json", "Set file where bounce statistics of notification contacts are stored.")
+	rootCmd.PersistentFlags().IntVarP(&flags.BounceThreshold, "bounce-threshold", "", 3, "Set amount of hard bounces and complaints after which the account owner is warned about the contact.")
+	rootCmd.PersistentFlags().DurationVarP(&flags.BouncePollInterval, "bounce-poll-interval", "", time.Minute*5, "Set how often are bounces read.")
 
 	// cache
 	rootCmd.PersistentFlags().BoolVarP(&flags.CacheEnabled, "cache", "", false, "Enable or disable caching of db records")
@@ -101,6 +414,25 @@ func main() {
 }
 
 func validateFlags() {
+	for _, server := range flags.SMTPFailover {
+		if _, _, err := parseHostPort(server); err != nil {
+			fmt.Printf("Invalid SMTP failover server %s, must be in host:port format.\n", server)
+			panic(err)
+		}
+	}
+	for _, dkim := range flags.DKIM {
+		if _, err := parseDKIM(dkim); err != nil {
+			fmt.Printf("Invalid DKIM configuration %s, must be in from,domain,selector,keyfile format.\n", dkim)
+			panic(err)
+		}
+	}
+	if len(flags.SMSRoutes) > 0 && flags.SMSProvider == "" {
+		fmt.Printf("SMS routes require sms-provider which is used for other countries.\n")
+		panic(invalidSMSProvider)
+	}
+	if flags.SMTPTLSSkipVerify {
+		fmt.Printf("WARNING: SMTP server certificate verification is disabled.\n")
+	}
 	if flags.TimeProfiling && !flags.Debug {
 		fmt.Printf("WARNING: time profiling is shown via debug log, if you dont enabled debug log you wont see time profiling output.\n")
 	}
@@ -160,20 +492,312 @@ func mainExecute(cmd *cobra.Command, args []string) {
 	// also make sure to close log files before exiting
 	catchOSSignals(logger, dbClient)
 
-	var emailChan chan *gomail.Message
+	brandingProfiles, err := branding.Load(flags.BrandingFile)
+	if err != nil {
+		fmt.Printf("Failed to load branding profiles.\n")
+		panic(err)
+	}
+
+	var smsSender sms.Sender
+	if flags.SMSProvider != "" {
+		// every provider is created once, routes may share it with the default provider
+		smsProviders := map[string]sms.Sender{}
+		provider := func(name string) sms.Sender {
+			if sender, ok := smsProviders[name]; ok {
+				return sender
+			}
+			sender := newSMSSender(name, logger)
+			smsProviders[name] = sender
+			return sender
+		}
+		smsSender = provider(flags.SMSProvider)
+		if len(flags.SMSRoutes) > 0 {
+			routerConfig := sms.RouterConfig{
+				Default: smsSender,
+				Routes:  map[string]sms.Sender{},
+				Logger:  logger,
+			}
+			for country, name := range flags.SMSRoutes {
+				routerConfig.Routes[country] = provider(name)
+			}
+			smsSender, err = sms.NewRouter(routerConfig)
+			if err != nil {
+				fmt.Printf("Failed to create SMS router.\n")
+				panic(err)
+			}
+		}
+	}
+
+	phoneCountries, err := phonenumber.LoadDefaultCountries(flags.PhoneCountryFile, flags.PhoneDefaultCountry)
+	if err != nil {
+		fmt.Printf("Failed to load default countries of phone numbers.\n")
+		panic(err)
+	}
+
+	webhookEndpoints, err := webhook.LoadEndpoints(flags.WebhookFile)
+	if err != nil {
+		fmt.Printf("Failed to load webhook endpoints.\n")
+		panic(err)
+	}
+	webhookConfig := webhook.Config{
+		Timeout:        flags.WebhookTimeout,
+		Retries:        flags.WebhookRetries,
+		Secret:         flags.WebhookSecret,
+		Body:           flags.WebhookBody,
+		Headers:        flags.WebhookHeaders,
+		ClientCertFile: flags.WebhookClientCert,
+		ClientKeyFile:  flags.WebhookClientKey,
+		CAFile:         flags.WebhookCAFile,
+		Endpoints:      webhookEndpoints,
+		Logger:         logger,
+	}
+	webhookSender, err := webhook.New(webhookConfig)
+	if err != nil {
+		fmt.Printf("Failed to create webhook sender.\n")
+		panic(err)
+	}
+
+	slackConfig := slack.Config{
+		APIURL:    flags.SlackAPIURL,
+		Token:     flags.SlackToken,
+		StorePath: flags.SlackStore,
+		Timeout:   flags.SlackTimeout,
+		Retries:   flags.SlackRetries,
+		Logger:    logger,
+	}
+	slackClient, err := slack.New(slackConfig)
+	if err != nil {
+		fmt.Printf("Failed to create Slack client.\n")
+		panic(err)
+	}
+
+	var httpListener *listener.Listener
+	if flags.HTTPListenAddress != "" {
+		listenerConfig := listener.Config{
+			Address: flags.HTTPListenAddress,
+			Logger:  logger,
+		}
+		httpListener, err = listener.New(listenerConfig)
+		if err != nil {
+			fmt.Printf("Failed to create HTTP listener.\n")
+			panic(err)
+		}
+	}
+
+	var phoneCaller phone.Caller
+	// responses of contacts to alerts, e.g. acknowledgement by keypress during phone call
+	var incidentActions chan state.IncidentAction
+	if flags.PhoneProvider != "" || flags.ActionSecret != "" || (flags.TelegramToken != "" && flags.TelegramButtons) || flags.OpsgeniePollInterval > 0 {
+		incidentActions = make(chan state.IncidentAction, 16)
+	}
+	switch flags.PhoneProvider {
+	case "":
+	case phoneProviderTwilio:
+		// callbacks would never arrive without the listener
+		if flags.HTTPPublicURL != "" && httpListener == nil {
+			fmt.Printf("Phone call status callbacks need HTTP listener, set --http-listen-address.\n")
+			panic(missingHTTPListener)
+		}
+		twilioConfig := phone.TwilioConfig{
+			APIURL:             flags.TwilioAPIURL,
+			AccountSID:         flags.TwilioAccountSID,
+			AuthToken:          flags.TwilioAuthToken,
+			From:               flags.TwilioCallFrom,
+			PublicURL:          flags.HTTPPublicURL,
+			RingTimeout:        flags.PhoneRingTimeout,
+			Retries:            flags.PhoneRetries,
+			RetryInterval:      flags.PhoneRetryInterval,
+			Voice:              flags.PhoneVoice,
+			Language:           flags.PhoneLanguage,
+			Repeat:             flags.PhoneRepeat,
+			SilenceDuration:    flags.PhoneSilence,
+			IncidentActionChan: incidentActions,
+			Timeout:            flags.PhoneRequestTimeout,
+			Logger:             logger,
+		}
+		twilioCaller, err := phone.NewTwilio(twilioConfig)
+		if err != nil {
+			fmt.Printf("Failed to create Twilio voice client.\n")
+			panic(err)
+		}
+		if httpListener != nil {
+			httpListener.Handle(phone.StatusPath, twilioCaller.StatusHandler())
+			httpListener.Handle(phone.GatherPath, twilioCaller.GatherHandler())
+		}
+		phoneCaller = twilioCaller
+	default:
+		fmt.Printf("Unsupported phone provider %s.\n", flags.PhoneProvider)
+		panic(invalidPhoneProvider)
+	}
+
+	// acknowledge and silence links of chat notifications
+	var actionLinks *action.Links
+	if flags.ActionSecret != "" {
+		if httpListener == nil {
+			fmt.Printf("Acknowledge and silence links need HTTP listener, set --http-listen-address.\n")
+			panic(missingHTTPListener)
+		}
+		actionConfig := action.Config{
+			PublicURL:          flags.HTTPPublicURL,
+			Secret:             flags.ActionSecret,
+			SilenceDuration:    flags.ActionSilence,
+			IncidentActionChan: incidentActions,
+			Logger:             logger,
+		}
+		actionLinks, err = action.New(actionConfig)
+		if err != nil {
+			fmt.Printf("Failed to create acknowledge and silence links.\n")
+			panic(err)
+		}
+		httpListener.Handle(action.AckPath, actionLinks.AckHandler())
+		httpListener.Handle(action.SilencePath, actionLinks.SilenceHandler())
+	}
+
+	teamsConfig := teams.Config{
+		Timeout:     flags.TeamsTimeout,
+		Retries:     flags.TeamsRetries,
+		ActionLinks: actionLinks,
+		Logger:      logger,
+	}
+	teamsClient, err := teams.New(teamsConfig)
+	if err != nil {
+		fmt.Printf("Failed to create Teams client.\n")
+		panic(err)
+	}
+
+	var telegramBot *telegram.Bot
+	if flags.TelegramToken != "" {
+		telegramConfig := telegram.Config{
+			APIURL:          flags.TelegramAPIURL,
+			Token:           flags.TelegramToken,
+			Timeout:         flags.TelegramTimeout,
+			Retries:         flags.TelegramRetries,
+			PollTimeout:     flags.TelegramPollTimeout,
+			SilenceDuration: flags.ActionSilence,
+			Logger:          logger,
+		}
+		if flags.TelegramButtons {
+			telegramConfig.IncidentActionChan = incidentActions
+		}
+		telegramBot, err = telegram.New(telegramConfig)
+		if err != nil {
+			fmt.Printf("Failed to create Telegram bot.\n")
+			panic(err)
+		}
+		telegramBot.Start()
+	}
+
+	pagerDutyConfig := pagerduty.Config{
+		URL:      flags.PagerDutyURL,
+		Severity: flags.PagerDutySeverity,
+		Timeout:  flags.PagerDutyTimeout,
+		Retries:  flags.PagerDutyRetries,
+		Logger:   logger,
+	}
+	pagerDutyClient, err := pagerduty.New(pagerDutyConfig)
+	if err != nil {
+		fmt.Printf("Failed to create PagerDuty client.\n")
+		panic(err)
+	}
+
+	opsgenieConfig := opsgenie.Config{
+		APIURL:   flags.OpsgenieAPIURL,
+		Priority: flags.OpsgeniePriority,
+		Tags:     flags.OpsgenieTags,
+		Timeout:  flags.OpsgenieTimeout,
+		Retries:  flags.OpsgenieRetries,
+		Logger:   logger,
+	}
+	if flags.OpsgeniePollInterval > 0 {
+		opsgenieConfig.PollInterval = flags.OpsgeniePollInterval
+		opsgenieConfig.IncidentActionChan = incidentActions
+	}
+	opsgenieClient, err := opsgenie.New(opsgenieConfig)
+	if err != nil {
+		fmt.Printf("Failed to create Opsgenie client.\n")
+		panic(err)
+	}
+	opsgenieClient.Start()
+
+	discordConfig := discord.Config{
+		Timeout:     flags.DiscordTimeout,
+		Retries:     flags.DiscordRetries,
+		ActionLinks: actionLinks,
+		Logger:      logger,
+	}
+	discordClient, err := discord.New(discordConfig)
+	if err != nil {
+		fmt.Printf("Failed to create Discord client.\n")
+		panic(err)
+	}
+
+	var matrixClient *matrix.Client
+	if flags.MatrixAccessToken != "" {
+		matrixConfig := matrix.Config{
+			Homeserver:  flags.MatrixHomeserver,
+			AccessToken: flags.MatrixAccessToken,
+			Timeout:     flags.MatrixTimeout,
+			Retries:     flags.MatrixRetries,
+			ActionLinks: actionLinks,
+			Logger:      logger,
+		}
+		matrixClient, err = matrix.New(matrixConfig)
+		if err != nil {
+			fmt.Printf("Failed to create Matrix client.\n")
+			panic(err)
+		}
+	}
+
+	var emailChan chan *email.Envelope
 	// email section
 	if flags.SMTPEnabled {
 		// email channel
 		emailChan = email.BuildEmailChannel()
 		// start email daemon
+		smtpConfig := email.SMTPConfig{
+			Server:   flags.SMTPServer,
+			Port:     flags.SMTPPort,
+			Username: flags.SMTPUser,
+			Password: flags.SMTPPassword,
+			SMTPFrom: flags.EmailFrom,
+
+			TLSMode:        flags.SMTPTLSMode,
+			AuthMechanism:  flags.SMTPAuth,
+			CAFile:         flags.SMTPCAFile,
+			TLSFingerprint: flags.SMTPTLSFingerprint,
+			TLSSkipVerify:  flags.SMTPTLSSkipVerify,
+			ClientCertFile: flags.SMTPClientCert,
+			ClientKeyFile:  flags.SMTPClientKey,
+		}
+		// failover servers share all settings with the main server except the address
+		var failoverConfigs []email.SMTPConfig
+		for _, server := range flags.SMTPFailover {
+			host, port, _ := parseHostPort(server)
+			failoverConfig := smtpConfig
+			failoverConfig.Server = host
+			failoverConfig.Port = port
+			failoverConfigs = append(failoverConfigs, failoverConfig)
+		}
+		var dkimConfigs []email.DKIMConfig
+		for _, dkim := range flags.DKIM {
+			dkimConfig, _ := parseDKIM(dkim)
+			dkimConfigs = append(dkimConfigs, dkimConfig)
+		}
 		emailDaemonConfig := email.DaemonConfig{
-			SMTPConfig: email.SMTPConfig{
-				Server:   flags.SMTPServer,
-				Port:     flags.SMTPPort,
-				Username: flags.SMTPUser,
-				Password: flags.SMTPPassword,
-				SMTPFrom: flags.EmailFrom,
-			},
+			Transport:    flags.Transport,
+			SendmailPath: flags.SendmailPath,
+			MaildirPath:  flags.MaildirPath,
+			FileDir:      flags.EmlDir,
+
+			SMTPConfig:          smtpConfig,
+			FailoverSMTPConfigs: failoverConfigs,
+			DeadLetterDir:       flags.SMTPDeadLetterDir,
+			Workers:             flags.SMTPWorkers,
+			QueueSize:           flags.SMTPQueueSize,
+			DKIMConfigs:         dkimConfigs,
+			// bounces can be mapped to contacts only via VERP return path
+			VERP: flags.BounceSource != "",
+
 			Logger:    logger,
 			EmailChan: emailChan,
 		}
@@ -183,8 +807,49 @@ func mainExecute(cmd *cobra.Command, args []string) {
 			fmt.Printf("Failed to start email daemon.\n")
 			panic(err)
 		}
+		if httpListener != nil {
+			httpListener.Handle(email.HealthPath, emailDaemon.HealthHandler())
+		}
 		// run daemon
-		emailDaemon.StartDaemon()
+		err = emailDaemon.StartDaemon()
+		if err != nil {
+			fmt.Printf("Failed to start email daemon.\n")
+			panic(err)
+		}
+	}
+	if httpListener != nil {
+		err = httpListener.Start()
+		if err != nil {
+			fmt.Printf("Failed to start HTTP listener.\n")
+			panic(err)
+		}
+	}
+	if flags.BounceSource != "" {
+		bounceConfig := bounce.Config{
+			Source:      flags.BounceSource,
+			MaildirPath: flags.BounceMaildir,
+			IMAPConfig: bounce.IMAPConfig{
+				Server:   flags.BounceIMAPServer,
+				Username: flags.BounceIMAPUser,
+				Password: flags.BounceIMAPPassword,
+				Mailbox:  flags.BounceIMAPMailbox,
+				TLS:      flags.BounceIMAPTLS,
+			},
+			StorePath:    flags.BounceStore,
+			Threshold:    flags.BounceThreshold,
+			PollInterval: flags.BouncePollInterval,
+
+			SMTPEmailChan: emailChan,
+			Branding:      brandingProfiles,
+			DBClient:      dbClient,
+			Logger:        logger,
+		}
+		bounceProcessor, err := bounce.New(bounceConfig)
+		if err != nil {
+			fmt.Printf("Failed to start bounce processor.\n")
+			panic(err)
+		}
+		bounceProcessor.Start()
 	}
 	// make sure to close channel
 	defer func() {
@@ -193,17 +858,50 @@ func mainExecute(cmd *cobra.Command, args []string) {
 		}
 	}()
 
+	emailSubjects, err := email.LoadSubjectTemplates(flags.EmailSubject, flags.EmailSubjectFile)
+	if err != nil {
+		fmt.Printf("Failed to load email subject templates.\n")
+		panic(err)
+	}
+
 	intervals, err := dbClient.SQL_GetIntervals()
 	if err != nil {
 		panic(err)
 	}
+	// every interval loop gets all incident actions, only the loop which owns the service applies them
+	var serviceActions []chan state.IncidentAction
 	for _, interval := range intervals {
+		var actions chan state.IncidentAction
+		if incidentActions != nil {
+			actions = make(chan state.IncidentAction, 16)
+			serviceActions = append(serviceActions, actions)
+		}
 		// prepare main service/process config
 		mainServiceConfig := service.Config{
 			DBClient:      dbClient,
 			FetchInterval: time.Duration(interval) * time.Second,
 			SMTPEnabled:   flags.SMTPEnabled,
 			SMTPEmailChan: emailChan,
+			EmailHistory: email.HistoryConfig{
+				Checks: flags.EmailHistoryChecks,
+				Window: flags.EmailHistoryWindow,
+			},
+			EmailSubjects:  emailSubjects,
+			Branding:       brandingProfiles,
+			SMSSender:      smsSender,
+			SMSMaxParts:    flags.SMSMaxParts,
+			PhoneCaller:    phoneCaller,
+			PhoneCountries: phoneCountries,
+			WebhookSender:  webhookSender,
+			SlackClient:    slackClient,
+			TeamsClient:    teamsClient,
+			TelegramBot:    telegramBot,
+			PagerDuty:      pagerDutyClient,
+			Opsgenie:       opsgenieClient,
+			DiscordClient:  discordClient,
+			MatrixClient:   matrixClient,
+
+			IncidentActionChan: actions,
 
 			Logger:        logger,
 			TimeProfiling: flags.TimeProfiling,
@@ -216,12 +914,124 @@ func mainExecute(cmd *cobra.Command, args []string) {
 		// boot main service/process
 		go mainService.Boot()
 	}
+	if incidentActions != nil {
+		go fanOutIncidentActions(incidentActions, serviceActions)
+	}
 
 	// sleep little friend
 	fmt.Printf(">> Main thread sleeping forever ...\n")
 	select {}
 }
 
+// create client of SMS provider, it panics on invalid configuration like the rest of startup
+func newSMSSender(provider string, logger *exlogger.Logger) sms.Sender {
+	switch provider {
+	case smsProviderSMPP:
+		smppConfig := sms.SMPPConfig{
+			Server:              flags.SMPPServer,
+			TLS:                 flags.SMPPTLS,
+			SystemID:            flags.SMPPSystemID,
+			Password:            flags.SMPPPassword,
+			SystemType:          flags.SMPPSystemType,
+			SourceAddr:          flags.SMPPSourceAddr,
+			SourceTON:           byte(flags.SMPPSourceTON),
+			SourceNPI:           byte(flags.SMPPSourceNPI),
+			EnquireLinkInterval: flags.SMPPEnquireLink,
+			RequestTimeout:      flags.SMPPRequestTimeout,
+			DeliveryReceipts:    flags.SMPPDeliveryReceipt,
+			Logger:              logger,
+		}
+		smppClient, err := sms.NewSMPP(smppConfig)
+		if err != nil {
+			fmt.Printf("Failed to start SMPP client.\n")
+			panic(err)
+		}
+		smppClient.Start()
+		return smppClient
+	case smsProviderTwilio:
+		twilioConfig := sms.TwilioConfig{
+			APIURL:              flags.TwilioAPIURL,
+			AccountSID:          flags.TwilioAccountSID,
+			AuthToken:           flags.TwilioAuthToken,
+			From:                flags.TwilioSMSFrom,
+			MessagingServiceSID: flags.TwilioMessagingSID,
+			Timeout:             flags.SMSHTTPTimeout,
+			Retries:             flags.SMSHTTPRetries,
+			Logger:              logger,
+		}
+		smsSender, err := sms.NewTwilio(twilioConfig)
+		if err != nil {
+			fmt.Printf("Failed to create Twilio SMS client.\n")
+			panic(err)
+		}
+		return smsSender
+	case smsProviderHTTP:
+		gatewayConfig := sms.HTTPGatewayConfig{
+			URL:               flags.SMSHTTPURL,
+			Method:            flags.SMSHTTPMethod,
+			BodyFormat:        flags.SMSHTTPBodyFormat,
+			Body:              flags.SMSHTTPBody,
+			Headers:           flags.SMSHTTPHeaders,
+			Username:          flags.SMSHTTPUsername,
+			Password:          flags.SMSHTTPPassword,
+			From:              flags.SMSHTTPFrom,
+			MessageIDField:    flags.SMSHTTPMessageID,
+			ErrorCodeField:    flags.SMSHTTPErrorCode,
+			ErrorMessageField: flags.SMSHTTPErrorMessage,
+			SuccessCodes:      flags.SMSHTTPSuccessCodes,
+			RetryableCodes:    flags.SMSHTTPRetryable,
+			Timeout:           flags.SMSHTTPTimeout,
+			Retries:           flags.SMSHTTPRetries,
+			Logger:            logger,
+		}
+		smsSender, err := sms.NewHTTPGateway(gatewayConfig)
+		if err != nil {
+			fmt.Printf("Failed to create SMS gateway client.\n")
+			panic(err)
+		}
+		return smsSender
+	default:
+		fmt.Printf("Unsupported SMS provider %s.\n", provider)
+		panic(invalidSMSProvider)
+	}
+}
+
+func fanOutIncidentActions(in chan state.IncidentAction, out []chan state.IncidentAction) {
+	for action := range in {
+		for _, c := range out {
+			c <- action
+		}
+	}
+}
+
+// parse host:port string
+func parseHostPort(hostPort string) (string, int, error) {
+	host, portString, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, port, nil
+}
+
+// parse DKIM configuration in from,domain,selector,keyfile format
+func parseDKIM(s string) (email.DKIMConfig, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return email.DKIMConfig{}, invalidDKIMFormat
+	}
+	dkimConfig := email.DKIMConfig{
+		From:     strings.TrimSpace(parts[0]),
+		Domain:   strings.TrimSpace(parts[1]),
+		Selector: strings.TrimSpace(parts[2]),
+		KeyFile:  strings.TrimSpace(parts[3]),
+	}
+	return dkimConfig, nil
+}
+
 var sigTermErr = errors.New("SIGTERM")
 
 // catch Interrupt (Ctrl^C) or SIGTERM and exit
@@ -0,0 +1,201 @@
+package action
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/service/state"
+)
+
+const (
+	AckPath     = "/incident/ack"
+	SilencePath = "/incident/silence"
+
+	actionAck     = "ack"
+	actionSilence = "silence"
+)
+
+type Config struct {
+	// public URL of the HTTP listener, e.g. https://firefly.example.com
+	PublicURL string
+	// key of HMAC signature of the links, anybody with the key can acknowledge any incident
+	Secret string
+	// how long are FAIL notifications suppressed after silence link is used
+	SilenceDuration time.Duration
+	// actions are sent to services
+	IncidentActionChan chan state.IncidentAction
+
+	Logger *exlogger.Logger
+}
+
+// Links builds signed acknowledge and silence links for chat notifications and handles them
+//
+// link opens confirmation page and the action is done only after the button on the page is pressed,
+// so link previews and mail scanners which fetch every link do not acknowledge the incident
+type Links struct {
+	conf Config
+}
+
+func New(conf Config) (*Links, error) {
+	u, err := url.Parse(conf.PublicURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.PublicURL must be absolute http or https URL")
+	}
+	if len(conf.Secret) < 16 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Secret must have at least 16 characters")
+	}
+	if conf.SilenceDuration <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.SilenceDuration must be positive duration")
+	}
+	if conf.IncidentActionChan == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.IncidentActionChan must not be nil")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+	conf.PublicURL = strings.TrimSuffix(conf.PublicURL, "/")
+
+	return &Links{conf: conf}, nil
+}
+
+// URLs returns acknowledge and silence links of the incident for the contact
+// nil Links return empty links, channels leave the buttons out then
+func (l *Links) URLs(serviceID int, notificationID int, incidentID string) (string, string) {
+	if l == nil || incidentID == "" {
+		return "", ""
+	}
+	return l.link(AckPath, actionAck, serviceID, notificationID, incidentID), l.link(SilencePath, actionSilence, serviceID, notificationID, incidentID)
+}
+
+// SilenceDuration returns how long is the incident silenced by the silence link
+func (l *Links) SilenceDuration() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return l.conf.SilenceDuration
+}
+
+func (l *Links) link(path string, action string, serviceID int, notificationID int, incidentID string) string {
+	q := url.Values{}
+	q.Set("service", strconv.Itoa(serviceID))
+	q.Set("contact", strconv.Itoa(notificationID))
+	q.Set("incident", incidentID)
+	q.Set("sig", l.sign(action, q))
+	return l.conf.PublicURL + path + "?" + q.Encode()
+}
+
+func (l *Links) sign(action string, q url.Values) string {
+	mac := hmac.New(sha256.New, []byte(l.conf.Secret))
+	mac.Write([]byte(strings.Join([]string{action, q.Get("service"), q.Get("contact"), q.Get("incident")}, "|")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *Links) AckHandler() http.Handler {
+	return l.handler(actionAck)
+}
+
+func (l *Links) SilenceHandler() http.Handler {
+	return l.handler(actionSilence)
+}
+
+func (l *Links) handler(action string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		serviceID, notificationID, err := l.verify(action, q)
+		if err != nil {
+			l.conf.Logger.LogError(err, "refused %s link for incident %s", action, q.Get("incident"))
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Invalid link.\n"))
+			return
+		}
+
+		page := pageData{Action: "Acknowledge", IncidentID: q.Get("incident")}
+		if action == actionSilence {
+			page.Action = "Silence for " + FormatDuration(l.conf.SilenceDuration)
+		}
+		if r.Method == http.MethodPost {
+			incidentAction := state.IncidentAction{
+				ServiceID:  serviceID,
+				IncidentID: q.Get("incident"),
+				By:         "contact " + strconv.Itoa(notificationID),
+			}
+			if action == actionAck {
+				incidentAction.Acknowledge = true
+			} else {
+				incidentAction.SilenceUntil = time.Now().Add(l.conf.SilenceDuration)
+			}
+			if !state.SendIncidentAction(l.conf.IncidentActionChan, incidentAction) {
+				l.conf.Logger.LogError(incidentActionDroppedError, "dropped %s link used by contact %d for incident %s", action, notificationID, incidentAction.IncidentID)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("Incident could not be updated, please try again later.\n"))
+				return
+			}
+			l.conf.Logger.Log("%s link used by contact %d for incident %s", action, notificationID, incidentAction.IncidentID)
+			page.Done = true
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		pageTemplate.Execute(w, page)
+	})
+}
+
+func (l *Links) verify(action string, q url.Values) (int, int, error) {
+	sig, err := hex.DecodeString(q.Get("sig"))
+	if err != nil {
+		return 0, 0, errors.Wrap(invalidSignatureError, "signature is not hex encoded")
+	}
+	expected, _ := hex.DecodeString(l.sign(action, q))
+	if subtle.ConstantTimeCompare(sig, expected) != 1 {
+		return 0, 0, invalidSignatureError
+	}
+	serviceID, err := strconv.Atoi(q.Get("service"))
+	if err != nil {
+		return 0, 0, errors.Wrap(invalidSignatureError, "service must be number")
+	}
+	notificationID, err := strconv.Atoi(q.Get("contact"))
+	if err != nil {
+		return 0, 0, errors.Wrap(invalidSignatureError, "contact must be number")
+	}
+	return serviceID, notificationID, nil
+}
+
+// FormatDuration returns short form of silence duration, e.g. 1h instead of 1h0m0s
+func FormatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+type pageData struct {
+	Action     string
+	IncidentID string
+	Done       bool
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>{{ .Action }}</title></head>
+<body style="font-family: sans-serif; margin: 2em">
+{{ if .Done }}<p>Done, incident {{ .IncidentID }} is updated.</p>
+{{ else }}<form method="post"><p>Incident {{ .IncidentID }}</p><button type="submit">{{ .Action }}</button></form>
+{{ end }}</body></html>
+`))
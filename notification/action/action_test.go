@@ -0,0 +1,124 @@
+package action
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+
+	"github.com/exmonitor/firefly/service/state"
+)
+
+func newTestLinks(t *testing.T, actions chan state.IncidentAction) *Links {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := New(Config{PublicURL: "https://firefly.example.com/", Secret: "0123456789abcdef", SilenceDuration: time.Hour, IncidentActionChan: actions, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func request(t *testing.T, h http.Handler, method string, link string) *httptest.ResponseRecorder {
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, u.RequestURI(), nil))
+	return rec
+}
+
+func TestLinks(t *testing.T) {
+	actions := make(chan state.IncidentAction, 2)
+	l := newTestLinks(t, actions)
+	ackURL, silenceURL := l.URLs(7, 3, "7-1")
+	if !strings.HasPrefix(ackURL, "https://firefly.example.com"+AckPath+"?") || !strings.HasPrefix(silenceURL, "https://firefly.example.com"+SilencePath+"?") {
+		t.Fatalf("unexpected links %s and %s", ackURL, silenceURL)
+	}
+
+	// link previews only get the confirmation page
+	rec := request(t, l.AckHandler(), http.MethodGet, ackURL)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<button") || len(actions) != 0 {
+		t.Fatalf("expected confirmation page without action, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = request(t, l.AckHandler(), http.MethodPost, ackURL)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Done") {
+		t.Fatalf("expected done page, got %d %s", rec.Code, rec.Body)
+	}
+	ack := <-actions
+	if ack.ServiceID != 7 || ack.IncidentID != "7-1" || !ack.Acknowledge || ack.By != "contact 3" {
+		t.Fatalf("unexpected acknowledgement %+v", ack)
+	}
+
+	rec = request(t, l.SilenceHandler(), http.MethodPost, silenceURL)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected done page, got %d %s", rec.Code, rec.Body)
+	}
+	silence := <-actions
+	if silence.Acknowledge || time.Until(silence.SilenceUntil) < 59*time.Minute {
+		t.Fatalf("expected silence for 1h, got %+v", silence)
+	}
+
+	// ack link does not silence and signature covers the incident
+	if rec := request(t, l.SilenceHandler(), http.MethodPost, ackURL); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected HTTP 403 for ack link on silence path, got %d", rec.Code)
+	}
+	if rec := request(t, l.AckHandler(), http.MethodPost, strings.Replace(ackURL, "incident=7-1", "incident=7-2", 1)); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected HTTP 403 for changed incident, got %d", rec.Code)
+	}
+	if len(actions) != 0 {
+		t.Fatal("refused links must not send actions")
+	}
+}
+
+func TestLinkDoesNotBlockOnFullChannel(t *testing.T) {
+	// nobody reads the actions
+	l := newTestLinks(t, make(chan state.IncidentAction))
+	ackURL, _ := l.URLs(7, 3, "7-1")
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- request(t, l.AckHandler(), http.MethodPost, ackURL)
+	}()
+	select {
+	case rec := <-done:
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected HTTP 503 for dropped action, got %d", rec.Code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("link handler blocked on incident action channel")
+	}
+}
+
+func TestNilLinks(t *testing.T) {
+	var l *Links
+	if ack, silence := l.URLs(7, 3, "7-1"); ack != "" || silence != "" {
+		t.Fatalf("expected no links, got %q and %q", ack, silence)
+	}
+	if l.SilenceDuration() != 0 {
+		t.Fatal("expected zero silence duration")
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		time.Hour:                   "1h",
+		90 * time.Minute:            "1h30m",
+		30 * time.Minute:            "30m",
+		45 * time.Second:            "45s",
+		2*time.Hour + 5*time.Second: "2h0m5s",
+	}
+	for d, want := range tests {
+		if got := FormatDuration(d); got != want {
+			t.Errorf("FormatDuration(%s): expected %q, got %q", d, want, got)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package action
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var invalidSignatureError error = errors.New("invalid action link signature")
+
+var incidentActionDroppedError error = errors.New("incident action queue is full")
@@ -0,0 +1,265 @@
+package bounce
+
+import (
+	"strings"
+	"time"
+
+	"github.com/exmonitor/exclient/database"
+	dbnotification "github.com/exmonitor/exclient/database/spec/notification"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/branding"
+	"github.com/exmonitor/firefly/notification/email"
+)
+
+const (
+	sourceMaildir = "maildir"
+	sourceIMAP    = "imap"
+
+	contactTypeEmail = "email"
+
+	// account owner is warned again about the same contact only after this interval
+	warningInterval = time.Hour * 24
+)
+
+type Config struct {
+	// one of maildir, imap
+	Source      string
+	MaildirPath string
+	IMAPConfig  IMAPConfig
+	// json file with bounce records
+	StorePath string
+	// amount of hard bounces and complaints after which is the account owner warned
+	Threshold    int
+	PollInterval time.Duration
+
+	// warnings are only logged when email channel is nil
+	SMTPEmailChan chan *email.Envelope
+	// branding and account owner address of warning emails, default branding is used when nil
+	Branding *branding.Profiles
+	DBClient database.ClientInterface
+	Logger   *exlogger.Logger
+}
+
+func New(conf Config) (*Processor, error) {
+	var src source
+	switch conf.Source {
+	case sourceMaildir:
+		if conf.MaildirPath == "" {
+			return nil, errors.Wrap(invalidConfigError, "conf.MaildirPath must not be empty")
+		}
+		src = &maildirSource{path: conf.MaildirPath}
+	case sourceIMAP:
+		if conf.IMAPConfig.Server == "" {
+			return nil, errors.Wrap(invalidConfigError, "conf.IMAPConfig.Server must not be empty")
+		}
+		if conf.IMAPConfig.Mailbox == "" {
+			return nil, errors.Wrap(invalidConfigError, "conf.IMAPConfig.Mailbox must not be empty")
+		}
+		src = &imapSource{conf: conf.IMAPConfig}
+	default:
+		return nil, errors.Wrapf(invalidConfigError, "conf.Source %q is not supported", conf.Source)
+	}
+	if conf.StorePath == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.StorePath must not be empty")
+	}
+	if conf.Threshold <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Threshold must be positive number")
+	}
+	if conf.PollInterval <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.PollInterval must be positive duration")
+	}
+	if conf.DBClient == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.DBClient must not be nil")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+
+	s, err := loadStore(conf.StorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	newProcessor := &Processor{
+		source:       src,
+		store:        s,
+		threshold:    conf.Threshold,
+		pollInterval: conf.PollInterval,
+		emailChan:    conf.SMTPEmailChan,
+		branding:     conf.Branding,
+		dbClient:     conf.DBClient,
+		logger:       conf.Logger,
+	}
+	return newProcessor, nil
+}
+
+// Processor reads delivery status notifications and abuse reports of our alert emails,
+// maps them back to the notification contact via VERP return path and warns account owner about dead contacts
+type Processor struct {
+	source       source
+	store        *store
+	threshold    int
+	pollInterval time.Duration
+
+	emailChan chan *email.Envelope
+	branding  *branding.Profiles
+	dbClient  database.ClientInterface
+	logger    *exlogger.Logger
+}
+
+// source of bounce reports, handler is called for every unprocessed email
+// email is marked as processed only when handler returns nil
+type source interface {
+	poll(handler func(raw []byte) error) error
+	String() string
+}
+
+func (p *Processor) Start() {
+	go p.run()
+}
+
+func (p *Processor) run() {
+	p.logger.Log("started bounce processor reading %s every %s", p.source.String(), p.pollInterval)
+	for {
+		err := p.source.poll(p.handle)
+		if err != nil {
+			p.logger.LogError(err, "failed to read bounces from %s", p.source.String())
+		}
+		err = p.store.save()
+		if err != nil {
+			p.logger.LogError(err, "failed to save bounce records")
+		}
+		time.Sleep(p.pollInterval)
+	}
+}
+
+// process single email from the bounce mailbox
+// unknown emails are skipped, error is returned only when the email should be processed again later
+func (p *Processor) handle(raw []byte) error {
+	r, err := parseReport(raw)
+	if errors.Cause(err) == notReportError {
+		p.logger.LogDebug("skipping email in bounce mailbox, it is not delivery or feedback report")
+		return nil
+	} else if err != nil {
+		p.logger.LogError(err, "skipping malformed email in bounce mailbox")
+		return nil
+	}
+
+	serviceID, notificationID, ok := findVERP(r.verpCandidates)
+	if !ok {
+		p.logger.LogDebug("skipping %s for %s, it does not contain VERP address", r.kind, r.recipient)
+		return nil
+	}
+
+	settings, err := p.dbClient.SQL_GetUsersNotificationSettings(serviceID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch notification settings for service %d", serviceID)
+	}
+	contact := findContact(settings, notificationID)
+	if contact == nil {
+		p.logger.LogDebug("skipping %s for notification %d of service %d, contact does not exist anymore", r.kind, notificationID, serviceID)
+		return nil
+	}
+	// VERP address is public, never trust report which is not about the contact address
+	if r.recipient != "" && !strings.EqualFold(r.recipient, contact.Target) {
+		p.logger.LogError(recipientMismatchError, "skipping %s for notification %d, report recipient %s does not match contact %s", r.kind, notificationID, r.recipient, contact.Target)
+		return nil
+	}
+
+	record := p.store.get(serviceID, notificationID, contact.Target)
+	p.store.Lock()
+	switch {
+	case r.kind == reportKindComplaint:
+		record.Complaints++
+	case r.hard:
+		record.HardBounces++
+	default:
+		record.SoftBounces++
+	}
+	record.LastStatus = r.status
+	record.LastDiagnostic = r.diagnostic
+	record.LastBounce = time.Now()
+	failures := record.HardBounces + record.Complaints
+	shouldWarn := failures >= p.threshold && time.Since(record.LastWarning) > warningInterval
+	if shouldWarn {
+		record.LastWarning = time.Now()
+	}
+	p.store.Unlock()
+
+	if r.hard {
+		p.logger.Log("recorded %s for %s (service %d, notification %d): %s %s", r.kind, contact.Target, serviceID, notificationID, r.status, r.diagnostic)
+	} else {
+		p.logger.LogDebug("recorded soft bounce for %s (service %d, notification %d): %s %s", contact.Target, serviceID, notificationID, r.status, r.diagnostic)
+	}
+
+	if shouldWarn {
+		p.warnOwner(serviceID, contact, settings, failures, r)
+	}
+	return nil
+}
+
+// warn account owner about contact which keeps bouncing
+// owner address comes from branding, when it is unknown the warning is sent to all other email contacts of the service
+func (p *Processor) warnOwner(serviceID int, contact *dbnotification.UserNotificationSettings, settings []*dbnotification.UserNotificationSettings, failures int, r *report) {
+	p.logger.LogError(deadContactError, "contact %s of service %d failed %d times, last reason: %s", contact.Target, serviceID, failures, r.diagnostic)
+
+	if p.emailChan == nil {
+		return
+	}
+	serviceInfo, err := p.dbClient.SQL_GetServiceDetails(serviceID)
+	if err != nil || serviceInfo == nil {
+		p.logger.LogError(err, "failed to fetch service info for bounce warning of service %d", serviceID)
+		return
+	}
+
+	// owner which is the bouncing contact itself would never get the warning
+	if owner := p.branding.Owner(serviceID); owner != "" && !strings.EqualFold(owner, contact.Target) {
+		b := p.branding.Select(serviceID, 0, owner)
+		p.emailChan <- &email.Envelope{Message: warningEmail(b, owner, contact.Target, serviceInfo, failures, r.diagnostic), Branding: b}
+		return
+	}
+
+	sent := 0
+	for _, n := range settings {
+		if n.Type != contactTypeEmail || n.ID == contact.ID || strings.EqualFold(n.Target, contact.Target) {
+			continue
+		}
+		// never warn contact which is bouncing itself
+		if p.isDead(n.ID) {
+			continue
+		}
+		b := p.branding.Select(serviceID, n.ID, n.Target)
+		p.emailChan <- &email.Envelope{Message: warningEmail(b, n.Target, contact.Target, serviceInfo, failures, r.diagnostic), Branding: b}
+		sent++
+	}
+	if sent == 0 {
+		p.logger.LogError(deadContactError, "no account owner and no other working email contact of service %d to warn about %s", serviceID, contact.Target)
+	}
+}
+
+func (p *Processor) isDead(notificationID int) bool {
+	p.store.Lock()
+	defer p.store.Unlock()
+	r, ok := p.store.records[notificationID]
+	return ok && r.HardBounces+r.Complaints >= p.threshold
+}
+
+func findVERP(candidates []string) (int, int, bool) {
+	for _, c := range candidates {
+		if serviceID, notificationID, ok := email.ParseVERP(c); ok {
+			return serviceID, notificationID, true
+		}
+	}
+	return 0, 0, false
+}
+
+func findContact(settings []*dbnotification.UserNotificationSettings, notificationID int) *dbnotification.UserNotificationSettings {
+	for _, n := range settings {
+		if n.ID == notificationID && n.Type == contactTypeEmail {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package bounce
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/exmonitor/exclient/database"
+	dbnotification "github.com/exmonitor/exclient/database/spec/notification"
+	"github.com/exmonitor/exclient/database/spec/service"
+	"github.com/exmonitor/exlogger"
+
+	"github.com/exmonitor/firefly/notification/branding"
+	"github.com/exmonitor/firefly/notification/email"
+)
+
+// fakeDB returns the same contacts for every service, other queries are not implemented
+type fakeDB struct {
+	database.ClientInterface
+	settings []*dbnotification.UserNotificationSettings
+}
+
+func (db *fakeDB) SQL_GetUsersNotificationSettings(serviceID int) ([]*dbnotification.UserNotificationSettings, error) {
+	return db.settings, nil
+}
+
+func (db *fakeDB) SQL_GetServiceDetails(serviceID int) (*service.Service, error) {
+	return &service.Service{ID: serviceID, Target: "https://example.com"}, nil
+}
+
+func newTestProcessor(t *testing.T, threshold int, settings ...*dbnotification.UserNotificationSettings) *Processor {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadStore(filepath.Join(t.TempDir(), "bounces.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Processor{
+		store:     s,
+		threshold: threshold,
+		emailChan: make(chan *email.Envelope, 10),
+		dbClient:  &fakeDB{settings: settings},
+		logger:    logger,
+	}
+}
+
+func TestHandleWarnsOwnerAfterThreshold(t *testing.T) {
+	p := newTestProcessor(t, 2,
+		&dbnotification.UserNotificationSettings{ID: 345, Type: contactTypeEmail, Target: "Ops@example.org"},
+		&dbnotification.UserNotificationSettings{ID: 346, Type: contactTypeEmail, Target: "owner@example.org"},
+		&dbnotification.UserNotificationSettings{ID: 347, Type: "sms", Target: "+420123456789"},
+	)
+	raw := readFixture(t, "dsn_hard.eml")
+
+	if err := p.handle(raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.emailChan) != 0 {
+		t.Fatal("owner must not be warned before threshold")
+	}
+	if err := p.handle(raw); err != nil {
+		t.Fatal(err)
+	}
+	record := p.store.records[345]
+	if record.HardBounces != 2 || record.LastStatus != "5.1.1" {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	if len(p.emailChan) != 1 {
+		t.Fatalf("expected 1 warning email, got %d", len(p.emailChan))
+	}
+	warning := <-p.emailChan
+	if to := warning.Message.GetHeader("To"); len(to) != 1 || to[0] != "owner@example.org" {
+		t.Fatalf("expected warning to the other email contact, got %q", to)
+	}
+	if subject := warning.Message.GetHeader("Subject"); len(subject) != 1 || !strings.Contains(subject[0], "Ops@example.org") {
+		t.Fatalf("expected dead contact in subject, got %q", subject)
+	}
+
+	// warning is not repeated within warning interval
+	if err := p.handle(raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.emailChan) != 0 {
+		t.Fatal("owner must be warned only once per interval")
+	}
+}
+
+func TestHandleCountsSoftBouncesAndComplaints(t *testing.T) {
+	p := newTestProcessor(t, 5,
+		&dbnotification.UserNotificationSettings{ID: 88, Type: contactTypeEmail, Target: "oncall@example.net"},
+		&dbnotification.UserNotificationSettings{ID: 346, Type: contactTypeEmail, Target: "boss@example.org"},
+	)
+	for _, fixture := range []string{"dsn_delayed.eml", "arf_complaint.eml", "autoreply.eml"} {
+		if err := p.handle(readFixture(t, fixture)); err != nil {
+			t.Fatalf("%s: %v", fixture, err)
+		}
+	}
+	if r := p.store.records[88]; r == nil || r.SoftBounces != 1 || r.HardBounces != 0 {
+		t.Fatalf("expected 1 soft bounce, got %+v", r)
+	}
+	if r := p.store.records[346]; r == nil || r.Complaints != 1 {
+		t.Fatalf("expected 1 complaint, got %+v", r)
+	}
+	if len(p.store.records) != 2 {
+		t.Fatalf("expected records only for reports, got %d", len(p.store.records))
+	}
+}
+
+func TestHandleIgnoresReportForOtherRecipient(t *testing.T) {
+	// VERP address is public, report about different address must not count
+	p := newTestProcessor(t, 1,
+		&dbnotification.UserNotificationSettings{ID: 345, Type: contactTypeEmail, Target: "changed@example.org"},
+	)
+	if err := p.handle(readFixture(t, "dsn_hard.eml")); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.store.records) != 0 {
+		t.Fatalf("expected no record, got %+v", p.store.records[345])
+	}
+}
+
+func TestStoreSurvivesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bounces.json")
+	s, err := loadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.get(12, 345, "ops@example.org").HardBounces = 3
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = loadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := s.get(12, 345, "ops@example.org"); r.HardBounces != 3 {
+		t.Fatalf("expected 3 hard bounces after reload, got %d", r.HardBounces)
+	}
+	// changed contact target starts from scratch
+	if r := s.get(12, 345, "new@example.org"); r.HardBounces != 0 {
+		t.Fatalf("expected reset record for changed target, got %d hard bounces", r.HardBounces)
+	}
+}
+
+func loadBranding(t *testing.T, content string) *branding.Profiles {
+	path := filepath.Join(t.TempDir(), "branding.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := branding.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profiles
+}
+
+func TestWarnOwnerRecipients(t *testing.T) {
+	deadContact := &dbnotification.UserNotificationSettings{ID: 345, Type: contactTypeEmail, Target: "ops@example.org"}
+	otherContact := &dbnotification.UserNotificationSettings{ID: 346, Type: contactTypeEmail, Target: "boss@example.org"}
+	smsContact := &dbnotification.UserNotificationSettings{ID: 347, Type: "sms", Target: "+420123456789"}
+
+	testCases := []struct {
+		name     string
+		branding string
+		settings []*dbnotification.UserNotificationSettings
+		wantTo   []string
+		wantName string
+	}{
+		{
+			name:     "service owner",
+			branding: `{"profiles": {"acme": {"name": "Acme Monitoring", "owner": "Acme Admin <admin@acme.example>"}}, "services": {"12": "acme"}}`,
+			settings: []*dbnotification.UserNotificationSettings{deadContact, otherContact},
+			wantTo:   []string{"admin@acme.example"},
+			wantName: "Acme Monitoring",
+		},
+		{
+			name:     "default owner when service profile has none",
+			branding: `{"default": {"name": "AlerTea", "owner": "owner@alertea.example"}, "profiles": {"acme": {"name": "Acme Monitoring"}}, "services": {"12": "acme"}}`,
+			settings: []*dbnotification.UserNotificationSettings{deadContact, otherContact},
+			wantTo:   []string{"owner@alertea.example"},
+			// the warning is still about the service, so it keeps the service branding
+			wantName: "Acme Monitoring",
+		},
+		{
+			name:     "owner unknown, other contacts are warned",
+			settings: []*dbnotification.UserNotificationSettings{deadContact, otherContact, smsContact},
+			wantTo:   []string{"boss@example.org"},
+			wantName: branding.DefaultName,
+		},
+		{
+			name:     "owner is the bouncing contact, other contacts are warned",
+			branding: `{"profiles": {"acme": {"name": "Acme Monitoring", "owner": "OPS@example.org"}}, "services": {"12": "acme"}}`,
+			settings: []*dbnotification.UserNotificationSettings{deadContact, otherContact},
+			wantTo:   []string{"boss@example.org"},
+			wantName: "Acme Monitoring",
+		},
+		{
+			name:     "owner unknown and no other contact",
+			settings: []*dbnotification.UserNotificationSettings{deadContact, smsContact},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProcessor(t, 1, tc.settings...)
+			if tc.branding != "" {
+				p.branding = loadBranding(t, tc.branding)
+			}
+			if err := p.handle(readFixture(t, "dsn_hard.eml")); err != nil {
+				t.Fatal(err)
+			}
+
+			var to []string
+			for len(p.emailChan) > 0 {
+				warning := <-p.emailChan
+				to = append(to, warning.Message.GetHeader("To")...)
+				if warning.Branding == nil || warning.Branding.Name != tc.wantName {
+					t.Errorf("expected branding %q, got %+v", tc.wantName, warning.Branding)
+				}
+				if subject := warning.Message.GetHeader("Subject"); len(subject) != 1 || !strings.Contains(subject[0], "ops@example.org") {
+					t.Errorf("expected dead contact in subject, got %q", subject)
+				}
+			}
+			if !reflect.DeepEqual(to, tc.wantTo) {
+				t.Fatalf("expected warning to %q, got %q", tc.wantTo, to)
+			}
+		})
+	}
+}
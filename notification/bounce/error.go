@@ -0,0 +1,13 @@
+package bounce
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var notReportError error = errors.New("email is not delivery status or feedback report")
+
+var imapError error = errors.New("IMAP command failed")
+
+var recipientMismatchError error = errors.New("report recipient mismatch")
+
+var deadContactError error = errors.New("contact keeps bouncing")
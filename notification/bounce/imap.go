@@ -0,0 +1,198 @@
+package bounce
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const imapTimeout = time.Minute
+
+type IMAPConfig struct {
+	// host:port of IMAP server
+	Server   string
+	Username string
+	Password string
+	Mailbox  string
+	// use implicit TLS (port 993), plain connection is meant only for local mailbox
+	TLS bool
+}
+
+// imapSource reads unseen reports from IMAP mailbox, processed emails are flagged as seen
+// only the few commands needed for this are implemented
+type imapSource struct {
+	conf IMAPConfig
+}
+
+func (s *imapSource) String() string {
+	return fmt.Sprintf("imap %s/%s", s.conf.Server, s.conf.Mailbox)
+}
+
+func (s *imapSource) poll(handler func(raw []byte) error) error {
+	c, err := dialIMAP(s.conf)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	_, err = c.command("LOGIN %s %s", imapQuote(s.conf.Username), imapQuote(s.conf.Password))
+	if err != nil {
+		return errors.Wrap(err, "IMAP login failed")
+	}
+	_, err = c.command("SELECT %s", imapQuote(s.conf.Mailbox))
+	if err != nil {
+		return errors.Wrapf(err, "failed to select IMAP mailbox %s", s.conf.Mailbox)
+	}
+
+	responses, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return errors.Wrap(err, "IMAP search failed")
+	}
+	var uids []string
+	for _, r := range responses {
+		if strings.HasPrefix(r.line, "* SEARCH") {
+			uids = append(uids, strings.Fields(strings.TrimPrefix(r.line, "* SEARCH"))...)
+		}
+	}
+
+	for _, uid := range uids {
+		responses, err := c.command("UID FETCH %s BODY.PEEK[]", uid)
+		if err != nil {
+			return errors.Wrapf(err, "failed to fetch IMAP message %s", uid)
+		}
+		var raw []byte
+		for _, r := range responses {
+			if len(r.literals) > 0 {
+				raw = r.literals[0]
+				break
+			}
+		}
+		if raw == nil {
+			continue
+		}
+
+		err = handler(raw)
+		if err != nil {
+			return err
+		}
+		_, err = c.command(`UID STORE %s +FLAGS.SILENT (\Seen)`, uid)
+		if err != nil {
+			return errors.Wrapf(err, "failed to flag IMAP message %s as seen", uid)
+		}
+	}
+
+	c.command("LOGOUT")
+	return nil
+}
+
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// untagged response line, literals are removed from the line and stored separately
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+func dialIMAP(conf IMAPConfig) (*imapConn, error) {
+	var conn net.Conn
+	var err error
+	if conf.TLS {
+		host, _, _ := net.SplitHostPort(conf.Server)
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: imapTimeout}, "tcp", conf.Server, &tls.Config{ServerName: host})
+	} else {
+		conn, err = net.DialTimeout("tcp", conf.Server, imapTimeout)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to IMAP server %s", conf.Server)
+	}
+
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(imapTimeout))
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to read IMAP greeting")
+	}
+	if !strings.HasPrefix(greeting.line, "* OK") && !strings.HasPrefix(greeting.line, "* PREAUTH") {
+		conn.Close()
+		return nil, errors.Wrapf(imapError, "unexpected greeting %q", greeting.line)
+	}
+	return c, nil
+}
+
+// send command and read all responses until tagged completion
+func (c *imapConn) command(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("F%03d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+
+	_, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		r, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(r.line, tag+" ") {
+			status := strings.TrimPrefix(r.line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return nil, errors.Wrap(imapError, status)
+			}
+			return responses, nil
+		}
+		responses = append(responses, r)
+	}
+}
+
+var literalRegexp = regexp.MustCompile(`\{(\d+)\}$`)
+
+// read single response, line ending with {n} is followed by n bytes of literal and continues after it
+func (c *imapConn) readResponse() (imapResponse, error) {
+	var r imapResponse
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return r, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		r.line += line
+
+		match := literalRegexp.FindStringSubmatch(line)
+		if match == nil {
+			return r, nil
+		}
+		size, _ := strconv.Atoi(match[1])
+		literal := make([]byte, size)
+		_, err = io.ReadFull(c.r, literal)
+		if err != nil {
+			return r, err
+		}
+		r.literals = append(r.literals, literal)
+	}
+}
+
+func (c *imapConn) close() {
+	c.conn.Close()
+}
+
+func imapQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,157 @@
+package bounce
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeIMAPServer implements commands used by imapSource on single mailbox
+type fakeIMAPServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	messages map[string]string
+	seen     map[string]bool
+	commands []string
+}
+
+func newFakeIMAPServer(t *testing.T, messages map[string]string) *fakeIMAPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeIMAPServer{listener: l, messages: messages, seen: map[string]bool{}}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeIMAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeIMAPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK fake IMAP4rev1 ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(strings.TrimRight(line, "\r\n"))
+		if len(fields) < 2 {
+			return
+		}
+		tag, cmd := fields[0], strings.ToUpper(strings.Join(fields[1:], " "))
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(fields[1:], " "))
+
+		switch {
+		case strings.HasPrefix(cmd, "LOGIN"):
+			if fields[3] != `"secret"` {
+				fmt.Fprintf(conn, "%s NO [AUTHENTICATIONFAILED] invalid credentials\r\n", tag)
+				s.mu.Unlock()
+				continue
+			}
+		case strings.HasPrefix(cmd, "SELECT"):
+			fmt.Fprintf(conn, "* %d EXISTS\r\n", len(s.messages))
+		case cmd == "UID SEARCH UNSEEN":
+			var uids []string
+			for uid := range s.messages {
+				if !s.seen[uid] {
+					uids = append(uids, uid)
+				}
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n", strings.Join(uids, " "))
+		case strings.HasPrefix(cmd, "UID FETCH"):
+			raw := s.messages[fields[3]]
+			fmt.Fprintf(conn, "* 1 FETCH (UID %s BODY[] {%d}\r\n%s)\r\n", fields[3], len(raw), raw)
+		case strings.HasPrefix(cmd, "UID STORE"):
+			s.seen[fields[3]] = true
+		case cmd == "LOGOUT":
+			fmt.Fprint(conn, "* BYE logging out\r\n")
+		}
+		s.mu.Unlock()
+		fmt.Fprintf(conn, "%s OK done\r\n", tag)
+	}
+}
+
+func TestIMAPSourceFlagsProcessedEmails(t *testing.T) {
+	report := strings.Replace(string(readFixture(t, "dsn_hard.eml")), "\n", "\r\n", -1)
+	s := newFakeIMAPServer(t, map[string]string{
+		"7": report,
+		"9": "Subject: {5}\r\n\r\nliteral like text\r\n",
+	})
+	src := &imapSource{conf: IMAPConfig{Server: s.listener.Addr().String(), Username: "bounces", Password: "secret", Mailbox: "INBOX"}}
+
+	var handled []string
+	err := src.poll(func(raw []byte) error {
+		handled = append(handled, string(raw))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 2 {
+		t.Fatalf("expected 2 handled emails, got %d", len(handled))
+	}
+	for _, raw := range handled {
+		if raw != s.messages["7"] && raw != s.messages["9"] {
+			t.Fatalf("fetched email does not match the stored one: %q", raw)
+		}
+	}
+	s.mu.Lock()
+	if !s.seen["7"] || !s.seen["9"] {
+		t.Fatalf("expected both emails flagged as seen, got %v", s.seen)
+	}
+	s.mu.Unlock()
+
+	// nothing is fetched again
+	err = src.poll(func(raw []byte) error {
+		t.Fatal("seen email handled again")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIMAPSourceKeepsEmailUnseenOnHandlerError(t *testing.T) {
+	s := newFakeIMAPServer(t, map[string]string{"7": "Subject: test\r\n\r\nbody\r\n"})
+	src := &imapSource{conf: IMAPConfig{Server: s.listener.Addr().String(), Username: "bounces", Password: "secret", Mailbox: "INBOX"}}
+
+	handlerErr := fmt.Errorf("database is down")
+	if err := src.poll(func(raw []byte) error { return handlerErr }); err != handlerErr {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen["7"] {
+		t.Fatal("email must stay unseen when handler fails")
+	}
+}
+
+func TestIMAPSourceLoginFailure(t *testing.T) {
+	s := newFakeIMAPServer(t, nil)
+	src := &imapSource{conf: IMAPConfig{Server: s.listener.Addr().String(), Username: "bounces", Password: `wrong "pass"`, Mailbox: "INBOX"}}
+	err := src.poll(func(raw []byte) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "AUTHENTICATIONFAILED") {
+		t.Fatalf("expected login failure, got %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.commands) == 0 || s.commands[0] != `LOGIN "bounces" "wrong \"pass\""` {
+		t.Fatalf("expected quoted login, got %q", s.commands)
+	}
+}
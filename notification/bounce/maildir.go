@@ -0,0 +1,52 @@
+package bounce
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maildirSource reads reports from new directory of local maildir
+// processed emails are moved into cur directory and flagged as seen, as any mail client would do
+type maildirSource struct {
+	path string
+}
+
+func (s *maildirSource) String() string {
+	return "maildir " + s.path
+}
+
+func (s *maildirSource) poll(handler func(raw []byte) error) error {
+	files, err := ioutil.ReadDir(filepath.Join(s.path, "new"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to read maildir %s", s.path)
+	}
+
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(s.path, "new", f.Name())
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read email %s", path)
+		}
+		err = handler(raw)
+		if err != nil {
+			return err
+		}
+
+		name := f.Name()
+		if !strings.Contains(name, ":2,") {
+			name += ":2,S"
+		}
+		err = os.Rename(path, filepath.Join(s.path, "cur", name))
+		if err != nil {
+			return errors.Wrapf(err, "failed to move email %s into cur directory", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package bounce
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func newTestMaildir(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, "new", name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func listDir(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+func TestMaildirSourceMovesProcessedEmails(t *testing.T) {
+	dir := newTestMaildir(t, map[string]string{
+		"1700000000.1.host":      "first",
+		"1700000001.2.host:2,":   "second",
+		".1700000002.3.host.tmp": "partial",
+	})
+	s := &maildirSource{path: dir}
+
+	var seen []string
+	err := s.poll(func(raw []byte) error {
+		seen = append(seen, string(raw))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || seen[0] != "first" || seen[1] != "second" {
+		t.Fatalf("expected both emails to be handled, got %q", seen)
+	}
+	cur := listDir(t, filepath.Join(dir, "cur"))
+	if len(cur) != 2 || cur[0] != "1700000000.1.host:2,S" || cur[1] != "1700000001.2.host:2," {
+		t.Fatalf("unexpected cur directory %q", cur)
+	}
+	if n := listDir(t, filepath.Join(dir, "new")); len(n) != 1 {
+		t.Fatalf("expected only hidden file left in new directory, got %q", n)
+	}
+}
+
+func TestMaildirSourceKeepsEmailOnHandlerError(t *testing.T) {
+	dir := newTestMaildir(t, map[string]string{"1700000000.1.host": "first"})
+	s := &maildirSource{path: dir}
+	handlerErr := errors.New("database is down")
+
+	err := s.poll(func(raw []byte) error { return handlerErr })
+	if err != handlerErr {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if n := listDir(t, filepath.Join(dir, "new")); len(n) != 1 {
+		t.Fatalf("expected email to stay in new directory, got %q", n)
+	}
+}
@@ -0,0 +1,165 @@
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	reportKindBounce    = "bounce"
+	reportKindComplaint = "complaint"
+)
+
+// headers of the report message which can contain VERP address the report was delivered to
+var deliveryHeaders = []string{"Delivered-To", "X-Original-To", "Envelope-To", "To"}
+
+// report is parsed delivery status notification (RFC 3464) or abuse feedback report (RFC 5965)
+type report struct {
+	kind string
+	// permanent failure, for complaints always true as the recipient does not want our emails
+	hard       bool
+	recipient  string
+	status     string
+	diagnostic string
+	// addresses which may contain VERP encoded return path
+	verpCandidates []string
+}
+
+var blockSeparator = regexp.MustCompile(`\r?\n[ \t]*\r?\n`)
+
+// parse raw email into report, returns notReportError for all other emails (autoreplies, spam, ...)
+func parseReport(raw []byte) (*report, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse email")
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return nil, notReportError
+	}
+
+	r := &report{}
+	for _, h := range deliveryHeaders {
+		for _, value := range msg.Header[textproto.CanonicalMIMEHeaderKey(h)] {
+			r.verpCandidates = append(r.verpCandidates, value)
+		}
+	}
+
+	switch strings.ToLower(params["report-type"]) {
+	case "delivery-status":
+		r.kind = reportKindBounce
+	case "feedback-report":
+		r.kind = reportKindComplaint
+		r.hard = true
+	default:
+		return nil, notReportError
+	}
+
+	found := false
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read report part")
+		}
+		body, err := readPart(part)
+		if err != nil {
+			return nil, err
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			found = r.parseDeliveryStatus(body)
+		case "message/feedback-report":
+			found = r.parseFeedbackReport(body)
+		case "message/rfc822", "text/rfc822-headers", "message/global", "message/global-headers":
+			// returned original message, its Return-Path is our VERP address
+			original, err := mail.ReadMessage(bytes.NewReader(append(body, '\r', '\n', '\r', '\n')))
+			if err == nil {
+				r.verpCandidates = append(r.verpCandidates, original.Header.Get("Return-Path"))
+			}
+		}
+	}
+	if !found {
+		return nil, errors.Wrap(notReportError, "report does not contain machine readable part")
+	}
+
+	return r, nil
+}
+
+func readPart(part *multipart.Part) ([]byte, error) {
+	var reader io.Reader = part
+	if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+		reader = base64.NewDecoder(base64.StdEncoding, part)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read report part")
+	}
+	return body, nil
+}
+
+// delivery status contains per-message block followed by per-recipient blocks
+// we send every email to single recipient, so first failed or delayed recipient is used
+func (r *report) parseDeliveryStatus(body []byte) bool {
+	blocks := blockSeparator.Split(strings.TrimSpace(string(body)), -1)
+	for _, block := range blocks[1:] {
+		fields, err := parseFields(block)
+		if err != nil {
+			continue
+		}
+		action := strings.ToLower(fields.Get("Action"))
+		if action != "failed" && action != "delayed" {
+			continue
+		}
+		r.recipient = stripAddressType(fields.Get("Final-Recipient"))
+		if original := stripAddressType(fields.Get("Original-Recipient")); original != "" {
+			r.recipient = original
+		}
+		r.status = fields.Get("Status")
+		r.diagnostic = stripAddressType(fields.Get("Diagnostic-Code"))
+		r.hard = action == "failed" && strings.HasPrefix(r.status, "5")
+		return true
+	}
+	return false
+}
+
+func (r *report) parseFeedbackReport(body []byte) bool {
+	fields, err := parseFields(string(body))
+	if err != nil {
+		return false
+	}
+	r.recipient = stripAddressType(fields.Get("Original-Rcpt-To"))
+	r.status = fields.Get("Feedback-Type")
+	r.diagnostic = "recipient reported email as " + r.status
+	r.verpCandidates = append(r.verpCandidates, fields.Get("Original-Mail-From"))
+	return true
+}
+
+func parseFields(block string) (textproto.MIMEHeader, error) {
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(strings.TrimSpace(block) + "\r\n\r\n")))
+	return reader.ReadMIMEHeader()
+}
+
+// remove address type from value, 'rfc822; user@example.com' -> 'user@example.com'
+func stripAddressType(value string) string {
+	if i := strings.Index(value, ";"); i >= 0 {
+		value = value[i+1:]
+	}
+	return strings.Trim(strings.TrimSpace(value), "<>")
+}
@@ -0,0 +1,127 @@
+package bounce
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestParseReport(t *testing.T) {
+	tests := []struct {
+		fixture        string
+		kind           string
+		hard           bool
+		recipient      string
+		status         string
+		diagnostic     string
+		serviceID      int
+		notificationID int
+	}{
+		{
+			fixture:        "dsn_hard.eml",
+			kind:           reportKindBounce,
+			hard:           true,
+			recipient:      "ops@example.org",
+			status:         "5.1.1",
+			diagnostic:     "550 5.1.1 User unknown",
+			serviceID:      12,
+			notificationID: 345,
+		},
+		{
+			// base64 encoded status, VERP address only in the returned message
+			fixture:        "dsn_delayed.eml",
+			kind:           reportKindBounce,
+			hard:           false,
+			recipient:      "oncall@example.net",
+			status:         "4.4.1",
+			diagnostic:     "421 4.4.1 Connection timed out",
+			serviceID:      7,
+			notificationID: 88,
+		},
+		{
+			fixture:        "arf_complaint.eml",
+			kind:           reportKindComplaint,
+			hard:           true,
+			recipient:      "boss@example.org",
+			status:         "abuse",
+			diagnostic:     "recipient reported email as abuse",
+			serviceID:      12,
+			notificationID: 346,
+		},
+	}
+	for _, tt := range tests {
+		raw := readFixture(t, tt.fixture)
+		// mail servers deliver reports with both line endings
+		variants := map[string][]byte{
+			"lf":   raw,
+			"crlf": bytes.Replace(raw, []byte("\n"), []byte("\r\n"), -1),
+		}
+		for name, raw := range variants {
+			t.Run(tt.fixture+"/"+name, func(t *testing.T) {
+				r, err := parseReport(raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.kind != tt.kind || r.hard != tt.hard {
+					t.Errorf("expected %s hard=%t, got %s hard=%t", tt.kind, tt.hard, r.kind, r.hard)
+				}
+				if r.recipient != tt.recipient || r.status != tt.status || r.diagnostic != tt.diagnostic {
+					t.Errorf("expected %s %s %q, got %s %s %q", tt.recipient, tt.status, tt.diagnostic, r.recipient, r.status, r.diagnostic)
+				}
+				serviceID, notificationID, ok := findVERP(r.verpCandidates)
+				if !ok || serviceID != tt.serviceID || notificationID != tt.notificationID {
+					t.Errorf("expected VERP %d-%d, got %d-%d (found %t) from %q", tt.serviceID, tt.notificationID, serviceID, notificationID, ok, r.verpCandidates)
+				}
+			})
+		}
+	}
+}
+
+func TestParseReportSkipsOtherEmails(t *testing.T) {
+	withoutStatus := []byte("From: postmaster@example.org\r\n" +
+		"Content-Type: multipart/report; report-type=delivery-status; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nundeliverable\r\n--b--\r\n")
+	disposition := []byte("From: ops@example.org\r\n" +
+		"Content-Type: multipart/report; report-type=disposition-notification; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nread\r\n--b--\r\n")
+
+	tests := map[string][]byte{
+		"autoreply":             readFixture(t, "autoreply.eml"),
+		"report without status": withoutStatus,
+		"read receipt":          disposition,
+	}
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseReport(raw)
+			if errors.Cause(err) != notReportError {
+				t.Fatalf("expected not report error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStripAddressType(t *testing.T) {
+	tests := map[string]string{
+		"rfc822; user@example.com":   "user@example.com",
+		"rfc822;<user@example.com>":  "user@example.com",
+		"smtp; 550 5.1.1 No mailbox": "550 5.1.1 No mailbox",
+		"user@example.com":           "user@example.com",
+		"":                           "",
+	}
+	for value, want := range tests {
+		if got := stripAddressType(value); got != want {
+			t.Errorf("stripAddressType(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package bounce
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// contactRecord holds bounce statistics for single notification contact
+type contactRecord struct {
+	ServiceID      int       `json:"serviceId"`
+	NotificationID int       `json:"notificationId"`
+	Target         string    `json:"target"`
+	HardBounces    int       `json:"hardBounces"`
+	SoftBounces    int       `json:"softBounces"`
+	Complaints     int       `json:"complaints"`
+	LastStatus     string    `json:"lastStatus"`
+	LastDiagnostic string    `json:"lastDiagnostic"`
+	LastBounce     time.Time `json:"lastBounce"`
+	LastWarning    time.Time `json:"lastWarning"`
+}
+
+// store keeps bounce records in json file, so they survive restart
+type store struct {
+	path    string
+	records map[int]*contactRecord // int is holder for notification ID
+
+	sync.Mutex
+}
+
+func loadStore(path string) (*store, error) {
+	s := &store{
+		path:    path,
+		records: map[int]*contactRecord{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to read bounce store %s", path)
+	}
+
+	var records []*contactRecord
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse bounce store %s", path)
+	}
+	for _, r := range records {
+		s.records[r.NotificationID] = r
+	}
+	return s, nil
+}
+
+// return record for the contact, new record is created if there is none
+func (s *store) get(serviceID int, notificationID int, target string) *contactRecord {
+	s.Lock()
+	defer s.Unlock()
+	r, ok := s.records[notificationID]
+	if !ok {
+		r = &contactRecord{ServiceID: serviceID, NotificationID: notificationID}
+		s.records[notificationID] = r
+	}
+	// contact target could have been changed in the meantime
+	if r.Target != target {
+		*r = contactRecord{ServiceID: serviceID, NotificationID: notificationID, Target: target}
+	}
+	return r
+}
+
+// save records into file, file is replaced atomically
+func (s *store) save() error {
+	s.Lock()
+	var records []*contactRecord
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	s.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode bounce store")
+	}
+
+	tmpPath := s.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0640)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write bounce store %s", tmpPath)
+	}
+	return os.Rename(tmpPath, s.path)
+}
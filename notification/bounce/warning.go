@@ -0,0 +1,24 @@
+package bounce
+
+import (
+	"fmt"
+
+	"github.com/exmonitor/exclient/database/spec/service"
+	"gopkg.in/gomail.v2"
+
+	"github.com/exmonitor/firefly/notification/branding"
+)
+
+// build warning email about contact which keeps bouncing
+// From header is set by email daemon
+func warningEmail(b *branding.Profile, to string, deadContact string, serviceInfo *service.Service, failures int, lastDiagnostic string) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", fmt.Sprintf("%s: notification contact %s keeps bouncing", b.Name, deadContact))
+	m.SetBody("text/plain", fmt.Sprintf(
+		"Alert emails for your service %s (ID %d) could not be delivered to %s.\n\n"+
+			"Delivery failed or was reported as spam %d times, last reason:\n%s\n\n"+
+			"Please check the address in notification settings, otherwise you may miss the next outage.\n",
+		serviceInfo.Target, serviceInfo.ID, deadContact, failures, lastDiagnostic))
+	return m
+}
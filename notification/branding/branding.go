@@ -0,0 +1,173 @@
+package branding
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// name of the default profile, used when no other profile is selected
+const DefaultName = "AlerTea"
+
+// Profile holds branding of notifications sent for single partner
+type Profile struct {
+	Name string `json:"name"`
+	// sender address, empty means the deployment default --smtp-email-from
+	// "Name <address>" is accepted, only the address is used
+	From       string `json:"from"`
+	LogoURL    string `json:"logoUrl"`
+	Footer     string `json:"footer"`
+	SupportURL string `json:"supportUrl"`
+	// address of the account owner, receives warnings about notification contacts which keep bouncing
+	Owner string `json:"owner"`
+}
+
+var defaultProfile = &Profile{Name: DefaultName}
+
+// Profiles selects branding profile for notification
+type Profiles struct {
+	defaultProfile *Profile
+	profiles       map[string]*Profile
+	// int is holder for service ID
+	services map[int]*Profile
+	// key is notification id or lower case contact target
+	contacts map[string]*Profile
+}
+
+// file format of branding profiles, services and contacts reference profiles by name
+type file struct {
+	Default  *Profile           `json:"default"`
+	Profiles map[string]Profile `json:"profiles"`
+	Services map[string]string  `json:"services"`
+	Contacts map[string]string  `json:"contacts"`
+}
+
+// Load reads branding profiles from json file
+// empty path means only the default AlerTea profile is used
+func Load(path string) (*Profiles, error) {
+	p := &Profiles{
+		defaultProfile: defaultProfile,
+		profiles:       map[string]*Profile{},
+		services:       map[int]*Profile{},
+		contacts:       map[string]*Profile{},
+	}
+	if path == "" {
+		return p, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read branding file %s", path)
+	}
+	var f file
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse branding file %s", path)
+	}
+
+	if f.Default != nil {
+		err = validateProfile("default", f.Default)
+		if err != nil {
+			return nil, err
+		}
+		p.defaultProfile = f.Default
+	}
+	for name, profile := range f.Profiles {
+		profile := profile
+		err = validateProfile(name, &profile)
+		if err != nil {
+			return nil, err
+		}
+		p.profiles[name] = &profile
+	}
+	for service, name := range f.Services {
+		serviceID, err := strconv.Atoi(service)
+		if err != nil {
+			return nil, errors.Wrapf(invalidConfigError, "service %q must be service ID", service)
+		}
+		profile, ok := p.profiles[name]
+		if !ok {
+			return nil, errors.Wrapf(invalidConfigError, "service %d references unknown branding profile %q", serviceID, name)
+		}
+		p.services[serviceID] = profile
+	}
+	for contact, name := range f.Contacts {
+		profile, ok := p.profiles[name]
+		if !ok {
+			return nil, errors.Wrapf(invalidConfigError, "contact %s references unknown branding profile %q", contact, name)
+		}
+		p.contacts[strings.ToLower(contact)] = profile
+	}
+	return p, nil
+}
+
+func validateProfile(name string, p *Profile) error {
+	if p.Name == "" {
+		return errors.Wrapf(invalidConfigError, "branding profile %q must have name", name)
+	}
+	if p.From != "" {
+		parsed, err := mail.ParseAddress(p.From)
+		if err != nil {
+			return errors.Wrapf(invalidConfigError, "branding profile %q has invalid from address %q", name, p.From)
+		}
+		// sender name is always taken from Name
+		p.From = parsed.Address
+	}
+	if p.Owner != "" {
+		parsed, err := mail.ParseAddress(p.Owner)
+		if err != nil {
+			return errors.Wrapf(invalidConfigError, "branding profile %q has invalid owner address %q", name, p.Owner)
+		}
+		p.Owner = parsed.Address
+	}
+	for _, u := range []string{p.LogoURL, p.SupportURL} {
+		if u == "" {
+			continue
+		}
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return errors.Wrapf(invalidConfigError, "branding profile %q has invalid url %q", name, u)
+		}
+	}
+	return nil
+}
+
+// Select returns branding for the notification, contact profile is preferred over service profile
+// nil Profiles always return the default profile
+func (p *Profiles) Select(serviceID int, notificationID int, target string) *Profile {
+	if p == nil {
+		return defaultProfile
+	}
+	if profile, ok := p.contacts[strconv.Itoa(notificationID)]; ok {
+		return profile
+	}
+	if profile, ok := p.contacts[strings.ToLower(target)]; ok {
+		return profile
+	}
+	if profile, ok := p.services[serviceID]; ok {
+		return profile
+	}
+	return p.defaultProfile
+}
+
+// Owner returns account owner address of the service, owner of the default profile is used when the service profile has none
+// empty string means the owner is unknown
+func (p *Profiles) Owner(serviceID int) string {
+	if p == nil {
+		return ""
+	}
+	if profile, ok := p.services[serviceID]; ok && profile.Owner != "" {
+		return profile.Owner
+	}
+	return p.defaultProfile.Owner
+}
+
+// Default returns the built-in AlerTea profile
+func Default() *Profile {
+	return defaultProfile
+}
@@ -0,0 +1,161 @@
+package branding
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const testBranding = `{
+	"default": {"name": "Firefly", "from": "Firefly Alerts <alerts@firefly.example>", "owner": "ops@firefly.example"},
+	"profiles": {
+		"acme": {"name": "Acme, Inc.", "from": "\"Acme, Inc.\" <noc@acme.example>", "owner": "Acme Admin <admin@acme.example>", "supportUrl": "https://acme.example/support"},
+		"gruene": {"name": "Grüne Überwachung", "from": "=?utf-8?q?Gr=C3=BCne?= <alarm@gruene.example>", "logoUrl": "https://gruene.example/logo.png"}
+	},
+	"services": {"12": "acme", "13": "gruene"},
+	"contacts": {"345": "gruene", "Boss@Acme.example": "gruene"}
+}`
+
+func writeBranding(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "branding.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	p, err := Load(writeBranding(t, testBranding))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acme := p.Select(12, 1, "oncall@acme.example")
+	if acme.Name != "Acme, Inc." || acme.SupportURL != "https://acme.example/support" {
+		t.Fatalf("expected acme profile, got %+v", acme)
+	}
+	// only the address is kept, sender name comes from Name
+	testCases := []struct {
+		profile *Profile
+		from    string
+		owner   string
+	}{
+		{profile: p.defaultProfile, from: "alerts@firefly.example", owner: "ops@firefly.example"},
+		{profile: acme, from: "noc@acme.example", owner: "admin@acme.example"},
+		{profile: p.profiles["gruene"], from: "alarm@gruene.example"},
+	}
+	for _, tc := range testCases {
+		if tc.profile.From != tc.from || tc.profile.Owner != tc.owner {
+			t.Errorf("profile %s expected from %q and owner %q, got %q and %q", tc.profile.Name, tc.from, tc.owner, tc.profile.From, tc.profile.Owner)
+		}
+	}
+}
+
+func TestLoadEmptyPath(t *testing.T) {
+	p, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Select(12, 345, "oncall@example.com"); got != Default() {
+		t.Errorf("expected default profile, got %+v", got)
+	}
+	if got := p.Owner(12); got != "" {
+		t.Errorf("expected unknown owner, got %q", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{name: "default without name", content: `{"default": {"from": "alerts@example.com"}}`},
+		{name: "invalid from", content: `{"profiles": {"acme": {"name": "Acme", "from": "Acme, Inc. <noc@acme.example>"}}}`},
+		{name: "invalid owner", content: `{"profiles": {"acme": {"name": "Acme", "owner": "admin"}}}`},
+		{name: "invalid logo url", content: `{"profiles": {"acme": {"name": "Acme", "logoUrl": "ftp://acme.example/logo.png"}}}`},
+		{name: "support url without host", content: `{"profiles": {"acme": {"name": "Acme", "supportUrl": "https://"}}}`},
+		{name: "service is not ID", content: `{"profiles": {"acme": {"name": "Acme"}}, "services": {"acme.example": "acme"}}`},
+		{name: "service with unknown profile", content: `{"services": {"12": "acme"}}`},
+		{name: "contact with unknown profile", content: `{"contacts": {"oncall@example.com": "acme"}}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Load(writeBranding(t, tc.content)); errors.Cause(err) != invalidConfigError {
+				t.Fatalf("expected invalid config error, got %v", err)
+			}
+		})
+	}
+
+	if _, err := Load(writeBranding(t, `{"profiles": `)); err == nil {
+		t.Error("expected error for malformed json")
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	p, err := Load(writeBranding(t, testBranding))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name           string
+		serviceID      int
+		notificationID int
+		target         string
+		want           string
+	}{
+		{name: "service profile", serviceID: 12, notificationID: 1, target: "oncall@acme.example", want: "Acme, Inc."},
+		{name: "contact by notification id wins over service", serviceID: 12, notificationID: 345, target: "oncall@acme.example", want: "Grüne Überwachung"},
+		{name: "contact by address, case insensitive", serviceID: 12, notificationID: 1, target: "boss@ACME.example", want: "Grüne Überwachung"},
+		{name: "deployment default", serviceID: 99, notificationID: 1, target: "oncall@example.com", want: "Firefly"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := p.Select(tc.serviceID, tc.notificationID, tc.target); got.Name != tc.want {
+				t.Errorf("expected profile %q, got %q", tc.want, got.Name)
+			}
+		})
+	}
+
+	var nilProfiles *Profiles
+	if got := nilProfiles.Select(12, 345, "oncall@example.com"); got != Default() || got.Name != DefaultName {
+		t.Errorf("expected built-in profile for nil profiles, got %+v", got)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	p, err := Load(writeBranding(t, testBranding))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name      string
+		serviceID int
+		want      string
+	}{
+		{name: "service profile owner", serviceID: 12, want: "admin@acme.example"},
+		{name: "service profile without owner", serviceID: 13, want: "ops@firefly.example"},
+		{name: "service without profile", serviceID: 99, want: "ops@firefly.example"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := p.Owner(tc.serviceID); got != tc.want {
+				t.Errorf("expected owner %q, got %q", tc.want, got)
+			}
+		})
+	}
+
+	var nilProfiles *Profiles
+	if got := nilProfiles.Owner(12); got != "" {
+		t.Errorf("expected unknown owner for nil profiles, got %q", got)
+	}
+}
@@ -0,0 +1,5 @@
+package branding
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
@@ -0,0 +1,204 @@
+package discord
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/action"
+	"github.com/exmonitor/firefly/notification/payload"
+	"github.com/exmonitor/firefly/service/state"
+)
+
+const testWebhookPath = "/api/webhooks/123456/secret-token"
+
+// fakeWebhook is local Discord webhook, it answers with the statuses in order, the last one is repeated
+type fakeWebhook struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	messages []message
+	statuses []int
+}
+
+func newFakeWebhook(t *testing.T, statuses ...int) *fakeWebhook {
+	f := &fakeWebhook{statuses: statuses}
+	// Discord webhooks are https only
+	f.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var m message
+		if r.URL.Path != testWebhookPath || json.Unmarshal(body, &m) != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Unknown Webhook", "code": 10015}`))
+			return
+		}
+		f.mu.Lock()
+		i := len(f.messages)
+		if i >= len(f.statuses) {
+			i = len(f.statuses) - 1
+		}
+		f.messages = append(f.messages, m)
+		f.mu.Unlock()
+		w.WriteHeader(f.statuses[i])
+		if f.statuses[i] == http.StatusTooManyRequests {
+			w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.1, "global": false}`))
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeWebhook) received() []message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]message(nil), f.messages...)
+}
+
+func newTestClient(t *testing.T, f *fakeWebhook, retries int, links *action.Links) *Client {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(Config{Timeout: time.Second, Retries: retries, ActionLinks: links, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.client = f.Client()
+	return c
+}
+
+func failedPayload() *payload.Payload {
+	return &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http",
+		Target: "https://example.com/*admin*", FailMessage: "HTTP 503 ```@everyone```"}
+}
+
+func TestSendEmbed(t *testing.T) {
+	f := newFakeWebhook(t, http.StatusNoContent)
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := action.New(action.Config{PublicURL: "https://firefly.example.com", Secret: "0123456789abcdef", SilenceDuration: time.Hour,
+		IncidentActionChan: make(chan state.IncidentAction, 1), Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, f, 0, links)
+	start := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+
+	if err := c.Send(f.URL+testWebhookPath, Notification{NotificationID: 3, IncidentID: "7-1", IncidentStart: start, Payload: failedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	resolved := &payload.Payload{ServiceID: 7, Status: payload.StatusResolved, Host: "web1", ServiceType: "http", Target: "https://example.com"}
+	if err := c.Send(f.URL+testWebhookPath, Notification{NotificationID: 3, IncidentID: "7-1", IncidentStart: start, Payload: resolved}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := f.received()
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(messages))
+	}
+	m := messages[0]
+	if m.AllowedMentions.Parse == nil || len(m.AllowedMentions.Parse) != 0 {
+		t.Fatalf("expected mentions disabled, got %+v", m.AllowedMentions)
+	}
+	e := m.Embeds[0]
+	if e.Color != colorFailed || e.Title != "\U0001F534 CRITICAL http check of web1 has failed" || e.Timestamp != "2024-03-05T14:30:00Z" {
+		t.Fatalf("unexpected failed embed %+v", e)
+	}
+	ackURL, silenceURL := links.URLs(7, 3, "7-1")
+	wantDescription := "```\nHTTP 503 '''@everyone'''\n```\n[Acknowledge](" + ackURL + ") · [Silence 1h](" + silenceURL + ")"
+	if e.Description != wantDescription {
+		t.Fatalf("expected description\n%s\ngot\n%s", wantDescription, e.Description)
+	}
+	if len(e.Fields) == 0 || e.Fields[0].Name != "Target" || e.Fields[0].Value != `https://example.com/\*admin\*` {
+		t.Fatalf("expected escaped target field, got %+v", e.Fields)
+	}
+	if e.Footer == nil || e.Footer.Text != "Incident 7-1" {
+		t.Fatalf("unexpected footer %+v", e.Footer)
+	}
+
+	e = messages[1].Embeds[0]
+	if e.Color != colorResolved || strings.Contains(e.Description, "Acknowledge") || !strings.HasPrefix(e.Footer.Text, "Incident 7-1, lasted ") {
+		t.Fatalf("unexpected resolved embed %+v", e)
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		path         string
+		retries      int
+		wantMessages int
+		wantStatus   int
+		wantMessage  string
+	}{
+		{name: "rate limit is retried", statuses: []int{http.StatusTooManyRequests, http.StatusNoContent}, retries: 1, wantMessages: 2},
+		{name: "retries run out", statuses: []int{http.StatusBadGateway}, retries: 1, wantMessages: 2, wantStatus: http.StatusBadGateway},
+		{name: "removed webhook is not retried", statuses: []int{http.StatusNoContent}, path: "/api/webhooks/123456/removed", retries: 3, wantStatus: http.StatusNotFound, wantMessage: "Unknown Webhook"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeWebhook(t, tt.statuses...)
+			c := newTestClient(t, f, tt.retries, nil)
+			path := testWebhookPath
+			if tt.path != "" {
+				path = tt.path
+			}
+			err := c.Send(f.URL+path, Notification{NotificationID: 3, IncidentID: "7-1", Payload: failedPayload()})
+			if got := len(f.received()); got != tt.wantMessages {
+				t.Fatalf("expected %d messages, got %d", tt.wantMessages, got)
+			}
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			e, ok := errors.Cause(err).(*StatusError)
+			if !ok || e.StatusCode != tt.wantStatus {
+				t.Fatalf("expected HTTP %d, got %v", tt.wantStatus, err)
+			}
+			if tt.wantMessage != "" && e.Message != tt.wantMessage {
+				t.Fatalf("expected message %q, got %q", tt.wantMessage, e.Message)
+			}
+		})
+	}
+}
+
+func TestSendErrorHidesWebhookToken(t *testing.T) {
+	f := newFakeWebhook(t, http.StatusNoContent)
+	c := newTestClient(t, f, 0, nil)
+	target := f.URL + testWebhookPath
+	f.Close()
+
+	err := c.Send(target, Notification{NotificationID: 3, Payload: failedPayload()})
+	if err == nil {
+		t.Fatal("expected error of closed webhook")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Fatalf("error must not contain the webhook token: %v", err)
+	}
+}
+
+func TestSendRequiresHTTPS(t *testing.T) {
+	f := newFakeWebhook(t, http.StatusNoContent)
+	c := newTestClient(t, f, 0, nil)
+	for _, target := range []string{"http://discord.com" + testWebhookPath, "discord.com" + testWebhookPath, ""} {
+		if err := c.Send(target, Notification{Payload: failedPayload()}); errors.Cause(err) != invalidTargetError {
+			t.Errorf("%q: expected invalid target, got %v", target, err)
+		}
+	}
+	if len(f.received()) != 0 {
+		t.Fatal("invalid targets must not be requested")
+	}
+}
@@ -0,0 +1,121 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/exmonitor/firefly/notification/action"
+)
+
+// embed colours, same as Slack attachments
+const (
+	colorFailed   = 0xE01E5A
+	colorResolved = 0x2EB67D
+)
+
+// limits of Discord embeds, longer messages are refused
+const (
+	maxTitleLength       = 256
+	maxDescriptionLength = 4096
+	maxFieldValueLength  = 1024
+	maxFields            = 25
+)
+
+type message struct {
+	Username string  `json:"username,omitempty"`
+	Embeds   []embed `json:"embeds"`
+	// nothing is pinged, fail messages must not mention @everyone
+	AllowedMentions allowedMentions `json:"allowed_mentions"`
+}
+
+type allowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+type embed struct {
+	Title       string  `json:"title"`
+	Description string  `json:"description,omitempty"`
+	Color       int     `json:"color"`
+	Fields      []field `json:"fields,omitempty"`
+	Footer      *footer `json:"footer,omitempty"`
+	Timestamp   string  `json:"timestamp,omitempty"`
+}
+
+type field struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type footer struct {
+	Text string `json:"text"`
+}
+
+// characters with special meaning in Discord markdown
+var escaper = strings.NewReplacer("\\", "\\\\", "*", "\\*", "_", "\\_", "~", "\\~", "`", "\\`", "|", "\\|", ">", "\\>", "[", "\\[", "]", "\\]", "#", "\\#")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// build embed with check details, action links are added to FAIL embeds when set
+func alertMessage(n Notification, ackURL string, silenceURL string, silenceDuration time.Duration) message {
+	p := n.Payload
+	e := embed{
+		Title: truncate(fmt.Sprintf("\U0001F7E2 %s %s check of %s has recovered", p.Status, p.ServiceType, p.Host), maxTitleLength),
+		Color: colorResolved,
+	}
+	if p.Failed {
+		e.Title = truncate(fmt.Sprintf("\U0001F534 %s %s check of %s has failed", p.Status, p.ServiceType, p.Host), maxTitleLength)
+		e.Color = colorFailed
+	}
+
+	var description []string
+	if p.Failed && p.FailMessage != "" {
+		// code block ends at the first triple backtick, so backticks of the message are replaced
+		description = append(description, "```\n"+truncate(strings.Replace(p.FailMessage, "`", "'", -1), maxDescriptionLength-200)+"\n```")
+	}
+	if p.Failed && ackURL != "" {
+		description = append(description, fmt.Sprintf("[Acknowledge](%s) · [Silence %s](%s)", ackURL, action.FormatDuration(silenceDuration), silenceURL))
+	}
+	e.Description = strings.Join(description, "\n")
+
+	if p.Target != "" && p.Target != p.Host {
+		e.Fields = append(e.Fields, field{Name: "Target", Value: truncate(escape(p.Target), maxFieldValueLength)})
+	}
+	for _, f := range p.Fields() {
+		if len(e.Fields) == maxFields {
+			break
+		}
+		e.Fields = append(e.Fields, field{Name: f.Name, Value: truncate(escape(f.Value), maxFieldValueLength), Inline: true})
+	}
+
+	// Discord shows the timestamp next to the footer in local time of the reader
+	e.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	if n.IncidentID != "" {
+		info := "Incident " + n.IncidentID
+		if !n.IncidentStart.IsZero() {
+			if p.Failed {
+				e.Timestamp = n.IncidentStart.UTC().Format(time.RFC3339)
+			} else {
+				info += ", lasted " + time.Since(n.IncidentStart).Round(time.Second).String()
+			}
+		}
+		e.Footer = &footer{Text: info}
+	}
+
+	return message{
+		Username:        "firefly",
+		Embeds:          []embed{e},
+		AllowedMentions: allowedMentions{Parse: []string{}},
+	}
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-3]) + "..."
+}
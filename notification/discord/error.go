@@ -0,0 +1,7 @@
+package discord
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var invalidTargetError error = errors.New("invalid Discord webhook URL")
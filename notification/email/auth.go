@@ -0,0 +1,73 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+)
+
+const (
+	authPlain   = "plain"
+	authLogin   = "login"
+	authCRAMMD5 = "cram-md5"
+	authNone    = "none"
+)
+
+func validAuthMechanism(mechanism string) bool {
+	switch mechanism {
+	case authPlain, authLogin, authCRAMMD5, authNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// return smtp.Auth for configured mechanism, nil means no authentication
+func smtpAuth(conf SMTPConfig) smtp.Auth {
+	switch conf.AuthMechanism {
+	case authPlain:
+		return smtp.PlainAuth("", conf.Username, conf.Password, conf.Server)
+	case authLogin:
+		return &loginAuth{username: conf.Username, password: conf.Password, host: conf.Server}
+	case authCRAMMD5:
+		return smtp.CRAMMD5Auth(conf.Username, conf.Password)
+	default:
+		return nil
+	}
+}
+
+// loginAuth implements LOGIN authentication mechanism, which is not part of net/smtp
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// same rules as smtp.PlainAuth, never send credentials over unencrypted connection to remote host
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, unencryptedAuthError
+	}
+	if server.Name != a.host {
+		return "", nil, fmt.Errorf("wrong host name %s", server.Name)
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch {
+	case bytes.EqualFold(fromServer, []byte("Username:")):
+		return []byte(a.username), nil
+	case bytes.EqualFold(fromServer, []byte("Password:")):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
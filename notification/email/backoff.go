@@ -1,64 +1,27 @@
 package email
 
-
 import (
 	"time"
 
 	"github.com/cenkalti/backoff"
-	"github.com/exmonitor/exlogger"
-)
-
-const (
-	maxRetry = 15
 )
 
-var backoffMin = time.Millisecond * 500
-var backoffMax = time.Second * 20
-var maxElapsedTime = time.Minute * 120
-
-type emailBackoff struct {
-	maxRetries uint64
-	retryCount uint64
-	underlying backoff.BackOff
-	logger *exlogger.Logger
-}
-
-func NewEmailBackoff(logger *exlogger.Logger) backoff.BackOff {
+// how long the circuit breaker stays open after SMTP server failed
+var backoffMin = time.Second * 5
+var backoffMax = time.Minute * 5
 
+// backoff used by circuit breaker to compute how long should be SMTP server skipped,
+// it never stops as we never want to give up on SMTP server completely
+func newBreakerBackoff() backoff.BackOff {
 	b := &backoff.ExponentialBackOff{
 		InitialInterval:     backoffMin,
 		RandomizationFactor: backoff.DefaultRandomizationFactor,
 		Multiplier:          backoff.DefaultMultiplier,
 		MaxInterval:         backoffMax,
-		MaxElapsedTime:      maxElapsedTime,
+		MaxElapsedTime:      0,
 		Clock:               backoff.SystemClock,
 	}
-
 	b.Reset()
 
-	s := &emailBackoff{
-		maxRetries: maxRetry,
-		retryCount: 0,
-		underlying: b,
-		logger:     logger,
-	}
-
-	return s
+	return b
 }
-
-func (b *emailBackoff) NextBackOff() time.Duration {
-	if b.retryCount+1 >= b.maxRetries {
-		return backoff.Stop
-	}
-	b.retryCount++
-
-	b.logger.Log("retrying email request  %d/%d", b.retryCount, b.maxRetries)
-
-	return b.underlying.NextBackOff()
-}
-
-func (b *emailBackoff) Reset() {
-	b.retryCount = 0
-	b.underlying.Reset()
-}
-
@@ -0,0 +1,74 @@
+package email
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+
+	// amount of consecutive failures needed to open the circuit
+	breakerFailureThreshold = 3
+)
+
+// circuitBreaker protects daemon from waiting on SMTP server which is down
+// closed - server is used normally
+// open - server is skipped until openUntil
+// half-open - open period elapsed, one attempt is allowed, success closes the circuit, failure opens it again for longer time
+type circuitBreaker struct {
+	state     string
+	failures  int
+	openUntil time.Time
+	backoff   backoff.BackOff
+	sync.Mutex
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		state:   breakerClosed,
+		backoff: newBreakerBackoff(),
+	}
+}
+
+// check if server can be used right now
+func (c *circuitBreaker) allow() bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.state == breakerOpen && time.Now().After(c.openUntil) {
+		c.state = breakerHalfOpen
+	}
+	return c.state != breakerOpen
+}
+
+func (c *circuitBreaker) success() {
+	c.Lock()
+	defer c.Unlock()
+	c.state = breakerClosed
+	c.failures = 0
+	c.backoff.Reset()
+}
+
+// record failure, returns true if the circuit has been opened by this failure
+func (c *circuitBreaker) failure() bool {
+	c.Lock()
+	defer c.Unlock()
+	c.failures++
+	if c.state == breakerHalfOpen || c.failures >= breakerFailureThreshold {
+		c.state = breakerOpen
+		c.openUntil = time.Now().Add(c.backoff.NextBackOff())
+		return true
+	}
+	return false
+}
+
+// return current state and time until the circuit is open
+func (c *circuitBreaker) status() (string, int, time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	return c.state, c.failures, c.openUntil
+}
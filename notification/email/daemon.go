@@ -1,12 +1,26 @@
 package email
 
 import (
-	"crypto/tls"
-	"github.com/cenkalti/backoff"
+	"fmt"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/exmonitor/exlogger"
 	"github.com/pkg/errors"
-	"gopkg.in/gomail.v2"
-	"time"
+
+	"github.com/exmonitor/firefly/notification/branding"
+)
+
+const (
+	// how often is the queue retried when all SMTP servers are down
+	queueRetryInterval = time.Second * 10
+	// how often is health reported into log while daemon is unhealthy
+	healthReportInterval = time.Minute
+
+	// email which could not be delivered in this time is moved to dead letter store
+	maxQueueAge = time.Hour * 2
 )
 
 type SMTPConfig struct {
@@ -15,31 +29,88 @@ type SMTPConfig struct {
 	Username string
 	Password string
 	SMTPFrom string
+
+	// one of tls, starttls, none
+	TLSMode string
+	// one of plain, login, cram-md5, none
+	AuthMechanism string
+	// PEM bundle used for server certificate verification, system pool is used when empty
+	CAFile string
+	// sha256 fingerprint of server certificate, replaces CA verification when set
+	TLSFingerprint string
+	TLSSkipVerify  bool
+	ClientCertFile string
+	ClientKeyFile  string
 }
 
 type DaemonConfig struct {
+	// one of smtp, sendmail, maildir, file
+	Transport string
+	// path to sendmail compatible binary, used by sendmail transport
+	SendmailPath string
+	// maildir where emails are delivered, used by maildir transport
+	MaildirPath string
+	// directory where emails are written as .eml files, used by file transport
+	FileDir string
+
 	SMTPConfig SMTPConfig
+	// ordered list of SMTP servers used when the previous one is unavailable
+	FailoverSMTPConfigs []SMTPConfig
+	// directory for emails which could not be delivered
+	DeadLetterDir string
+	// amount of concurrent SMTP workers, each has its own connection to SMTP server
+	Workers int
+	// maximum amount of emails waiting for delivery, oldest recovery email is moved to dead letter store on overflow
+	QueueSize int
+	// DKIM keys used for signing, selected by 'From' address
+	DKIMConfigs []DKIMConfig
+	// encode service and notification id into return path, so bounces can be processed
+	VERP bool
 
-	EmailChan chan *gomail.Message
+	EmailChan chan *Envelope
 	Logger    *exlogger.Logger
 }
 
 func NewDaemon(conf DaemonConfig) (*Daemon, error) {
-	if conf.SMTPConfig.Server == "" {
-		return nil, errors.Wrap(invalidConfigError, "conf.SMTPConfig.Server must not be empty")
+	if !validTransport(conf.Transport) {
+		return nil, errors.Wrapf(invalidConfigError, "conf.Transport %q is not supported", conf.Transport)
 	}
-	if conf.SMTPConfig.Port == 0 {
-		return nil, errors.Wrap(invalidConfigError, "conf.SMTPConfig.Port must not be zero")
+	if conf.Transport == transportSMTP {
+		if err := validateSMTPConfig(conf.SMTPConfig, "conf.SMTPConfig"); err != nil {
+			return nil, err
+		}
+		for i, failoverConfig := range conf.FailoverSMTPConfigs {
+			if err := validateSMTPConfig(failoverConfig, fmt.Sprintf("conf.FailoverSMTPConfigs[%d]", i)); err != nil {
+				return nil, err
+			}
+		}
 	}
-	if conf.SMTPConfig.Username == "" {
-		return nil, errors.Wrap(invalidConfigError, "conf.SMTPConfig.Username must not be empty")
+	if conf.Transport == transportSendmail && conf.SendmailPath == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.SendmailPath must not be empty")
 	}
-	if conf.SMTPConfig.Password == "" {
-		return nil, errors.Wrap(invalidConfigError, "conf.SMTPConfig.Password must not be empty")
+	if conf.Transport == transportMaildir && conf.MaildirPath == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.MaildirPath must not be empty")
+	}
+	if conf.Transport == transportFile && conf.FileDir == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.FileDir must not be empty")
+	}
+	for i, dkimConfig := range conf.DKIMConfigs {
+		if err := validateDKIMConfig(dkimConfig, fmt.Sprintf("conf.DKIMConfigs[%d]", i)); err != nil {
+			return nil, err
+		}
 	}
 	if conf.SMTPConfig.SMTPFrom == "" {
 		return nil, errors.Wrap(invalidConfigError, "conf.SMTPConfig.SMTPFrom must not be empty")
 	}
+	if conf.DeadLetterDir == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.DeadLetterDir must not be empty")
+	}
+	if conf.Workers <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Workers must be positive number")
+	}
+	if conf.QueueSize <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.QueueSize must be positive number")
+	}
 	if conf.Logger == nil {
 		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
 	}
@@ -48,113 +119,234 @@ func NewDaemon(conf DaemonConfig) (*Daemon, error) {
 	}
 
 	newDaemon := &Daemon{
-		smtpConfig: conf.SMTPConfig,
-		emailChan:  conf.EmailChan,
-		logger:     conf.Logger,
+		transport:           conf.Transport,
+		sendmailPath:        conf.SendmailPath,
+		maildirPath:         conf.MaildirPath,
+		fileDir:             conf.FileDir,
+		smtpConfig:          conf.SMTPConfig,
+		failoverSMTPConfigs: conf.FailoverSMTPConfigs,
+		deadLetterDir:       conf.DeadLetterDir,
+		workers:             conf.Workers,
+		dkimConfigs:         conf.DKIMConfigs,
+		verp:                conf.VERP,
+		queue:               newEmailQueue(conf.QueueSize),
+		emailChan:           conf.EmailChan,
+		logger:              conf.Logger,
 	}
 	return newDaemon, nil
 }
 
+func validateSMTPConfig(conf SMTPConfig, name string) error {
+	if conf.Server == "" {
+		return errors.Wrapf(invalidConfigError, "%s.Server must not be empty", name)
+	}
+	if conf.Port == 0 {
+		return errors.Wrapf(invalidConfigError, "%s.Port must not be zero", name)
+	}
+	if !validTLSMode(conf.TLSMode) {
+		return errors.Wrapf(invalidConfigError, "%s.TLSMode %q is not supported", name, conf.TLSMode)
+	}
+	if !validAuthMechanism(conf.AuthMechanism) {
+		return errors.Wrapf(invalidConfigError, "%s.AuthMechanism %q is not supported", name, conf.AuthMechanism)
+	}
+	if conf.AuthMechanism != authNone && conf.Username == "" {
+		return errors.Wrapf(invalidConfigError, "%s.Username must not be empty", name)
+	}
+	if conf.AuthMechanism != authNone && conf.Password == "" {
+		return errors.Wrapf(invalidConfigError, "%s.Password must not be empty", name)
+	}
+	if (conf.ClientCertFile == "") != (conf.ClientKeyFile == "") {
+		return errors.Wrapf(invalidConfigError, "%s.ClientCertFile and %s.ClientKeyFile must be set together", name, name)
+	}
+	return nil
+}
+
 type Daemon struct {
-	smtpConfig SMTPConfig
+	transport           string
+	sendmailPath        string
+	maildirPath         string
+	fileDir             string
+	smtpConfig          SMTPConfig
+	failoverSMTPConfigs []SMTPConfig
+	deadLetterDir       string
+	workers             int
+	dkimConfigs         []DKIMConfig
+	verp                bool
 
-	emailChan chan *gomail.Message
+	emailChan chan *Envelope
 	logger    *exlogger.Logger
+
+	// internals
+	servers     []*server
+	queue       *emailQueue
+	deadLetter  *deadLetterStore
+	deadLetters int
+	// DKIM signers by 'From' address or domain
+	dkimSigners map[string]*dkimSigner
+	// guards deadLetters and health fields of servers
+	sync.Mutex
+}
+
+// server holds circuit breaker state for single transport, it is shared by all workers
+type server struct {
+	address     string
+	transport   transport
+	breaker     *circuitBreaker
+	lastError   error
+	lastSuccess time.Time
 }
 
-func (d *Daemon) StartDaemon() {
-	/*
-		// CRAM md5 AUTH
-		auth := smtp.CRAMMD5Auth(d.smtpConfig.Username, d.smtpConfig.Password)
-		// skip tls as for now we use self signed TLS fro postfix
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: true,
+func (d *Daemon) StartDaemon() error {
+	transports, err := d.buildTransports()
+	if err != nil {
+		return err
+	}
+	for _, t := range transports {
+		d.servers = append(d.servers, &server{
+			address:   t.String(),
+			transport: t,
+			breaker:   newCircuitBreaker(),
+		})
+	}
+
+	d.dkimSigners = map[string]*dkimSigner{}
+	for _, conf := range d.dkimConfigs {
+		signer, err := newDKIMSigner(conf)
+		if err != nil {
+			return errors.Wrapf(err, "failed to prepare DKIM signer for %s", conf.From)
 		}
-		// prepare smtp Dialer
-		smtpDialer := &gomail.Dialer{
-			Host:      d.smtpConfig.Server,
-			Port:      d.smtpConfig.Port,
-			SSL:       true,
-			TLSConfig: tlsConfig,
-			Auth:      auth,
-		}*/
-	smtpDialer := gomail.NewPlainDialer(d.smtpConfig.Server, d.smtpConfig.Port, d.smtpConfig.Username, d.smtpConfig.Password)
-	smtpDialer.TLSConfig = &tls.Config{
-		InsecureSkipVerify: true,
-	}
-
-	// run daemon
-	go d.runDaemon(smtpDialer)
+		d.dkimSigners[strings.ToLower(conf.From)] = signer
+	}
+
+	deadLetter, err := newDeadLetterStore(d.deadLetterDir)
+	if err != nil {
+		return err
+	}
+	d.deadLetter = deadLetter
+
+	// run workers and daemon
+	for i := 0; i < d.workers; i++ {
+		go newWorker(i, d).run()
+	}
+	go d.runDaemon()
+	return nil
 }
 
-// run email daemon, which will send any email sent to emalChan
-// if there is no email sent in last 30 sec, connection to SMTP will close and reopen again when new email is sent via channel
-func (d *Daemon) runDaemon(smtpDialer *gomail.Dialer) {
-	d.logger.Log("started email daemon")
+// run email daemon, which moves every email sent to emailChan into the queue for SMTP workers
+// emails are queued and retried while all SMTP servers are unavailable, so the outage never blocks other notifications
+func (d *Daemon) runDaemon() {
+	d.logger.Log("started email daemon with %d workers", d.workers)
+
+	healthTicker := time.NewTicker(healthReportInterval)
+	defer healthTicker.Stop()
 
-	var s gomail.SendCloser
-	var err error
-	open := false
 	for {
 		select {
 		// wait for message
-		case m, ok := <-d.emailChan:
+		case e, ok := <-d.emailChan:
 			// if channel is closed lets exit whole routine
 			if !ok {
 				d.logger.Log("emailChan is closed, stopping email daemon")
-				return
-			}
-			// if connection to SMTP server is closed, open it
-			if !open {
-				// prepare backoff
-				o := func() error {
-					  s, err = smtpDialer.Dial()
-					  return err
+				for _, q := range d.queue.close() {
+					d.moveToDeadLetter(q, daemonStoppedError)
 				}
-				// execute login via backoff
-				err = backoff.Retry(o, NewEmailBackoff(d.logger))
-				if err != nil {
-					panic(errors.Wrapf(err, "failed to connect to SMTP server"))
-				}
-
-				open = true
+				return
 			}
 
-			// assign 'From' email address
-			m.SetHeader("From", fromHeader(d.smtpConfig.SMTPFrom, emailName))
-			m.SetHeader("Return-Path", d.smtpConfig.SMTPFrom)
-
-			// try send email with backoff
-			o := func() error {
-				err = gomail.Send(s, m)
-				return err
+			dropped := d.queue.push(d.prepare(e))
+			if dropped != nil {
+				d.moveToDeadLetter(dropped, errors.Wrap(queueFullError, "email was dropped from queue"))
 			}
 
-			// execute send email via backoff
-			err = backoff.Retry(o, NewEmailBackoff(d.logger))
-			if err != nil {
-				d.logger.LogError(err, "failed to send email to %s", m.GetHeader("To"))
+		case <-healthTicker.C:
+			if h := d.Health(); !h.Healthy {
+				d.logger.Log("email daemon is unhealthy: %s", h.String())
 			} else {
-				d.logger.LogDebug("sent email to %s", m.GetHeader("To"))
+				d.logger.LogDebug("email daemon health: %s", h.String())
 			}
+		}
+	}
+}
 
-		// Close the connection to the SMTP server if no email was sent in
-		// the last 30 seconds.
-		case <-time.After(30 * time.Second):
-			if open {
-				// close connection
-				o := func() error {
-					err = s.Close()
-					return err
-				}
+// set sender and thread headers and sign the email, so it is ready for delivery
+func (d *Daemon) prepare(e *Envelope) *queuedEmail {
+	// assign 'From' email address, return path stays on our domain so bounces reach us
+	// name is encoded by gomail, so non-ASCII names and names with commas stay valid address
+	from, name := d.sender(e)
+	e.Message.SetAddressHeader("From", from, name)
+	e.Message.SetHeader("Return-Path", d.returnPath(e))
+	if e.ThreadID != "" {
+		setThreadHeaders(e.Message, e.ThreadID, e.FollowUp, from)
+	}
 
-				// execute send email via backoff
-				err = backoff.Retry(o, NewEmailBackoff(d.logger))
-				if err != nil {
-					panic(err)
-				}
-				open = false
-			}
+	q := &queuedEmail{msg: e.Message, critical: e.Critical, queuedAt: time.Now()}
+	// sign only after all headers are set, any later change would break the signature
+	if signer := d.dkimSigner(from); signer != nil {
+		raw, err := signer.sign(e.Message)
+		if err != nil {
+			d.logger.LogError(err, "failed to DKIM sign email to %s, sending unsigned", e.Message.GetHeader("To"))
+		} else {
+			q.raw = raw
 		}
 	}
+	return q
+}
+
+// sender address and name from the branding profile of the email
+func (d *Daemon) sender(e *Envelope) (string, string) {
+	from, name := d.smtpConfig.SMTPFrom, branding.DefaultName
+	if e.Branding != nil {
+		if e.Branding.From != "" {
+			from = e.Branding.From
+		}
+		if e.Branding.Name != "" {
+			name = e.Branding.Name
+		}
+	}
+	return from, name
+}
+
+// return path is used as envelope sender, bounces are delivered to this address
+func (d *Daemon) returnPath(e *Envelope) string {
+	if d.verp && e.ServiceID > 0 {
+		return VERPAddress(d.smtpConfig.SMTPFrom, e.ServiceID, e.NotificationID)
+	}
+	return d.smtpConfig.SMTPFrom
+}
+
+// find DKIM signer for the 'From' address, signer for exact address is preferred over signer for whole domain
+func (d *Daemon) dkimSigner(from string) *dkimSigner {
+	from = strings.ToLower(from)
+	if signer, ok := d.dkimSigners[from]; ok {
+		return signer
+	}
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		return d.dkimSigners[from[at+1:]]
+	}
+	return nil
+}
+
+func (d *Daemon) moveToDeadLetter(q *queuedEmail, reason error) {
+	d.Lock()
+	d.deadLetters++
+	d.Unlock()
+
+	err := d.deadLetter.store(q, reason)
+	if err != nil {
+		d.logger.LogError(err, "failed to store undeliverable email to %s in dead letter store, email is lost", q.msg.GetHeader("To"))
+		return
+	}
+	d.logger.LogError(reason, "email to %s could not be delivered after %d attempts, moved to dead letter store %s", q.msg.GetHeader("To"), q.attempts, d.deadLetterDir)
+}
+
+// SMTP 5xx replies and errors marked by transport as permanent, retrying them makes no sense
+func isPermanentError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *textproto.Error:
+		return e.Code >= 500
+	case *permanentError:
+		return true
+	default:
+		return false
+	}
 }
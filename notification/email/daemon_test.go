@@ -0,0 +1,52 @@
+package email
+
+import (
+	"bytes"
+	"net/mail"
+	"testing"
+
+	"github.com/exmonitor/firefly/notification/branding"
+)
+
+func TestPrepareFromHeader(t *testing.T) {
+	testCases := []struct {
+		name        string
+		branding    *branding.Profile
+		wantName    string
+		wantAddress string
+	}{
+		{name: "deployment default", wantName: branding.DefaultName, wantAddress: "alerts@alertea.example"},
+		{name: "branding name", branding: &branding.Profile{Name: "Acme Monitoring"}, wantName: "Acme Monitoring", wantAddress: "alerts@alertea.example"},
+		{name: "branding sender", branding: &branding.Profile{Name: "Acme", From: "noc@acme.example"}, wantName: "Acme", wantAddress: "noc@acme.example"},
+		{name: "non-ASCII name", branding: &branding.Profile{Name: "Grüne Überwachung", From: "alarm@gruene.example"}, wantName: "Grüne Überwachung", wantAddress: "alarm@gruene.example"},
+		{name: "name with comma", branding: &branding.Profile{Name: "Acme, Inc."}, wantName: "Acme, Inc.", wantAddress: "alerts@alertea.example"},
+		{name: "name with quotes and brackets", branding: &branding.Profile{Name: `Ops "24/7" <NOC>`}, wantName: `Ops "24/7" <NOC>`, wantAddress: "alerts@alertea.example"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDaemon(t)
+			d.smtpConfig.SMTPFrom = "alerts@alertea.example"
+
+			q := d.prepare(&Envelope{Message: testMessage("oncall@example.com"), Critical: true, Branding: tc.branding})
+			var buf bytes.Buffer
+			if _, err := q.content().WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			msg, err := mail.ReadMessage(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := msg.Header.AddressList("From")
+			if err != nil {
+				t.Fatalf("invalid From header %q: %s", msg.Header.Get("From"), err)
+			}
+			if len(from) != 1 || from[0].Name != tc.wantName || from[0].Address != tc.wantAddress {
+				t.Fatalf("expected From %q <%s>, got %v", tc.wantName, tc.wantAddress, from)
+			}
+			if got := msg.Header.Get("Return-Path"); got != "alerts@alertea.example" {
+				t.Errorf("expected deployment return path, got %s", got)
+			}
+		})
+	}
+}
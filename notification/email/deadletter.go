@@ -0,0 +1,66 @@
+package email
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// deadLetterStore keeps emails which could not be delivered
+// every email is saved as separate .eml file together with .err file containing the reason,
+// so they can be inspected and resent manually
+type deadLetterStore struct {
+	dir string
+}
+
+func newDeadLetterStore(dir string) (*deadLetterStore, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create dead letter directory %s", dir)
+	}
+	return &deadLetterStore{dir: dir}, nil
+}
+
+func (s *deadLetterStore) store(q *queuedEmail, reason error) error {
+	name := fmt.Sprintf("%s-%s", time.Now().Format("20060102T150405.000000000"), sanitizeFileName(strings.Join(q.msg.GetHeader("To"), ",")))
+
+	err := writeMessageFile(filepath.Join(s.dir, name+".eml"), q.content())
+	if err != nil {
+		return errors.Wrap(err, "failed to write dead letter file")
+	}
+
+	err = writeFile(filepath.Join(s.dir, name+".err"), []byte(reason.Error()+"\n"))
+	if err != nil {
+		return errors.Wrap(err, "failed to write dead letter reason file")
+	}
+
+	return nil
+}
+
+func writeFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// keep only safe characters in the file name
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '@', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,261 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/gomail.v2"
+)
+
+const (
+	dkimAlgorithmRSA     = "rsa-sha256"
+	dkimAlgorithmEd25519 = "ed25519-sha256"
+)
+
+// headers which are signed when present in the message
+var dkimSignedHeaders = []string{
+	"From",
+	"Reply-To",
+	"Subject",
+	"Date",
+	"To",
+	"Cc",
+	"Message-ID",
+	"In-Reply-To",
+	"References",
+	"Mime-Version",
+	"Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+type DKIMConfig struct {
+	// email address or whole domain used in 'From' which should be signed by this key
+	From     string
+	Domain   string
+	Selector string
+	// PEM private key, RSA (PKCS1 or PKCS8) or Ed25519 (PKCS8)
+	KeyFile string
+}
+
+func validateDKIMConfig(conf DKIMConfig, name string) error {
+	if conf.From == "" {
+		return errors.Wrapf(invalidConfigError, "%s.From must not be empty", name)
+	}
+	if conf.Domain == "" {
+		return errors.Wrapf(invalidConfigError, "%s.Domain must not be empty", name)
+	}
+	if conf.Selector == "" {
+		return errors.Wrapf(invalidConfigError, "%s.Selector must not be empty", name)
+	}
+	if conf.KeyFile == "" {
+		return errors.Wrapf(invalidConfigError, "%s.KeyFile must not be empty", name)
+	}
+	return nil
+}
+
+// dkimSigner adds DKIM-Signature header to the rendered email, relaxed/relaxed canonicalization is used
+type dkimSigner struct {
+	domain    string
+	selector  string
+	algorithm string
+	key       crypto.Signer
+}
+
+func newDKIMSigner(conf DKIMConfig) (*dkimSigner, error) {
+	key, err := loadDKIMKey(conf.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &dkimSigner{
+		domain:   conf.Domain,
+		selector: conf.Selector,
+		key:      key,
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		s.algorithm = dkimAlgorithmRSA
+	case ed25519.PrivateKey:
+		s.algorithm = dkimAlgorithmEd25519
+	default:
+		return nil, errors.Wrapf(invalidConfigError, "DKIM key %s must be RSA or Ed25519", conf.KeyFile)
+	}
+
+	return s, nil
+}
+
+func loadDKIMKey(keyFile string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read DKIM key %s", keyFile)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Wrapf(invalidConfigError, "DKIM key %s is not PEM encoded", keyFile)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse DKIM key %s", keyFile)
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse DKIM key %s", keyFile)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.Wrapf(invalidConfigError, "DKIM key %s is not usable for signing", keyFile)
+		}
+		return signer, nil
+	default:
+		return nil, errors.Wrapf(invalidConfigError, "DKIM key %s has unsupported PEM type %s", keyFile, block.Type)
+	}
+}
+
+// render email and return it with DKIM-Signature header prepended
+// message must not be rendered again after signing, as gomail generates new MIME boundaries on every render
+func (s *dkimSigner) sign(m *gomail.Message) ([]byte, error) {
+	var raw bytes.Buffer
+	_, err := m.WriteTo(&raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to render email")
+	}
+
+	header, body := splitMessage(raw.Bytes())
+	fields := parseHeaderFields(header)
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	var signedNames []string
+	var signedData bytes.Buffer
+	for _, name := range dkimSignedHeaders {
+		if field, ok := lastHeaderField(fields, name); ok {
+			signedNames = append(signedNames, strings.ToLower(name))
+			signedData.WriteString(relaxedHeader(field))
+			signedData.WriteString("\r\n")
+		}
+	}
+
+	sigValue := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		s.algorithm, s.domain, s.selector, time.Now().Unix(), strings.Join(signedNames, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	// signature header itself is signed with empty b= tag and without trailing CRLF
+	signedData.WriteString(relaxedHeader("DKIM-Signature: " + sigValue))
+
+	hash := sha256.Sum256(signedData.Bytes())
+	var signature []byte
+	switch s.algorithm {
+	case dkimAlgorithmEd25519:
+		// RFC 8463, Ed25519 signs the SHA-256 hash of the data
+		signature, err = s.key.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	default:
+		signature, err = s.key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compute DKIM signature")
+	}
+
+	var signed bytes.Buffer
+	signed.WriteString("DKIM-Signature: ")
+	signed.WriteString(sigValue)
+	signed.WriteString(foldBase64(base64.StdEncoding.EncodeToString(signature)))
+	signed.WriteString("\r\n")
+	signed.Write(raw.Bytes())
+
+	return signed.Bytes(), nil
+}
+
+// split rendered message into header and body
+func splitMessage(raw []byte) ([]byte, []byte) {
+	i := bytes.Index(raw, []byte("\r\n\r\n"))
+	if i < 0 {
+		return raw, nil
+	}
+	return raw[:i+2], raw[i+4:]
+}
+
+// split header into fields, folded lines are kept together with the field
+func parseHeaderFields(header []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSuffix(fields[i], "\r\n")
+	}
+	return fields
+}
+
+// DKIM signs the last occurrence of the header
+func lastHeaderField(fields []string, name string) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		colon := strings.IndexByte(fields[i], ':')
+		if colon > 0 && strings.EqualFold(strings.TrimSpace(fields[i][:colon]), name) {
+			return fields[i], true
+		}
+	}
+	return "", false
+}
+
+var wspRegexp = regexp.MustCompile(`[ \t]+`)
+
+// relaxed header canonicalization, RFC 6376 section 3.4.2
+func relaxedHeader(field string) string {
+	colon := strings.IndexByte(field, ':')
+	name := strings.ToLower(strings.TrimSpace(field[:colon]))
+	value := strings.Replace(field[colon+1:], "\r\n", "", -1)
+	value = wspRegexp.ReplaceAllString(value, " ")
+	return name + ":" + strings.TrimSpace(value)
+}
+
+// relaxed body canonicalization, RFC 6376 section 3.4.4
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(wspRegexp.ReplaceAllString(line, " "), " ")
+	}
+	// remove all empty lines at the end of the body
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// fold long signature so the header line does not exceed line length limit
+func foldBase64(s string) string {
+	const lineLength = 72
+	var b strings.Builder
+	for len(s) > lineLength {
+		b.WriteString(s[:lineLength])
+		b.WriteString("\r\n ")
+		s = s[lineLength:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
@@ -0,0 +1,223 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// write key as PEM file and return its path
+func writeDKIMKey(t *testing.T, key crypto.Signer) string {
+	var block *pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	file := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// verifyDKIM checks relaxed/relaxed signature of the first DKIM-Signature header like a receiving server
+func verifyDKIM(t *testing.T, signed []byte, pub crypto.PublicKey) error {
+	header, body := splitMessage(signed)
+	fields := parseHeaderFields(header)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "DKIM-Signature: ") {
+		t.Fatalf("expected DKIM-Signature as the first header, got %q", fields[0])
+	}
+	sigField := fields[0]
+	tags := map[string]string{}
+	for _, tag := range strings.Split(strings.TrimPrefix(sigField, "DKIM-Signature: "), ";") {
+		kv := strings.SplitN(strings.TrimSpace(tag), "=", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = strings.Map(func(r rune) rune {
+				if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+					return -1
+				}
+				return r
+			}, kv[1])
+		}
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return errors.New("body hash mismatch")
+	}
+
+	var data bytes.Buffer
+	for _, name := range strings.Split(tags["h"], ":") {
+		field, ok := lastHeaderField(fields[1:], name)
+		if !ok {
+			return errors.Errorf("signed header %s is missing", name)
+		}
+		data.WriteString(relaxedHeader(field) + "\r\n")
+	}
+	// signature header with empty b= tag
+	b := strings.LastIndex(sigField, "; b=") + len("; b=")
+	data.WriteString(relaxedHeader(sigField[:b]))
+	hash := sha256.Sum256(data.Bytes())
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, hash[:], signature) {
+			return errors.New("ed25519 signature mismatch")
+		}
+		return nil
+	}
+	t.Fatalf("unexpected key type %T", pub)
+	return nil
+}
+
+func TestDKIMSignRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		key       crypto.Signer
+		algorithm string
+	}{
+		{name: "rsa", key: rsaKey, algorithm: dkimAlgorithmRSA},
+		{name: "ed25519", key: edKey, algorithm: dkimAlgorithmEd25519},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := newDKIMSigner(DKIMConfig{From: "example.com", Domain: "example.com", Selector: "firefly", KeyFile: writeDKIMKey(t, tt.key)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signer.algorithm != tt.algorithm {
+				t.Fatalf("expected algorithm %s, got %s", tt.algorithm, signer.algorithm)
+			}
+
+			m := testMessage("ops@example.com")
+			m.SetHeader("Subject", "[FAIL] web   server\tis down")
+			m.SetBody("text/plain", "line with trailing spaces   \r\n\r\n\r\n")
+			m.AddAlternative("text/html", "<p>down</p>")
+			signed, err := signer.sign(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(signed, []byte("a="+tt.algorithm+"; c=relaxed/relaxed; d=example.com; s=firefly;")) {
+				t.Fatalf("unexpected DKIM-Signature header in %q", signed)
+			}
+			if err := verifyDKIM(t, signed, tt.key.Public()); err != nil {
+				t.Fatalf("signature does not verify: %v", err)
+			}
+
+			tampered := bytes.Replace(signed, []byte("<p>down</p>"), []byte("<p>up</p>"), 1)
+			if verifyDKIM(t, tampered, tt.key.Public()) == nil {
+				t.Fatal("tampered body must not verify")
+			}
+			tampered = bytes.Replace(signed, []byte("Subject: "), []byte("Subject: Re: "), 1)
+			if verifyDKIM(t, tampered, tt.key.Public()) == nil {
+				t.Fatal("tampered subject must not verify")
+			}
+		})
+	}
+}
+
+func TestDKIMSignerSelection(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDaemon(t)
+	configs := []DKIMConfig{
+		{From: "example.com", Domain: "example.com", Selector: "domain", KeyFile: writeDKIMKey(t, edKey)},
+		{From: "Alerts@example.com", Domain: "example.com", Selector: "alerts", KeyFile: writeDKIMKey(t, edKey)},
+	}
+	d.dkimSigners = map[string]*dkimSigner{}
+	for _, conf := range configs {
+		signer, err := newDKIMSigner(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.dkimSigners[strings.ToLower(conf.From)] = signer
+	}
+
+	tests := []struct {
+		from     string
+		selector string
+	}{
+		{from: "alerts@example.com", selector: "alerts"},
+		{from: "ALERTS@EXAMPLE.COM", selector: "alerts"},
+		{from: "ops@example.com", selector: "domain"},
+		{from: "ops@example.org", selector: ""},
+	}
+	for _, tt := range tests {
+		signer := d.dkimSigner(tt.from)
+		selector := ""
+		if signer != nil {
+			selector = signer.selector
+		}
+		if selector != tt.selector {
+			t.Errorf("from %s: expected selector %q, got %q", tt.from, tt.selector, selector)
+		}
+	}
+}
+
+func TestLoadDKIMKeyRejectsInvalidKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := ioutil.WriteFile(file, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadDKIMKey(file); errors.Cause(err) != invalidConfigError {
+		t.Fatalf("expected invalid config error, got %v", err)
+	}
+}
+
+// example from RFC 6376 section 3.4.5
+func TestDKIMRelaxedCanonicalization(t *testing.T) {
+	header := []byte("A: X\r\nB : Y\t\r\n\tZ  \r\n")
+	fields := parseHeaderFields(header)
+	want := []string{"a:X", "b:Y Z"}
+	if len(fields) != len(want) {
+		t.Fatalf("expected %d header fields, got %q", len(want), fields)
+	}
+	for i, field := range fields {
+		if got := relaxedHeader(field); got != want[i] {
+			t.Errorf("header %d: expected %q, got %q", i, want[i], got)
+		}
+	}
+
+	body := []byte(" C \r\nD \t E\r\n\r\n\r\n")
+	if got := string(relaxedBody(body)); got != " C\r\nD E\r\n" {
+		t.Errorf("unexpected relaxed body %q", got)
+	}
+	if got := relaxedBody([]byte("\r\n\r\n")); got != nil {
+		t.Errorf("expected empty body, got %q", got)
+	}
+}
@@ -2,19 +2,46 @@ package email
 
 import (
 	"fmt"
+	"io"
+	"time"
+
 	"github.com/exmonitor/exclient/database/spec/service"
 	"github.com/pkg/errors"
 	"gopkg.in/gomail.v2"
+
+	"github.com/exmonitor/firefly/notification/branding"
+	"github.com/exmonitor/firefly/notification/payload"
+	"github.com/exmonitor/firefly/service/metadata"
 )
 
 type EmailConfig struct {
+	// incident and notification id are used for email threading
+	IncidentID     string
+	NotificationID int
+	// resend or recovery email, it is sent as reply to the first FAIL email
+	FollowUp bool
+	// time of the first failure of the incident
+	IncidentStart time.Time
+	// number of the resent FAIL notification, 0 for first notification and recovery
+	Resend int
+
 	Failed      bool
 	FailedMsg   string
 	To          string
 	ServiceInfo *service.Service
-	SMTPEnabled bool
-
-	SMTPEmailChan chan *gomail.Message
+	// decoded service metadata, it is decoded from ServiceInfo when nil
+	Metadata *metadata.Metadata
+	// service type specific content, it is built from ServiceInfo and Metadata when nil
+	Payload *payload.Payload
+	// recent check results shown in the email, can be nil
+	History *History
+	// branding of the email, default AlerTea branding is used when nil
+	Branding *branding.Profile
+	// subject templates, default template is used when nil
+	SubjectTemplates *SubjectTemplates
+	SMTPEnabled      bool
+
+	SMTPEmailChan chan *Envelope
 }
 
 func NewEmail(conf EmailConfig) (*Email, error) {
@@ -28,12 +55,33 @@ func NewEmail(conf EmailConfig) (*Email, error) {
 		return nil, errors.Wrap(invalidConfigError, "conf.SMTPEmailChan cannot be nil")
 	}
 
+	if conf.Metadata == nil {
+		// metadata are only informative in email, so broken metadata never block the notification
+		conf.Metadata, _ = metadata.Decode(conf.ServiceInfo)
+	}
+	if conf.Payload == nil {
+		conf.Payload = payload.New(conf.ServiceInfo, conf.Metadata, conf.Failed, conf.FailedMsg, nil)
+	}
+	if conf.Branding == nil {
+		conf.Branding = branding.Default()
+	}
+
 	newEmail := &Email{
-		failed:      conf.Failed,
-		failedMsg:   conf.FailedMsg,
-		to:          conf.To,
-		serviceInfo: conf.ServiceInfo,
-		smtpEnabled: conf.SMTPEnabled,
+		incidentID:       conf.IncidentID,
+		notificationID:   conf.NotificationID,
+		followUp:         conf.FollowUp,
+		incidentStart:    conf.IncidentStart,
+		resend:           conf.Resend,
+		failed:           conf.Failed,
+		failedMsg:        conf.FailedMsg,
+		to:               conf.To,
+		serviceInfo:      conf.ServiceInfo,
+		metadata:         conf.Metadata,
+		payload:          conf.Payload,
+		history:          conf.History,
+		branding:         conf.Branding,
+		subjectTemplates: conf.SubjectTemplates,
+		smtpEnabled:      conf.SMTPEnabled,
 
 		emailChan: conf.SMTPEmailChan,
 	}
@@ -42,12 +90,22 @@ func NewEmail(conf EmailConfig) (*Email, error) {
 }
 
 type Email struct {
-	failed      bool
-	failedMsg   string
-	to          string
-	serviceInfo *service.Service
-
-	emailChan   chan *gomail.Message
+	incidentID       string
+	notificationID   int
+	followUp         bool
+	incidentStart    time.Time
+	resend           int
+	failed           bool
+	failedMsg        string
+	to               string
+	serviceInfo      *service.Service
+	metadata         *metadata.Metadata
+	payload          *payload.Payload
+	history          *History
+	branding         *branding.Profile
+	subjectTemplates *SubjectTemplates
+
+	emailChan   chan *Envelope
 	smtpEnabled bool
 }
 
@@ -57,7 +115,15 @@ func (e *Email) Send() {
 
 	if e.smtpEnabled {
 		// send email to email daemon via channel
-		e.emailChan <- msg
+		e.emailChan <- &Envelope{
+			Message:        msg,
+			Critical:       e.failed,
+			ThreadID:       e.threadID(),
+			FollowUp:       e.followUp,
+			ServiceID:      e.serviceInfo.ID,
+			NotificationID: e.notificationID,
+			Branding:       e.branding,
+		}
 	} else {
 		fmt.Printf("<< fake email sent to %s\n %s\n", e.to, e.emailBody())
 		return
@@ -65,17 +131,36 @@ func (e *Email) Send() {
 
 }
 
+// every recipient has its own thread for the incident
+// empty thread id means email is not threaded
+func (e *Email) threadID() string {
+	if e.incidentID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s.%d", e.incidentID, e.notificationID)
+}
+
 func (e *Email) buildEmail() *gomail.Message {
 	m := gomail.NewMessage()
 
 	m.SetHeader("To", e.to)
 	m.SetHeader("Subject", e.emailSubject())
-	m.SetBody("text/html", e.emailBody())
+	m.SetBody("text/plain", e.emailTextBody())
+	m.AddAlternative("text/html", e.emailBody())
+
+	if hasChart(e.history) {
+		// encoding image into memory buffer never fails
+		chart, _ := renderSparkline(e.history.Points)
+		m.Embed(sparklineFileName, gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(chart)
+			return err
+		}))
+	}
 
 	return m
 }
 
 // to remove the complexity of the channel from other packages
-func BuildEmailChannel() chan *gomail.Message {
-	return make(chan *gomail.Message)
+func BuildEmailChannel() chan *Envelope {
+	return make(chan *Envelope, emailChanBuffer)
 }
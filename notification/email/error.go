@@ -3,3 +3,17 @@ package email
 import "errors"
 
 var invalidConfigError error = errors.New("invalid config")
+
+var fingerprintMismatchError error = errors.New("TLS certificate fingerprint mismatch")
+
+var startTLSNotSupportedError error = errors.New("SMTP server does not support STARTTLS")
+
+var unencryptedAuthError error = errors.New("refusing to authenticate over unencrypted connection")
+
+var invalidMessageError error = errors.New("invalid email message")
+
+var allServersUnavailableError error = errors.New("all SMTP servers are unavailable")
+
+var queueFullError error = errors.New("email queue is full")
+
+var daemonStoppedError error = errors.New("email daemon stopped")
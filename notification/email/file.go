@@ -0,0 +1,40 @@
+package email
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fileTransport writes every email as .eml file into directory
+// it is meant for integration tests and staging environments, where full MIME message can be inspected without mail server
+type fileTransport struct {
+	dir string
+}
+
+func newFileTransport(dir string) (*fileTransport, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create email directory %s", dir)
+	}
+	return &fileTransport{dir: dir}, nil
+}
+
+func (t *fileTransport) String() string {
+	return t.dir
+}
+
+func (t *fileTransport) Dial() (transportConn, error) {
+	return &nopConn{send: t.send}, nil
+}
+
+func (t *fileTransport) send(from string, to []string, msg io.WriterTo) error {
+	name := fmt.Sprintf("%s-%d-%s.eml", time.Now().Format("20060102T150405.000000000"), atomic.AddUint64(&deliveryCounter, 1), sanitizeFileName(strings.Join(to, ",")))
+	return writeMessageFile(filepath.Join(t.dir, name), msg)
+}
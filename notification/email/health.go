@@ -0,0 +1,97 @@
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// path of the email health endpoint on the HTTP listener
+const HealthPath = "/health/email"
+
+// Health describes current state of the email daemon
+type Health struct {
+	// false when any email is waiting in queue and no SMTP server is available
+	Healthy bool `json:"healthy"`
+	// amount of emails waiting for delivery
+	Queued         int `json:"queued"`
+	QueuedCritical int `json:"queuedCritical"`
+	QueuedRecovery int `json:"queuedRecovery"`
+	// amount of emails moved to dead letter store since start
+	DeadLetters int            `json:"deadLetters"`
+	Servers     []ServerHealth `json:"servers"`
+}
+
+type ServerHealth struct {
+	Address string `json:"address"`
+	// circuit breaker state, one of closed, open, half-open
+	State       string    `json:"state"`
+	Failures    int       `json:"failures"`
+	OpenUntil   time.Time `json:"openUntil"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastSuccess time.Time `json:"lastSuccess"`
+}
+
+// return health of the email daemon, safe to call from any goroutine
+func (d *Daemon) Health() Health {
+	critical, recovery := d.queue.depth()
+
+	d.Lock()
+	defer d.Unlock()
+
+	h := Health{
+		Queued:         critical + recovery,
+		QueuedCritical: critical,
+		QueuedRecovery: recovery,
+		DeadLetters:    d.deadLetters,
+	}
+	available := false
+	for _, server := range d.servers {
+		state, failures, openUntil := server.breaker.status()
+		s := ServerHealth{
+			Address:     server.address,
+			State:       state,
+			Failures:    failures,
+			LastSuccess: server.lastSuccess,
+		}
+		if state == breakerOpen {
+			s.OpenUntil = openUntil
+		} else {
+			available = true
+		}
+		if server.lastError != nil {
+			s.LastError = server.lastError.Error()
+		}
+		h.Servers = append(h.Servers, s)
+	}
+	h.Healthy = available || h.Queued == 0
+
+	return h
+}
+
+// HealthHandler serves Health as json, status is 503 when the daemon is not healthy so load balancers and monitoring can probe it
+func (d *Daemon) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		h := d.Health()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !h.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(h)
+	})
+}
+
+func (h Health) String() string {
+	var servers []string
+	for _, s := range h.Servers {
+		servers = append(servers, fmt.Sprintf("%s:%s", s.Address, s.State))
+	}
+	return fmt.Sprintf("[healthy: %t, queued: %d (critical: %d, recovery: %d), deadLetters: %d, servers: %s]", h.Healthy, h.Queued, h.QueuedCritical, h.QueuedRecovery, h.DeadLetters, strings.Join(servers, ", "))
+}
@@ -0,0 +1,63 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		open       bool
+		queued     bool
+		method     string
+		wantStatus int
+		wantState  string
+	}{
+		{name: "idle", method: http.MethodGet, wantStatus: http.StatusOK, wantState: breakerClosed},
+		{name: "queued and server available", queued: true, method: http.MethodGet, wantStatus: http.StatusOK, wantState: breakerClosed},
+		// nothing waits for delivery, open circuit alone is not a problem
+		{name: "server down and queue empty", open: true, method: http.MethodGet, wantStatus: http.StatusOK, wantState: breakerOpen},
+		{name: "server down and email queued", open: true, queued: true, method: http.MethodGet, wantStatus: http.StatusServiceUnavailable, wantState: breakerOpen},
+		{name: "post", method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDaemon(t, &fakeTransport{})
+			if tc.open {
+				for i := 0; i < breakerFailureThreshold; i++ {
+					d.servers[0].breaker.failure()
+				}
+			}
+			if tc.queued {
+				d.queue.push(&queuedEmail{msg: testMessage("queued@example.com"), critical: true})
+			}
+
+			rec := httptest.NewRecorder()
+			d.HealthHandler().ServeHTTP(rec, httptest.NewRequest(tc.method, HealthPath, nil))
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+			if tc.wantStatus == http.StatusMethodNotAllowed {
+				return
+			}
+
+			var h Health
+			if err := json.Unmarshal(rec.Body.Bytes(), &h); err != nil {
+				t.Fatalf("failed to decode body %q: %s", rec.Body.String(), err)
+			}
+			if h.Healthy != (tc.wantStatus == http.StatusOK) {
+				t.Errorf("expected healthy %t, got %t", tc.wantStatus == http.StatusOK, h.Healthy)
+			}
+			if tc.queued && (h.Queued != 1 || h.QueuedCritical != 1) {
+				t.Errorf("expected one queued critical email, got %d (critical: %d)", h.Queued, h.QueuedCritical)
+			}
+			if len(h.Servers) != 1 || h.Servers[0].State != tc.wantState {
+				t.Errorf("expected one server in state %s, got %+v", tc.wantState, h.Servers)
+			}
+		})
+	}
+}
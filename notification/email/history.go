@@ -0,0 +1,135 @@
+package email
+
+import (
+	"sort"
+	"time"
+
+	"github.com/exmonitor/exclient/database"
+	"github.com/exmonitor/exclient/database/spec/service"
+	"github.com/olivere/elastic"
+	"github.com/pkg/errors"
+)
+
+const (
+	// chart window is drawn from raw check results only up to this amount of checks, longer windows use aggregated statuses
+	maxRawChartPoints = 120
+	// amount of aggregated buckets drawn in chart for longer windows
+	// every bucket costs one elastic query and history is loaded for every alert, so keep it low
+	aggregatedChartPoints = 24
+)
+
+type HistoryConfig struct {
+	// amount of recent check results shown in the email table, 0 disables history in emails
+	Checks int
+	// time window of the response time chart
+	Window time.Duration
+}
+
+// History holds recent check results of the service shown in the alert email
+type History struct {
+	// newest check first
+	Checks []HistoryCheck
+	// oldest point first
+	Points []HistoryPoint
+	// points are averages of aggregated statuses
+	Aggregated bool
+	Window     time.Duration
+}
+
+type HistoryCheck struct {
+	Time     time.Time
+	Result   bool
+	Duration time.Duration
+	Message  string
+}
+
+type HistoryPoint struct {
+	Time     time.Time
+	Result   bool
+	Duration time.Duration
+}
+
+// LoadHistory fetches recent check results and response times of the service from elastic
+// returns nil history when history is disabled
+func LoadHistory(dbClient database.ClientInterface, serviceInfo *service.Service, conf HistoryConfig) (*History, error) {
+	if conf.Checks <= 0 || serviceInfo == nil {
+		return nil, nil
+	}
+	interval := time.Duration(serviceInfo.Interval) * time.Second
+	if interval <= 0 {
+		return nil, errors.Wrapf(invalidConfigError, "service %d has no check interval", serviceInfo.ID)
+	}
+	window := conf.Window
+	// chart should cover at least the checks in the table
+	if minWindow := interval * time.Duration(conf.Checks); window < minWindow {
+		window = minWindow
+	}
+	to := time.Now()
+	from := to.Add(-window)
+	aggregated := window/interval > maxRawChartPoints
+
+	// raw results for the table, for short window they are also used for the chart
+	rawFrom := from
+	if aggregated {
+		// little extra time as the checks are not exactly on interval
+		rawFrom = to.Add(-interval * time.Duration(conf.Checks+1))
+	}
+	statuses, err := dbClient.ES_GetServicesStatus(rawFrom, to, elastic.NewTermQuery("id", serviceInfo.ID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch status history of service %d", serviceInfo.ID)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].InsertTimestamp.Before(statuses[j].InsertTimestamp)
+	})
+
+	h := &History{
+		Aggregated: aggregated,
+		Window:     window,
+	}
+	for i := len(statuses) - 1; i >= 0 && len(h.Checks) < conf.Checks; i-- {
+		s := statuses[i]
+		h.Checks = append(h.Checks, HistoryCheck{
+			Time:     s.InsertTimestamp,
+			Result:   s.Result,
+			Duration: s.Duration,
+			Message:  s.Message,
+		})
+	}
+
+	if !aggregated {
+		for _, s := range statuses {
+			h.Points = append(h.Points, HistoryPoint{Time: s.InsertTimestamp, Result: s.Result, Duration: s.Duration})
+		}
+		return h, nil
+	}
+
+	// aggregated statuses are fetched per bucket, each call returns the latest aggregation in the bucket
+	for _, b := range aggregatedBuckets(from, to, aggregatedChartPoints) {
+		a, err := dbClient.ES_GetAggregatedServiceStatusByID(b.from, b.to, serviceInfo.ID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch aggregated status history of service %d", serviceInfo.ID)
+		}
+		if a == nil {
+			continue
+		}
+		h.Points = append(h.Points, HistoryPoint{Time: a.TimestampTo, Result: a.Result, Duration: a.AvgDuration})
+	}
+	return h, nil
+}
+
+type timeRange struct {
+	from time.Time
+	to   time.Time
+}
+
+// split window into count adjacent buckets, the last bucket ends exactly at to so no time is lost by rounding
+func aggregatedBuckets(from time.Time, to time.Time, count int) []timeRange {
+	size := to.Sub(from) / time.Duration(count)
+	buckets := make([]timeRange, count)
+	for i := range buckets {
+		buckets[i].from = from.Add(size * time.Duration(i))
+		buckets[i].to = buckets[i].from.Add(size)
+	}
+	buckets[count-1].to = to
+	return buckets
+}
@@ -0,0 +1,212 @@
+package email
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exclient/database"
+	"github.com/exmonitor/exclient/database/spec/service"
+	"github.com/exmonitor/exclient/database/spec/status"
+	"github.com/olivere/elastic"
+	"github.com/pkg/errors"
+)
+
+// fakeHistoryDB serves raw statuses and one aggregation per bucket, every query range is recorded
+type fakeHistoryDB struct {
+	database.ClientInterface
+	statuses []*status.ServiceStatus
+	// aggregation is returned for bucket when aggregate returns non nil
+	aggregate     func(from time.Time, to time.Time) *status.AgregatedServiceStatus
+	rawErr        error
+	aggregatedErr error
+
+	mu                sync.Mutex
+	rawQueries        []timeRange
+	aggregatedQueries []timeRange
+}
+
+func (db *fakeHistoryDB) ES_GetServicesStatus(from time.Time, to time.Time, elasticQuery ...elastic.Query) ([]*status.ServiceStatus, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.rawQueries = append(db.rawQueries, timeRange{from: from, to: to})
+	return db.statuses, db.rawErr
+}
+
+func (db *fakeHistoryDB) ES_GetAggregatedServiceStatusByID(from time.Time, to time.Time, serviceID int) (*status.AgregatedServiceStatus, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.aggregatedQueries = append(db.aggregatedQueries, timeRange{from: from, to: to})
+	if db.aggregatedErr != nil || db.aggregate == nil {
+		return nil, db.aggregatedErr
+	}
+	return db.aggregate(from, to), nil
+}
+
+// statuses every interval ending now, oldest first, every third check fails
+func testStatuses(count int, interval time.Duration) []*status.ServiceStatus {
+	now := time.Now()
+	var statuses []*status.ServiceStatus
+	for i := count - 1; i >= 0; i-- {
+		statuses = append(statuses, &status.ServiceStatus{
+			Id:              7,
+			Result:          i%3 != 0,
+			Duration:        time.Duration(i+1) * time.Millisecond,
+			Message:         "ok",
+			InsertTimestamp: now.Add(-interval * time.Duration(i)),
+		})
+	}
+	return statuses
+}
+
+func TestLoadHistoryRawWindow(t *testing.T) {
+	statuses := testStatuses(30, time.Minute)
+	// elastic returns statuses in no particular order
+	shuffled := append([]*status.ServiceStatus{}, statuses[10:]...)
+	shuffled = append(shuffled, statuses[:10]...)
+	db := &fakeHistoryDB{statuses: shuffled}
+
+	h, err := LoadHistory(db, &service.Service{ID: 7, Interval: 60}, HistoryConfig{Checks: 5, Window: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Aggregated || h.Window != time.Hour {
+		t.Fatalf("expected raw history of one hour, got aggregated %t, window %s", h.Aggregated, h.Window)
+	}
+	if len(db.rawQueries) != 1 || len(db.aggregatedQueries) != 0 {
+		t.Fatalf("expected single raw query, got %d raw and %d aggregated", len(db.rawQueries), len(db.aggregatedQueries))
+	}
+	if span := db.rawQueries[0].to.Sub(db.rawQueries[0].from); span != time.Hour {
+		t.Errorf("expected raw query over the whole window, got %s", span)
+	}
+	if len(h.Checks) != 5 {
+		t.Fatalf("expected 5 checks, got %d", len(h.Checks))
+	}
+	for i, c := range h.Checks {
+		want := statuses[len(statuses)-1-i]
+		if !c.Time.Equal(want.InsertTimestamp) || c.Result != want.Result || c.Duration != want.Duration {
+			t.Errorf("check %d expected newest first %+v, got %+v", i, want, c)
+		}
+	}
+	if len(h.Points) != len(statuses) {
+		t.Fatalf("expected %d points, got %d", len(statuses), len(h.Points))
+	}
+	for i, p := range h.Points {
+		if !p.Time.Equal(statuses[i].InsertTimestamp) {
+			t.Fatalf("expected points oldest first, point %d is %s", i, p.Time)
+		}
+	}
+}
+
+func TestLoadHistoryAggregatedWindow(t *testing.T) {
+	// every other bucket has no aggregation, e.g. aggregator was down
+	calls := 0
+	db := &fakeHistoryDB{
+		statuses: testStatuses(10, time.Minute),
+		aggregate: func(from time.Time, to time.Time) *status.AgregatedServiceStatus {
+			calls++
+			if calls%2 == 0 {
+				return nil
+			}
+			return &status.AgregatedServiceStatus{ServiceID: 7, Result: true, AvgDuration: time.Second, TimestampFrom: from, TimestampTo: to.Add(-time.Second)}
+		},
+	}
+
+	h, err := LoadHistory(db, &service.Service{ID: 7, Interval: 60}, HistoryConfig{Checks: 5, Window: time.Hour * 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Aggregated {
+		t.Fatal("expected aggregated history for 24h window")
+	}
+	if len(h.Checks) != 5 {
+		t.Fatalf("expected 5 checks from raw statuses, got %d", len(h.Checks))
+	}
+	// raw statuses are fetched only for the table
+	if span := db.rawQueries[0].to.Sub(db.rawQueries[0].from); span != time.Minute*6 {
+		t.Errorf("expected raw query over 6 checks, got %s", span)
+	}
+
+	if len(db.aggregatedQueries) != aggregatedChartPoints {
+		t.Fatalf("expected %d aggregated queries, got %d", aggregatedChartPoints, len(db.aggregatedQueries))
+	}
+	buckets := db.aggregatedQueries
+	if !buckets[len(buckets)-1].to.Equal(db.rawQueries[0].to) {
+		t.Errorf("expected the last bucket to end now")
+	}
+	if span := buckets[len(buckets)-1].to.Sub(buckets[0].from); span != time.Hour*24 {
+		t.Errorf("expected buckets to cover 24h, got %s", span)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if !buckets[i].from.Equal(buckets[i-1].to) {
+			t.Fatalf("bucket %d does not follow bucket %d", i, i-1)
+		}
+	}
+	if len(h.Points) == 0 || len(h.Points) >= aggregatedChartPoints {
+		t.Fatalf("expected empty buckets to be skipped, got %d points", len(h.Points))
+	}
+	for i := 1; i < len(h.Points); i++ {
+		if !h.Points[i].Time.After(h.Points[i-1].Time) {
+			t.Fatalf("expected points oldest first, point %d is %s", i, h.Points[i].Time)
+		}
+	}
+}
+
+func TestLoadHistoryWindowCoversChecks(t *testing.T) {
+	db := &fakeHistoryDB{}
+	h, err := LoadHistory(db, &service.Service{ID: 7, Interval: 300}, HistoryConfig{Checks: 20, Window: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Window != time.Minute*100 {
+		t.Errorf("expected window extended to 20 checks, got %s", h.Window)
+	}
+}
+
+func TestLoadHistoryErrors(t *testing.T) {
+	dbErr := errors.New("elastic is down")
+	testCases := []struct {
+		name        string
+		serviceInfo *service.Service
+		conf        HistoryConfig
+		db          *fakeHistoryDB
+		wantNil     bool
+		wantErr     error
+	}{
+		{name: "disabled", serviceInfo: &service.Service{ID: 7, Interval: 60}, db: &fakeHistoryDB{}, wantNil: true},
+		{name: "no service", conf: HistoryConfig{Checks: 5}, db: &fakeHistoryDB{}, wantNil: true},
+		{name: "no interval", serviceInfo: &service.Service{ID: 7}, conf: HistoryConfig{Checks: 5}, db: &fakeHistoryDB{}, wantErr: invalidConfigError},
+		{name: "raw query fails", serviceInfo: &service.Service{ID: 7, Interval: 60}, conf: HistoryConfig{Checks: 5, Window: time.Hour}, db: &fakeHistoryDB{rawErr: dbErr}, wantErr: dbErr},
+		{name: "aggregated query fails", serviceInfo: &service.Service{ID: 7, Interval: 60}, conf: HistoryConfig{Checks: 5, Window: time.Hour * 24}, db: &fakeHistoryDB{aggregatedErr: dbErr}, wantErr: dbErr},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := LoadHistory(tc.db, tc.serviceInfo, tc.conf)
+			if errors.Cause(err) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantNil && h != nil {
+				t.Fatalf("expected no history, got %+v", h)
+			}
+		})
+	}
+}
+
+func TestAggregatedBuckets(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// window not divisible by the bucket count
+	to := from.Add(time.Hour + time.Second)
+	buckets := aggregatedBuckets(from, to, 7)
+	if len(buckets) != 7 {
+		t.Fatalf("expected 7 buckets, got %d", len(buckets))
+	}
+	if !buckets[0].from.Equal(from) || !buckets[6].to.Equal(to) {
+		t.Fatalf("expected buckets from %s to %s, got %s to %s", from, to, buckets[0].from, buckets[6].to)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if !buckets[i].from.Equal(buckets[i-1].to) {
+			t.Fatalf("gap between bucket %d and %d", i-1, i)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package email
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// counter used for unique file names within the same nanosecond
+var deliveryCounter uint64
+
+// maildirTransport delivers emails into local maildir
+// message is written into tmp directory first and moved into new directory, so readers never see partial message
+type maildirTransport struct {
+	path     string
+	hostname string
+}
+
+func newMaildirTransport(path string) (*maildirTransport, error) {
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(path, dir), 0750)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create maildir %s", path)
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	return &maildirTransport{path: path, hostname: sanitizeFileName(hostname)}, nil
+}
+
+func (t *maildirTransport) String() string {
+	return t.path
+}
+
+func (t *maildirTransport) Dial() (transportConn, error) {
+	return &nopConn{send: t.send}, nil
+}
+
+func (t *maildirTransport) send(from string, to []string, msg io.WriterTo) error {
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), atomic.AddUint64(&deliveryCounter, 1), t.hostname)
+	tmpPath := filepath.Join(t.path, "tmp", name)
+
+	err := writeMessageFile(tmpPath, msg)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, filepath.Join(t.path, "new", name))
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "failed to move email into maildir")
+	}
+	return nil
+}
+
+// write message into new file, file is synced to disk before it is closed
+func writeMessageFile(path string, msg io.WriterTo) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return errors.Wrap(err, "failed to create email file")
+	}
+	_, err = msg.WriteTo(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(path)
+		return errors.Wrap(err, "failed to write email file")
+	}
+	return nil
+}
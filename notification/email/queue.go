@@ -0,0 +1,169 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"gopkg.in/gomail.v2"
+
+	"github.com/exmonitor/firefly/notification/branding"
+)
+
+const (
+	// buffer of the email channel, emails are moved from the channel to the queue immediately
+	emailChanBuffer = 100
+)
+
+// Envelope wraps email message with metadata needed by email daemon
+type Envelope struct {
+	Message *gomail.Message
+	// critical emails are always sent before recovery emails
+	Critical bool
+	// all emails with the same thread id are threaded in mail clients
+	ThreadID string
+	// follow-up email replies to the first email in the thread
+	FollowUp bool
+	// used for VERP return path, so bounces can be mapped back to the contact
+	ServiceID      int
+	NotificationID int
+	// sender name and address, default AlerTea branding is used when nil
+	Branding *branding.Profile
+}
+
+type queuedEmail struct {
+	msg *gomail.Message
+	// already rendered message, set when the message was signed and must not be rendered again
+	raw      []byte
+	critical bool
+	attempts int
+	queuedAt time.Time
+}
+
+// return message content which should be sent
+func (e *queuedEmail) content() io.WriterTo {
+	if e.raw != nil {
+		return bytes.NewReader(e.raw)
+	}
+	return e.msg
+}
+
+// emailQueue is bounded priority queue shared by all SMTP workers
+// critical emails are always dequeued before recovery emails, FIFO order is kept within each priority
+type emailQueue struct {
+	critical []*queuedEmail
+	recovery []*queuedEmail
+	maxSize  int
+	// dequeue is paused when all SMTP servers are unavailable
+	pausedUntil time.Time
+	closed      bool
+
+	cond *sync.Cond
+	sync.Mutex
+}
+
+func newEmailQueue(maxSize int) *emailQueue {
+	q := &emailQueue{
+		maxSize: maxSize,
+	}
+	q.cond = sync.NewCond(&q.Mutex)
+	return q
+}
+
+// add email to the end of the queue
+// if the queue is full the oldest recovery (or critical if there is no recovery) email is dropped and returned
+func (q *emailQueue) push(e *queuedEmail) *queuedEmail {
+	q.Lock()
+	defer q.Unlock()
+
+	var dropped *queuedEmail
+	if len(q.critical)+len(q.recovery) >= q.maxSize {
+		if len(q.recovery) > 0 {
+			dropped, q.recovery = q.recovery[0], q.recovery[1:]
+		} else {
+			dropped, q.critical = q.critical[0], q.critical[1:]
+		}
+	}
+	if e.critical {
+		q.critical = append(q.critical, e)
+	} else {
+		q.recovery = append(q.recovery, e)
+	}
+	q.cond.Signal()
+
+	return dropped
+}
+
+// return email back to the front of the queue, used when email could not be sent due to SMTP outage
+// dequeue is paused for pause duration, so workers dont spin while SMTP servers are down
+// returns false when the queue is already closed, the caller must store the email elsewhere
+func (q *emailQueue) requeue(e *queuedEmail, pause time.Duration) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return false
+	}
+	if e.critical {
+		q.critical = append([]*queuedEmail{e}, q.critical...)
+	} else {
+		q.recovery = append([]*queuedEmail{e}, q.recovery...)
+	}
+	q.pausedUntil = time.Now().Add(pause)
+	time.AfterFunc(pause, q.cond.Broadcast)
+	return true
+}
+
+// wait for email in the queue
+// returns nil email when deadline passed, so the worker can do housekeeping, and false when the queue is closed
+func (q *emailQueue) pop(deadline time.Time) (*queuedEmail, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	timer := time.AfterFunc(time.Until(deadline), q.cond.Broadcast)
+	defer timer.Stop()
+
+	for {
+		if q.closed {
+			return nil, false
+		}
+		now := time.Now()
+		if !now.Before(q.pausedUntil) {
+			var e *queuedEmail
+			if len(q.critical) > 0 {
+				e, q.critical = q.critical[0], q.critical[1:]
+			} else if len(q.recovery) > 0 {
+				e, q.recovery = q.recovery[0], q.recovery[1:]
+			}
+			if e != nil {
+				return e, true
+			}
+		}
+		if !now.Before(deadline) {
+			return nil, true
+		}
+		q.cond.Wait()
+	}
+}
+
+// close the queue and return all emails which were not sent
+func (q *emailQueue) close() []*queuedEmail {
+	q.Lock()
+	defer q.Unlock()
+
+	q.closed = true
+	rest := append(q.critical, q.recovery...)
+	q.critical = nil
+	q.recovery = nil
+	q.cond.Broadcast()
+
+	return rest
+}
+
+// return amount of queued critical and recovery emails
+func (q *emailQueue) depth() (int, int) {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.critical), len(q.recovery)
+}
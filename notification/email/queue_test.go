@@ -0,0 +1,65 @@
+package email
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmailQueuePriorityAndOverflow(t *testing.T) {
+	q := newEmailQueue(3)
+	recovery1 := &queuedEmail{msg: testMessage("r1@example.com")}
+	recovery2 := &queuedEmail{msg: testMessage("r2@example.com")}
+	critical1 := &queuedEmail{msg: testMessage("c1@example.com"), critical: true}
+	critical2 := &queuedEmail{msg: testMessage("c2@example.com"), critical: true}
+
+	for _, e := range []*queuedEmail{recovery1, recovery2, critical1} {
+		if dropped := q.push(e); dropped != nil {
+			t.Fatalf("unexpected drop of %v", dropped.msg.GetHeader("To"))
+		}
+	}
+	// full queue drops the oldest recovery email
+	if dropped := q.push(critical2); dropped != recovery1 {
+		t.Fatalf("expected oldest recovery email to be dropped")
+	}
+
+	want := []*queuedEmail{critical1, critical2, recovery2}
+	for i, w := range want {
+		e, ok := q.pop(time.Now().Add(time.Second))
+		if !ok || e != w {
+			t.Fatalf("pop %d returned %v, want %v", i, e.msg.GetHeader("To"), w.msg.GetHeader("To"))
+		}
+	}
+	if e, ok := q.pop(time.Now()); !ok || e != nil {
+		t.Fatalf("expected empty queue")
+	}
+}
+
+func TestEmailQueueRequeuePausesAndKeepsOrder(t *testing.T) {
+	q := newEmailQueue(10)
+	first := &queuedEmail{msg: testMessage("first@example.com"), critical: true}
+	second := &queuedEmail{msg: testMessage("second@example.com"), critical: true}
+	q.push(second)
+	if !q.requeue(first, 100*time.Millisecond) {
+		t.Fatal("requeue into open queue failed")
+	}
+	if e, _ := q.pop(time.Now().Add(10 * time.Millisecond)); e != nil {
+		t.Fatal("expected paused queue")
+	}
+	if e, _ := q.pop(time.Now().Add(time.Second)); e != first {
+		t.Fatal("expected requeued email at the front of the queue")
+	}
+}
+
+func TestEmailQueueRequeueAfterClose(t *testing.T) {
+	q := newEmailQueue(10)
+	q.push(&queuedEmail{msg: testMessage("queued@example.com")})
+	if rest := q.close(); len(rest) != 1 {
+		t.Fatalf("expected 1 email returned by close, got %d", len(rest))
+	}
+	if q.requeue(&queuedEmail{msg: testMessage("inflight@example.com")}, time.Second) {
+		t.Fatal("requeue into closed queue must fail")
+	}
+	if _, ok := q.pop(time.Now().Add(time.Second)); ok {
+		t.Fatal("closed queue must not return emails")
+	}
+}
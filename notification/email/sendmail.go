@@ -0,0 +1,71 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const sendmailTimeout = time.Minute
+
+// sendmail exit codes from sysexits.h which will not disappear by retrying
+var sendmailPermanentExitCodes = map[int]bool{
+	64: true, // EX_USAGE
+	65: true, // EX_DATAERR
+	67: true, // EX_NOUSER
+	68: true, // EX_NOHOST
+	77: true, // EX_NOPERM
+}
+
+// sendmailTransport pipes emails into local sendmail compatible binary (sendmail, postfix, exim, msmtp, ...)
+type sendmailTransport struct {
+	path string
+}
+
+func newSendmailTransport(path string) *sendmailTransport {
+	return &sendmailTransport{path: path}
+}
+
+func (t *sendmailTransport) String() string {
+	return t.path
+}
+
+func (t *sendmailTransport) Dial() (transportConn, error) {
+	return &nopConn{send: t.send}, nil
+}
+
+func (t *sendmailTransport) send(from string, to []string, msg io.WriterTo) error {
+	var content bytes.Buffer
+	_, err := msg.WriteTo(&content)
+	if err != nil {
+		return errors.Wrap(err, "failed to render email")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendmailTimeout)
+	defer cancel()
+
+	// -i: dont treat single dot as end of message, -f: envelope sender
+	args := append([]string{"-i", "-f", from, "--"}, to...)
+	cmd := exec.CommandContext(ctx, t.path, args...)
+	cmd.Stdin = &content
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		err = errors.Wrapf(err, "sendmail failed: %s", strings.TrimSpace(stderr.String()))
+		if exitErr, ok := errors.Cause(err).(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && sendmailPermanentExitCodes[status.ExitStatus()] {
+				return newPermanentError(err)
+			}
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package email
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/gomail.v2"
+)
+
+const dialTimeout = time.Second * 30
+
+// smtpDialer opens connection to SMTP server according to configured TLS mode and auth mechanism
+// gomail.Dialer is not used as it always tries STARTTLS and cant be forced to require it
+type smtpDialer struct {
+	server    string
+	port      int
+	tlsMode   string
+	tlsConfig *tls.Config
+	auth      smtp.Auth
+}
+
+func newSMTPDialer(conf SMTPConfig) (*smtpDialer, error) {
+	var tlsConfig *tls.Config
+	if conf.TLSMode != tlsModeNone {
+		var err error
+		tlsConfig, err = buildTLSConfig(conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build TLS config")
+		}
+	}
+
+	d := &smtpDialer{
+		server:    conf.Server,
+		port:      conf.Port,
+		tlsMode:   conf.TLSMode,
+		tlsConfig: tlsConfig,
+		auth:      smtpAuth(conf),
+	}
+	return d, nil
+}
+
+func (d *smtpDialer) String() string {
+	return net.JoinHostPort(d.server, strconv.Itoa(d.port))
+}
+
+func (d *smtpDialer) Dial() (transportConn, error) {
+	addr := d.String()
+
+	var conn net.Conn
+	var err error
+	if d.tlsMode == tlsModeImplicit {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, d.tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, dialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := smtp.NewClient(conn, d.server)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if d.tlsMode == tlsModeStartTLS {
+		// never fall back to plain connection when STARTTLS is required
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return nil, errors.Wrapf(startTLSNotSupportedError, "server %s", addr)
+		}
+		if err := c.StartTLS(d.tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if d.auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(d.auth); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return &smtpSender{client: c}, nil
+}
+
+// send message content via sender, envelope is taken from message headers
+// unlike gomail.Send it keeps the original SMTP error, so permanent failures can be recognized
+// Return-Path header is used as envelope sender when present
+func sendMessage(s gomail.Sender, m *gomail.Message, content io.WriterTo) error {
+	from, to, err := envelope(m)
+	if err != nil {
+		// retrying or another server will not fix the address, so the email goes straight to dead letter store
+		return newPermanentError(err)
+	}
+	return s.Send(from, to, content)
+}
+
+// envelope sender and recipients of the message
+func envelope(m *gomail.Message) (string, []string, error) {
+	from := firstHeader(m, "Return-Path", "Sender", "From")
+	if from == "" {
+		return "", nil, errors.Wrap(invalidMessageError, "missing From header")
+	}
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", nil, errors.Wrapf(invalidMessageError, "invalid sender %q", from)
+	}
+
+	var to []string
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		for _, value := range m.GetHeader(field) {
+			addr, err := mail.ParseAddress(value)
+			if err != nil {
+				return "", nil, errors.Wrapf(invalidMessageError, "invalid recipient %q", value)
+			}
+			to = append(to, addr.Address)
+		}
+	}
+	if len(to) == 0 {
+		return "", nil, errors.Wrap(invalidMessageError, "missing recipients")
+	}
+	return fromAddr.Address, to, nil
+}
+
+func firstHeader(m *gomail.Message, fields ...string) string {
+	for _, field := range fields {
+		if values := m.GetHeader(field); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// smtpSender implements transportConn on top of net/smtp client
+type smtpSender struct {
+	client *smtp.Client
+}
+
+// failed transaction is reset, so refused recipient does not break the next email on the same connection
+func (s *smtpSender) Send(from string, to []string, msg io.WriterTo) error {
+	err := s.send(from, to, msg)
+	if err != nil {
+		// broken connection is found by the worker, it checks the connection after permanent errors
+		s.client.Reset()
+	}
+	return err
+}
+
+func (s *smtpSender) send(from string, to []string, msg io.WriterTo) error {
+	if err := s.client.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := s.client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := s.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *smtpSender) noop() error {
+	return s.client.Noop()
+}
+
+func (s *smtpSender) Close() error {
+	return s.client.Quit()
+}
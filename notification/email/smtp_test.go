@@ -0,0 +1,203 @@
+package email
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/gomail.v2"
+)
+
+// fakeSMTPServer accepts plain SMTP connections, recipients starting with "bad" are refused with 550
+type fakeSMTPServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	commands []string
+	messages []string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTPServer{listener: l}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	// transaction state, RCPT is refused before MAIL like real servers do
+	mail := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		switch {
+		case cmd == "EHLO" || cmd == "HELO":
+			reply("250 fake")
+		case cmd == "MAIL":
+			if mail {
+				reply("503 5.5.1 Error: nested MAIL command")
+				continue
+			}
+			mail = true
+			reply("250 OK")
+		case cmd == "RCPT":
+			if !mail {
+				reply("503 5.5.1 Error: need MAIL command")
+			} else if strings.Contains(strings.ToLower(line), "<bad") {
+				reply("550 5.1.1 User unknown")
+			} else {
+				reply("250 OK")
+			}
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data []string
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data = append(data, l)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, strings.Join(data, ""))
+			s.mu.Unlock()
+			mail = false
+			reply("250 OK queued")
+		case cmd == "RSET":
+			mail = false
+			reply("250 OK")
+		case cmd == "NOOP":
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 5.5.2 Error: command not recognized")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) sent() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...), append([]string(nil), s.messages...)
+}
+
+func testMessage(to string) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", "alerts@example.com")
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", "test")
+	m.SetBody("text/plain", "body")
+	return m
+}
+
+func dialFakeSMTP(t *testing.T, s *fakeSMTPServer) transportConn {
+	dialer, err := newSMTPDialer(SMTPConfig{Server: "127.0.0.1", Port: s.port(), TLSMode: tlsModeNone, AuthMechanism: authNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestSMTPSenderResetsRefusedTransaction(t *testing.T) {
+	s := newFakeSMTPServer(t)
+	conn := dialFakeSMTP(t, s)
+
+	m := testMessage("bad@example.com")
+	err := sendMessage(conn, m, m)
+	if err == nil || !isPermanentError(err) {
+		t.Fatalf("expected permanent error for refused recipient, got %v", err)
+	}
+
+	// the same connection must accept next email
+	m = testMessage("ops@example.com")
+	if err := sendMessage(conn, m, m); err != nil {
+		t.Fatalf("expected next email to be sent on the same connection, got %v", err)
+	}
+
+	commands, messages := s.sent()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 delivered message, got %d", len(messages))
+	}
+	rset := -1
+	for i, c := range commands {
+		if c == "RSET" {
+			rset = i
+		}
+	}
+	if rset < 0 {
+		t.Fatalf("expected RSET after refused recipient, got commands %q", commands)
+	}
+}
+
+func TestSendMessageInvalidAddressIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+	}{
+		{name: "invalid recipient", from: "alerts@example.com", to: "not an address"},
+		{name: "invalid sender", from: "not an address", to: "ops@example.com"},
+		{name: "missing recipient", from: "alerts@example.com", to: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMessage(tt.to)
+			m.SetHeader("From", tt.from)
+			if tt.to == "" {
+				m.SetHeader("To")
+			}
+			sender := gomail.SendFunc(func(string, []string, io.WriterTo) error {
+				t.Fatal("invalid message must not reach the server")
+				return nil
+			})
+			err := sendMessage(sender, m, m)
+			if !isPermanentError(err) {
+				t.Fatalf("expected permanent error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package email
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"time"
+)
+
+const (
+	sparklineWidth  = 300
+	sparklineHeight = 50
+	sparklinePad    = 3
+	// content id of the chart embedded in html body
+	sparklineFileName = "response-time.png"
+)
+
+var (
+	sparklineLine    = color.RGBA{0x33, 0x66, 0xcc, 0xff}
+	sparklineFailure = color.RGBA{0xdd, 0x22, 0x22, 0xff}
+	sparklineAxis    = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+)
+
+// render response time chart as PNG, failed checks are marked with red dots
+func renderSparkline(points []HistoryPoint) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, sparklineWidth, sparklineHeight))
+	for x := 0; x < sparklineWidth; x++ {
+		for y := 0; y < sparklineHeight; y++ {
+			img.Set(x, y, color.White)
+		}
+		img.Set(x, sparklineHeight-1, sparklineAxis)
+	}
+
+	xs, ys := sparklineCoordinates(points)
+	for i := 1; i < len(points); i++ {
+		drawLine(img, xs[i-1], ys[i-1], xs[i], ys[i], sparklineLine)
+	}
+	for i, p := range points {
+		if !p.Result {
+			drawDot(img, xs[i], ys[i], sparklineFailure)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scale points into the image, x axis is time so gaps in data stay visible
+func sparklineCoordinates(points []HistoryPoint) ([]int, []int) {
+	xs := make([]int, len(points))
+	ys := make([]int, len(points))
+	if len(points) == 0 {
+		return xs, ys
+	}
+
+	first, last := points[0].Time, points[len(points)-1].Time
+	maxDuration := maxPointDuration(points)
+	width := sparklineWidth - 2*sparklinePad
+	height := sparklineHeight - 2*sparklinePad
+	for i, p := range points {
+		if span := last.Sub(first); span > 0 {
+			xs[i] = sparklinePad + int(int64(width-1)*int64(p.Time.Sub(first))/int64(span))
+		} else {
+			xs[i] = sparklinePad + width/2
+		}
+		ys[i] = sparklinePad + height - 1
+		if maxDuration > 0 {
+			ys[i] -= int(int64(height-1) * int64(p.Duration) / int64(maxDuration))
+		}
+	}
+	return xs, ys
+}
+
+func maxPointDuration(points []HistoryPoint) time.Duration {
+	var max time.Duration
+	for _, p := range points {
+		if p.Duration > max {
+			max = p.Duration
+		}
+	}
+	return max
+}
+
+// bresenham line
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx, sx := abs(x1-x0), 1
+	if x0 > x1 {
+		sx = -1
+	}
+	dy, sy := -abs(y1-y0), 1
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func drawDot(img *image.RGBA, x, y int, c color.Color) {
+	for dx := -2; dx <= 2; dx++ {
+		for dy := -2; dy <= 2; dy++ {
+			if dx*dx+dy*dy <= 5 {
+				img.Set(x+dx, y+dy, c)
+			}
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// unicode sparkline for plain text body, failed checks are shown as x
+func textSparkline(points []HistoryPoint) string {
+	maxDuration := maxPointDuration(points)
+	var b strings.Builder
+	for _, p := range points {
+		if !p.Result {
+			b.WriteRune('x')
+			continue
+		}
+		i := 0
+		if maxDuration > 0 {
+			i = int(int64(len(sparkBlocks)-1) * int64(p.Duration) / int64(maxDuration))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
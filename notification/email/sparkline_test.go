@@ -0,0 +1,101 @@
+package email
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func testPoints(durations ...time.Duration) []HistoryPoint {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var points []HistoryPoint
+	for i, d := range durations {
+		// negative duration marks failed check
+		points = append(points, HistoryPoint{Time: start.Add(time.Minute * time.Duration(i)), Result: d >= 0, Duration: abs64(d)})
+	}
+	return points
+}
+
+func abs64(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func TestTextSparkline(t *testing.T) {
+	testCases := []struct {
+		name   string
+		points []HistoryPoint
+		want   string
+	}{
+		{name: "empty", want: ""},
+		{name: "scaled to max", points: testPoints(time.Second, 2*time.Second, 4*time.Second, 8*time.Second), want: "▁▂▄█"},
+		{name: "failure", points: testPoints(time.Second, -time.Second, 8*time.Second), want: "▁x█"},
+		{name: "zero durations", points: testPoints(0, 0), want: "▁▁"},
+		{name: "only failures", points: testPoints(-time.Second, -time.Second), want: "xx"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := textSparkline(tc.points); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSparklineCoordinates(t *testing.T) {
+	width := sparklineWidth - 2*sparklinePad
+	height := sparklineHeight - 2*sparklinePad
+
+	xs, ys := sparklineCoordinates(testPoints(0, 4*time.Second, 2*time.Second))
+	// x follows time, y is inverted so the slowest check is at the top
+	wantXs := []int{sparklinePad, sparklinePad + (width-1)/2, sparklinePad + width - 1}
+	wantYs := []int{sparklinePad + height - 1, sparklinePad, sparklinePad + height - 1 - (height-1)/2}
+	for i := range xs {
+		if xs[i] != wantXs[i] || ys[i] != wantYs[i] {
+			t.Errorf("point %d expected at %d,%d, got %d,%d", i, wantXs[i], wantYs[i], xs[i], ys[i])
+		}
+	}
+
+	// single point is centered
+	xs, ys = sparklineCoordinates(testPoints(time.Second))
+	if xs[0] != sparklinePad+width/2 || ys[0] != sparklinePad {
+		t.Errorf("single point expected at %d,%d, got %d,%d", sparklinePad+width/2, sparklinePad, xs[0], ys[0])
+	}
+}
+
+func TestRenderSparkline(t *testing.T) {
+	points := testPoints(time.Second, 3*time.Second, -2*time.Second, time.Second)
+	data, err := renderSparkline(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("chart is not valid PNG: %s", err)
+	}
+	if b := img.Bounds(); b.Dx() != sparklineWidth || b.Dy() != sparklineHeight {
+		t.Fatalf("expected %dx%d chart, got %dx%d", sparklineWidth, sparklineHeight, b.Dx(), b.Dy())
+	}
+
+	xs, ys := sparklineCoordinates(points)
+	rgba := func(x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+	if c := rgba(0, 0); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("expected white background, got %v", c)
+	}
+	if c := rgba(0, sparklineHeight-1); c != sparklineAxis {
+		t.Errorf("expected axis at the bottom, got %v", c)
+	}
+	if c := rgba(xs[1], ys[1]); c != sparklineLine {
+		t.Errorf("expected line through the slowest check, got %v", c)
+	}
+	if c := rgba(xs[2], ys[2]); c != sparklineFailure {
+		t.Errorf("expected failure dot, got %v", c)
+	}
+}
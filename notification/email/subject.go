@@ -0,0 +1,133 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// example '[AlerTea] CRITICAL: myhost - tcp:84.12.34.54:22'
+const DefaultSubjectTemplate = `[{{ .Brand }}] {{ with .Reminder }}{{ . }} {{ end }}{{ .Status }}: {{ .Host }} - {{ .ServiceType }}:{{ .Target }}{{ with .Port }}:{{ . }}{{ end }}`
+
+// SubjectData is available in subject templates
+type SubjectData struct {
+	// name of the branding profile
+	Brand string
+	// CRITICAL or Resolved
+	Status      string
+	Host        string
+	Target      string
+	ServiceType string
+	Port        string
+	// time since the first failure, rounded to seconds
+	IncidentDuration string
+	// how many times was the FAIL notification already resent to the contact, 0 for first notification
+	Resend int
+	// [REMINDER n] for resent notifications, empty otherwise
+	Reminder string
+}
+
+// SubjectTemplates holds deployment subject template and optional templates for specific contacts
+type SubjectTemplates struct {
+	defaultTemplate *template.Template
+	// key is notification id or lower case contact email address
+	contactTemplates map[string]*template.Template
+}
+
+// NewSubjectTemplates parses deployment template and contact templates
+// contact templates are keyed by notification id or contact email address
+func NewSubjectTemplates(defaultTemplate string, contactTemplates map[string]string) (*SubjectTemplates, error) {
+	if defaultTemplate == "" {
+		defaultTemplate = DefaultSubjectTemplate
+	}
+	t := &SubjectTemplates{contactTemplates: map[string]*template.Template{}}
+
+	var err error
+	t.defaultTemplate, err = parseSubjectTemplate("default", defaultTemplate)
+	if err != nil {
+		return nil, err
+	}
+	for contact, text := range contactTemplates {
+		tmpl, err := parseSubjectTemplate(contact, text)
+		if err != nil {
+			return nil, err
+		}
+		t.contactTemplates[strings.ToLower(contact)] = tmpl
+	}
+	return t, nil
+}
+
+// LoadSubjectTemplates reads contact templates from json file with object {"contact": "template"}
+// empty path means there are no contact templates
+func LoadSubjectTemplates(defaultTemplate string, path string) (*SubjectTemplates, error) {
+	contactTemplates := map[string]string{}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read subject templates file %s", path)
+		}
+		err = json.Unmarshal(data, &contactTemplates)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse subject templates file %s", path)
+		}
+	}
+	return NewSubjectTemplates(defaultTemplate, contactTemplates)
+}
+
+// parse template and try it on sample data, so typos in field names are found at startup
+func parseSubjectTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(invalidConfigError, "failed to parse subject template for %s: %s", name, err)
+	}
+	err = tmpl.Execute(ioutil.Discard, SubjectData{})
+	if err != nil {
+		return nil, errors.Wrapf(invalidConfigError, "failed to execute subject template for %s: %s", name, err)
+	}
+	return tmpl, nil
+}
+
+// render subject for the contact, contact template is preferred over deployment template
+func (t *SubjectTemplates) render(notificationID int, to string, data SubjectData) (string, error) {
+	tmpl := t.defaultTemplate
+	if contactTmpl, ok := t.contactTemplates[strconv.Itoa(notificationID)]; ok {
+		tmpl = contactTmpl
+	} else if contactTmpl, ok := t.contactTemplates[strings.ToLower(to)]; ok {
+		tmpl = contactTmpl
+	}
+
+	var subject bytes.Buffer
+	err := tmpl.Execute(&subject, data)
+	if err != nil {
+		return "", err
+	}
+	// subject must be single line
+	s := strings.Join(strings.Fields(subject.String()), " ")
+	// mail filters rely on the marker, so resends carry it even when template does not use it
+	if data.Reminder != "" && !strings.Contains(s, data.Reminder) {
+		s = data.Reminder + " " + s
+	}
+	return s, nil
+}
+
+func reminderMarker(resend int) string {
+	if resend <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("[REMINDER %d]", resend)
+}
+
+// incident duration rounded to seconds, empty when the incident start is unknown
+func incidentDuration(start time.Time) string {
+	if start.IsZero() {
+		return ""
+	}
+	return time.Since(start).Round(time.Second).String()
+}
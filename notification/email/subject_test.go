@@ -0,0 +1,137 @@
+package email
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func testSubjectData(resend int) SubjectData {
+	return SubjectData{
+		Brand:            "AlerTea",
+		Status:           "CRITICAL",
+		Host:             "myhost",
+		Target:           "84.12.34.54",
+		ServiceType:      "tcp",
+		Port:             "22",
+		IncidentDuration: "5m0s",
+		Resend:           resend,
+		Reminder:         reminderMarker(resend),
+	}
+}
+
+func TestSubjectTemplatesRender(t *testing.T) {
+	contactTemplates := map[string]string{
+		"42":                   "{{ .Status }} by id: {{ .Host }}",
+		"Oncall@Example.com":   "{{ .Status }} by address: {{ .Host }}",
+		"reminder@example.com": "{{ .Reminder }} {{ .Status }} {{ .Host }} down for {{ .IncidentDuration }}",
+		"multiline@example.com": `{{ .Status }}
+			{{ .Host }}   {{ .Target }}`,
+	}
+	templates, err := NewSubjectTemplates("", contactTemplates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name           string
+		notificationID int
+		to             string
+		resend         int
+		want           string
+	}{
+		{name: "default template", notificationID: 1, to: "someone@example.com", want: "[AlerTea] CRITICAL: myhost - tcp:84.12.34.54:22"},
+		{name: "default template reminder", notificationID: 1, to: "someone@example.com", resend: 2, want: "[AlerTea] [REMINDER 2] CRITICAL: myhost - tcp:84.12.34.54:22"},
+		{name: "contact by address, case insensitive", notificationID: 7, to: "oncall@EXAMPLE.com", want: "CRITICAL by address: myhost"},
+		// notification id wins over address
+		{name: "contact by notification id", notificationID: 42, to: "oncall@example.com", want: "CRITICAL by id: myhost"},
+		{name: "reminder inserted when template leaves it out", notificationID: 42, to: "oncall@example.com", resend: 3, want: "[REMINDER 3] CRITICAL by id: myhost"},
+		{name: "reminder kept where template puts it", notificationID: 8, to: "reminder@example.com", resend: 1, want: "[REMINDER 1] CRITICAL myhost down for 5m0s"},
+		{name: "empty reminder collapsed", notificationID: 8, to: "reminder@example.com", want: "CRITICAL myhost down for 5m0s"},
+		{name: "single line", notificationID: 9, to: "multiline@example.com", want: "CRITICAL myhost 84.12.34.54"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := templates.render(tc.notificationID, tc.to, testSubjectData(tc.resend))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewSubjectTemplatesErrors(t *testing.T) {
+	testCases := []struct {
+		name             string
+		defaultTemplate  string
+		contactTemplates map[string]string
+	}{
+		{name: "default parse error", defaultTemplate: "{{ .Status "},
+		{name: "default unknown field", defaultTemplate: "{{ .Severity }}"},
+		{name: "contact parse error", contactTemplates: map[string]string{"oncall@example.com": "{{ if .Reminder }}"}},
+		{name: "contact unknown field", contactTemplates: map[string]string{"42": "{{ .Hostname }}"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSubjectTemplates(tc.defaultTemplate, tc.contactTemplates)
+			if errors.Cause(err) != invalidConfigError {
+				t.Fatalf("expected invalid config error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadSubjectTemplates(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "subjects.json")
+	if err := ioutil.WriteFile(valid, []byte(`{"oncall@example.com": "{{ .Status }} {{ .Host }}"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte(`{"oncall@example.com": "{{ .Status "}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := LoadSubjectTemplates("{{ .Host }} is {{ .Status }}", valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := templates.render(1, "oncall@example.com", testSubjectData(0)); got != "CRITICAL myhost" {
+		t.Errorf("expected contact template from file, got %q", got)
+	}
+	if got, _ := templates.render(1, "other@example.com", testSubjectData(0)); got != "myhost is CRITICAL" {
+		t.Errorf("expected deployment template, got %q", got)
+	}
+
+	if _, err := LoadSubjectTemplates("", invalid); errors.Cause(err) != invalidConfigError {
+		t.Errorf("expected invalid config error for template in file, got %v", err)
+	}
+	if _, err := LoadSubjectTemplates("", filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestReminderMarker(t *testing.T) {
+	for resend, want := range map[int]string{-1: "", 0: "", 1: "[REMINDER 1]", 12: "[REMINDER 12]"} {
+		if got := reminderMarker(resend); got != want {
+			t.Errorf("resend %d expected %q, got %q", resend, want, got)
+		}
+	}
+}
+
+func TestIncidentDuration(t *testing.T) {
+	if got := incidentDuration(time.Time{}); got != "" {
+		t.Errorf("expected empty duration for unknown start, got %q", got)
+	}
+	if got := incidentDuration(time.Now().Add(-time.Minute*90 - time.Millisecond*200)); got != "1h30m0s" {
+		t.Errorf("expected duration rounded to seconds, got %q", got)
+	}
+}
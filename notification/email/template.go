@@ -4,12 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
-	"regexp"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/exmonitor/firefly/notification/payload"
 )
 
 const (
-	emailName = "AlerTea"
-
 	statusFailed   = `CRITICAL`
 	statusResolved = `Resolved`
 )
@@ -17,6 +18,9 @@ const (
 const emailTemplateCritical_ENG = `
 <html>
  <body>
+   {{ if .LogoURL }}
+   <img src="{{ .LogoURL }}" alt="{{ .Brand }}" height="40">
+   {{ end }}
    <h2>
      <font color="red">
        CRITICAL
@@ -42,11 +46,104 @@ const emailTemplateCritical_ENG = `
        <td><label>Port:</label></td>
        <td>{{ .Port }}</td>
      </tr>  
+     {{ with .Payload.HTTP }}
+     <tr>
+       <td><label>URL:</label></td>
+       <td><a rel="nofollow" style='text-decoration:none;'>{{ .Method }} {{ .URL }}</a></td>
+     </tr>
+     {{ if .ExpectedStatus }}
+     <tr>
+       <td><label>Expected status:</label></td>
+       <td>{{ .ExpectedStatus }}</td>
+     </tr>
+     {{ end }}
+     {{ if .ActualStatus }}
+     <tr>
+       <td><label>Actual status:</label></td>
+       <td>{{ .ActualStatus }}</td>
+     </tr>
+     {{ end }}
+     {{ if $.Payload.ResponseTime }}
+     <tr>
+       <td><label>Response time:</label></td>
+       <td>{{ duration $.Payload.ResponseTime }}</td>
+     </tr>
+     {{ end }}
+     {{ end }}
+     {{ with .Payload.TCP }}
+     <tr>
+       <td><label>Address:</label></td>
+       <td><a rel="nofollow" style='text-decoration:none;'>{{ .Address }}</a></td>
+     </tr>
+     {{ if .ErrorClass }}
+     <tr>
+       <td><label>Connect error:</label></td>
+       <td>{{ .ErrorClass }}</td>
+     </tr>
+     {{ end }}
+     {{ end }}
+     {{ with .Payload.ICMP }}
+     {{ if .PacketCount }}
+     <tr>
+       <td><label>Packets:</label></td>
+       <td>{{ .PacketCount }}</td>
+     </tr>
+     {{ end }}
+     {{ if ge .PacketLoss 0.0 }}
+     <tr>
+       <td><label>Packet loss:</label></td>
+       <td>{{ .PacketLoss }}%</td>
+     </tr>
+     {{ end }}
+     {{ if .RTT }}
+     <tr>
+       <td><label>RTT:</label></td>
+       <td>{{ duration .RTT }}</td>
+     </tr>
+     {{ end }}
+     {{ end }}
    </table>
    <br>
    <p>
      Failure reason: <a rel="nofollow" style='text-decoration:none;'> {{ .FailMessage }} </a>
    </p>
+   {{ if .History }}
+   <br>
+   <p>
+     Recent checks:
+   </p>
+   {{ if .HistoryChart }}
+   <p>
+     <img src="cid:response-time.png" alt="response time chart" width="300" height="50"><br>
+     <small>Response time, last {{ .HistoryWindow }}{{ if .HistoryAggregated }} (averaged){{ end }}</small>
+   </p>
+   {{ end }}
+   <table>
+     <tr>
+       <th align="left">Time</th>
+       <th align="left">Status</th>
+       <th align="left">Response time</th>
+       <th align="left">Message</th>
+     </tr>
+     {{ range .History }}
+     <tr>
+       <td>{{ .Time }}</td>
+       <td><font color="{{ .Color }}">{{ .Status }}</font></td>
+       <td>{{ .Duration }}</td>
+       <td>{{ .Message }}</td>
+     </tr>
+     {{ end }}
+   </table>
+   {{ end }}
+   {{ if or .Footer .SupportURL }}
+   <hr>
+   <p>
+     <small>
+       {{ .Footer }}
+       {{ if .SupportURL }}<br><a href="{{ .SupportURL }}">{{ .Brand }} support</a>{{ end }}
+     </small>
+   </p>
+   {{ end }}
  </body>
 </html>
 `
@@ -54,6 +151,9 @@ const emailTemplateCritical_ENG = `
 const emailTemplateOK_ENG = `
 <html>
  <body>
+   {{ if .LogoURL }}
+   <img src="{{ .LogoURL }}" alt="{{ .Brand }}" height="40">
+   {{ end }}
    <h2>
      <font color="green">
        Resolved
@@ -80,17 +180,199 @@ const emailTemplateOK_ENG = `
        <td><label>Port:</label></td>
        <td>{{ .Port }}</td>
      </tr>  
-   </table>   
+     {{ with .Payload.HTTP }}
+     <tr>
+       <td><label>URL:</label></td>
+       <td><a rel="nofollow" style='text-decoration:none;'>{{ .Method }} {{ .URL }}</a></td>
+     </tr>
+     {{ if .ExpectedStatus }}
+     <tr>
+       <td><label>Expected status:</label></td>
+       <td>{{ .ExpectedStatus }}</td>
+     </tr>
+     {{ end }}
+     {{ if .ActualStatus }}
+     <tr>
+       <td><label>Actual status:</label></td>
+       <td>{{ .ActualStatus }}</td>
+     </tr>
+     {{ end }}
+     {{ if $.Payload.ResponseTime }}
+     <tr>
+       <td><label>Response time:</label></td>
+       <td>{{ duration $.Payload.ResponseTime }}</td>
+     </tr>
+     {{ end }}
+     {{ end }}
+     {{ with .Payload.TCP }}
+     <tr>
+       <td><label>Address:</label></td>
+       <td><a rel="nofollow" style='text-decoration:none;'>{{ .Address }}</a></td>
+     </tr>
+     {{ if .ErrorClass }}
+     <tr>
+       <td><label>Connect error:</label></td>
+       <td>{{ .ErrorClass }}</td>
+     </tr>
+     {{ end }}
+     {{ end }}
+     {{ with .Payload.ICMP }}
+     {{ if .PacketCount }}
+     <tr>
+       <td><label>Packets:</label></td>
+       <td>{{ .PacketCount }}</td>
+     </tr>
+     {{ end }}
+     {{ if ge .PacketLoss 0.0 }}
+     <tr>
+       <td><label>Packet loss:</label></td>
+       <td>{{ .PacketLoss }}%</td>
+     </tr>
+     {{ end }}
+     {{ if .RTT }}
+     <tr>
+       <td><label>RTT:</label></td>
+       <td>{{ duration .RTT }}</td>
+     </tr>
+     {{ end }}
+     {{ end }}
+   </table>
+   {{ if .History }}
+   <br>
+   <p>
+     Recent checks:
+   </p>
+   {{ if .HistoryChart }}
+   <p>
+     <img src="cid:response-time.png" alt="response time chart" width="300" height="50"><br>
+     <small>Response time, last {{ .HistoryWindow }}{{ if .HistoryAggregated }} (averaged){{ end }}</small>
+   </p>
+   {{ end }}
+   <table>
+     <tr>
+       <th align="left">Time</th>
+       <th align="left">Status</th>
+       <th align="left">Response time</th>
+       <th align="left">Message</th>
+     </tr>
+     {{ range .History }}
+     <tr>
+       <td>{{ .Time }}</td>
+       <td><font color="{{ .Color }}">{{ .Status }}</font></td>
+       <td>{{ .Duration }}</td>
+       <td>{{ .Message }}</td>
+     </tr>
+     {{ end }}
+   </table>
+   {{ end }}
+   {{ if or .Footer .SupportURL }}
+   <hr>
+   <p>
+     <small>
+       {{ .Footer }}
+       {{ if .SupportURL }}<br><a href="{{ .SupportURL }}">{{ .Brand }} support</a>{{ end }}
+     </small>
+   </p>
+   {{ end }}
  </body>
 </html>
 `
 
+const emailTextTemplateCritical_ENG = `CRITICAL
+
+Your service has failed monitoring check.
+
+Host:       {{ .Host }}
+DNS/IP:     {{ .Target }}
+Check type: {{ .ServiceType }}
+Port:       {{ .Port }}
+{{ with .Payload.HTTP }}URL:        {{ .Method }} {{ .URL }}
+{{ with .ExpectedStatus }}Expected:   {{ . }}
+{{ end }}{{ with .ActualStatus }}Actual:     {{ . }}
+{{ end }}{{ with $.Payload.ResponseTime }}Response:   {{ duration . }}
+{{ end }}{{ end }}{{ with .Payload.TCP }}Address:    {{ .Address }}
+{{ with .ErrorClass }}Error:      {{ . }}
+{{ end }}{{ end }}{{ with .Payload.ICMP }}{{ with .PacketCount }}Packets:    {{ . }}
+{{ end }}{{ if ge .PacketLoss 0.0 }}Loss:       {{ .PacketLoss }}%
+{{ end }}{{ with .RTT }}RTT:        {{ duration . }}
+{{ end }}{{ end }}
+Failure reason: {{ .FailMessage }}
+{{ if .History }}
+{{ if .HistorySparkline }}Response time, last {{ .HistoryWindow }}{{ if .HistoryAggregated }} (averaged){{ end }}: {{ .HistorySparkline }}
+
+{{ end }}Recent checks:
+{{ range .History }}{{ .Time }}  {{ printf "%-4s" .Status }}  {{ printf "%10s" .Duration }}  {{ .Message }}
+{{ end }}{{ end }}{{ if or .Footer .SupportURL }}
+-- 
+{{ with .Footer }}{{ . }}
+{{ end }}{{ with .SupportURL }}Support: {{ . }}
+{{ end }}{{ end }}`
+
+const emailTextTemplateOK_ENG = `Resolved
+
+Your service has passed monitoring check.
+
+Host:       {{ .Host }}
+DNS/IP:     {{ .Target }}
+Check type: {{ .ServiceType }}
+Port:       {{ .Port }}
+{{ with .Payload.HTTP }}URL:        {{ .Method }} {{ .URL }}
+{{ with .ExpectedStatus }}Expected:   {{ . }}
+{{ end }}{{ with .ActualStatus }}Actual:     {{ . }}
+{{ end }}{{ with $.Payload.ResponseTime }}Response:   {{ duration . }}
+{{ end }}{{ end }}{{ with .Payload.TCP }}Address:    {{ .Address }}
+{{ with .ErrorClass }}Error:      {{ . }}
+{{ end }}{{ end }}{{ with .Payload.ICMP }}{{ with .PacketCount }}Packets:    {{ . }}
+{{ end }}{{ if ge .PacketLoss 0.0 }}Loss:       {{ .PacketLoss }}%
+{{ end }}{{ with .RTT }}RTT:        {{ duration . }}
+{{ end }}{{ end }}{{ if .History }}
+{{ if .HistorySparkline }}Response time, last {{ .HistoryWindow }}{{ if .HistoryAggregated }} (averaged){{ end }}: {{ .HistorySparkline }}
+
+{{ end }}Recent checks:
+{{ range .History }}{{ .Time }}  {{ printf "%-4s" .Status }}  {{ printf "%10s" .Duration }}  {{ .Message }}
+{{ end }}{{ end }}{{ if or .Footer .SupportURL }}
+-- 
+{{ with .Footer }}{{ . }}
+{{ end }}{{ with .SupportURL }}Support: {{ . }}
+{{ end }}{{ end }}`
+
+var textTemplateFuncs = texttemplate.FuncMap{
+	"duration": payload.FormatDuration,
+}
+
+var emailTextTemplateCritical = texttemplate.Must(texttemplate.New("emailTextCritical").Funcs(textTemplateFuncs).Parse(emailTextTemplateCritical_ENG))
+var emailTextTemplateOK = texttemplate.Must(texttemplate.New("emailTextOK").Funcs(textTemplateFuncs).Parse(emailTextTemplateOK_ENG))
+
+var defaultSubjectTemplates = mustSubjectTemplates(NewSubjectTemplates(DefaultSubjectTemplate, nil))
+
 type TemplateData struct {
+	Brand      string
+	LogoURL    string
+	Footer     string
+	SupportURL string
+
 	Host        string
 	Target      string
 	ServiceType string
 	Port        string
 	FailMessage string
+
+	// service type specific details
+	Payload *payload.Payload
+
+	History           []HistoryRow
+	HistoryChart      bool
+	HistorySparkline  string
+	HistoryWindow     string
+	HistoryAggregated bool
+}
+
+type HistoryRow struct {
+	Time     string
+	Status   string
+	Color    string
+	Duration string
+	Message  string
 }
 
 func (e *Email) emailBody() string {
@@ -103,19 +385,11 @@ func (e *Email) emailBody() string {
 		tmplText = emailTemplateOK_ENG
 	}
 
-	tmplData := TemplateData{
-		Host:        e.serviceInfo.Host,
-		Target:      e.serviceInfo.Target,
-		ServiceType: e.serviceInfo.ServiceTypeString(),
-		Port:        tryParsePort(e.serviceInfo.Metadata),
-		FailMessage: e.failedMsg,
-	}
-
-	tmpl, err := template.New("email").Parse(tmplText)
+	tmpl, err := template.New("email").Funcs(template.FuncMap(textTemplateFuncs)).Parse(tmplText)
 	if err != nil {
 		fmt.Errorf("failed to parse template: %s", err.Error())
 	} else {
-		err = tmpl.Execute(&body, tmplData)
+		err = tmpl.Execute(&body, e.templateData())
 		if err != nil {
 			fmt.Errorf("failed to execute template: %s", err.Error())
 		}
@@ -124,17 +398,98 @@ func (e *Email) emailBody() string {
 	return body.String()
 }
 
+// plain text alternative of the email body, for clients which dont render html
+func (e *Email) emailTextBody() string {
+	var body bytes.Buffer
+
+	tmpl := emailTextTemplateOK
+	if e.failed {
+		tmpl = emailTextTemplateCritical
+	}
+	// template is parsed at startup, execute can fail only on writer error which never happens for bytes.Buffer
+	tmpl.Execute(&body, e.templateData())
+
+	return body.String()
+}
+
+func (e *Email) templateData() TemplateData {
+	data := TemplateData{
+		Brand:       e.branding.Name,
+		LogoURL:     e.branding.LogoURL,
+		Footer:      e.branding.Footer,
+		SupportURL:  e.branding.SupportURL,
+		Host:        e.serviceInfo.Host,
+		Target:      e.serviceInfo.Target,
+		ServiceType: e.serviceInfo.ServiceTypeString(),
+		Port:        e.metadata.PortString(),
+		FailMessage: e.failedMsg,
+		Payload:     e.payload,
+	}
+	if e.history != nil {
+		data.History = historyRows(e.history)
+		data.HistoryChart = hasChart(e.history)
+		data.HistorySparkline = textSparkline(e.history.Points)
+		data.HistoryWindow = e.history.Window.String()
+		data.HistoryAggregated = e.history.Aggregated
+	}
+	return data
+}
+
+func historyRows(h *History) []HistoryRow {
+	var rows []HistoryRow
+	for _, c := range h.Checks {
+		row := HistoryRow{
+			Time:     c.Time.Format("2006-01-02 15:04:05 MST"),
+			Status:   "OK",
+			Color:    "green",
+			Duration: c.Duration.Round(time.Millisecond).String(),
+			Message:  c.Message,
+		}
+		if !c.Result {
+			row.Status = "FAIL"
+			row.Color = "red"
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// chart makes sense only with at least two points
+func hasChart(h *History) bool {
+	return h != nil && len(h.Points) > 1
+}
+
 func (e *Email) emailSubject() string {
-	//get string for bool value
-	status := stringStatus(e.failed)
-	// example '[AlerTea] CRITICAL: myhost - tcp:84.12.34.54'
-	subject := fmt.Sprintf("AlerTea: %s - %s ", status, e.serviceInfo.Host)
+	templates := e.subjectTemplates
+	if templates == nil {
+		templates = defaultSubjectTemplates
+	}
+	data := e.templateData()
+	subjectData := SubjectData{
+		Brand:            data.Brand,
+		Status:           stringStatus(e.failed),
+		Host:             data.Host,
+		Target:           data.Target,
+		ServiceType:      data.ServiceType,
+		Port:             data.Port,
+		IncidentDuration: incidentDuration(e.incidentStart),
+		Resend:           e.resend,
+		Reminder:         reminderMarker(e.resend),
+	}
 
+	subject, err := templates.render(e.notificationID, e.to, subjectData)
+	if err != nil {
+		// templates are verified at startup, but never send email without subject
+		subject, _ = defaultSubjectTemplates.render(e.notificationID, e.to, subjectData)
+	}
 	return subject
 }
 
-func fromHeader(emailFrom string, name string) string {
-	return fmt.Sprintf("%s <%s>", name, emailFrom)
+func mustSubjectTemplates(t *SubjectTemplates, err error) *SubjectTemplates {
+	if err != nil {
+		panic(err)
+	}
+	return t
 }
 
 func stringStatus(s bool) string {
@@ -144,17 +499,3 @@ func stringStatus(s bool) string {
 		return statusResolved
 	}
 }
-
-// try parse port number from service metadata
-// in case of service without port, it will return empty string
-func tryParsePort(metadata string) string {
-	port := ""
-	regExp := regexp.MustCompile(`"port": (\d*),`)
-
-	result := regExp.FindStringSubmatch(metadata)
-	if len(result) > 1 {
-		port = fmt.Sprintf("%s", result[1])
-	}
-
-	return port
-}
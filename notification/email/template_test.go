@@ -0,0 +1,121 @@
+package email
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exclient/database/spec/service"
+	"github.com/exmonitor/exclient/database/spec/status"
+
+	"github.com/exmonitor/firefly/notification/payload"
+	"github.com/exmonitor/firefly/service/metadata"
+)
+
+func TestTemplateTypeSections(t *testing.T) {
+	httpService := &service.Service{ID: 5, Type: 1, Host: "myhost", Target: "master.cz", Metadata: `{"target": "https://master.cz", "method": "get", "query": "?a=1", "allowedHttpStatusCodes": [200, 201]}`}
+	tcpService := &service.Service{ID: 3, Type: 2, Host: "myhost", Target: "10.0.0.1", Metadata: `{"target": "10.0.0.1", "port": 22}`}
+	icmpService := &service.Service{ID: 4, Type: 3, Host: "myhost", Target: "8.8.8.8", Metadata: `{"target": "8.8.8.8", "packetCount": 3}`}
+
+	// labels of all type sections, every case lists the labels it expects
+	htmlLabels := []string{"URL:", "Expected status:", "Actual status:", "Response time:", "Address:", "Connect error:", "Packets:", "Packet loss:", "RTT:"}
+	textLabels := []string{"URL:", "Expected:", "Actual:", "Response:", "Address:", "Error:", "Packets:", "Loss:", "RTT:"}
+
+	testCases := []struct {
+		name        string
+		serviceInfo *service.Service
+		failed      bool
+		failMessage string
+		lastStatus  *status.ServiceStatus
+		wantHTML    []string
+		wantText    []string
+	}{
+		{
+			name:        "http critical",
+			serviceInfo: httpService,
+			failed:      true,
+			failMessage: "status code 503",
+			lastStatus:  &status.ServiceStatus{Duration: 250 * time.Millisecond},
+			wantHTML:    []string{"URL:", "GET https://master.cz?a=1", "Expected status:", "200, 201", "Actual status:", "503", "Response time:", "250ms"},
+			wantText:    []string{"URL:        GET https://master.cz?a=1\n", "Expected:   200, 201\n", "Actual:     503\n", "Response:   250ms\n", "Failure reason: status code 503\n"},
+		},
+		{
+			name:        "http resolved",
+			serviceInfo: httpService,
+			wantHTML:    []string{"URL:", "GET https://master.cz?a=1", "Expected status:", "200, 201"},
+			wantText:    []string{"URL:        GET https://master.cz?a=1\n", "Expected:   200, 201\n"},
+		},
+		{
+			name:        "tcp critical",
+			serviceInfo: tcpService,
+			failed:      true,
+			failMessage: "dial tcp 10.0.0.1:22: connect: connection refused",
+			wantHTML:    []string{"Address:", "10.0.0.1:22", "Connect error:", "refused"},
+			wantText:    []string{"Port:       22\n", "Address:    10.0.0.1:22\n", "Error:      refused\n"},
+		},
+		{
+			name:        "tcp resolved",
+			serviceInfo: tcpService,
+			wantHTML:    []string{"Address:", "10.0.0.1:22"},
+			wantText:    []string{"Address:    10.0.0.1:22\n"},
+		},
+		{
+			name:        "icmp critical",
+			serviceInfo: icmpService,
+			failed:      true,
+			failMessage: "3 packets transmitted, 0 received, 100% packet loss, time 2003ms",
+			wantHTML:    []string{"Packets:", "Packet loss:", "100%"},
+			wantText:    []string{"Packets:    3\n", "Loss:       100%\n"},
+		},
+		{
+			name:        "icmp resolved",
+			serviceInfo: icmpService,
+			lastStatus:  &status.ServiceStatus{Message: "rtt min/avg/max/mdev = 10.125/12.5/15.75/1.25 ms"},
+			wantHTML:    []string{"Packets:", "RTT:", "12.5ms"},
+			wantText:    []string{"Packets:    3\n", "RTT:        12.5ms\n"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := metadata.Decode(tc.serviceInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := NewEmail(EmailConfig{
+				To:          "oncall@example.com",
+				Failed:      tc.failed,
+				FailedMsg:   tc.failMessage,
+				ServiceInfo: tc.serviceInfo,
+				Metadata:    m,
+				Payload:     payload.New(tc.serviceInfo, m, tc.failed, tc.failMessage, tc.lastStatus),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkSections(t, "html", e.emailBody(), tc.wantHTML, htmlLabels)
+			checkSections(t, "text", e.emailTextBody(), tc.wantText, textLabels)
+		})
+	}
+}
+
+// body must contain every wanted string and no label of other sections
+func checkSections(t *testing.T, kind string, body string, want []string, labels []string) {
+	for _, w := range want {
+		if !strings.Contains(body, w) {
+			t.Errorf("%s body does not contain %q:\n%s", kind, w, body)
+		}
+	}
+	for _, label := range labels {
+		expected := false
+		for _, w := range want {
+			if strings.HasPrefix(w, label) {
+				expected = true
+			}
+		}
+		if !expected && strings.Contains(body, label) {
+			t.Errorf("%s body contains unexpected section %q:\n%s", kind, label, body)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// set Message-ID, In-Reply-To and References headers so mail clients thread all emails of the incident
+// first email of the thread has stable Message-ID derived from thread id, follow-ups reply to it
+func setThreadHeaders(m *gomail.Message, threadID string, followUp bool, from string) {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	root := fmt.Sprintf("<firefly.%s@%s>", threadID, domain)
+
+	if !followUp {
+		m.SetHeader("Message-ID", root)
+		return
+	}
+	m.SetHeader("Message-ID", fmt.Sprintf("<firefly.%s.%d.%d@%s>", threadID, time.Now().UnixNano(), atomic.AddUint64(&deliveryCounter, 1), domain))
+	m.SetHeader("In-Reply-To", root)
+	m.SetHeader("References", root)
+}
@@ -0,0 +1,69 @@
+package email
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// render message and parse its headers back, so header values are checked the way mail clients see them
+func renderedHeader(t *testing.T, from string, threadID string, followUp bool) mail.Header {
+	m := testMessage("oncall@example.com")
+	setThreadHeaders(m, threadID, followUp, from)
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.Header
+}
+
+func TestSetThreadHeaders(t *testing.T) {
+	testCases := []struct {
+		name     string
+		from     string
+		threadID string
+		wantRoot string
+	}{
+		{name: "sender domain", from: "alerts@example.com", threadID: "42-1700000000", wantRoot: "<firefly.42-1700000000@example.com>"},
+		{name: "last at sign wins", from: "\"ops@team\"@mail.example.org", threadID: "7-1", wantRoot: "<firefly.7-1@mail.example.org>"},
+		{name: "sender without domain", from: "alerts", threadID: "7-1", wantRoot: "<firefly.7-1@localhost>"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			first := renderedHeader(t, tc.from, tc.threadID, false)
+			if got := first.Get("Message-ID"); got != tc.wantRoot {
+				t.Errorf("expected first Message-ID %s, got %s", tc.wantRoot, got)
+			}
+			if first.Get("In-Reply-To") != "" || first.Get("References") != "" {
+				t.Errorf("first email must not reply to anything, got In-Reply-To %q, References %q", first.Get("In-Reply-To"), first.Get("References"))
+			}
+
+			domain := tc.wantRoot[strings.LastIndex(tc.wantRoot, "@"):]
+			seen := map[string]bool{tc.wantRoot: true}
+			for i := 0; i < 3; i++ {
+				followUp := renderedHeader(t, tc.from, tc.threadID, true)
+				id := followUp.Get("Message-ID")
+				if seen[id] {
+					t.Errorf("follow-up %d reuses Message-ID %s", i, id)
+				}
+				seen[id] = true
+				if !strings.HasPrefix(id, "<firefly."+tc.threadID+".") || !strings.HasSuffix(id, domain) {
+					t.Errorf("follow-up %d has unexpected Message-ID %s", i, id)
+				}
+				if got := followUp.Get("In-Reply-To"); got != tc.wantRoot {
+					t.Errorf("follow-up %d expected In-Reply-To %s, got %s", i, tc.wantRoot, got)
+				}
+				if got := followUp.Get("References"); got != tc.wantRoot {
+					t.Errorf("follow-up %d expected References %s, got %s", i, tc.wantRoot, got)
+				}
+			}
+		})
+	}
+}
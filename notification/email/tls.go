@@ -0,0 +1,91 @@
+package email
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// implicit TLS, connection is encrypted from the first byte (usually port 465)
+	tlsModeImplicit = "tls"
+	// plain connection upgraded via STARTTLS command (usually port 587)
+	tlsModeStartTLS = "starttls"
+	// no encryption at all, meant only for local relays
+	tlsModeNone = "none"
+)
+
+func validTLSMode(mode string) bool {
+	switch mode {
+	case tlsModeImplicit, tlsModeStartTLS, tlsModeNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// build tls config for connection to SMTP server
+// certificates are verified against CAFile (or system pool if empty) unless fingerprint pinning is used,
+// in that case only the pinned leaf certificate is accepted, which allows to use self signed certificates safely
+func buildTLSConfig(conf SMTPConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         conf.Server,
+		InsecureSkipVerify: conf.TLSSkipVerify,
+	}
+
+	if conf.CAFile != "" {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read CA file %s", conf.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Wrapf(invalidConfigError, "CA file %s does not contain any PEM certificate", conf.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if conf.ClientCertFile != "" || conf.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.ClientCertFile, conf.ClientKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load SMTP client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if conf.TLSFingerprint != "" {
+		pin, err := parseFingerprint(conf.TLSFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		// chain verification is replaced by comparing the leaf certificate with the pinned one
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.Wrap(fingerprintMismatchError, "server did not present any certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !strings.EqualFold(hex.EncodeToString(sum[:]), pin) {
+				return errors.Wrapf(fingerprintMismatchError, "got %s", hex.EncodeToString(sum[:]))
+			}
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// parse sha256 fingerprint in hex format, colons are allowed (openssl x509 -fingerprint -sha256 output)
+func parseFingerprint(fingerprint string) (string, error) {
+	pin := strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+	b, err := hex.DecodeString(pin)
+	if err != nil || len(b) != sha256.Size {
+		return "", errors.Wrapf(invalidConfigError, "TLS fingerprint %s is not valid sha256 hex string", fingerprint)
+	}
+	return pin, nil
+}
@@ -0,0 +1,101 @@
+package email
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"gopkg.in/gomail.v2"
+)
+
+const (
+	transportSMTP     = "smtp"
+	transportSendmail = "sendmail"
+	transportMaildir  = "maildir"
+	transportFile     = "file"
+)
+
+func validTransport(name string) bool {
+	switch name {
+	case transportSMTP, transportSendmail, transportMaildir, transportFile:
+		return true
+	default:
+		return false
+	}
+}
+
+// transport delivers rendered emails
+// every worker dials its own transportConn, SMTP keeps persistent connection, other transports are stateless
+type transport interface {
+	Dial() (transportConn, error)
+	// address used in logs and health report
+	String() string
+}
+
+type transportConn interface {
+	gomail.Sender
+	// keep connection alive, error means the connection is broken
+	noop() error
+	Close() error
+}
+
+// permanentError marks failure which will not disappear by retrying, email is moved to dead letter store
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func newPermanentError(err error) error {
+	return &permanentError{err: err}
+}
+
+// nopConn is transportConn for transports without connection
+type nopConn struct {
+	send gomail.SendFunc
+}
+
+func (c *nopConn) Send(from string, to []string, msg io.WriterTo) error {
+	return c.send(from, to, msg)
+}
+
+func (c *nopConn) noop() error {
+	return nil
+}
+
+func (c *nopConn) Close() error {
+	return nil
+}
+
+// build list of transports in failover order
+func (d *Daemon) buildTransports() ([]transport, error) {
+	switch d.transport {
+	case transportSMTP:
+		var transports []transport
+		for _, smtpConfig := range append([]SMTPConfig{d.smtpConfig}, d.failoverSMTPConfigs...) {
+			dialer, err := newSMTPDialer(smtpConfig)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to prepare SMTP dialer for %s", smtpConfig.Server)
+			}
+			transports = append(transports, dialer)
+		}
+		return transports, nil
+	case transportSendmail:
+		return []transport{newSendmailTransport(d.sendmailPath)}, nil
+	case transportMaildir:
+		t, err := newMaildirTransport(d.maildirPath)
+		if err != nil {
+			return nil, err
+		}
+		return []transport{t}, nil
+	case transportFile:
+		t, err := newFileTransport(d.fileDir)
+		if err != nil {
+			return nil, err
+		}
+		return []transport{t}, nil
+	default:
+		return nil, errors.Wrapf(invalidConfigError, "unknown transport %s", d.transport)
+	}
+}
@@ -0,0 +1,224 @@
+package email
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/gomail.v2"
+)
+
+const (
+	testSubject   = "[FAIL] Služba api.example.com je nedostupná"
+	testPlainBody = "Service api.example.com is down."
+	testHTMLBody  = "<p>Service <b>api.example.com</b> is down.</p>"
+)
+
+func testMultipartMessage(to string) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetAddressHeader("From", "alerts@example.com", "AlerTea")
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", testSubject)
+	m.SetBody("text/plain", testPlainBody)
+	m.AddAlternative("text/html", testHTMLBody)
+	return m
+}
+
+// parse .eml file back, returns headers and body of every MIME part by content type
+func parseEML(t *testing.T, path string) (mail.Header, map[string]string) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %s", path, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("expected multipart/alternative message, got %s", mediaType)
+	}
+
+	parts := map[string]string{}
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+	return msg.Header, parts
+}
+
+func checkEML(t *testing.T, path string, to string) {
+	header, parts := parseEML(t, path)
+
+	from, err := header.AddressList("From")
+	if err != nil {
+		t.Fatalf("failed to parse From: %s", err)
+	}
+	if len(from) != 1 || from[0].Name != "AlerTea" || from[0].Address != "alerts@example.com" {
+		t.Errorf("unexpected From %v", from)
+	}
+	if header.Get("To") != to {
+		t.Errorf("expected To %s, got %s", to, header.Get("To"))
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != testSubject {
+		t.Errorf("expected subject %q, got %q", testSubject, subject)
+	}
+	if parts["text/plain"] != testPlainBody {
+		t.Errorf("expected plain part %q, got %q", testPlainBody, parts["text/plain"])
+	}
+	if parts["text/html"] != testHTMLBody {
+		t.Errorf("expected html part %q, got %q", testHTMLBody, parts["text/html"])
+	}
+}
+
+func sendThrough(t *testing.T, tr transport, to ...string) {
+	conn, err := tr.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, addr := range to {
+		if err := gomail.Send(conn, testMultipartMessage(addr)); err != nil {
+			t.Fatalf("failed to send email to %s: %s", addr, err)
+		}
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "eml")
+	tr, err := newFileTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendThrough(t, tr, "first@example.com", "second@example.com")
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 .eml files, got %v", files)
+	}
+	for _, to := range []string{"first@example.com", "second@example.com"} {
+		var found string
+		for _, f := range files {
+			if strings.HasSuffix(f, "-"+to+".eml") {
+				found = f
+			}
+		}
+		if found == "" {
+			t.Errorf("no .eml file for %s in %v", to, files)
+			continue
+		}
+		checkEML(t, found, to)
+	}
+}
+
+func TestMaildirTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+	tr, err := newMaildirTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendThrough(t, tr, "oncall@example.com")
+
+	// message is moved out of tmp once written
+	for sub, want := range map[string]int{"tmp": 0, "new": 1, "cur": 0} {
+		files, err := ioutil.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != want {
+			t.Fatalf("expected %d files in %s, got %d", want, sub, len(files))
+		}
+		if want == 1 {
+			checkEML(t, filepath.Join(dir, sub, files[0].Name()), "oncall@example.com")
+		}
+	}
+}
+
+// write sendmail stub which stores its arguments and stdin next to itself and exits with exitCode
+func newSendmailStub(t *testing.T, exitCode int) string {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sendmail")
+	script := fmt.Sprintf("#!/bin/sh\nprintf '%%s\\n' \"$@\" > %s/args\ncat > %s/stdin\n[ %d -eq 0 ] || echo 'stub refused message' >&2\nexit %d\n", dir, dir, exitCode, exitCode)
+	if err := ioutil.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSendmailTransport(t *testing.T) {
+	testCases := []struct {
+		name          string
+		exitCode      int
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{name: "delivered", exitCode: 0},
+		// EX_TEMPFAIL
+		{name: "temporary failure", exitCode: 75, wantErr: true},
+		// EX_NOUSER
+		{name: "unknown user", exitCode: 67, wantErr: true, wantPermanent: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := newSendmailStub(t, tc.exitCode)
+			conn, err := newSendmailTransport(path).Dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := testMultipartMessage("oncall@example.com")
+			err = conn.Send("bounces+1-2@example.com", []string{"oncall@example.com", "backup@example.com"}, m)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %t, got %v", tc.wantErr, err)
+			}
+			if isPermanentError(err) != tc.wantPermanent {
+				t.Errorf("expected permanent %t, got %v", tc.wantPermanent, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "stub refused message") {
+				t.Errorf("expected stderr of sendmail in error, got %s", err)
+			}
+
+			dir := filepath.Dir(path)
+			args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantArgs := "-i\n-f\nbounces+1-2@example.com\n--\noncall@example.com\nbackup@example.com\n"
+			if string(args) != wantArgs {
+				t.Errorf("expected args %q, got %q", wantArgs, args)
+			}
+			checkEML(t, filepath.Join(dir, "stdin"), "oncall@example.com")
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package email
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// VERP (variable envelope return path) encodes service and notification id into envelope sender,
+// so the bounce can be mapped back to the contact, e.g. alert+b-12-345@alertea.com
+const verpTag = "+b-"
+
+var verpRegexp = regexp.MustCompile(`\+b-(\d+)-(\d+)@`)
+
+// build VERP return path from the 'From' address
+func VERPAddress(from string, serviceID int, notificationID int) string {
+	at := strings.LastIndex(from, "@")
+	if at < 0 {
+		return from
+	}
+	local := from[:at]
+	// never nest tags when From already contains plus addressing
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	return fmt.Sprintf("%s%s%d-%d%s", local, verpTag, serviceID, notificationID, from[at:])
+}
+
+// parse service and notification id from VERP address
+func ParseVERP(address string) (int, int, bool) {
+	result := verpRegexp.FindStringSubmatch(address)
+	if len(result) != 3 {
+		return 0, 0, false
+	}
+	serviceID, err := strconv.Atoi(result[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	notificationID, err := strconv.Atoi(result[2])
+	if err != nil {
+		return 0, 0, false
+	}
+	return serviceID, notificationID, true
+}
@@ -0,0 +1,43 @@
+package email
+
+import "testing"
+
+func TestVERPAddress(t *testing.T) {
+	tests := []struct {
+		from string
+		want string
+	}{
+		{from: "alert@example.com", want: "alert+b-12-345@example.com"},
+		// existing plus tag is replaced, never nested
+		{from: "alert+monitoring@example.com", want: "alert+b-12-345@example.com"},
+		{from: "invalid", want: "invalid"},
+	}
+	for _, tt := range tests {
+		if got := VERPAddress(tt.from, 12, 345); got != tt.want {
+			t.Errorf("VERPAddress(%q) = %q, want %q", tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestParseVERP(t *testing.T) {
+	tests := []struct {
+		address        string
+		serviceID      int
+		notificationID int
+		ok             bool
+	}{
+		{address: VERPAddress("alert@example.com", 12, 345), serviceID: 12, notificationID: 345, ok: true},
+		{address: "<alert+b-1-2@example.com>", serviceID: 1, notificationID: 2, ok: true},
+		{address: "Firefly <alert+b-7-88@example.com>", serviceID: 7, notificationID: 88, ok: true},
+		{address: "alert@example.com", ok: false},
+		{address: "alert+b-12@example.com", ok: false},
+		{address: "alert+b-12-x@example.com", ok: false},
+		{address: "alert+b-99999999999999999999-1@example.com", ok: false},
+	}
+	for _, tt := range tests {
+		serviceID, notificationID, ok := ParseVERP(tt.address)
+		if ok != tt.ok || serviceID != tt.serviceID || notificationID != tt.notificationID {
+			t.Errorf("ParseVERP(%q) = %d, %d, %t, want %d, %d, %t", tt.address, serviceID, notificationID, ok, tt.serviceID, tt.notificationID, tt.ok)
+		}
+	}
+}
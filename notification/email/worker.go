@@ -0,0 +1,158 @@
+package email
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// NOOP is sent on idle connections in this interval to keep them open
+	keepaliveInterval = time.Minute
+)
+
+// worker sends emails from the shared queue, every worker keeps its own persistent connection to each server
+type worker struct {
+	id     int
+	daemon *Daemon
+	conns  map[*server]transportConn
+}
+
+func newWorker(id int, d *Daemon) *worker {
+	return &worker{
+		id:     id,
+		daemon: d,
+		conns:  map[*server]transportConn{},
+	}
+}
+
+func (w *worker) run() {
+	d := w.daemon
+	// make sure that unexpected panic never stops email delivery
+	defer func() {
+		if r := recover(); r != nil {
+			d.logger.LogError(fmt.Errorf("%v", r), "email worker %d crashed, restarting", w.id)
+			w.closeConnections()
+			go newWorker(w.id, d).run()
+		}
+	}()
+
+	nextKeepalive := time.Now().Add(keepaliveInterval)
+	for {
+		q, ok := d.queue.pop(nextKeepalive)
+		if !ok {
+			w.closeConnections()
+			return
+		}
+		if q == nil {
+			w.keepalive()
+			nextKeepalive = time.Now().Add(keepaliveInterval)
+			continue
+		}
+
+		err := w.send(q)
+		q.attempts++
+		switch {
+		case err == nil:
+			d.logger.LogDebug("worker %d sent email to %s", w.id, q.msg.GetHeader("To"))
+		case isPermanentError(err):
+			d.moveToDeadLetter(q, err)
+		case time.Since(q.queuedAt) > maxQueueAge:
+			d.moveToDeadLetter(q, errors.Wrapf(err, "email was not delivered in %s", maxQueueAge))
+		default:
+			// all servers are unavailable, return email to the queue and try again later
+			if !d.queue.requeue(q, queueRetryInterval) {
+				// daemon stopped while the email was being sent
+				d.moveToDeadLetter(q, errors.Wrap(daemonStoppedError, err.Error()))
+				continue
+			}
+			critical, recovery := d.queue.depth()
+			d.logger.LogError(err, "failed to send email to %s, attempt %d, %d emails queued", q.msg.GetHeader("To"), q.attempts, critical+recovery)
+		}
+	}
+}
+
+// send email via first available server
+func (w *worker) send(q *queuedEmail) error {
+	d := w.daemon
+	// malformed address is refused before dialing, it must never count as server failure
+	if _, _, err := envelope(q.msg); err != nil {
+		return newPermanentError(err)
+	}
+	var lastErr error = allServersUnavailableError
+	for _, s := range d.servers {
+		if !s.breaker.allow() {
+			continue
+		}
+
+		err := w.sendVia(s, q)
+		if err == nil {
+			s.breaker.success()
+			d.Lock()
+			s.lastSuccess = time.Now()
+			s.lastError = nil
+			d.Unlock()
+			return nil
+		}
+		if isPermanentError(err) {
+			// server is fine, it just refused this message
+			s.breaker.success()
+			return err
+		}
+
+		d.Lock()
+		s.lastError = err
+		d.Unlock()
+		if s.breaker.failure() {
+			_, _, openUntil := s.breaker.status()
+			d.logger.LogError(err, "server %s is unavailable, skipping it until %s", s.address, openUntil.Format(time.RFC3339))
+		}
+		lastErr = errors.Wrapf(err, "server %s", s.address)
+	}
+	return lastErr
+}
+
+func (w *worker) sendVia(s *server, q *queuedEmail) error {
+	// if connection to server is closed, open it
+	conn, ok := w.conns[s]
+	if !ok {
+		c, err := s.transport.Dial()
+		if err != nil {
+			return errors.Wrap(err, "failed to connect to server")
+		}
+		conn = c
+		w.conns[s] = conn
+	}
+
+	err := sendMessage(conn, q.msg, q.content())
+	// after permanent error the transaction was reset, the connection is kept only when it still responds
+	if err != nil && (!isPermanentError(err) || conn.noop() != nil) {
+		// connection is in unknown state, drop it and dial again next time
+		conn.Close()
+		delete(w.conns, s)
+	}
+	return err
+}
+
+// send NOOP on all open connections, broken connections are dropped and dialed again when needed
+func (w *worker) keepalive() {
+	for s, conn := range w.conns {
+		err := conn.noop()
+		if err != nil {
+			w.daemon.logger.LogDebug("worker %d dropping connection to server %s: %s", w.id, s.address, err)
+			conn.Close()
+			delete(w.conns, s)
+		}
+	}
+}
+
+func (w *worker) closeConnections() {
+	for s, conn := range w.conns {
+		err := conn.Close()
+		if err != nil {
+			w.daemon.logger.LogDebug("worker %d failed to close connection to server %s: %s", w.id, s.address, err)
+		}
+		delete(w.conns, s)
+	}
+}
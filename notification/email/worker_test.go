@@ -0,0 +1,168 @@
+package email
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"gopkg.in/gomail.v2"
+)
+
+// fakeTransport counts dials and sends, every send returns err
+// send blocks until release is closed when release is set
+type fakeTransport struct {
+	mu      sync.Mutex
+	dials   int
+	sends   int
+	err     error
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (t *fakeTransport) Dial() (transportConn, error) {
+	t.mu.Lock()
+	t.dials++
+	t.mu.Unlock()
+	return &nopConn{send: func(string, []string, io.WriterTo) error {
+		if t.release != nil {
+			t.sending <- struct{}{}
+			<-t.release
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.sends++
+		return t.err
+	}}, nil
+}
+
+func (t *fakeTransport) String() string {
+	return "fake"
+}
+
+func newTestDaemon(t *testing.T, transports ...transport) *Daemon {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetterDir := t.TempDir()
+	deadLetter, err := newDeadLetterStore(deadLetterDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Daemon{
+		deadLetterDir: deadLetterDir,
+		deadLetter:    deadLetter,
+		queue:         newEmailQueue(10),
+		logger:        logger,
+	}
+	for _, tr := range transports {
+		d.servers = append(d.servers, &server{address: tr.String(), transport: tr, breaker: newCircuitBreaker()})
+	}
+	return d
+}
+
+func deadLetterFiles(t *testing.T, d *Daemon) []string {
+	files, err := filepath.Glob(filepath.Join(d.deadLetterDir, "*.err"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestWorkerMovesInvalidAddressToDeadLetter(t *testing.T) {
+	tr := &fakeTransport{}
+	d := newTestDaemon(t, tr, tr)
+	w := newWorker(0, d)
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", "alerts@example.com")
+	m.SetHeader("To", "broken address")
+	m.SetBody("text/plain", "body")
+	err := w.send(&queuedEmail{msg: m, critical: true, queuedAt: time.Now()})
+	if !isPermanentError(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	if tr.dials != 0 {
+		t.Fatalf("expected no dial for invalid address, got %d", tr.dials)
+	}
+	for _, s := range d.servers {
+		if state, failures, _ := s.breaker.status(); state != breakerClosed || failures != 0 {
+			t.Fatalf("expected closed breaker without failures, got %s with %d failures", state, failures)
+		}
+	}
+}
+
+func TestWorkerRunDeadLettersInvalidAddressWithoutPausingQueue(t *testing.T) {
+	tr := &fakeTransport{}
+	d := newTestDaemon(t, tr)
+
+	bad := gomail.NewMessage()
+	bad.SetHeader("From", "alerts@example.com")
+	bad.SetHeader("To", "broken address")
+	bad.SetBody("text/plain", "body")
+	d.queue.push(&queuedEmail{msg: bad, critical: true, queuedAt: time.Now()})
+	d.queue.push(&queuedEmail{msg: testMessage("ops@example.com"), critical: true, queuedAt: time.Now()})
+
+	done := make(chan struct{})
+	go func() {
+		newWorker(0, d).run()
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tr.mu.Lock()
+		sends := tr.sends
+		tr.mu.Unlock()
+		if sends == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("valid email was not sent after invalid one")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.queue.close()
+	<-done
+
+	files := deadLetterFiles(t, d)
+	if len(files) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(files))
+	}
+	reason, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("dead letter reason: %s", reason)
+}
+
+func TestWorkerDeadLettersEmailInFlightWhenQueueCloses(t *testing.T) {
+	tr := &fakeTransport{
+		err:     errors.New("connection refused"),
+		sending: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	d := newTestDaemon(t, tr)
+	d.queue.push(&queuedEmail{msg: testMessage("ops@example.com"), critical: true, queuedAt: time.Now()})
+
+	done := make(chan struct{})
+	go func() {
+		newWorker(0, d).run()
+		close(done)
+	}()
+	<-tr.sending
+	// daemon stops while the only email is being sent
+	if rest := d.queue.close(); len(rest) != 0 {
+		t.Fatalf("expected empty queue, got %d emails", len(rest))
+	}
+	close(tr.release)
+	<-done
+
+	if files := deadLetterFiles(t, d); len(files) != 1 {
+		t.Fatalf("expected in-flight email in dead letter store, got %d files", len(files))
+	}
+}
@@ -5,3 +5,21 @@ import "errors"
 var invalidConfigError error = errors.New("invalid config")
 
 var unknownContactTypeError error = errors.New("unknown contact type")
+
+var webhookDisabledError error = errors.New("webhook channel is not configured")
+
+var missingServiceInfoError error = errors.New("service info is missing")
+
+var slackDisabledError error = errors.New("Slack channel is not configured")
+
+var teamsDisabledError error = errors.New("Teams channel is not configured")
+
+var telegramDisabledError error = errors.New("Telegram channel is not configured")
+
+var pagerDutyDisabledError error = errors.New("PagerDuty channel is not configured")
+
+var opsgenieDisabledError error = errors.New("Opsgenie channel is not configured")
+
+var discordDisabledError error = errors.New("Discord channel is not configured")
+
+var matrixDisabledError error = errors.New("Matrix channel is not configured")
@@ -0,0 +1,37 @@
+package retry
+
+import (
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+const (
+	// delays between attempts of notification channels
+	DefaultInterval    = time.Second
+	DefaultMaxInterval = time.Second * 30
+)
+
+// New returns exponential backoff with default delays, which allows at most retries repeated attempts
+func New(retries int) backoff.BackOff {
+	return NewExponential(retries, DefaultInterval, DefaultMaxInterval)
+}
+
+// NewExponential returns exponential backoff starting at interval and capped at maxInterval
+// zero retries means single attempt, WithMaxRetries would retry forever
+func NewExponential(retries int, interval time.Duration, maxInterval time.Duration) backoff.BackOff {
+	if retries <= 0 {
+		return &backoff.StopBackOff{}
+	}
+	b := &backoff.ExponentialBackOff{
+		InitialInterval:     interval,
+		RandomizationFactor: backoff.DefaultRandomizationFactor,
+		Multiplier:          backoff.DefaultMultiplier,
+		MaxInterval:         maxInterval,
+		MaxElapsedTime:      0,
+		Clock:               backoff.SystemClock,
+	}
+	b.Reset()
+
+	return backoff.WithMaxRetries(b, uint64(retries))
+}
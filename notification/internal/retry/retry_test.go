@@ -0,0 +1,59 @@
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+func TestNewExponential(t *testing.T) {
+	testCases := []struct {
+		name        string
+		retries     int
+		maxInterval time.Duration
+		wantDelays  int
+	}{
+		{name: "zero retries is single attempt", retries: 0, maxInterval: time.Second * 30, wantDelays: 0},
+		{name: "negative retries is single attempt", retries: -1, maxInterval: time.Second * 30, wantDelays: 0},
+		{name: "three retries", retries: 3, maxInterval: time.Second * 30, wantDelays: 3},
+		{name: "capped delay", retries: 8, maxInterval: time.Second * 2, wantDelays: 8},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewExponential(tc.retries, DefaultInterval, tc.maxInterval)
+			delays := 0
+			for {
+				next := b.NextBackOff()
+				if next == backoff.Stop {
+					break
+				}
+				delays++
+				if delays > tc.retries {
+					t.Fatalf("backoff did not stop after %d retries", tc.retries)
+				}
+				// randomization may stretch the delay by half
+				if next <= 0 || next > tc.maxInterval*3/2 {
+					t.Errorf("delay %d out of range: %s", delays, next)
+				}
+			}
+			if delays != tc.wantDelays {
+				t.Fatalf("expected %d delays, got %d", tc.wantDelays, delays)
+			}
+		})
+	}
+}
+
+func TestNewWithoutRetries(t *testing.T) {
+	errTest := errors.New("test error")
+	attempts := 0
+	err := backoff.Retry(func() error {
+		attempts++
+		return errTest
+	}, New(0))
+	if attempts != 1 || err != errTest {
+		t.Fatalf("expected single attempt without retries, got %d attempts and %v", attempts, err)
+	}
+}
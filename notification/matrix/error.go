@@ -0,0 +1,7 @@
+package matrix
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var invalidRoomError error = errors.New("invalid Matrix room")
@@ -0,0 +1,79 @@
+package matrix
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/exmonitor/firefly/notification/action"
+)
+
+const (
+	msgTypeText = "m.text"
+	formatHTML  = "org.matrix.custom.html"
+)
+
+// content of m.room.message event, clients without HTML support show the plain body
+type messageContent struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+// build message with check details, action links are added to FAIL messages when set
+// m.text is used instead of m.notice, default push rules do not notify about notices
+func alertMessage(n Notification, ackURL string, silenceURL string, silenceDuration time.Duration) messageContent {
+	p := n.Payload
+	emoji, verb := "\U0001F7E2", "recovered"
+	if p.Failed {
+		emoji, verb = "\U0001F534", "failed"
+	}
+
+	plain := []string{fmt.Sprintf("%s %s %s check of %s has %s", emoji, p.Status, p.ServiceType, p.Host, verb)}
+	formatted := []string{fmt.Sprintf("<p>%s <strong>%s</strong> %s check of <strong>%s</strong> has %s</p>", emoji, html.EscapeString(p.Status), html.EscapeString(p.ServiceType), html.EscapeString(p.Host), verb)}
+
+	var items []string
+	if p.Target != "" && p.Target != p.Host {
+		plain = append(plain, "Target: "+p.Target)
+		items = append(items, "<li><strong>Target</strong>: "+html.EscapeString(p.Target)+"</li>")
+	}
+	for _, f := range p.Fields() {
+		plain = append(plain, f.Name+": "+f.Value)
+		items = append(items, "<li><strong>"+html.EscapeString(f.Name)+"</strong>: "+html.EscapeString(f.Value)+"</li>")
+	}
+	if len(items) > 0 {
+		formatted = append(formatted, "<ul>"+strings.Join(items, "")+"</ul>")
+	}
+
+	if p.Failed && p.FailMessage != "" {
+		plain = append(plain, "", p.FailMessage)
+		formatted = append(formatted, "<pre><code>"+html.EscapeString(p.FailMessage)+"</code></pre>")
+	}
+	if p.Failed && ackURL != "" {
+		silence := "Silence " + action.FormatDuration(silenceDuration)
+		plain = append(plain, "", "Acknowledge: "+ackURL, silence+": "+silenceURL)
+		formatted = append(formatted, fmt.Sprintf(`<p><a href="%s">Acknowledge</a> · <a href="%s">%s</a></p>`, html.EscapeString(ackURL), html.EscapeString(silenceURL), silence))
+	}
+
+	if n.IncidentID != "" {
+		info := "Incident " + n.IncidentID
+		if !n.IncidentStart.IsZero() {
+			if p.Failed {
+				info += ", started " + n.IncidentStart.UTC().Format("2006-01-02 15:04 MST")
+			} else {
+				info += ", lasted " + time.Since(n.IncidentStart).Round(time.Second).String()
+			}
+		}
+		plain = append(plain, "", info)
+		formatted = append(formatted, "<p><em>"+html.EscapeString(info)+"</em></p>")
+	}
+
+	return messageContent{
+		MsgType:       msgTypeText,
+		Body:          strings.Join(plain, "\n"),
+		Format:        formatHTML,
+		FormattedBody: strings.Join(formatted, ""),
+	}
+}
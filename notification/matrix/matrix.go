@@ -0,0 +1,234 @@
+package matrix
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/action"
+	"github.com/exmonitor/firefly/notification/internal/retry"
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+const (
+	maxResponseSize = 64 * 1024
+)
+
+type Config struct {
+	// base URL of the homeserver, e.g. https://matrix.example.org
+	Homeserver  string
+	AccessToken string
+	// timeout of single request
+	Timeout time.Duration
+	// how many times is temporary failure retried
+	Retries int
+	// acknowledge and silence links are added when set
+	ActionLinks *action.Links
+
+	Logger *exlogger.Logger
+}
+
+// Notification is single notification of Matrix contact
+type Notification struct {
+	NotificationID int
+	IncidentID     string
+	IncidentStart  time.Time
+	Payload        *payload.Payload
+}
+
+// Client sends m.room.message events as the user of the access token, contact target is room ID or alias
+// the user must already be joined to the room
+type Client struct {
+	conf   Config
+	client *http.Client
+	// resolved room aliases, key is alias
+	rooms   map[string]string
+	roomsMu sync.Mutex
+}
+
+func New(conf Config) (*Client, error) {
+	u, err := url.Parse(conf.Homeserver)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.Homeserver must be absolute http or https URL")
+	}
+	if conf.AccessToken == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.AccessToken must not be empty")
+	}
+	if conf.Timeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Timeout must be positive duration")
+	}
+	if conf.Retries < 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Retries must not be negative")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+	conf.Homeserver = strings.TrimSuffix(conf.Homeserver, "/")
+
+	c := &Client{
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout},
+		rooms:  map[string]string{},
+	}
+	return c, nil
+}
+
+// Send posts the alert to the room, target is room ID like !abc:example.org or alias like #ops:example.org
+func (c *Client) Send(room string, n Notification) error {
+	room = strings.TrimSpace(room)
+	if (!strings.HasPrefix(room, "!") && !strings.HasPrefix(room, "#")) || !strings.Contains(room, ":") {
+		return errors.Wrapf(invalidRoomError, "%q must be room ID or alias", room)
+	}
+	if n.Payload == nil {
+		return errors.Wrap(invalidRoomError, "notification has no payload")
+	}
+	ackURL, silenceURL := c.conf.ActionLinks.URLs(n.Payload.ServiceID, n.NotificationID, n.IncidentID)
+	content := alertMessage(n, ackURL, silenceURL, c.conf.ActionLinks.SilenceDuration())
+	// homeserver deduplicates retries with the same transaction id
+	txnID := newTxnID()
+
+	send := func() error {
+		roomID, err := c.roomID(room)
+		if err == nil {
+			err = c.call(http.MethodPut, "/_matrix/client/v3/rooms/"+url.PathEscape(roomID)+"/send/m.room.message/"+txnID, content, nil)
+		}
+		if err != nil && !isTemporaryError(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	notify := func(err error, next time.Duration) {
+		c.conf.Logger.LogError(err, "failed to send Matrix message to room %s, retrying in %s", room, next.Round(time.Millisecond))
+	}
+	err := backoff.RetryNotify(send, retry.New(c.conf.Retries), notify)
+	if permanent, ok := err.(*backoff.PermanentError); ok {
+		err = permanent.Err
+	}
+	return err
+}
+
+// roomID resolves room alias, room IDs are returned unchanged
+func (c *Client) roomID(room string) (string, error) {
+	if strings.HasPrefix(room, "!") {
+		return room, nil
+	}
+	c.roomsMu.Lock()
+	roomID, ok := c.rooms[room]
+	c.roomsMu.Unlock()
+	if ok {
+		return roomID, nil
+	}
+
+	var r struct {
+		RoomID string `json:"room_id"`
+	}
+	err := c.call(http.MethodGet, "/_matrix/client/v3/directory/room/"+url.PathEscape(room), nil, &r)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve Matrix room alias %s", room)
+	}
+	if r.RoomID == "" {
+		return "", errors.Wrapf(invalidRoomError, "alias %s has no room", room)
+	}
+	c.roomsMu.Lock()
+	c.rooms[room] = r.RoomID
+	c.roomsMu.Unlock()
+	return r.RoomID, nil
+}
+
+// call Client-Server API, response is decoded into result when it is not nil
+func (c *Client) call(method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode Matrix request")
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.conf.Homeserver+path, reader)
+	if err != nil {
+		return errors.Wrapf(invalidConfigError, "failed to create Matrix request: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.conf.AccessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Matrix request failed")
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return errors.Wrap(err, "failed to read Matrix response")
+	}
+
+	if resp.StatusCode/100 != 2 {
+		e := &APIError{StatusCode: resp.StatusCode}
+		var r struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		if json.Unmarshal(respBody, &r) == nil {
+			e.ErrCode, e.Message = r.ErrCode, r.Error
+		}
+		return e
+	}
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return errors.Wrap(err, "failed to parse Matrix response")
+		}
+	}
+	return nil
+}
+
+func newTxnID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		// still unique enough for messages in flight
+		return "firefly-" + strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return "firefly-" + hex.EncodeToString(b)
+}
+
+// network failures, rate limits and server side errors can succeed later
+func isTemporaryError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *APIError:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// APIError is failure returned by the homeserver
+type APIError struct {
+	StatusCode int
+	// Matrix error code, e.g. M_FORBIDDEN
+	ErrCode string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.ErrCode == "" {
+		return fmt.Sprintf("Matrix returned HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Matrix returned HTTP %d: %s %s", e.StatusCode, e.ErrCode, e.Message)
+}
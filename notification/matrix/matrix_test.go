@@ -0,0 +1,232 @@
+package matrix
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+const (
+	testToken  = "syt_test_token"
+	testRoomID = "!abc123:example.org"
+	testAlias  = "#ops:example.org"
+)
+
+// sentEvent is m.room.message received by fake homeserver
+type sentEvent struct {
+	roomID  string
+	txnID   string
+	content messageContent
+}
+
+// fakeHomeserver knows single room with alias, statuses holds HTTP statuses of the next send requests, empty means 200
+type fakeHomeserver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	events   []sentEvent
+	resolves int
+	statuses []int
+}
+
+func newFakeHomeserver(t *testing.T, statuses ...int) *fakeHomeserver {
+	f := &fakeHomeserver{statuses: statuses}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid access token passed."}`))
+			return
+		}
+		// room ID and alias are escaped in the path
+		path := r.URL.EscapedPath()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch {
+		case r.Method == http.MethodGet && strings.HasPrefix(path, "/_matrix/client/v3/directory/room/"):
+			f.resolves++
+			if path != "/_matrix/client/v3/directory/room/%23ops:example.org" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errcode": "M_NOT_FOUND", "error": "Room alias not found"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"room_id": testRoomID, "servers": []string{"example.org"}})
+		case r.Method == http.MethodPut && strings.HasPrefix(path, "/_matrix/client/v3/rooms/"):
+			parts := strings.Split(strings.TrimPrefix(path, "/_matrix/client/v3/rooms/"), "/")
+			if len(parts) != 4 || parts[1] != "send" || parts[2] != "m.room.message" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			e := sentEvent{roomID: parts[0], txnID: parts[3]}
+			json.Unmarshal(body, &e.content)
+			f.events = append(f.events, e)
+			if len(f.statuses) > 0 {
+				status := f.statuses[0]
+				f.statuses = f.statuses[1:]
+				w.WriteHeader(status)
+				switch status {
+				case http.StatusForbidden:
+					w.Write([]byte(`{"errcode": "M_FORBIDDEN", "error": "User not in room"}`))
+				case http.StatusTooManyRequests:
+					w.Write([]byte(`{"errcode": "M_LIMIT_EXCEEDED", "error": "Too many requests"}`))
+				default:
+					// proxy in front of the homeserver
+					w.Write([]byte("bad gateway"))
+				}
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"event_id": "$event" + parts[3]})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errcode": "M_UNRECOGNIZED", "error": "Unrecognized request"}`))
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeHomeserver) received() ([]sentEvent, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]sentEvent(nil), f.events...), f.resolves
+}
+
+func newTestClient(t *testing.T, f *fakeHomeserver, token string, retries int) *Client {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(Config{Homeserver: f.URL + "/", AccessToken: token, Timeout: time.Second, Retries: retries, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func failedPayload() *payload.Payload {
+	return &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web<1>", ServiceType: "http",
+		Target: "https://example.com/?a=1&b=2", FailMessage: "<html>503</html>"}
+}
+
+func TestSendToRoomID(t *testing.T) {
+	f := newFakeHomeserver(t)
+	c := newTestClient(t, f, testToken, 0)
+	start := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+
+	if err := c.Send(" "+testRoomID+" ", Notification{IncidentID: "7-1", IncidentStart: start, Payload: failedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	events, resolves := f.received()
+	if len(events) != 1 || resolves != 0 {
+		t.Fatalf("expected single event without alias lookup, got %d events and %d lookups", len(events), resolves)
+	}
+	e := events[0]
+	if e.roomID != "%21abc123:example.org" || !strings.HasPrefix(e.txnID, "firefly-") {
+		t.Fatalf("unexpected room %s or transaction %s", e.roomID, e.txnID)
+	}
+	content := e.content
+	if content.MsgType != msgTypeText || content.Format != formatHTML {
+		t.Fatalf("unexpected message type %+v", content)
+	}
+	wantBody := "\U0001F534 CRITICAL http check of web<1> has failed\nTarget: https://example.com/?a=1&b=2"
+	if !strings.HasPrefix(content.Body, wantBody) || !strings.HasSuffix(content.Body, "\n\n<html>503</html>\n\nIncident 7-1, started 2024-03-05 14:30 UTC") {
+		t.Fatalf("unexpected plain body\n%s", content.Body)
+	}
+	for _, want := range []string{
+		"<strong>web&lt;1&gt;</strong>",
+		"<li><strong>Target</strong>: https://example.com/?a=1&amp;b=2</li>",
+		"<pre><code>&lt;html&gt;503&lt;/html&gt;</code></pre>",
+		"<p><em>Incident 7-1, started 2024-03-05 14:30 UTC</em></p>",
+	} {
+		if !strings.Contains(content.FormattedBody, want) {
+			t.Fatalf("expected %q in formatted body\n%s", want, content.FormattedBody)
+		}
+	}
+}
+
+func TestSendResolvesAliasOnce(t *testing.T) {
+	f := newFakeHomeserver(t)
+	c := newTestClient(t, f, testToken, 0)
+	for i := 0; i < 2; i++ {
+		if err := c.Send(testAlias, Notification{IncidentID: "7-1", Payload: failedPayload()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, resolves := f.received()
+	if len(events) != 2 || resolves != 1 {
+		t.Fatalf("expected 2 events with single alias lookup, got %d events and %d lookups", len(events), resolves)
+	}
+	if events[0].roomID != "%21abc123:example.org" || events[0].txnID == events[1].txnID {
+		t.Fatalf("expected events in resolved room with own transaction ids, got %+v", events)
+	}
+
+	err := c.Send("#unknown:example.org", Notification{Payload: failedPayload()})
+	if e, ok := errors.Cause(err).(*APIError); !ok || e.StatusCode != http.StatusNotFound || e.ErrCode != "M_NOT_FOUND" {
+		t.Fatalf("expected unknown alias, got %v", err)
+	}
+}
+
+func TestSendRetriesWithSameTransaction(t *testing.T) {
+	f := newFakeHomeserver(t, http.StatusTooManyRequests)
+	c := newTestClient(t, f, testToken, 1)
+	if err := c.Send(testRoomID, Notification{Payload: failedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	events, _ := f.received()
+	if len(events) != 2 || events[0].txnID != events[1].txnID {
+		t.Fatalf("expected retry with the same transaction id, got %+v", events)
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		token      string
+		wantEvents int
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "not joined room is not retried", statuses: []int{http.StatusForbidden}, token: testToken, wantEvents: 1, wantStatus: http.StatusForbidden, wantCode: "M_FORBIDDEN"},
+		{name: "retries run out", statuses: []int{http.StatusBadGateway, http.StatusBadGateway}, token: testToken, wantEvents: 2, wantStatus: http.StatusBadGateway},
+		{name: "invalid token", token: "wrong", wantStatus: http.StatusUnauthorized, wantCode: "M_UNKNOWN_TOKEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHomeserver(t, tt.statuses...)
+			c := newTestClient(t, f, tt.token, 1)
+			err := c.Send(testRoomID, Notification{Payload: failedPayload()})
+			if events, _ := f.received(); len(events) != tt.wantEvents {
+				t.Fatalf("expected %d events, got %d", tt.wantEvents, len(events))
+			}
+			e, ok := errors.Cause(err).(*APIError)
+			if !ok || e.StatusCode != tt.wantStatus || e.ErrCode != tt.wantCode {
+				t.Fatalf("expected HTTP %d %s, got %v", tt.wantStatus, tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestSendInvalidRoom(t *testing.T) {
+	f := newFakeHomeserver(t)
+	c := newTestClient(t, f, testToken, 0)
+	for _, room := range []string{"", "ops", "!abc123", "#ops", "@user:example.org"} {
+		if err := c.Send(room, Notification{Payload: failedPayload()}); errors.Cause(err) != invalidRoomError {
+			t.Errorf("%q: expected invalid room, got %v", room, err)
+		}
+	}
+	if events, resolves := f.received(); len(events) != 0 || resolves != 0 {
+		t.Fatal("invalid rooms must not be requested")
+	}
+}
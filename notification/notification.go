@@ -6,33 +6,79 @@ import (
 	"github.com/exmonitor/exclient/database"
 	dbnotification "github.com/exmonitor/exclient/database/spec/notification"
 	"github.com/exmonitor/exclient/database/spec/service"
+	"github.com/exmonitor/exclient/database/spec/status"
 	"github.com/exmonitor/exlogger"
+	"github.com/olivere/elastic"
 	"github.com/pkg/errors"
 
+	"github.com/exmonitor/firefly/notification/branding"
+	"github.com/exmonitor/firefly/notification/discord"
 	"github.com/exmonitor/firefly/notification/email"
+	"github.com/exmonitor/firefly/notification/matrix"
+	"github.com/exmonitor/firefly/notification/opsgenie"
+	"github.com/exmonitor/firefly/notification/pagerduty"
+	"github.com/exmonitor/firefly/notification/payload"
 	"github.com/exmonitor/firefly/notification/phone"
+	"github.com/exmonitor/firefly/notification/phonenumber"
+	"github.com/exmonitor/firefly/notification/slack"
 	"github.com/exmonitor/firefly/notification/sms"
+	"github.com/exmonitor/firefly/notification/teams"
+	"github.com/exmonitor/firefly/notification/telegram"
+	"github.com/exmonitor/firefly/notification/webhook"
+	"github.com/exmonitor/firefly/service/metadata"
 	"github.com/exmonitor/firefly/service/state"
-	"gopkg.in/gomail.v2"
 )
 
 type Config struct {
 	ServiceID                  int
+	IncidentID                 string
+	IncidentStart              time.Time
 	Failed                     bool
 	FailedMsg                  string
 	NotificationSentTimestamps map[int]time.Time
+	NotificationResendCounts   map[int]int
 	NotificationChangeChannel  chan state.NotificationChange
 	SMTPEnabled                bool
-	SMTPEmailChan              chan *gomail.Message
+	SMTPEmailChan              chan *email.Envelope
+	EmailHistory               email.HistoryConfig
+	EmailSubjects              *email.SubjectTemplates
+	Branding                   *branding.Profiles
+	// fake sender is used when nil
+	SMSSender sms.Sender
+	// SMS text is shortened to fit into this amount of concatenated parts
+	SMSMaxParts int
+	// fake caller is used when nil
+	PhoneCaller phone.Caller
+	// default countries of national SMS and phone numbers, only international numbers are accepted when nil
+	PhoneCountries *phonenumber.DefaultCountries
+	WebhookSender  *webhook.Sender
+	SlackClient    *slack.Client
+	TeamsClient    *teams.Client
+	TelegramBot    *telegram.Bot
+	PagerDuty      *pagerduty.Client
+	Opsgenie       *opsgenie.Client
+	DiscordClient  *discord.Client
+	MatrixClient   *matrix.Client
 
 	DBClient database.ClientInterface
 	Logger   *exlogger.Logger
 }
 
+// last status is searched in this amount of check intervals
+const lastStatusIntervals = 3
+
 const (
-	contactTypeEmail = "email"
-	contactTypeSms   = "sms"
-	contactTypePhone = "phone"
+	contactTypeEmail     = "email"
+	contactTypeSms       = "sms"
+	contactTypePhone     = "phone"
+	contactTypeWebhook   = "webhook"
+	contactTypeSlack     = "slack"
+	contactTypeTeams     = "teams"
+	contactTypeTelegram  = "telegram"
+	contactTypePagerDuty = "pagerduty"
+	contactTypeOpsgenie  = "opsgenie"
+	contactTypeDiscord   = "discord"
+	contactTypeMatrix    = "matrix"
 )
 
 func New(conf Config) (*Service, error) {
@@ -48,12 +94,30 @@ func New(conf Config) (*Service, error) {
 
 	newService := &Service{
 		checkId:                   conf.ServiceID,
+		incidentID:                conf.IncidentID,
+		incidentStart:             conf.IncidentStart,
 		failed:                    conf.Failed,
 		failedMsg:                 conf.FailedMsg,
 		notificationSentTimestamp: conf.NotificationSentTimestamps,
+		notificationResendCount:   conf.NotificationResendCounts,
 		notificationChangeChannel: conf.NotificationChangeChannel,
 		smtpEnabled:               conf.SMTPEnabled,
 		smtpEmailChan:             conf.SMTPEmailChan,
+		emailHistory:              conf.EmailHistory,
+		emailSubjects:             conf.EmailSubjects,
+		branding:                  conf.Branding,
+		smsSender:                 conf.SMSSender,
+		smsMaxParts:               conf.SMSMaxParts,
+		phoneCaller:               conf.PhoneCaller,
+		phoneCountries:            conf.PhoneCountries,
+		webhookSender:             conf.WebhookSender,
+		slackClient:               conf.SlackClient,
+		teamsClient:               conf.TeamsClient,
+		telegramBot:               conf.TelegramBot,
+		pagerDuty:                 conf.PagerDuty,
+		opsgenie:                  conf.Opsgenie,
+		discordClient:             conf.DiscordClient,
+		matrixClient:              conf.MatrixClient,
 
 		dbClient: conf.DBClient,
 		logger:   conf.Logger,
@@ -64,12 +128,33 @@ func New(conf Config) (*Service, error) {
 
 type Service struct {
 	checkId                   int
+	incidentID                string
+	incidentStart             time.Time
 	failed                    bool
 	failedMsg                 string
 	notificationSentTimestamp map[int]time.Time
+	notificationResendCount   map[int]int
 	notificationChangeChannel chan state.NotificationChange
 	smtpEnabled               bool
-	smtpEmailChan             chan *gomail.Message
+	smtpEmailChan             chan *email.Envelope
+	emailHistory              email.HistoryConfig
+	emailSubjects             *email.SubjectTemplates
+	branding                  *branding.Profiles
+	smsSender                 sms.Sender
+	smsMaxParts               int
+	phoneCaller               phone.Caller
+	phoneCountries            *phonenumber.DefaultCountries
+	webhookSender             *webhook.Sender
+	slackClient               *slack.Client
+	teamsClient               *teams.Client
+	telegramBot               *telegram.Bot
+	pagerDuty                 *pagerduty.Client
+	opsgenie                  *opsgenie.Client
+	discordClient             *discord.Client
+	matrixClient              *matrix.Client
+	// loaded lazily, shared by all email contacts
+	history       *email.History
+	historyLoaded bool
 
 	dbClient database.ClientInterface
 	logger   *exlogger.Logger
@@ -82,23 +167,78 @@ func (s *Service) Run() {
 	if err != nil {
 		s.logger.LogError(err, "failed to fetch user notification settings")
 	}
+	notificationSettings = s.normalizeContacts(notificationSettings)
 
 	// get monitoring service details
 	serviceInfo, err := s.dbClient.SQL_GetServiceDetails(s.checkId)
 	if err != nil {
 		s.logger.LogError(err, "failed to fetch service info")
 	}
+	// decode service metadata once for all contacts
+	var serviceMetadata *metadata.Metadata
+	if serviceInfo != nil {
+		serviceMetadata, err = metadata.Decode(serviceInfo)
+		if err != nil {
+			s.logger.LogError(err, "failed to decode metadata of service id %d", s.checkId)
+		}
+	}
+	// content shared by all channels
+	var p *payload.Payload
+	if serviceInfo != nil {
+		p = payload.New(serviceInfo, serviceMetadata, s.failed, s.failedMsg, s.lastStatus(serviceInfo))
+	}
 
 	for _, n := range notificationSettings {
+		// resend and recovery notifications are follow-ups of the first FAIL notification
+		_, followUp := s.notificationSentTimestamp[n.ID]
+		// number of this resend, the counter is increased only after the notification is sent
+		resend := 0
+		if followUp && s.failed {
+			resend = s.notificationResendCount[n.ID] + 1
+		}
+		// PagerDuty repeats and escalates alerts itself, it gets only the first FAIL and the recovery
+		if n.Type == contactTypePagerDuty && followUp && s.failed {
+			continue
+		}
 		// check if we should resent notification
 		if !s.canSentNotification(n) && s.failed {
 			// notification was already sent and its still to early to resent
 			continue
 		}
 		// execute notification
-		s.executeNotification(serviceInfo, n)
+		s.executeNotification(serviceInfo, serviceMetadata, p, n, followUp, resend)
+
+	}
+}
+
+// normalizeContacts validates SMS and phone contacts as soon as settings are loaded,
+// contacts with invalid number are logged and dropped, so nothing below sees a number which can't be dialed
+func (s *Service) normalizeContacts(settings []*dbnotification.UserNotificationSettings) []*dbnotification.UserNotificationSettings {
+	valid := make([]*dbnotification.UserNotificationSettings, 0, len(settings))
+	for _, n := range settings {
+		normalized, err := s.normalizeTarget(n)
+		if err != nil {
+			s.logger.LogError(err, "skipping %s contact id %d of service id %d, target %q is not valid phone number", n.Type, n.ID, s.checkId, n.Target)
+			continue
+		}
+		valid = append(valid, normalized)
+	}
+	return valid
+}
 
+// SMS and phone targets are normalized to E.164, so the same number written differently is the same contact
+// settings are copied as they may be shared with other services
+func (s *Service) normalizeTarget(n *dbnotification.UserNotificationSettings) (*dbnotification.UserNotificationSettings, error) {
+	if n.Type != contactTypeSms && n.Type != contactTypePhone {
+		return n, nil
 	}
+	target, err := phonenumber.Normalize(n.Target, s.phoneCountries.Select(s.checkId, n.ID, n.Target))
+	if err != nil {
+		return n, err
+	}
+	normalized := *n
+	normalized.Target = target
+	return &normalized, nil
 }
 
 // func to to determine if notification should be sent
@@ -146,17 +286,63 @@ func (s *Service) canSentNotification(notificationSettings *dbnotification.UserN
 
 }
 
-func (s *Service) executeNotification(serviceInfo *service.Service, n *dbnotification.UserNotificationSettings) {
+// fetch the newest check result, it adds response time and details to the payload
+func (s *Service) lastStatus(serviceInfo *service.Service) *status.ServiceStatus {
+	interval := time.Duration(serviceInfo.Interval) * time.Second
+	if interval <= 0 {
+		return nil
+	}
+	now := time.Now()
+	statuses, err := s.dbClient.ES_GetServicesStatus(now.Add(-interval*lastStatusIntervals), now, elastic.NewTermQuery("id", serviceInfo.ID))
+	if err != nil {
+		s.logger.LogError(err, "failed to fetch last status for service id %d", s.checkId)
+		return nil
+	}
+	var last *status.ServiceStatus
+	for _, st := range statuses {
+		if last == nil || st.InsertTimestamp.After(last.InsertTimestamp) {
+			last = st
+		}
+	}
+	return last
+}
+
+// fetch recent check results for emails, history is fetched only once for all contacts
+// email is sent without history when the fetch fails
+func (s *Service) loadHistory(serviceInfo *service.Service) *email.History {
+	if s.historyLoaded {
+		return s.history
+	}
+	s.historyLoaded = true
+
+	history, err := email.LoadHistory(s.dbClient, serviceInfo, s.emailHistory)
+	if err != nil {
+		s.logger.LogError(err, "failed to load check history for service id %d", s.checkId)
+		return nil
+	}
+	s.history = history
+	return history
+}
+
+func (s *Service) executeNotification(serviceInfo *service.Service, serviceMetadata *metadata.Metadata, p *payload.Payload, n *dbnotification.UserNotificationSettings, followUp bool, resend int) {
 	switch n.Type {
 	case contactTypeEmail:
 		// prepare email config
 		emailConfig := email.EmailConfig{
-			To:            n.Target,
-			Failed:        s.failed,
-			FailedMsg:     s.failedMsg,
-			ServiceInfo:   serviceInfo,
-			SMTPEnabled:   s.smtpEnabled,
-			SMTPEmailChan: s.smtpEmailChan,
+			To:               n.Target,
+			IncidentID:       s.incidentID,
+			NotificationID:   n.ID,
+			FollowUp:         followUp,
+			IncidentStart:    s.incidentStart,
+			Resend:           resend,
+			Failed:           s.failed,
+			FailedMsg:        s.failedMsg,
+			ServiceInfo:      serviceInfo,
+			History:          s.loadHistory(serviceInfo),
+			SubjectTemplates: s.emailSubjects,
+			Branding:         s.branding.Select(s.checkId, n.ID, n.Target),
+			SMTPEnabled:      s.smtpEnabled,
+			SMTPEmailChan:    s.smtpEmailChan,
 		}
 
 		emailSender, err := email.NewEmail(emailConfig)
@@ -167,18 +353,180 @@ func (s *Service) executeNotification(serviceInfo *service.Service, n *dbnotific
 		emailSender.Send()
 		break
 	case contactTypeSms:
-		msg := SMSTemplate(s.failed, serviceInfo)
-		err := sms.Send(n.Target, msg)
+		msg := SMSTemplate(p, s.smsMaxParts)
+		sender := s.smsSender
+		if sender == nil {
+			sender = sms.FakeSender{}
+		}
+		err := sender.Send(n.Target, msg)
 		if err != nil {
 			s.logger.LogError(err, "failed to send SMS to %s for check id %d", n.Target, s.checkId)
 		}
 		break
 	case contactTypePhone:
-		msg := CallTemplate(s.failed, serviceInfo)
-		err := phone.Call(n.Target, msg)
+		msg := CallTemplate(p)
+		caller := s.phoneCaller
+		if caller == nil {
+			caller = phone.FakeCaller{}
+		}
+		// only FAIL calls can be acknowledged
+		var incident phone.Incident
+		if s.failed {
+			incident = phone.Incident{ServiceID: s.checkId, IncidentID: s.incidentID}
+		}
+		err := caller.Call(n.Target, msg, incident)
 		if err != nil {
 			s.logger.LogError(err, "failed to call to %s for check id %d", n.Target, s.checkId)
 		}
+	case contactTypeWebhook:
+		// webhook URL can contain secret token, so the contact is logged by id
+		if s.webhookSender == nil {
+			s.logger.LogError(webhookDisabledError, "failed to send webhook to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		if p == nil {
+			s.logger.LogError(missingServiceInfoError, "failed to send webhook to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		event := webhook.NewEvent(p, webhook.EventInfo{
+			IncidentID:     s.incidentID,
+			IncidentStart:  s.incidentStart,
+			NotificationID: n.ID,
+			Resend:         resend,
+		})
+		err := s.webhookSender.Send(n.ID, n.Target, event)
+		if err != nil {
+			s.logger.LogError(err, "failed to send webhook to contact id %d for check id %d", n.ID, s.checkId)
+		}
+	case contactTypeSlack:
+		// incoming webhook URL is secret, so the contact is logged by id
+		if s.slackClient == nil {
+			s.logger.LogError(slackDisabledError, "failed to send Slack message to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		if p == nil {
+			s.logger.LogError(missingServiceInfoError, "failed to send Slack message to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		err := s.slackClient.Send(n.Target, slack.Notification{
+			NotificationID: n.ID,
+			IncidentID:     s.incidentID,
+			IncidentStart:  s.incidentStart,
+			Resend:         resend,
+			Payload:        p,
+		})
+		if err != nil {
+			s.logger.LogError(err, "failed to send Slack message to contact id %d for check id %d", n.ID, s.checkId)
+		}
+	case contactTypeTeams:
+		// webhook URL is secret, so the contact is logged by id
+		if s.teamsClient == nil {
+			s.logger.LogError(teamsDisabledError, "failed to send Teams card to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		if p == nil {
+			s.logger.LogError(missingServiceInfoError, "failed to send Teams card to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		err := s.teamsClient.Send(n.Target, teams.Notification{
+			NotificationID: n.ID,
+			IncidentID:     s.incidentID,
+			IncidentStart:  s.incidentStart,
+			Payload:        p,
+		})
+		if err != nil {
+			s.logger.LogError(err, "failed to send Teams card to contact id %d for check id %d", n.ID, s.checkId)
+		}
+	case contactTypeTelegram:
+		if s.telegramBot == nil {
+			s.logger.LogError(telegramDisabledError, "failed to send Telegram message to chat %s for check id %d", n.Target, s.checkId)
+			break
+		}
+		if p == nil {
+			s.logger.LogError(missingServiceInfoError, "failed to send Telegram message to chat %s for check id %d", n.Target, s.checkId)
+			break
+		}
+		err := s.telegramBot.Send(n.Target, telegram.Notification{
+			IncidentID:    s.incidentID,
+			IncidentStart: s.incidentStart,
+			Payload:       p,
+		})
+		if err != nil {
+			s.logger.LogError(err, "failed to send Telegram message to chat %s for check id %d", n.Target, s.checkId)
+		}
+	case contactTypePagerDuty:
+		// routing key is secret, so the contact is logged by id
+		if s.pagerDuty == nil {
+			s.logger.LogError(pagerDutyDisabledError, "failed to send PagerDuty event to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		if p == nil {
+			s.logger.LogError(missingServiceInfoError, "failed to send PagerDuty event to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		err := s.pagerDuty.Send(n.Target, pagerduty.Notification{
+			IncidentID:    s.incidentID,
+			IncidentStart: s.incidentStart,
+			Payload:       p,
+		})
+		if err != nil {
+			s.logger.LogError(err, "failed to send PagerDuty event to contact id %d for check id %d", n.ID, s.checkId)
+		}
+	case contactTypeOpsgenie:
+		// API key is secret, so the contact is logged by id
+		if s.opsgenie == nil {
+			s.logger.LogError(opsgenieDisabledError, "failed to send Opsgenie alert to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		if p == nil {
+			s.logger.LogError(missingServiceInfoError, "failed to send Opsgenie alert to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		err := s.opsgenie.Send(n.Target, opsgenie.Notification{
+			IncidentID: s.incidentID,
+			Resend:     resend,
+			Payload:    p,
+		})
+		if err != nil {
+			s.logger.LogError(err, "failed to send Opsgenie alert to contact id %d for check id %d", n.ID, s.checkId)
+		}
+	case contactTypeDiscord:
+		// webhook URL contains token, so the contact is logged by id
+		if s.discordClient == nil {
+			s.logger.LogError(discordDisabledError, "failed to send Discord message to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		if p == nil {
+			s.logger.LogError(missingServiceInfoError, "failed to send Discord message to contact id %d for check id %d", n.ID, s.checkId)
+			break
+		}
+		err := s.discordClient.Send(n.Target, discord.Notification{
+			NotificationID: n.ID,
+			IncidentID:     s.incidentID,
+			IncidentStart:  s.incidentStart,
+			Payload:        p,
+		})
+		if err != nil {
+			s.logger.LogError(err, "failed to send Discord message to contact id %d for check id %d", n.ID, s.checkId)
+		}
+	case contactTypeMatrix:
+		if s.matrixClient == nil {
+			s.logger.LogError(matrixDisabledError, "failed to send Matrix message to room %s for check id %d", n.Target, s.checkId)
+			break
+		}
+		if p == nil {
+			s.logger.LogError(missingServiceInfoError, "failed to send Matrix message to room %s for check id %d", n.Target, s.checkId)
+			break
+		}
+		err := s.matrixClient.Send(n.Target, matrix.Notification{
+			NotificationID: n.ID,
+			IncidentID:     s.incidentID,
+			IncidentStart:  s.incidentStart,
+			Payload:        p,
+		})
+		if err != nil {
+			s.logger.LogError(err, "failed to send Matrix message to room %s for check id %d", n.Target, s.checkId)
+		}
 	default:
 		s.logger.LogError(unknownContactTypeError, "contact type %s not recognized", n.Type)
 	}
@@ -0,0 +1,148 @@
+package notification
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exclient/database"
+	dbnotification "github.com/exmonitor/exclient/database/spec/notification"
+	"github.com/exmonitor/exclient/database/spec/service"
+	"github.com/exmonitor/exlogger"
+
+	"github.com/exmonitor/firefly/notification/pagerduty"
+	"github.com/exmonitor/firefly/notification/phonenumber"
+	"github.com/exmonitor/firefly/service/state"
+)
+
+// fakeDB returns the same contacts for every service, other queries are not implemented
+type fakeDB struct {
+	database.ClientInterface
+	settings []*dbnotification.UserNotificationSettings
+}
+
+func (db *fakeDB) SQL_GetUsersNotificationSettings(serviceID int) ([]*dbnotification.UserNotificationSettings, error) {
+	return db.settings, nil
+}
+
+// interval is 0, so the last status is not searched
+func (db *fakeDB) SQL_GetServiceDetails(serviceID int) (*service.Service, error) {
+	return &service.Service{ID: serviceID, Host: "web1", Target: "https://example.com", Type: 1}, nil
+}
+
+func TestNormalizeContacts(t *testing.T) {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	countries, err := phonenumber.LoadDefaultCountries("", "SK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{checkId: 7, phoneCountries: countries, logger: logger}
+
+	smsContact := &dbnotification.UserNotificationSettings{ID: 1, Type: contactTypeSms, Target: "0601 234 567"}
+	settings := []*dbnotification.UserNotificationSettings{
+		smsContact,
+		{ID: 2, Type: contactTypePhone, Target: "+421 601 234 568"},
+		{ID: 3, Type: contactTypeSms, Target: "not a number"},
+		{ID: 4, Type: contactTypePhone, Target: "0601"},
+		{ID: 5, Type: "email", Target: "ops@example.com"},
+	}
+	got := s.normalizeContacts(settings)
+
+	want := map[int]string{1: "+421601234567", 2: "+421601234568", 5: "ops@example.com"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d contacts, got %d", len(want), len(got))
+	}
+	for _, n := range got {
+		if n.Target != want[n.ID] {
+			t.Errorf("contact %d: expected target %q, got %q", n.ID, want[n.ID], n.Target)
+		}
+	}
+	// settings may be shared with other services
+	if smsContact.Target != "0601 234 567" {
+		t.Fatalf("loaded settings must not be modified, got %q", smsContact.Target)
+	}
+}
+
+func TestRunSendsPagerDutyOnlyFirstFailAndRecovery(t *testing.T) {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var events []pagerduty.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var e pagerduty.Event
+		json.Unmarshal(body, &e)
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	pd, err := pagerduty.New(pagerduty.Config{URL: srv.URL, Severity: "critical", Timeout: time.Second, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contact := &dbnotification.UserNotificationSettings{ID: 3, Type: contactTypePagerDuty, Target: "R0123456789abcdef0123456789abcde", ResentAfterMin: 5}
+
+	tests := []struct {
+		name       string
+		failed     bool
+		sentBefore time.Duration
+		wantAction string
+	}{
+		{name: "first FAIL triggers", failed: true, wantAction: "trigger"},
+		// PagerDuty escalates by itself, resend is not sent even when it is due
+		{name: "resend is skipped", failed: true, sentBefore: time.Hour},
+		{name: "recovery resolves", failed: false, sentBefore: time.Hour, wantAction: "resolve"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			events = nil
+			mu.Unlock()
+			sent := map[int]time.Time{}
+			if tt.sentBefore > 0 {
+				sent[contact.ID] = time.Now().Add(-tt.sentBefore)
+			}
+			changes := make(chan state.NotificationChange, 1)
+			s, err := New(Config{
+				ServiceID:                  7,
+				IncidentID:                 "7-1",
+				IncidentStart:              time.Now().Add(-2 * time.Hour),
+				Failed:                     tt.failed,
+				FailedMsg:                  "HTTP 503",
+				NotificationSentTimestamps: sent,
+				NotificationResendCounts:   map[int]int{},
+				NotificationChangeChannel:  changes,
+				PagerDuty:                  pd,
+				DBClient:                   &fakeDB{settings: []*dbnotification.UserNotificationSettings{contact}},
+				Logger:                     logger,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Run()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if tt.wantAction == "" {
+				if len(events) != 0 || len(changes) != 0 {
+					t.Fatalf("expected no event and no resend record, got %d events and %d changes", len(events), len(changes))
+				}
+				return
+			}
+			if len(events) != 1 || events[0].EventAction != tt.wantAction || events[0].DedupKey != pagerduty.DedupKey(7, "7-1") {
+				t.Fatalf("expected single %s event, got %+v", tt.wantAction, events)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package opsgenie
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+const (
+	// Opsgenie truncates longer message and description
+	maxMessageLength     = 130
+	maxDescriptionLength = 15000
+
+	source = "firefly"
+)
+
+type createAlert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+	Entity      string            `json:"entity,omitempty"`
+	Source      string            `json:"source"`
+	Priority    string            `json:"priority"`
+}
+
+// body of close and add note requests
+type alertNote struct {
+	Source string `json:"source"`
+	Note   string `json:"note,omitempty"`
+}
+
+// Alias identifies the alert of the service, Opsgenie deduplicates open alerts with the same alias
+// so the alert is also found after restart of firefly
+func Alias(serviceID int) string {
+	return "firefly-service-" + strconv.Itoa(serviceID)
+}
+
+func newCreateAlert(p *payload.Payload, incidentID string, priority string, tags []string) *createAlert {
+	a := &createAlert{
+		Message:     truncate(p.Summary(), maxMessageLength),
+		Alias:       Alias(p.ServiceID),
+		Description: truncate(description(p, incidentID), maxDescriptionLength),
+		Tags:        append(append([]string{source}, tags...), p.ServiceType),
+		Details: map[string]string{
+			"service id": strconv.Itoa(p.ServiceID),
+		},
+		Entity:   p.Host,
+		Source:   source,
+		Priority: priority,
+	}
+	if incidentID != "" {
+		a.Details["incident id"] = incidentID
+	}
+	for _, f := range p.Fields() {
+		a.Details[f.Name] = f.Value
+	}
+	return a
+}
+
+// plain text description, e.g.
+// tcp check of myhost has failed
+// Address: myhost:22
+func description(p *payload.Payload, incidentID string) string {
+	lines := []string{p.ServiceType + " check of " + p.Host + " has failed"}
+	if p.Target != "" && p.Target != p.Host {
+		lines = append(lines, "Target: "+p.Target)
+	}
+	for _, f := range p.Fields() {
+		lines = append(lines, f.Name+": "+f.Value)
+	}
+	if p.FailMessage != "" {
+		lines = append(lines, "", p.FailMessage)
+	}
+	if incidentID != "" {
+		lines = append(lines, "", "Incident "+incidentID)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// note added on every resend of firefly, so the alert timeline shows the outage still lasts
+func resendNote(p *payload.Payload, resend int) string {
+	return "Still failing, resend " + strconv.Itoa(resend) + ": " + p.Summary()
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-3]) + "..."
+}
@@ -0,0 +1,9 @@
+package opsgenie
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var invalidAPIKeyError error = errors.New("invalid Opsgenie API key")
+
+var incidentActionDroppedError error = errors.New("incident action queue is full")
@@ -0,0 +1,351 @@
+package opsgenie
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/internal/retry"
+	"github.com/exmonitor/firefly/notification/payload"
+	"github.com/exmonitor/firefly/service/state"
+)
+
+const (
+	DefaultAPIURL = "https://api.opsgenie.com"
+
+	maxResponseSize = 64 * 1024
+)
+
+// priorities accepted by Alert API
+var priorities = []string{"P1", "P2", "P3", "P4", "P5"}
+
+type Config struct {
+	// base URL of Alert API, DefaultAPIURL when empty, EU accounts use https://api.eu.opsgenie.com
+	APIURL string
+	// priority of created alerts
+	Priority string
+	// added to every alert besides firefly and the service type
+	Tags []string
+	// timeout of single request
+	Timeout time.Duration
+	// how many times is temporary failure retried
+	Retries int
+	// how often is status of open alerts checked for acknowledgement
+	PollInterval time.Duration
+	// acknowledgements done in Opsgenie are sent to services, status is not polled when nil
+	IncidentActionChan chan state.IncidentAction
+
+	Logger *exlogger.Logger
+}
+
+// Notification is single notification of Opsgenie contact
+type Notification struct {
+	IncidentID string
+	// number of resend of FAIL notification, 0 for the first one
+	Resend  int
+	Payload *payload.Payload
+}
+
+// Client creates, annotates and closes alerts via Alert API, contact target is API key of the integration
+type Client struct {
+	conf   Config
+	client *http.Client
+
+	// alerts which are polled for acknowledgement, key is API key and alias
+	// alerts created before restart are polled again after their next resend
+	open   map[alertKey]openAlert
+	openMu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type alertKey struct {
+	apiKey string
+	alias  string
+}
+
+type openAlert struct {
+	serviceID  int
+	incidentID string
+}
+
+func New(conf Config) (*Client, error) {
+	if conf.APIURL == "" {
+		conf.APIURL = DefaultAPIURL
+	}
+	if !validPriority(conf.Priority) {
+		return nil, errors.Wrapf(invalidConfigError, "conf.Priority must be one of %s", strings.Join(priorities, ", "))
+	}
+	if conf.Timeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Timeout must be positive duration")
+	}
+	if conf.Retries < 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Retries must not be negative")
+	}
+	if conf.IncidentActionChan != nil && conf.PollInterval <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.PollInterval must be positive duration")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+	conf.APIURL = strings.TrimSuffix(conf.APIURL, "/")
+
+	c := &Client{
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout},
+		open:   map[alertKey]openAlert{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	return c, nil
+}
+
+// Start polls open alerts in background, it does nothing when acknowledgements are not read back
+func (c *Client) Start() {
+	if c.conf.IncidentActionChan == nil {
+		close(c.done)
+		return
+	}
+	go c.poll()
+}
+
+// Stop ends polling and waits until the running poll is finished
+func (c *Client) Stop() {
+	c.once.Do(func() { close(c.stop) })
+	<-c.done
+}
+
+// Send creates the alert for the first FAIL notification, adds note for resends and closes it on recovery
+func (c *Client) Send(apiKey string, n Notification) error {
+	apiKey = strings.TrimSpace(apiKey)
+	if apiKey == "" {
+		return errors.Wrap(invalidAPIKeyError, "API key must not be empty")
+	}
+	if n.Payload == nil {
+		return errors.Wrap(invalidAPIKeyError, "notification has no payload")
+	}
+	alias := Alias(n.Payload.ServiceID)
+	identifier := "/v2/alerts/" + url.PathEscape(alias)
+
+	var path string
+	var body interface{}
+	switch {
+	case !n.Payload.Failed:
+		path, body = identifier+"/close?identifierType=alias", alertNote{Source: source, Note: n.Payload.Summary()}
+	case n.Resend > 0:
+		path, body = identifier+"/notes?identifierType=alias", alertNote{Source: source, Note: resendNote(n.Payload, n.Resend)}
+	default:
+		path, body = "/v2/alerts", newCreateAlert(n.Payload, n.IncidentID, c.conf.Priority, c.conf.Tags)
+	}
+
+	err := c.retry(apiKey, http.MethodPost, path, body, alias)
+	if err != nil {
+		return err
+	}
+
+	key := alertKey{apiKey: apiKey, alias: alias}
+	c.openMu.Lock()
+	if n.Payload.Failed {
+		c.open[key] = openAlert{serviceID: n.Payload.ServiceID, incidentID: n.IncidentID}
+	} else {
+		delete(c.open, key)
+	}
+	c.openMu.Unlock()
+	return nil
+}
+
+func (c *Client) retry(apiKey string, method string, path string, body interface{}, alias string) error {
+	call := func() error {
+		err := c.call(apiKey, method, path, body, nil)
+		if err != nil && !isTemporaryError(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	notify := func(err error, next time.Duration) {
+		c.conf.Logger.LogError(err, "failed to update Opsgenie alert %s, retrying in %s", alias, next.Round(time.Millisecond))
+	}
+	err := backoff.RetryNotify(call, retry.New(c.conf.Retries), notify)
+	if permanent, ok := err.(*backoff.PermanentError); ok {
+		err = permanent.Err
+	}
+	return err
+}
+
+func (c *Client) poll() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.conf.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		c.openMu.Lock()
+		alerts := make(map[alertKey]openAlert, len(c.open))
+		for key, a := range c.open {
+			alerts[key] = a
+		}
+		c.openMu.Unlock()
+
+		for key, a := range alerts {
+			c.checkAlert(key, a)
+		}
+	}
+}
+
+type alertStatus struct {
+	Data struct {
+		Status       string `json:"status"`
+		Acknowledged bool   `json:"acknowledged"`
+		Report       struct {
+			AcknowledgedBy string `json:"acknowledgedBy"`
+			ClosedBy       string `json:"closedBy"`
+		} `json:"report"`
+	} `json:"data"`
+}
+
+// alert acknowledged or closed in Opsgenie acknowledges the incident, so firefly stops resending
+func (c *Client) checkAlert(key alertKey, a openAlert) {
+	var status alertStatus
+	err := c.call(key.apiKey, http.MethodGet, "/v2/alerts/"+url.PathEscape(key.alias)+"?identifierType=alias", nil, &status)
+	if e, ok := errors.Cause(err).(*StatusError); ok && e.StatusCode == http.StatusNotFound {
+		// alerts are created asynchronously, it may not exist yet
+		return
+	}
+	if err != nil {
+		c.conf.Logger.LogError(err, "failed to check status of Opsgenie alert %s", key.alias)
+		return
+	}
+
+	by := status.Data.Report.AcknowledgedBy
+	if !status.Data.Acknowledged {
+		if status.Data.Status != "closed" {
+			return
+		}
+		by = status.Data.Report.ClosedBy
+	}
+
+	c.openMu.Lock()
+	current, ok := c.open[key]
+	c.openMu.Unlock()
+	if !ok || current != a {
+		// incident recovered or a new one started meanwhile
+		return
+	}
+	incidentAction := state.IncidentAction{
+		ServiceID:   a.serviceID,
+		IncidentID:  a.incidentID,
+		Acknowledge: true,
+		By:          "Opsgenie " + by,
+	}
+	if !state.SendIncidentAction(c.conf.IncidentActionChan, incidentAction) {
+		// alert stays open, so the acknowledgement is read again by the next poll
+		c.conf.Logger.LogError(incidentActionDroppedError, "dropped acknowledgement of Opsgenie alert %s of incident %s by %s", key.alias, a.incidentID, by)
+		return
+	}
+	c.conf.Logger.Log("Opsgenie alert %s of incident %s acknowledged by %s", key.alias, a.incidentID, by)
+
+	c.openMu.Lock()
+	if c.open[key] == a {
+		delete(c.open, key)
+	}
+	c.openMu.Unlock()
+}
+
+// call Alert API, response is decoded into result when it is not nil
+func (c *Client) call(apiKey string, method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode Opsgenie request")
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.conf.APIURL+path, reader)
+	if err != nil {
+		return errors.Wrapf(invalidConfigError, "failed to create Opsgenie request: %s", err)
+	}
+	req.Header.Set("Authorization", "GenieKey "+apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Opsgenie request failed")
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return errors.Wrap(err, "failed to read Opsgenie response")
+	}
+
+	if resp.StatusCode/100 != 2 {
+		e := &StatusError{StatusCode: resp.StatusCode}
+		var r struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &r) == nil && r.Message != "" {
+			e.Message = r.Message
+		} else {
+			e.Message = strings.TrimSpace(string(respBody))
+		}
+		return e
+	}
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return errors.Wrap(err, "failed to parse Opsgenie response")
+		}
+	}
+	return nil
+}
+
+func validPriority(priority string) bool {
+	for _, p := range priorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// network failures, throttling and server side errors can succeed later
+func isTemporaryError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *StatusError:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// StatusError is failure returned by Alert API
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Opsgenie returned HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Opsgenie returned HTTP %d: %s", e.StatusCode, e.Message)
+}
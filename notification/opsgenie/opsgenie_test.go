@@ -0,0 +1,329 @@
+package opsgenie
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/payload"
+	"github.com/exmonitor/firefly/service/state"
+)
+
+const testAPIKey = "eb243592-faa2-4ba2-a551-1afdf565c889"
+
+// apiRequest is request received by fake Alert API
+type apiRequest struct {
+	method string
+	uri    string
+	auth   string
+	body   map[string]interface{}
+}
+
+// fakeAlertAPI is local stand-in of Alert API, statuses of alerts are set by tests, key is alias
+// statuses holds HTTP statuses of the next POST requests, empty means 202
+type fakeAlertAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []apiRequest
+	alerts   map[string]string
+	statuses []int
+}
+
+func newFakeAlertAPI(t *testing.T) *fakeAlertAPI {
+	f := &fakeAlertAPI{alerts: map[string]string{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req := apiRequest{method: r.Method, uri: r.URL.RequestURI(), auth: r.Header.Get("Authorization")}
+		json.Unmarshal(body, &req.body)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.requests = append(f.requests, req)
+
+		if r.Method == http.MethodGet {
+			alias := strings.TrimPrefix(r.URL.Path, "/v2/alerts/")
+			status, ok := f.alerts[alias]
+			if !ok || r.URL.Query().Get("identifierType") != "alias" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message": "Alert does not exist"}`))
+				return
+			}
+			w.Write([]byte(status))
+			return
+		}
+		if len(f.statuses) > 0 {
+			status := f.statuses[0]
+			f.statuses = f.statuses[1:]
+			w.WriteHeader(status)
+			w.Write([]byte(`{"message": "Request failed"}`))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"result": "Request will be processed", "requestId": "43a29c5c"}`))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeAlertAPI) setAlert(alias string, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.alerts[alias] = status
+}
+
+func (f *fakeAlertAPI) received(method string) []apiRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var requests []apiRequest
+	for _, r := range f.requests {
+		if r.method == method {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+func newTestClient(t *testing.T, f *fakeAlertAPI, actions chan state.IncidentAction) *Client {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(Config{APIURL: f.URL + "/", Priority: "P2", Tags: []string{"team-ops"}, Timeout: time.Second, Retries: 1,
+		PollInterval: 20 * time.Millisecond, IncidentActionChan: actions, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func failedPayload() *payload.Payload {
+	return &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http", Target: "https://example.com", FailMessage: "HTTP 503"}
+}
+
+func resolvedPayload() *payload.Payload {
+	return &payload.Payload{ServiceID: 7, Status: payload.StatusResolved, Host: "web1", ServiceType: "http", Target: "https://example.com"}
+}
+
+func isOpen(c *Client, alias string) bool {
+	c.openMu.Lock()
+	defer c.openMu.Unlock()
+	_, ok := c.open[alertKey{apiKey: testAPIKey, alias: alias}]
+	return ok
+}
+
+func TestSendCreateNoteClose(t *testing.T) {
+	f := newFakeAlertAPI(t)
+	c := newTestClient(t, f, nil)
+	alias := Alias(7)
+
+	if err := c.Send(" "+testAPIKey+" ", Notification{IncidentID: "7-1", Payload: failedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(testAPIKey, Notification{IncidentID: "7-1", Resend: 2, Payload: failedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	if !isOpen(c, alias) {
+		t.Fatal("expected failed alert to be polled")
+	}
+	if err := c.Send(testAPIKey, Notification{IncidentID: "7-1", Payload: resolvedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	if isOpen(c, alias) {
+		t.Fatal("expected closed alert not to be polled")
+	}
+
+	requests := f.received(http.MethodPost)
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(requests))
+	}
+	for _, r := range requests {
+		if r.auth != "GenieKey "+testAPIKey {
+			t.Fatalf("expected GenieKey authorization, got %q", r.auth)
+		}
+	}
+	create, note, closeReq := requests[0], requests[1], requests[2]
+	if create.uri != "/v2/alerts" || create.body["alias"] != alias || create.body["priority"] != "P2" || create.body["entity"] != "web1" {
+		t.Fatalf("unexpected create request %s %v", create.uri, create.body)
+	}
+	tags, _ := json.Marshal(create.body["tags"])
+	if string(tags) != `["firefly","team-ops","http"]` {
+		t.Fatalf("unexpected tags %s", tags)
+	}
+	if details := create.body["details"].(map[string]interface{}); details["service id"] != "7" || details["incident id"] != "7-1" {
+		t.Fatalf("unexpected details %v", details)
+	}
+	if note.uri != "/v2/alerts/"+alias+"/notes?identifierType=alias" || !strings.HasPrefix(note.body["note"].(string), "Still failing, resend 2: ") {
+		t.Fatalf("unexpected note request %s %v", note.uri, note.body)
+	}
+	if closeReq.uri != "/v2/alerts/"+alias+"/close?identifierType=alias" || closeReq.body["source"] != source {
+		t.Fatalf("unexpected close request %s %v", closeReq.uri, closeReq.body)
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		wantStatus   int
+	}{
+		{name: "throttling is retried", statuses: []int{http.StatusTooManyRequests}, wantRequests: 2},
+		{name: "retries run out", statuses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}, wantRequests: 2, wantStatus: http.StatusServiceUnavailable},
+		{name: "invalid key is not retried", statuses: []int{http.StatusUnauthorized}, wantRequests: 1, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeAlertAPI(t)
+			f.statuses = tt.statuses
+			c := newTestClient(t, f, nil)
+			err := c.Send(testAPIKey, Notification{IncidentID: "7-1", Payload: failedPayload()})
+			if got := len(f.received(http.MethodPost)); got != tt.wantRequests {
+				t.Fatalf("expected %d requests, got %d", tt.wantRequests, got)
+			}
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if e, ok := errors.Cause(err).(*StatusError); !ok || e.StatusCode != tt.wantStatus || e.Message != "Request failed" {
+				t.Fatalf("expected HTTP %d, got %v", tt.wantStatus, err)
+			}
+			// alert which was not created is not polled
+			if isOpen(c, Alias(7)) {
+				t.Fatal("expected failed alert not to be polled")
+			}
+		})
+	}
+
+	f := newFakeAlertAPI(t)
+	c := newTestClient(t, f, nil)
+	if err := c.Send(" ", Notification{Payload: failedPayload()}); errors.Cause(err) != invalidAPIKeyError {
+		t.Fatalf("expected invalid API key, got %v", err)
+	}
+}
+
+func TestPollAcknowledgement(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		wantBy string
+	}{
+		{name: "acknowledged", status: `{"data": {"status": "open", "acknowledged": true, "report": {"acknowledgedBy": "jane@example.com"}}}`, wantBy: "Opsgenie jane@example.com"},
+		{name: "closed", status: `{"data": {"status": "closed", "acknowledged": false, "report": {"closedBy": "john@example.com"}}}`, wantBy: "Opsgenie john@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeAlertAPI(t)
+			actions := make(chan state.IncidentAction, 1)
+			c := newTestClient(t, f, actions)
+			c.Start()
+			defer c.Stop()
+
+			if err := c.Send(testAPIKey, Notification{IncidentID: "7-1", Payload: failedPayload()}); err != nil {
+				t.Fatal(err)
+			}
+			// alert is not created yet, then nobody has acknowledged it
+			waitForPolls(t, f, 2)
+			f.setAlert(Alias(7), `{"data": {"status": "open", "acknowledged": false}}`)
+			waitForPolls(t, f, 4)
+			if len(actions) != 0 {
+				t.Fatal("unacknowledged alert must not send action")
+			}
+
+			f.setAlert(Alias(7), tt.status)
+			select {
+			case a := <-actions:
+				if a.ServiceID != 7 || a.IncidentID != "7-1" || !a.Acknowledge || a.By != tt.wantBy {
+					t.Fatalf("unexpected action %+v", a)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("acknowledgement was not read back")
+			}
+			if isOpen(c, Alias(7)) {
+				t.Fatal("acknowledged alert must not be polled again")
+			}
+		})
+	}
+}
+
+func waitForPolls(t *testing.T, f *fakeAlertAPI, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.received(http.MethodGet)) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d polls", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCheckAlertDoesNotBlockOnFullChannel(t *testing.T) {
+	f := newFakeAlertAPI(t)
+	// nobody reads the actions
+	c := newTestClient(t, f, make(chan state.IncidentAction))
+	if err := c.Send(testAPIKey, Notification{IncidentID: "7-1", Payload: failedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	f.setAlert(Alias(7), `{"data": {"status": "open", "acknowledged": true, "report": {"acknowledgedBy": "jane@example.com"}}}`)
+	key := alertKey{apiKey: testAPIKey, alias: Alias(7)}
+	a := openAlert{serviceID: 7, incidentID: "7-1"}
+
+	done := make(chan struct{})
+	go func() {
+		c.checkAlert(key, a)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll blocked on incident action channel")
+	}
+	if !isOpen(c, Alias(7)) {
+		t.Fatal("dropped acknowledgement must be read again by the next poll")
+	}
+
+	actions := make(chan state.IncidentAction, 1)
+	c.conf.IncidentActionChan = actions
+	c.checkAlert(key, a)
+	if len(actions) != 1 || isOpen(c, Alias(7)) {
+		t.Fatal("expected acknowledgement on the next poll")
+	}
+}
+
+func TestAcknowledgementOfOldIncidentIsIgnored(t *testing.T) {
+	f := newFakeAlertAPI(t)
+	actions := make(chan state.IncidentAction, 1)
+	c := newTestClient(t, f, actions)
+	if err := c.Send(testAPIKey, Notification{IncidentID: "7-2", Payload: failedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	f.setAlert(Alias(7), `{"data": {"status": "open", "acknowledged": true}}`)
+
+	// poll started before the new incident replaced the old one
+	c.checkAlert(alertKey{apiKey: testAPIKey, alias: Alias(7)}, openAlert{serviceID: 7, incidentID: "7-1"})
+	if len(actions) != 0 || !isOpen(c, Alias(7)) {
+		t.Fatal("acknowledgement of old incident must not touch the new one")
+	}
+}
+
+func TestStartWithoutActions(t *testing.T) {
+	f := newFakeAlertAPI(t)
+	c := newTestClient(t, f, nil)
+	c.Start()
+	if err := c.Send(testAPIKey, Notification{IncidentID: "7-1", Payload: failedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	c.Stop()
+	if got := len(f.received(http.MethodGet)); got != 0 {
+		t.Fatalf("expected no polling without action channel, got %d polls", got)
+	}
+}
@@ -0,0 +1,7 @@
+package pagerduty
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var invalidRoutingKeyError error = errors.New("invalid PagerDuty routing key")
@@ -0,0 +1,94 @@
+package pagerduty
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	actionTrigger = "trigger"
+	actionResolve = "resolve"
+
+	// PagerDuty refuses longer summary
+	maxSummaryLength = 1024
+)
+
+// Event is body of Events API v2 request
+type Event struct {
+	RoutingKey  string        `json:"routing_key"`
+	EventAction string        `json:"event_action"`
+	DedupKey    string        `json:"dedup_key"`
+	Payload     *EventPayload `json:"payload,omitempty"`
+	Client      string        `json:"client,omitempty"`
+}
+
+// EventPayload is sent only with trigger events, resolve needs only the dedup key
+type EventPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Component     string            `json:"component,omitempty"`
+	Class         string            `json:"class,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// DedupKey identifies the incident in PagerDuty, so resolve closes the alert opened by trigger
+// incidents of services created before incident ids were introduced share the service key
+func DedupKey(serviceID int, incidentID string) string {
+	key := "firefly-" + strconv.Itoa(serviceID)
+	if incidentID != "" {
+		key += "-" + incidentID
+	}
+	return key
+}
+
+// NewEvent builds trigger event for failed payload and resolve event otherwise
+func NewEvent(routingKey string, severity string, n Notification) *Event {
+	p := n.Payload
+	e := &Event{
+		RoutingKey:  routingKey,
+		EventAction: actionResolve,
+		DedupKey:    DedupKey(p.ServiceID, n.IncidentID),
+		Client:      "firefly",
+	}
+	if !p.Failed {
+		return e
+	}
+
+	e.EventAction = actionTrigger
+	e.Payload = &EventPayload{
+		Summary:   truncate(p.Summary(), maxSummaryLength),
+		Source:    p.Host,
+		Severity:  severity,
+		Component: p.Target,
+		Class:     p.ServiceType,
+		CustomDetails: map[string]string{
+			"service id": strconv.Itoa(p.ServiceID),
+		},
+	}
+	if e.Payload.Source == "" {
+		e.Payload.Source = p.Target
+	}
+	if !n.IncidentStart.IsZero() {
+		e.Payload.Timestamp = n.IncidentStart.UTC().Format(time.RFC3339)
+	}
+	if n.IncidentID != "" {
+		e.Payload.CustomDetails["incident id"] = n.IncidentID
+	}
+	if p.FailMessage != "" {
+		e.Payload.CustomDetails["fail message"] = p.FailMessage
+	}
+	for _, f := range p.Fields() {
+		e.Payload.CustomDetails[f.Name] = f.Value
+	}
+	return e
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-3]) + "..."
+}
@@ -0,0 +1,173 @@
+package pagerduty
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/internal/retry"
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+const (
+	DefaultURL = "https://events.pagerduty.com/v2/enqueue"
+
+	maxResponseSize = 4 * 1024
+)
+
+// severities accepted by Events API v2
+var severities = []string{"critical", "error", "warning", "info"}
+
+type Config struct {
+	// Events API v2 enqueue URL, DefaultURL when empty
+	URL string
+	// severity of triggered alerts
+	Severity string
+	// timeout of single request
+	Timeout time.Duration
+	// how many times is temporary failure retried
+	Retries int
+
+	Logger *exlogger.Logger
+}
+
+// Notification is single notification of PagerDuty contact
+type Notification struct {
+	IncidentID    string
+	IncidentStart time.Time
+	Payload       *payload.Payload
+}
+
+// Client sends trigger and resolve events to Events API v2, contact target is integration routing key
+type Client struct {
+	conf   Config
+	client *http.Client
+}
+
+func New(conf Config) (*Client, error) {
+	if conf.URL == "" {
+		conf.URL = DefaultURL
+	}
+	if !validSeverity(conf.Severity) {
+		return nil, errors.Wrapf(invalidConfigError, "conf.Severity must be one of %s", strings.Join(severities, ", "))
+	}
+	if conf.Timeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Timeout must be positive duration")
+	}
+	if conf.Retries < 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Retries must not be negative")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+
+	c := &Client{
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout},
+	}
+	return c, nil
+}
+
+// Send triggers the alert for FAIL notification and resolves it for OK notification
+func (c *Client) Send(routingKey string, n Notification) error {
+	routingKey = strings.TrimSpace(routingKey)
+	if len(routingKey) != 32 {
+		return errors.Wrap(invalidRoutingKeyError, "routing key must have 32 characters")
+	}
+	if n.Payload == nil {
+		return errors.Wrap(invalidRoutingKeyError, "notification has no payload")
+	}
+	event := NewEvent(routingKey, c.conf.Severity, n)
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode PagerDuty event")
+	}
+
+	send := func() error {
+		err := c.send(body)
+		if err != nil && !isTemporaryError(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	notify := func(err error, next time.Duration) {
+		c.conf.Logger.LogError(err, "failed to send PagerDuty %s event %s, retrying in %s", event.EventAction, event.DedupKey, next.Round(time.Millisecond))
+	}
+	err = backoff.RetryNotify(send, retry.New(c.conf.Retries), notify)
+	if permanent, ok := err.(*backoff.PermanentError); ok {
+		err = permanent.Err
+	}
+	return err
+}
+
+func (c *Client) send(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(invalidConfigError, "failed to create PagerDuty request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "PagerDuty request failed")
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	e := &StatusError{StatusCode: resp.StatusCode}
+	var r struct {
+		Message string   `json:"message"`
+		Errors  []string `json:"errors"`
+	}
+	if json.Unmarshal(respBody, &r) == nil && r.Message != "" {
+		e.Message = strings.Join(append([]string{r.Message}, r.Errors...), ": ")
+	} else {
+		e.Message = strings.TrimSpace(string(respBody))
+	}
+	return e
+}
+
+func validSeverity(severity string) bool {
+	for _, s := range severities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// network failures, throttling and server side errors can succeed later
+func isTemporaryError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *StatusError:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// StatusError is failure returned by Events API
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("PagerDuty returned HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("PagerDuty returned HTTP %d: %s", e.StatusCode, e.Message)
+}
@@ -0,0 +1,196 @@
+package pagerduty
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+const testRoutingKey = "R0123456789abcdef0123456789abcde"
+
+// fakeEvents is local Events API v2 endpoint, it answers with the statuses in order, the last one is repeated
+type fakeEvents struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	events   []Event
+	statuses []int
+}
+
+func newFakeEvents(t *testing.T, statuses ...int) *fakeEvents {
+	f := &fakeEvents{statuses: statuses}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var e Event
+		if r.URL.Path != "/v2/enqueue" || json.Unmarshal(body, &e) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		i := len(f.events)
+		if i >= len(f.statuses) {
+			i = len(f.statuses) - 1
+		}
+		f.events = append(f.events, e)
+		f.mu.Unlock()
+		w.WriteHeader(f.statuses[i])
+		if f.statuses[i] == http.StatusAccepted {
+			json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Event processed", "dedup_key": e.DedupKey})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "invalid event", "message": "Event object is invalid", "errors": []string{"Length of 'routing_key' is incorrect"}})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeEvents) received() []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Event(nil), f.events...)
+}
+
+func newTestClient(t *testing.T, f *fakeEvents, retries int) *Client {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(Config{URL: f.URL + "/v2/enqueue", Severity: "critical", Timeout: time.Second, Retries: retries, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDedupKey(t *testing.T) {
+	if got := DedupKey(7, "7-1700000000"); got != "firefly-7-7-1700000000" {
+		t.Fatalf("unexpected dedup key %q", got)
+	}
+	if got := DedupKey(7, ""); got != "firefly-7" {
+		t.Fatalf("unexpected dedup key without incident %q", got)
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	start := time.Date(2024, 3, 5, 14, 30, 0, 0, time.FixedZone("CET", 3600))
+	failed := &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "db1", ServiceType: "tcp", Target: "10.0.0.5",
+		FailMessage: "connection refused", TCP: &payload.TCP{Address: "10.0.0.5:5432", ErrorClass: payload.ErrorClassRefused}}
+
+	e := NewEvent(testRoutingKey, "error", Notification{IncidentID: "7-1", IncidentStart: start, Payload: failed})
+	if e.EventAction != actionTrigger || e.DedupKey != "firefly-7-7-1" || e.RoutingKey != testRoutingKey || e.Client != "firefly" {
+		t.Fatalf("unexpected trigger event %+v", e)
+	}
+	p := e.Payload
+	if p.Severity != "error" || p.Source != "db1" || p.Component != "10.0.0.5" || p.Class != "tcp" || p.Timestamp != "2024-03-05T13:30:00Z" {
+		t.Fatalf("unexpected trigger payload %+v", p)
+	}
+	if p.CustomDetails["service id"] != "7" || p.CustomDetails["incident id"] != "7-1" || p.CustomDetails["fail message"] != "connection refused" {
+		t.Fatalf("unexpected custom details %v", p.CustomDetails)
+	}
+
+	// resolve closes the alert of the same incident and carries nothing else
+	resolved := &payload.Payload{ServiceID: 7, Status: payload.StatusResolved, Host: "db1", ServiceType: "tcp", Target: "10.0.0.5"}
+	e = NewEvent(testRoutingKey, "error", Notification{IncidentID: "7-1", IncidentStart: start, Payload: resolved})
+	if e.EventAction != actionResolve || e.DedupKey != "firefly-7-7-1" || e.Payload != nil {
+		t.Fatalf("unexpected resolve event %+v", e)
+	}
+
+	// source falls back to target and long summary is cut
+	failed = &payload.Payload{ServiceID: 8, Failed: true, Status: payload.StatusFailed, Target: "example.com", ServiceType: "icmp", FailMessage: strings.Repeat("x", 2000)}
+	e = NewEvent(testRoutingKey, "critical", Notification{Payload: failed})
+	if e.Payload.Source != "example.com" || e.Payload.Timestamp != "" || e.DedupKey != "firefly-8" {
+		t.Fatalf("unexpected event without incident %+v", e.Payload)
+	}
+	if n := len([]rune(e.Payload.Summary)); n > maxSummaryLength {
+		t.Fatalf("expected summary cut to %d characters, got %d", maxSummaryLength, n)
+	}
+}
+
+func TestSendTriggerAndResolve(t *testing.T) {
+	f := newFakeEvents(t, http.StatusAccepted)
+	c := newTestClient(t, f, 0)
+	failed := &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http"}
+	resolved := &payload.Payload{ServiceID: 7, Status: payload.StatusResolved, Host: "web1", ServiceType: "http"}
+
+	if err := c.Send(" "+testRoutingKey+"\n", Notification{IncidentID: "7-1", Payload: failed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(testRoutingKey, Notification{IncidentID: "7-1", Payload: resolved}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := f.received()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].EventAction != actionTrigger || events[1].EventAction != actionResolve {
+		t.Fatalf("expected trigger and resolve, got %s and %s", events[0].EventAction, events[1].EventAction)
+	}
+	if events[0].DedupKey != events[1].DedupKey || events[0].RoutingKey != testRoutingKey {
+		t.Fatalf("expected the same dedup key and trimmed routing key, got %+v and %+v", events[0], events[1])
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []int
+		retries     int
+		wantEvents  int
+		wantStatus  int
+		wantMessage string
+	}{
+		{name: "throttling is retried", statuses: []int{http.StatusTooManyRequests, http.StatusAccepted}, retries: 1, wantEvents: 2},
+		{name: "retries run out", statuses: []int{http.StatusInternalServerError}, retries: 1, wantEvents: 2, wantStatus: http.StatusInternalServerError},
+		{name: "invalid event is not retried", statuses: []int{http.StatusBadRequest}, retries: 3, wantEvents: 1, wantStatus: http.StatusBadRequest,
+			wantMessage: "Event object is invalid: Length of 'routing_key' is incorrect"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeEvents(t, tt.statuses...)
+			c := newTestClient(t, f, tt.retries)
+			p := &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http"}
+			err := c.Send(testRoutingKey, Notification{IncidentID: "7-1", Payload: p})
+			if got := len(f.received()); got != tt.wantEvents {
+				t.Fatalf("expected %d events, got %d", tt.wantEvents, got)
+			}
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			e, ok := errors.Cause(err).(*StatusError)
+			if !ok || e.StatusCode != tt.wantStatus {
+				t.Fatalf("expected HTTP %d, got %v", tt.wantStatus, err)
+			}
+			if tt.wantMessage != "" && e.Message != tt.wantMessage {
+				t.Fatalf("expected message %q, got %q", tt.wantMessage, e.Message)
+			}
+		})
+	}
+}
+
+func TestSendInvalidRoutingKey(t *testing.T) {
+	f := newFakeEvents(t, http.StatusAccepted)
+	c := newTestClient(t, f, 0)
+	p := &payload.Payload{ServiceID: 7, Failed: true}
+	for _, key := range []string{"", "short", testRoutingKey + "x"} {
+		if err := c.Send(key, Notification{Payload: p}); errors.Cause(err) != invalidRoutingKeyError {
+			t.Errorf("%q: expected invalid routing key, got %v", key, err)
+		}
+	}
+	if len(f.received()) != 0 {
+		t.Fatal("invalid routing keys must not be sent")
+	}
+}
@@ -0,0 +1,290 @@
+package payload
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exmonitor/exclient/database/spec/service"
+	"github.com/exmonitor/exclient/database/spec/status"
+
+	"github.com/exmonitor/firefly/service/metadata"
+)
+
+const (
+	StatusFailed   = "CRITICAL"
+	StatusResolved = "Resolved"
+)
+
+// connect error classes of TCP checks
+const (
+	ErrorClassTimeout     = "timeout"
+	ErrorClassRefused     = "refused"
+	ErrorClassDNS         = "dns"
+	ErrorClassReset       = "reset"
+	ErrorClassUnreachable = "unreachable"
+	ErrorClassTLS         = "tls"
+	ErrorClassOther       = "other"
+)
+
+// Payload is channel independent content of the notification
+// exactly one of HTTP, TCP and ICMP is set for known service types
+type Payload struct {
+	ServiceID   int
+	Failed      bool
+	Status      string
+	Host        string
+	Target      string
+	ServiceType string
+	FailMessage string
+	// response time of the last check, 0 when unknown
+	ResponseTime time.Duration
+
+	HTTP *HTTP
+	TCP  *TCP
+	ICMP *ICMP
+}
+
+type HTTP struct {
+	URL    string
+	Method string
+	// allowed status codes, readable list
+	ExpectedStatus string
+	// status code returned by the server, 0 when unknown
+	ActualStatus int
+}
+
+type TCP struct {
+	// host:port
+	Address    string
+	ErrorClass string
+}
+
+type ICMP struct {
+	PacketCount int
+	// percent, -1 when unknown
+	PacketLoss float64
+	// average round trip time, 0 when unknown
+	RTT time.Duration
+}
+
+// Field is single labeled value, channels without templates render fields in order
+type Field struct {
+	Name  string
+	Value string
+}
+
+// New builds payload of the notification
+// serviceMetadata and lastStatus are optional, they only add details
+func New(serviceInfo *service.Service, serviceMetadata *metadata.Metadata, failed bool, failMessage string, lastStatus *status.ServiceStatus) *Payload {
+	p := &Payload{
+		ServiceID:   serviceInfo.ID,
+		Failed:      failed,
+		Status:      StatusResolved,
+		Host:        serviceInfo.Host,
+		Target:      serviceInfo.Target,
+		ServiceType: serviceInfo.ServiceTypeString(),
+		FailMessage: failMessage,
+	}
+	if failed {
+		p.Status = StatusFailed
+	}
+	message := failMessage
+	if lastStatus != nil {
+		p.ResponseTime = lastStatus.Duration
+		if message == "" {
+			message = lastStatus.Message
+		}
+	}
+
+	switch p.ServiceType {
+	case metadata.TypeHTTP:
+		p.HTTP = &HTTP{ActualStatus: parseHTTPStatus(message)}
+		if serviceMetadata != nil && serviceMetadata.HTTP != nil {
+			p.HTTP.URL = serviceMetadata.HTTP.URL()
+			p.HTTP.Method = serviceMetadata.HTTP.MethodOrDefault()
+			p.HTTP.ExpectedStatus = serviceMetadata.HTTP.ExpectedStatus()
+		} else {
+			p.HTTP.URL = serviceInfo.Target
+		}
+	case metadata.TypeTCP:
+		p.TCP = &TCP{Address: serviceInfo.Target}
+		if port := serviceMetadata.Port(); port > 0 {
+			p.TCP.Address = net.JoinHostPort(serviceInfo.Target, strconv.Itoa(port))
+		}
+		if failed {
+			p.TCP.ErrorClass = classifyConnectError(message)
+		}
+	case metadata.TypeICMP:
+		p.ICMP = &ICMP{PacketLoss: parsePacketLoss(message), RTT: parseRTT(message)}
+		if serviceMetadata != nil && serviceMetadata.ICMP != nil {
+			p.ICMP.PacketCount = serviceMetadata.ICMP.PacketCount
+		}
+		if p.ICMP.RTT == 0 && !failed {
+			p.ICMP.RTT = p.ResponseTime
+		}
+	}
+	return p
+}
+
+// Port returns port of the check as string, empty for checks without port
+func (p *Payload) Port() string {
+	if p.TCP != nil {
+		if _, port, err := net.SplitHostPort(p.TCP.Address); err == nil {
+			return port
+		}
+	}
+	return ""
+}
+
+// Fields returns type specific details of the check
+func (p *Payload) Fields() []Field {
+	var fields []Field
+	add := func(name string, value string) {
+		if value != "" {
+			fields = append(fields, Field{Name: name, Value: value})
+		}
+	}
+
+	switch {
+	case p.HTTP != nil:
+		add("URL", strings.TrimSpace(p.HTTP.Method+" "+p.HTTP.URL))
+		add("Expected status", p.HTTP.ExpectedStatus)
+		if p.HTTP.ActualStatus > 0 {
+			add("Actual status", strconv.Itoa(p.HTTP.ActualStatus))
+		}
+		add("Response time", FormatDuration(p.ResponseTime))
+	case p.TCP != nil:
+		add("Address", p.TCP.Address)
+		add("Error", p.TCP.ErrorClass)
+		add("Connect time", FormatDuration(p.ResponseTime))
+	case p.ICMP != nil:
+		if p.ICMP.PacketCount > 0 {
+			add("Packets", strconv.Itoa(p.ICMP.PacketCount))
+		}
+		if p.ICMP.PacketLoss >= 0 {
+			add("Packet loss", strconv.FormatFloat(p.ICMP.PacketLoss, 'f', -1, 64)+"%")
+		}
+		add("RTT", FormatDuration(p.ICMP.RTT))
+	}
+	return fields
+}
+
+// Summary returns single line description of the check, meant for short channels like SMS or chat
+// example 'CRITICAL myhost http GET https://myhost.com status 503 (expected 200)'
+func (p *Payload) Summary() string {
+	parts := []string{p.Status, p.Host}
+	switch {
+	case p.HTTP != nil:
+		parts = append(parts, p.ServiceType, p.HTTP.URL)
+		if p.HTTP.ActualStatus > 0 {
+			s := fmt.Sprintf("status %d", p.HTTP.ActualStatus)
+			if p.HTTP.ExpectedStatus != "" {
+				s += fmt.Sprintf(" (expected %s)", p.HTTP.ExpectedStatus)
+			}
+			parts = append(parts, s)
+		}
+	case p.TCP != nil:
+		parts = append(parts, p.ServiceType, p.TCP.Address)
+		if p.TCP.ErrorClass != "" {
+			parts = append(parts, p.TCP.ErrorClass)
+		}
+	case p.ICMP != nil:
+		parts = append(parts, p.ServiceType, p.Target)
+		if p.ICMP.PacketLoss >= 0 {
+			parts = append(parts, fmt.Sprintf("loss %s%%", strconv.FormatFloat(p.ICMP.PacketLoss, 'f', -1, 64)))
+		}
+	default:
+		parts = append(parts, p.ServiceType, p.Target)
+		if p.Failed && p.FailMessage != "" {
+			parts = append(parts, p.FailMessage)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// FormatDuration formats response time, short times keep sub-millisecond precision
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return ""
+	case d < time.Millisecond*100:
+		return d.Round(time.Microsecond * 100).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+var httpStatusRegexp = regexp.MustCompile(`(?i)(?:status(?:\s*code)?|http/\d(?:\.\d)?)\D{0,5}([1-5]\d\d)\b`)
+
+// checker reports status code in the message, e.g. 'unexpected status code 503'
+func parseHTTPStatus(message string) int {
+	match := httpStatusRegexp.FindStringSubmatch(message)
+	if match == nil {
+		return 0
+	}
+	code, _ := strconv.Atoi(match[1])
+	return code
+}
+
+// classify connect error from go net error message
+func classifyConnectError(message string) string {
+	m := strings.ToLower(message)
+	switch {
+	case m == "":
+		return ""
+	case strings.Contains(m, "timeout") || strings.Contains(m, "timed out") || strings.Contains(m, "deadline exceeded"):
+		return ErrorClassTimeout
+	case strings.Contains(m, "refused"):
+		return ErrorClassRefused
+	case strings.Contains(m, "no such host") || strings.Contains(m, "lookup ") || strings.Contains(m, "dns"):
+		return ErrorClassDNS
+	case strings.Contains(m, "reset by peer") || strings.Contains(m, "broken pipe") || strings.Contains(m, "eof"):
+		return ErrorClassReset
+	case strings.Contains(m, "unreachable") || strings.Contains(m, "no route to host"):
+		return ErrorClassUnreachable
+	case strings.Contains(m, "tls") || strings.Contains(m, "x509") || strings.Contains(m, "certificate"):
+		return ErrorClassTLS
+	}
+	return ErrorClassOther
+}
+
+var packetLossRegexp = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*%\s*packet\s*loss|packet\s*loss\D{0,3}(\d+(?:\.\d+)?)\s*%`)
+
+// parse packet loss from ping like message, -1 when unknown
+func parsePacketLoss(message string) float64 {
+	match := packetLossRegexp.FindStringSubmatch(message)
+	if match == nil {
+		return -1
+	}
+	value := match[1]
+	if value == "" {
+		value = match[2]
+	}
+	loss, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return -1
+	}
+	return loss
+}
+
+var pingSummaryRegexp = regexp.MustCompile(`min/avg/max(?:/[a-z]+)?\s*=\s*[\d.]+/([\d.]+)/`)
+var rttRegexp = regexp.MustCompile(`(?i)(?:rtt|avg)[^\d]{0,20}(\d+(?:\.\d+)?)\s*ms`)
+
+// parse average round trip time from ping like message
+func parseRTT(message string) time.Duration {
+	match := pingSummaryRegexp.FindStringSubmatch(message)
+	if match == nil {
+		match = rttRegexp.FindStringSubmatch(message)
+	}
+	if match == nil {
+		return 0
+	}
+	ms, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
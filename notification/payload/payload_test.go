@@ -0,0 +1,289 @@
+package payload
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exclient/database/spec/service"
+	"github.com/exmonitor/exclient/database/spec/status"
+
+	"github.com/exmonitor/firefly/service/metadata"
+)
+
+func TestParseHTTPStatus(t *testing.T) {
+	testCases := []struct {
+		message string
+		want    int
+	}{
+		{message: "status code 503", want: 503},
+		{message: "unexpected status code 404, allowed status codes [200 201]", want: 404},
+		{message: "Status: 401 Unauthorized", want: 401},
+		{message: "HTTP/1.1 502 Bad Gateway", want: 502},
+		{message: "HTTP/2 500", want: 500},
+		{message: `Get "https://master.cz": dial tcp: i/o timeout`, want: 0},
+		{message: "content check failed, string my_string not found", want: 0},
+		{message: "connect to port 8080 failed", want: 0},
+		{message: "status code 600", want: 0},
+		{message: "", want: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.message, func(t *testing.T) {
+			if got := parseHTTPStatus(tc.message); got != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestClassifyConnectError(t *testing.T) {
+	testCases := []struct {
+		message string
+		want    string
+	}{
+		{message: "dial tcp 10.0.0.1:22: i/o timeout", want: ErrorClassTimeout},
+		{message: "dial tcp: lookup master.cz: i/o timeout", want: ErrorClassTimeout},
+		{message: "context deadline exceeded", want: ErrorClassTimeout},
+		{message: "dial tcp 10.0.0.1:22: connect: connection refused", want: ErrorClassRefused},
+		{message: "dial tcp: lookup nonexistent.example on 127.0.0.53:53: no such host", want: ErrorClassDNS},
+		{message: "read tcp 10.0.0.2:51234->10.0.0.1:22: read: connection reset by peer", want: ErrorClassReset},
+		{message: "EOF", want: ErrorClassReset},
+		{message: "dial tcp 10.0.0.1:22: connect: no route to host", want: ErrorClassUnreachable},
+		{message: "dial tcp 10.0.0.1:22: connect: network is unreachable", want: ErrorClassUnreachable},
+		{message: "x509: certificate has expired or is not yet valid", want: ErrorClassTLS},
+		{message: "tls: handshake failure", want: ErrorClassTLS},
+		{message: "port check failed", want: ErrorClassOther},
+		{message: "", want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.message, func(t *testing.T) {
+			if got := classifyConnectError(tc.message); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParsePacketLoss(t *testing.T) {
+	testCases := []struct {
+		message string
+		want    float64
+	}{
+		{message: "3 packets transmitted, 0 received, 100% packet loss, time 2003ms", want: 100},
+		{message: "5 packets transmitted, 4 packets received, 20.0% packet loss", want: 20},
+		{message: "packet loss: 33.3%", want: 33.3},
+		{message: "4 packets transmitted, 4 received, 0% packet loss, time 3004ms", want: 0},
+		{message: "ping timeout", want: -1},
+		{message: "rtt min/avg/max/mdev = 10.125/12.5/15.75/1.25 ms", want: -1},
+		{message: "", want: -1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.message, func(t *testing.T) {
+			if got := parsePacketLoss(tc.message); got != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseRTT(t *testing.T) {
+	testCases := []struct {
+		message string
+		want    time.Duration
+	}{
+		// iputils ping summary line
+		{message: "rtt min/avg/max/mdev = 10.125/12.5/15.75/1.25 ms", want: 12500 * time.Microsecond},
+		// busybox and bsd ping
+		{message: "round-trip min/avg/max = 1.0/2.25/4.0 ms", want: 2250 * time.Microsecond},
+		{message: "round-trip min/avg/max/stddev = 0.5/0.75/1.0/0.25 ms", want: 750 * time.Microsecond},
+		{message: "avg rtt 15.5 ms", want: 15500 * time.Microsecond},
+		{message: "3 packets transmitted, 0 received, 100% packet loss, time 2003ms", want: 0},
+		{message: "", want: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.message, func(t *testing.T) {
+			if got := parseRTT(tc.message); got != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	testCases := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 0, want: ""},
+		{d: -time.Second, want: ""},
+		{d: 1234567 * time.Nanosecond, want: "1.2ms"},
+		{d: 12500 * time.Microsecond, want: "12.5ms"},
+		{d: 250 * time.Millisecond, want: "250ms"},
+		{d: 1234567 * time.Microsecond, want: "1.235s"},
+	}
+
+	for _, tc := range testCases {
+		if got := FormatDuration(tc.d); got != tc.want {
+			t.Errorf("%d expected %q, got %q", tc.d, tc.want, got)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	httpMetadata := &metadata.Metadata{Type: metadata.TypeHTTP, HTTP: &metadata.HTTP{
+		Target:                 "https://master.cz",
+		Port:                   443,
+		Method:                 "get",
+		Query:                  "?a=1",
+		AllowedHTTPStatusCodes: []int{200, 201},
+	}}
+	tcpMetadata := &metadata.Metadata{Type: metadata.TypeTCP, TCP: &metadata.TCP{Target: "10.0.0.1", Port: 22}}
+	icmpMetadata := &metadata.Metadata{Type: metadata.TypeICMP, ICMP: &metadata.ICMP{Target: "8.8.8.8", PacketCount: 3}}
+
+	testCases := []struct {
+		name        string
+		serviceInfo *service.Service
+		metadata    *metadata.Metadata
+		failed      bool
+		failMessage string
+		lastStatus  *status.ServiceStatus
+		wantSummary string
+		wantFields  []Field
+		wantPort    string
+	}{
+		{
+			name:        "http failed",
+			serviceInfo: &service.Service{ID: 5, Type: 1, Host: "myhost", Target: "master.cz"},
+			metadata:    httpMetadata,
+			failed:      true,
+			failMessage: "status code 503",
+			lastStatus:  &status.ServiceStatus{Duration: 250 * time.Millisecond},
+			wantSummary: "CRITICAL myhost http https://master.cz?a=1 status 503 (expected 200, 201)",
+			wantFields: []Field{
+				{Name: "URL", Value: "GET https://master.cz?a=1"},
+				{Name: "Expected status", Value: "200, 201"},
+				{Name: "Actual status", Value: "503"},
+				{Name: "Response time", Value: "250ms"},
+			},
+		},
+		{
+			name:        "http without metadata",
+			serviceInfo: &service.Service{ID: 5, Type: 1, Host: "myhost", Target: "master.cz"},
+			failed:      true,
+			failMessage: `Get "https://master.cz": dial tcp: i/o timeout`,
+			wantSummary: "CRITICAL myhost http master.cz",
+			wantFields:  []Field{{Name: "URL", Value: "master.cz"}},
+		},
+		{
+			name:        "tcp failed",
+			serviceInfo: &service.Service{ID: 3, Type: 2, Host: "myhost", Target: "10.0.0.1"},
+			metadata:    tcpMetadata,
+			failed:      true,
+			failMessage: "dial tcp 10.0.0.1:22: i/o timeout",
+			lastStatus:  &status.ServiceStatus{Duration: 5 * time.Second},
+			wantSummary: "CRITICAL myhost tcp 10.0.0.1:22 timeout",
+			wantFields: []Field{
+				{Name: "Address", Value: "10.0.0.1:22"},
+				{Name: "Error", Value: ErrorClassTimeout},
+				{Name: "Connect time", Value: "5s"},
+			},
+			wantPort: "22",
+		},
+		{
+			name:        "tcp refused from last status",
+			serviceInfo: &service.Service{ID: 3, Type: 2, Host: "myhost", Target: "2001:db8::1"},
+			metadata:    tcpMetadata,
+			failed:      true,
+			lastStatus:  &status.ServiceStatus{Message: "dial tcp [2001:db8::1]:22: connect: connection refused"},
+			wantSummary: "CRITICAL myhost tcp [2001:db8::1]:22 refused",
+			wantFields: []Field{
+				{Name: "Address", Value: "[2001:db8::1]:22"},
+				{Name: "Error", Value: ErrorClassRefused},
+			},
+			wantPort: "22",
+		},
+		{
+			name:        "tcp resolved",
+			serviceInfo: &service.Service{ID: 3, Type: 2, Host: "myhost", Target: "10.0.0.1"},
+			metadata:    tcpMetadata,
+			lastStatus:  &status.ServiceStatus{Message: "ok", Duration: 12500 * time.Microsecond},
+			wantSummary: "Resolved myhost tcp 10.0.0.1:22",
+			wantFields: []Field{
+				{Name: "Address", Value: "10.0.0.1:22"},
+				{Name: "Connect time", Value: "12.5ms"},
+			},
+			wantPort: "22",
+		},
+		{
+			name:        "icmp failed",
+			serviceInfo: &service.Service{ID: 4, Type: 3, Host: "myhost", Target: "8.8.8.8"},
+			metadata:    icmpMetadata,
+			failed:      true,
+			failMessage: "3 packets transmitted, 0 received, 100% packet loss, time 2003ms",
+			wantSummary: "CRITICAL myhost icmp 8.8.8.8 loss 100%",
+			wantFields: []Field{
+				{Name: "Packets", Value: "3"},
+				{Name: "Packet loss", Value: "100%"},
+			},
+		},
+		{
+			name:        "icmp resolved with ping summary",
+			serviceInfo: &service.Service{ID: 4, Type: 3, Host: "myhost", Target: "8.8.8.8"},
+			metadata:    icmpMetadata,
+			lastStatus:  &status.ServiceStatus{Message: "3 packets transmitted, 3 received, 0% packet loss, time 2003ms\nrtt min/avg/max/mdev = 10.125/12.5/15.75/1.25 ms", Duration: time.Second},
+			wantSummary: "Resolved myhost icmp 8.8.8.8 loss 0%",
+			wantFields: []Field{
+				{Name: "Packets", Value: "3"},
+				{Name: "Packet loss", Value: "0%"},
+				{Name: "RTT", Value: "12.5ms"},
+			},
+		},
+		{
+			// unknown loss is -1 and hidden, rtt falls back to response time of the check
+			name:        "icmp resolved with unknown loss",
+			serviceInfo: &service.Service{ID: 4, Type: 3, Host: "myhost", Target: "8.8.8.8"},
+			lastStatus:  &status.ServiceStatus{Message: "ok", Duration: 20 * time.Millisecond},
+			wantSummary: "Resolved myhost icmp 8.8.8.8",
+			wantFields:  []Field{{Name: "RTT", Value: "20ms"}},
+		},
+		{
+			name:        "unknown type",
+			serviceInfo: &service.Service{ID: 9, Type: 9, Host: "myhost", Target: "1.2.3.4"},
+			failed:      true,
+			failMessage: "check failed",
+			wantSummary: "CRITICAL myhost unknown 1.2.3.4 check failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := New(tc.serviceInfo, tc.metadata, tc.failed, tc.failMessage, tc.lastStatus)
+			if p.ServiceID != tc.serviceInfo.ID || p.Failed != tc.failed || p.FailMessage != tc.failMessage {
+				t.Errorf("unexpected common fields %+v", p)
+			}
+			if got := p.Summary(); got != tc.wantSummary {
+				t.Errorf("expected summary %q, got %q", tc.wantSummary, got)
+			}
+			if got := p.Fields(); !reflect.DeepEqual(got, tc.wantFields) {
+				t.Errorf("expected fields %+v, got %+v", tc.wantFields, got)
+			}
+			if got := p.Port(); got != tc.wantPort {
+				t.Errorf("expected port %q, got %q", tc.wantPort, got)
+			}
+		})
+	}
+}
+
+func TestPayloadICMPUnknownLoss(t *testing.T) {
+	p := New(&service.Service{ID: 4, Type: 3, Target: "8.8.8.8"}, nil, true, "ping timeout", nil)
+	if p.ICMP == nil || p.ICMP.PacketLoss != -1 || p.ICMP.RTT != 0 {
+		t.Fatalf("expected unknown loss and rtt, got %+v", p.ICMP)
+	}
+	if p.HTTP != nil || p.TCP != nil {
+		t.Fatalf("expected only icmp details, got %+v", p)
+	}
+}
@@ -0,0 +1,11 @@
+package phone
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var notAnsweredError error = errors.New("phone call was not answered")
+
+var invalidSignatureError error = errors.New("invalid callback signature")
+
+var incidentActionDroppedError error = errors.New("incident action queue is full")
@@ -2,8 +2,27 @@ package phone
 
 import "fmt"
 
+// Caller places voice call which reads the message to the phone number
+type Caller interface {
+	Call(number string, message string, incident Incident) error
+}
+
+// Incident identifies the outage the call is about, keypress responses are applied to it
+// zero value means the call can not be acknowledged, e.g. recovery notification
+type Incident struct {
+	ServiceID  int
+	IncidentID string
+}
+
+// Call only prints the call, it is used when no voice provider is configured
 func Call(number string, message string) error {
-	// TODO
 	fmt.Printf("<< Fake phone call notification sent to %s\n", number)
 	return nil
 }
+
+// FakeCaller prints calls instead of placing them
+type FakeCaller struct{}
+
+func (FakeCaller) Call(number string, message string, incident Incident) error {
+	return Call(number, message)
+}
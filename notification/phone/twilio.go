@@ -0,0 +1,511 @@
+package phone
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/service/state"
+)
+
+const (
+	DefaultTwilioAPIURL = "https://api.twilio.com"
+
+	// paths of status and keypress callbacks on firefly HTTP listener
+	StatusPath = "/phone/twilio/status"
+	GatherPath = "/phone/twilio/gather"
+
+	// calls without final status callback are forgotten after this time
+	callTimeout = time.Hour
+	// only beginning of the provider response is read
+	maxResponseSize = 64 * 1024
+	// form posted by status callback is small
+	maxCallbackSize = 64 * 1024
+
+	// twilio call statuses
+	callStatusInProgress = "in-progress"
+	callStatusCompleted  = "completed"
+	callStatusBusy       = "busy"
+	callStatusNoAnswer   = "no-answer"
+	callStatusFailed     = "failed"
+	callStatusCanceled   = "canceled"
+)
+
+type TwilioConfig struct {
+	// base URL of Twilio compatible voice API
+	APIURL     string
+	AccountSID string
+	AuthToken  string
+	// caller number
+	From string
+	// public base URL of firefly HTTP listener, status callbacks and retries are disabled when empty
+	PublicURL string
+	// how long the phone rings before the call counts as not answered
+	RingTimeout time.Duration
+	// how many times is busy, not answered or failed call placed again
+	Retries       int
+	RetryInterval time.Duration
+	// text to speech settings, provider defaults are used when empty
+	Voice    string
+	Language string
+	// how many times is the message read during the call
+	Repeat int
+	// callee can silence alerts of the incident for this time by keypress
+	SilenceDuration time.Duration
+	// keypress acknowledgements and silences are sent here, callee is not asked to press any key when nil
+	IncidentActionChan chan state.IncidentAction
+	// timeout of single API request
+	Timeout time.Duration
+
+	Logger *exlogger.Logger
+}
+
+func NewTwilio(conf TwilioConfig) (*Twilio, error) {
+	if conf.APIURL == "" {
+		conf.APIURL = DefaultTwilioAPIURL
+	}
+	if _, err := url.Parse(conf.APIURL); err != nil {
+		return nil, errors.Wrapf(invalidConfigError, "conf.APIURL is not valid URL: %s", err)
+	}
+	if conf.AccountSID == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.AccountSID must not be empty")
+	}
+	if conf.AuthToken == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.AuthToken must not be empty")
+	}
+	if conf.From == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.From must not be empty")
+	}
+	if conf.PublicURL != "" {
+		u, err := url.Parse(conf.PublicURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, errors.Wrap(invalidConfigError, "conf.PublicURL must be absolute http or https URL")
+		}
+	}
+	if conf.RingTimeout < time.Second*5 || conf.RingTimeout > time.Second*600 {
+		return nil, errors.Wrap(invalidConfigError, "conf.RingTimeout must be between 5s and 600s")
+	}
+	if conf.Retries < 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Retries must not be negative")
+	}
+	if conf.Retries > 0 && conf.RetryInterval <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.RetryInterval must be positive duration")
+	}
+	if conf.Repeat < 1 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Repeat must be positive number")
+	}
+	if conf.IncidentActionChan != nil && conf.SilenceDuration < time.Minute {
+		return nil, errors.Wrap(invalidConfigError, "conf.SilenceDuration must be at least 1m")
+	}
+	if conf.Timeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Timeout must be positive duration")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+	if conf.PublicURL == "" && conf.Retries > 0 {
+		conf.Logger.Log("phone call retries are disabled, they need status callbacks and public URL is not set")
+	}
+
+	newTwilio := &Twilio{
+		conf:    conf,
+		client:  &http.Client{Timeout: conf.Timeout},
+		speech:  speech{voice: conf.Voice, language: conf.Language},
+		calls:   map[string]*call{},
+		handled: map[string]time.Time{},
+	}
+	return newTwilio, nil
+}
+
+// Twilio places calls via Twilio compatible voice API and retries calls which were not answered
+type Twilio struct {
+	conf   TwilioConfig
+	client *http.Client
+	speech speech
+
+	sync.Mutex
+	// key is our call id sent in status callback URL, it stays the same for all attempts
+	calls map[string]*call
+	// incidents acknowledged or silenced by keypress until the time, calls about them are not retried
+	handled map[string]time.Time
+}
+
+type call struct {
+	id       string
+	number   string
+	message  string
+	incident Incident
+	attempt  int
+	sid      string
+	answered bool
+	placed   time.Time
+}
+
+// Call places the call, it returns once the provider accepted the call,
+// the outcome of the call is reported by status callbacks
+func (t *Twilio) Call(number string, message string, incident Incident) error {
+	c := &call{
+		id:       newCallID(),
+		number:   number,
+		message:  message,
+		incident: incident,
+	}
+	return t.place(c)
+}
+
+// keypress is asked for only when it can be applied to the incident
+func (t *Twilio) acknowledgeable(c *call) bool {
+	return t.conf.PublicURL != "" && t.conf.IncidentActionChan != nil && c.incident.IncidentID != ""
+}
+
+// place next attempt of the call, temporary API failures are retried like unanswered calls
+func (t *Twilio) place(c *call) error {
+	c.attempt++
+	c.sid = ""
+	c.answered = false
+	c.placed = time.Now()
+	// retry can run the next attempt concurrently, so c is not read after it is scheduled
+	attempt := c.attempt
+	t.track(c)
+
+	sid, err := t.createCall(c)
+	if err != nil {
+		t.forget(c.id)
+		if isTemporaryError(err) && t.retry(c, attempt) {
+			t.conf.Logger.LogError(err, "failed to call %s, attempt %d, retrying in %s", c.number, attempt, t.conf.RetryInterval)
+			return nil
+		}
+		return err
+	}
+
+	t.Lock()
+	c.sid = sid
+	t.Unlock()
+	t.conf.Logger.LogDebug("placed call %s to %s, attempt %d", sid, c.number, attempt)
+	return nil
+}
+
+// schedule next attempt, false when there are no attempts left
+func (t *Twilio) retry(c *call, attempt int) bool {
+	if t.conf.PublicURL == "" || attempt > t.conf.Retries {
+		return false
+	}
+	time.AfterFunc(t.conf.RetryInterval, func() {
+		if t.isHandled(c.incident) {
+			t.conf.Logger.LogDebug("not calling %s again, incident %s was already acknowledged or silenced", c.number, c.incident.IncidentID)
+			return
+		}
+		err := t.place(c)
+		if err != nil {
+			t.conf.Logger.LogError(err, "failed to call %s, attempt %d", c.number, attempt+1)
+		}
+	})
+	return true
+}
+
+func (t *Twilio) track(c *call) {
+	if t.conf.PublicURL == "" {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	for id, old := range t.calls {
+		if now.Sub(old.placed) > callTimeout {
+			delete(t.calls, id)
+		}
+	}
+	t.calls[c.id] = c
+}
+
+func (t *Twilio) isHandled(incident Incident) bool {
+	if incident.IncidentID == "" {
+		return false
+	}
+	t.Lock()
+	defer t.Unlock()
+	until, ok := t.handled[incident.IncidentID]
+	return ok && time.Now().Before(until)
+}
+
+func (t *Twilio) forget(id string) {
+	t.Lock()
+	delete(t.calls, id)
+	t.Unlock()
+}
+
+func (t *Twilio) createCall(c *call) (string, error) {
+	form := url.Values{}
+	form.Set("To", c.number)
+	form.Set("From", t.conf.From)
+	var gatherURL string
+	if t.acknowledgeable(c) {
+		gatherURL = t.callbackURL(GatherPath, c.id)
+	}
+	form.Set("Twiml", twiml(c.message, t.speech, t.conf.Repeat, gatherURL, t.conf.SilenceDuration))
+	form.Set("Timeout", strconv.Itoa(int(t.conf.RingTimeout/time.Second)))
+	if t.conf.PublicURL != "" {
+		form.Set("StatusCallback", t.callbackURL(StatusPath, c.id))
+		form.Set("StatusCallbackMethod", http.MethodPost)
+		for _, event := range []string{"initiated", "ringing", "answered", "completed"} {
+			form.Add("StatusCallbackEvent", event)
+		}
+	}
+
+	endpoint := strings.TrimRight(t.conf.APIURL, "/") + "/2010-04-01/Accounts/" + url.PathEscape(t.conf.AccountSID) + "/Calls.json"
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(t.conf.AccountSID, t.conf.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "request to Twilio API %s failed", t.conf.APIURL)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read response of Twilio API %s", t.conf.APIURL)
+	}
+
+	var r struct {
+		SID     string `json:"sid"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	// error pages of proxies in front of the API are not json, status code is enough then
+	_ = json.Unmarshal(body, &r)
+	if resp.StatusCode/100 == 2 && r.SID != "" {
+		return r.SID, nil
+	}
+	e := &APIError{StatusCode: resp.StatusCode, Message: r.Message}
+	if r.Code != 0 {
+		e.Code = strconv.Itoa(r.Code)
+	}
+	return "", e
+}
+
+func (t *Twilio) callbackURL(path string, id string) string {
+	return strings.TrimRight(t.conf.PublicURL, "/") + path + "?call=" + url.QueryEscape(id)
+}
+
+// StatusHandler receives status callbacks of placed calls, it must be registered on firefly HTTP listener at StatusPath
+func (t *Twilio) StatusHandler() http.Handler {
+	return t.callbackHandler(func(w http.ResponseWriter, r *http.Request) {
+		t.handleStatus(r.URL.Query().Get("call"), r.PostForm.Get("CallSid"), r.PostForm.Get("CallStatus"), r.PostForm.Get("CallDuration"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// GatherHandler receives keys pressed during calls, it must be registered on firefly HTTP listener at GatherPath
+func (t *Twilio) GatherHandler() http.Handler {
+	return t.callbackHandler(func(w http.ResponseWriter, r *http.Request) {
+		reply := t.handleKeypress(r.URL.Query().Get("call"), r.PostForm.Get("CallSid"), r.PostForm.Get("Digits"))
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(gatherReply(reply, t.speech, t.callbackURL(GatherPath, r.URL.Query().Get("call")), t.conf.SilenceDuration)))
+	})
+}
+
+// callback URLs are public, only requests signed by the provider are passed to the handler
+func (t *Twilio) callbackHandler(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxCallbackSize)
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !t.validSignature(r) {
+			t.conf.Logger.LogError(invalidSignatureError, "rejected call callback %s from %s", r.URL.Path, r.RemoteAddr)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// apply pressed key to the incident of the call, returns sentence read to the callee, empty for unknown key
+func (t *Twilio) handleKeypress(id string, sid string, digits string) string {
+	t.Lock()
+	c, ok := t.calls[id]
+	t.Unlock()
+	if !ok || !t.acknowledgeable(c) {
+		t.conf.Logger.LogDebug("ignoring key %s pressed during unknown call %s", digits, sid)
+		return "This alert can not be acknowledged anymore. Goodbye."
+	}
+
+	action := state.IncidentAction{
+		ServiceID:  c.incident.ServiceID,
+		IncidentID: c.incident.IncidentID,
+		By:         c.number,
+	}
+	var until time.Time
+	var reply string
+	switch digits {
+	case keyAcknowledge:
+		action.Acknowledge = true
+		// calls are forgotten after callTimeout, so there is nothing to retry later
+		until = time.Now().Add(callTimeout)
+		reply = "The incident is acknowledged. Goodbye."
+	case keySilence:
+		action.SilenceUntil = time.Now().Add(t.conf.SilenceDuration)
+		until = action.SilenceUntil
+		reply = fmt.Sprintf("Alerts are silenced for %s. Goodbye.", spokenDuration(t.conf.SilenceDuration))
+	default:
+		return ""
+	}
+
+	if !state.SendIncidentAction(t.conf.IncidentActionChan, action) {
+		t.conf.Logger.LogError(incidentActionDroppedError, "dropped key %s pressed by %s during call %s for incident %s", digits, c.number, sid, c.incident.IncidentID)
+		return "Your response could not be processed, please use another way to acknowledge the alert. Goodbye."
+	}
+	t.Lock()
+	if until.After(t.handled[c.incident.IncidentID]) {
+		t.handled[c.incident.IncidentID] = until
+	}
+	for incidentID, u := range t.handled {
+		if time.Now().After(u) {
+			delete(t.handled, incidentID)
+		}
+	}
+	t.Unlock()
+	t.conf.Logger.Log("key %s pressed by %s during call %s for incident %s", digits, c.number, sid, c.incident.IncidentID)
+	return reply
+}
+
+func (t *Twilio) handleStatus(id string, sid string, status string, duration string) {
+	t.Lock()
+	c, ok := t.calls[id]
+	// callback of previous attempt is not interesting anymore
+	if ok && c.sid != "" && c.sid != sid {
+		ok = false
+	}
+	if ok && status == callStatusInProgress {
+		c.answered = true
+	}
+	final := status == callStatusCompleted || status == callStatusBusy || status == callStatusNoAnswer || status == callStatusFailed || status == callStatusCanceled
+	var attempt int
+	var answered bool
+	if ok {
+		attempt, answered = c.attempt, c.answered
+		if final {
+			delete(t.calls, id)
+		}
+	}
+	t.Unlock()
+	if !ok {
+		t.conf.Logger.LogDebug("ignoring status %s of unknown call %s", status, sid)
+		return
+	}
+	if !final {
+		t.conf.Logger.LogDebug("call %s to %s is %s", sid, c.number, status)
+		return
+	}
+
+	if status == callStatusCompleted && (answered || duration != "" && duration != "0") {
+		t.conf.Logger.Log("call %s to %s was answered, attempt %d, duration %ss", sid, c.number, attempt, duration)
+		return
+	}
+	if status == callStatusCanceled {
+		t.conf.Logger.LogDebug("call %s to %s was canceled", sid, c.number)
+		return
+	}
+	if t.retry(c, attempt) {
+		t.conf.Logger.LogError(notAnsweredError, "call %s to %s ended as %s, attempt %d, calling again in %s", sid, c.number, status, attempt, t.conf.RetryInterval)
+		return
+	}
+	t.conf.Logger.LogError(notAnsweredError, "call %s to %s ended as %s, giving up after %d attempts", sid, c.number, status, attempt)
+}
+
+// X-Twilio-Signature is base64 HMAC-SHA1 of the full URL followed by sorted POST parameters and their values
+func (t *Twilio) validSignature(r *http.Request) bool {
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get("X-Twilio-Signature"))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+
+	data := strings.TrimRight(t.conf.PublicURL, "/") + r.URL.RequestURI()
+	keys := make([]string, 0, len(r.PostForm))
+	for k := range r.PostForm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range r.PostForm[k] {
+			data += k + v
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(t.conf.AuthToken))
+	mac.Write([]byte(data))
+	return subtle.ConstantTimeCompare(mac.Sum(nil), signature) == 1
+}
+
+func newCallID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		// still unique enough for calls in flight
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// network failures and throttling or server side errors of the provider can succeed later
+func isTemporaryError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *APIError:
+		return e.Temporary()
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// APIError is failure reported by voice API
+type APIError struct {
+	StatusCode int
+	// provider specific error code and message, empty when the provider did not send any
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("voice API returned HTTP %d", e.StatusCode)
+	if e.Code != "" {
+		msg += fmt.Sprintf(", error code %s", e.Code)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Temporary reports whether the request can succeed later, e.g. when the provider is throttling or down
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
@@ -0,0 +1,354 @@
+package phone
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+
+	"github.com/exmonitor/firefly/service/state"
+)
+
+const testPublicURL = "https://firefly.example.com"
+
+// fakeVoiceAPI accepts calls and answers with status codes in order, the last one is repeated
+type fakeVoiceAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	calls    []url.Values
+	statuses []int
+}
+
+func newFakeVoiceAPI(t *testing.T, statuses ...int) *fakeVoiceAPI {
+	api := &fakeVoiceAPI{statuses: statuses}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "AC1" || pass != "token" || r.URL.Path != "/2010-04-01/Accounts/AC1/Calls.json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		api.mu.Lock()
+		api.calls = append(api.calls, r.PostForm)
+		n := len(api.calls)
+		status := api.statuses[len(api.statuses)-1]
+		if n <= len(api.statuses) {
+			status = api.statuses[n-1]
+		}
+		api.mu.Unlock()
+		w.WriteHeader(status)
+		if status/100 == 2 {
+			w.Write([]byte(`{"sid": "CA` + strconv.Itoa(n) + `", "status": "queued"}`))
+		} else {
+			w.Write([]byte(`{"code": 20003, "message": "failure", "status": 500}`))
+		}
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (api *fakeVoiceAPI) placed() []url.Values {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]url.Values(nil), api.calls...)
+}
+
+func newTestTwilio(t *testing.T, api *fakeVoiceAPI, publicURL string, actions chan state.IncidentAction) *Twilio {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw, err := NewTwilio(TwilioConfig{
+		APIURL:             api.URL,
+		AccountSID:         "AC1",
+		AuthToken:          "token",
+		From:               "+15005550006",
+		PublicURL:          publicURL,
+		RingTimeout:        30 * time.Second,
+		Retries:            1,
+		RetryInterval:      10 * time.Millisecond,
+		Voice:              "alice",
+		Repeat:             2,
+		SilenceDuration:    time.Hour,
+		IncidentActionChan: actions,
+		Timeout:            time.Second,
+		Logger:             logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tw
+}
+
+// sign the callback like Twilio does
+func signCallback(authToken string, fullURL string, form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	data := fullURL
+	for _, k := range keys {
+		data += k + form.Get(k)
+	}
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// post signed callback to the handler, callbackURL is the URL sent to the voice API
+func postCallback(t *testing.T, handler http.Handler, callbackURL string, form url.Values, signature string) *httptest.ResponseRecorder {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, u.RequestURI(), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Twilio-Signature", signature)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func waitForCalls(t *testing.T, api *fakeVoiceAPI, n int) []url.Values {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		calls := api.placed()
+		if len(calls) >= n {
+			return calls
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d calls, got %d", n, len(calls))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTwilioPlacesCall(t *testing.T) {
+	api := newFakeVoiceAPI(t, http.StatusCreated)
+	tw := newTestTwilio(t, api, testPublicURL, make(chan state.IncidentAction, 1))
+
+	err := tw.Call("+420123456789", "Your HTTP check <web> has failed.", Incident{ServiceID: 12, IncidentID: "12-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := api.placed()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 call, got %d", len(calls))
+	}
+	form := calls[0]
+	if form.Get("To") != "+420123456789" || form.Get("From") != "+15005550006" || form.Get("Timeout") != "30" {
+		t.Fatalf("unexpected call parameters %v", form)
+	}
+	if !strings.HasPrefix(form.Get("StatusCallback"), testPublicURL+StatusPath+"?call=") {
+		t.Fatalf("unexpected status callback %s", form.Get("StatusCallback"))
+	}
+	if events := form["StatusCallbackEvent"]; len(events) != 4 {
+		t.Fatalf("unexpected status callback events %v", events)
+	}
+	twiml := form.Get("Twiml")
+	for _, want := range []string{
+		`<Gather input="dtmf" numDigits="1" timeout="5" method="POST" action="` + testPublicURL + GatherPath + `?call=`,
+		`<Say voice="alice">Your HTTP check &lt;web&gt; has failed.</Say>`,
+		`Press 1 to acknowledge. Press 2 to silence alerts for one hour.`,
+		`<Pause length="1"/>`,
+	} {
+		if !strings.Contains(twiml, want) {
+			t.Errorf("TwiML does not contain %s:\n%s", want, twiml)
+		}
+	}
+}
+
+func TestTwilioCallWithoutPublicURL(t *testing.T) {
+	api := newFakeVoiceAPI(t, http.StatusCreated)
+	tw := newTestTwilio(t, api, "", make(chan state.IncidentAction, 1))
+
+	if err := tw.Call("+420123456789", "down", Incident{ServiceID: 12, IncidentID: "12-1"}); err != nil {
+		t.Fatal(err)
+	}
+	form := api.placed()[0]
+	if form.Get("StatusCallback") != "" || strings.Contains(form.Get("Twiml"), "<Gather") {
+		t.Fatalf("callbacks need public URL, got %v", form)
+	}
+}
+
+func TestTwilioRetriesTemporaryAPIFailure(t *testing.T) {
+	api := newFakeVoiceAPI(t, http.StatusServiceUnavailable, http.StatusCreated)
+	tw := newTestTwilio(t, api, testPublicURL, nil)
+
+	if err := tw.Call("+420123456789", "down", Incident{}); err != nil {
+		t.Fatalf("temporary failure must be retried in background, got %v", err)
+	}
+	waitForCalls(t, api, 2)
+}
+
+func TestTwilioStatusCallbacks(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		duration string
+		calls    int
+	}{
+		{name: "answered", statuses: []string{"ringing", "in-progress", "completed"}, duration: "14", calls: 1},
+		{name: "no answer is retried", statuses: []string{"ringing", "no-answer"}, calls: 2},
+		{name: "busy is retried", statuses: []string{"busy"}, calls: 2},
+		{name: "canceled is not retried", statuses: []string{"canceled"}, calls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeVoiceAPI(t, http.StatusCreated)
+			tw := newTestTwilio(t, api, testPublicURL, nil)
+			if err := tw.Call("+420123456789", "down", Incident{}); err != nil {
+				t.Fatal(err)
+			}
+			callback := api.placed()[0].Get("StatusCallback")
+			for _, status := range tt.statuses {
+				form := url.Values{"CallSid": {"CA1"}, "CallStatus": {status}, "CallDuration": {tt.duration}}
+				rec := postCallback(t, tw.StatusHandler(), callback, form, signCallback("token", callback, form))
+				if rec.Code != http.StatusNoContent {
+					t.Fatalf("expected 204 for status %s, got %d", status, rec.Code)
+				}
+			}
+
+			waitForCalls(t, api, tt.calls)
+			time.Sleep(50 * time.Millisecond)
+			if n := len(api.placed()); n != tt.calls {
+				t.Fatalf("expected %d calls, got %d", tt.calls, n)
+			}
+			// second attempt is the last one
+			if tt.calls == 2 {
+				form := url.Values{"CallSid": {"CA2"}, "CallStatus": {"no-answer"}}
+				postCallback(t, tw.StatusHandler(), callback, form, signCallback("token", callback, form))
+				time.Sleep(50 * time.Millisecond)
+				if n := len(api.placed()); n != 2 {
+					t.Fatalf("expected retries to stop after 2 calls, got %d", n)
+				}
+			}
+		})
+	}
+}
+
+func TestTwilioKeypress(t *testing.T) {
+	api := newFakeVoiceAPI(t, http.StatusCreated)
+	actions := make(chan state.IncidentAction, 2)
+	tw := newTestTwilio(t, api, testPublicURL, actions)
+	if err := tw.Call("+420123456789", "down", Incident{ServiceID: 12, IncidentID: "12-1"}); err != nil {
+		t.Fatal(err)
+	}
+	statusCallback := api.placed()[0].Get("StatusCallback")
+	gatherURL := testPublicURL + GatherPath + strings.TrimPrefix(statusCallback, testPublicURL+StatusPath)
+
+	form := url.Values{"CallSid": {"CA1"}, "Digits": {"9"}}
+	rec := postCallback(t, tw.GatherHandler(), gatherURL, form, signCallback("token", gatherURL, form))
+	body, _ := ioutil.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "Unknown key.") || !strings.Contains(string(body), "<Gather") {
+		t.Fatalf("unknown key must repeat the prompt, got %s", body)
+	}
+
+	form = url.Values{"CallSid": {"CA1"}, "Digits": {"1"}}
+	rec = postCallback(t, tw.GatherHandler(), gatherURL, form, signCallback("token", gatherURL, form))
+	body, _ = ioutil.ReadAll(rec.Body)
+	if rec.Header().Get("Content-Type") != "text/xml" || !strings.Contains(string(body), "The incident is acknowledged.") {
+		t.Fatalf("unexpected reply %s", body)
+	}
+	select {
+	case action := <-actions:
+		if !action.Acknowledge || action.ServiceID != 12 || action.IncidentID != "12-1" || action.By != "+420123456789" {
+			t.Fatalf("unexpected incident action %+v", action)
+		}
+	default:
+		t.Fatal("expected incident action")
+	}
+
+	// acknowledged incident is not called again
+	form = url.Values{"CallSid": {"CA1"}, "CallStatus": {"no-answer"}}
+	postCallback(t, tw.StatusHandler(), statusCallback, form, signCallback("token", statusCallback, form))
+	time.Sleep(50 * time.Millisecond)
+	if n := len(api.placed()); n != 1 {
+		t.Fatalf("acknowledged incident must not be called again, got %d calls", n)
+	}
+}
+
+func TestTwilioSignature(t *testing.T) {
+	api := newFakeVoiceAPI(t, http.StatusCreated)
+	tw := newTestTwilio(t, api, "https://mycompany.com", nil)
+	tw.conf.AuthToken = "12345"
+
+	// example from Twilio security documentation
+	form := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+12349013030"},
+		"Digits":  {"1234"},
+		"From":    {"+12349013030"},
+		"To":      {"+18005551212"},
+	}
+	tests := []struct {
+		name      string
+		path      string
+		form      url.Values
+		signature string
+		code      int
+	}{
+		{name: "valid", path: "/myapp.php?foo=1&bar=2", form: form, signature: "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", code: http.StatusNoContent},
+		{name: "changed parameter", path: "/myapp.php?foo=1&bar=2", form: url.Values{"CallSid": {"CA1234567890ABCDE"}, "Digits": {"1"}}, signature: "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", code: http.StatusForbidden},
+		{name: "changed URL", path: "/myapp.php?foo=1&bar=3", form: form, signature: "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", code: http.StatusForbidden},
+		{name: "missing signature", path: "/myapp.php?foo=1&bar=2", form: form, signature: "", code: http.StatusForbidden},
+		{name: "malformed signature", path: "/myapp.php?foo=1&bar=2", form: form, signature: "not base64!", code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postCallback(t, tw.StatusHandler(), "https://mycompany.com"+tt.path, tt.form, tt.signature)
+			if rec.Code != tt.code {
+				t.Fatalf("expected HTTP %d, got %d", tt.code, rec.Code)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/myapp.php", nil)
+	rec := httptest.NewRecorder()
+	tw.StatusHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected HTTP 405 for GET, got %d", rec.Code)
+	}
+}
+
+func TestTwilioKeypressDoesNotBlockOnFullChannel(t *testing.T) {
+	api := newFakeVoiceAPI(t, http.StatusCreated)
+	// nobody reads the actions
+	tw := newTestTwilio(t, api, testPublicURL, make(chan state.IncidentAction))
+	if err := tw.Call("+420123456789", "down", Incident{ServiceID: 12, IncidentID: "12-1"}); err != nil {
+		t.Fatal(err)
+	}
+	statusCallback := api.placed()[0].Get("StatusCallback")
+	gatherURL := testPublicURL + GatherPath + strings.TrimPrefix(statusCallback, testPublicURL+StatusPath)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		form := url.Values{"CallSid": {"CA1"}, "Digits": {"1"}}
+		done <- postCallback(t, tw.GatherHandler(), gatherURL, form, signCallback("token", gatherURL, form))
+	}()
+	select {
+	case rec := <-done:
+		body, _ := ioutil.ReadAll(rec.Body)
+		if !strings.Contains(string(body), "could not be processed") {
+			t.Fatalf("unexpected reply %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("keypress handler blocked on incident action channel")
+	}
+	// dropped acknowledgement does not stop retries
+	if tw.isHandled(Incident{ServiceID: 12, IncidentID: "12-1"}) {
+		t.Fatal("incident must not be marked as handled")
+	}
+}
@@ -0,0 +1,111 @@
+package phone
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+const (
+	keyAcknowledge = "1"
+	keySilence     = "2"
+	// seconds to wait for keypress after the prompt
+	gatherTimeout = 5
+)
+
+// text to speech settings, provider defaults are used for empty values
+type speech struct {
+	voice    string
+	language string
+}
+
+// twiml returns voice markup which reads the message repeatedly with short pauses,
+// so the message is understood even when the callee picks up in the middle of it
+// when gatherURL is set, the callee is asked to acknowledge or silence the alert by keypress
+func twiml(message string, s speech, repeat int, gatherURL string, silence time.Duration) string {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString("<Response>")
+	if gatherURL != "" {
+		writeGatherStart(&b, gatherURL)
+	}
+	for i := 0; i < repeat; i++ {
+		if i > 0 {
+			b.WriteString(`<Pause length="1"/>`)
+		}
+		s.say(&b, message)
+		if gatherURL != "" {
+			s.say(&b, prompt(silence))
+		}
+	}
+	if gatherURL != "" {
+		b.WriteString("</Gather>")
+		s.say(&b, "No key was pressed. Goodbye.")
+	}
+	b.WriteString("</Response>")
+	return b.String()
+}
+
+// response to keypress, unknown key repeats the prompt
+func gatherReply(reply string, s speech, gatherURL string, silence time.Duration) string {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString("<Response>")
+	if reply == "" {
+		writeGatherStart(&b, gatherURL)
+		s.say(&b, "Unknown key. "+prompt(silence))
+		b.WriteString("</Gather>")
+		s.say(&b, "No key was pressed. Goodbye.")
+	} else {
+		s.say(&b, reply)
+	}
+	b.WriteString("</Response>")
+	return b.String()
+}
+
+func prompt(silence time.Duration) string {
+	return fmt.Sprintf("Press %s to acknowledge. Press %s to silence alerts for %s.", keyAcknowledge, keySilence, spokenDuration(silence))
+}
+
+// e.g. 'one hour', '30 minutes'
+func spokenDuration(d time.Duration) string {
+	switch {
+	case d == time.Hour:
+		return "one hour"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	case d == time.Minute:
+		return "one minute"
+	}
+	return fmt.Sprintf("%d minutes", d/time.Minute)
+}
+
+func writeGatherStart(b *bytes.Buffer, gatherURL string) {
+	b.WriteString("<Gather")
+	writeAttr(b, "input", "dtmf")
+	writeAttr(b, "numDigits", "1")
+	writeAttr(b, "timeout", fmt.Sprint(gatherTimeout))
+	writeAttr(b, "method", "POST")
+	writeAttr(b, "action", gatherURL)
+	b.WriteString(">")
+}
+
+func (s speech) say(b *bytes.Buffer, text string) {
+	b.WriteString("<Say")
+	if s.voice != "" {
+		writeAttr(b, "voice", s.voice)
+	}
+	if s.language != "" {
+		writeAttr(b, "language", s.language)
+	}
+	b.WriteString(">")
+	xml.EscapeText(b, []byte(text))
+	b.WriteString("</Say>")
+}
+
+func writeAttr(b *bytes.Buffer, name string, value string) {
+	b.WriteString(" " + name + `="`)
+	xml.EscapeText(b, []byte(value))
+	b.WriteString(`"`)
+}
@@ -0,0 +1,114 @@
+package phonenumber
+
+// numbering plan of single country
+type country struct {
+	callingCode string
+	// prefix dialed before national number inside the country, e.g. 0 in Germany
+	trunkPrefix string
+	// allowed length of national significant number, without calling code and trunk prefix
+	minLength int
+	maxLength int
+}
+
+// numbering plans of countries where our customers are, numbers with other calling codes
+// are accepted in international format and only checked against the E.164 length limit
+var countries = map[string]country{
+	// north america shares +1, country is detected by area code
+	"US": {callingCode: "1", trunkPrefix: "1", minLength: 10, maxLength: 10},
+	"CA": {callingCode: "1", trunkPrefix: "1", minLength: 10, maxLength: 10},
+
+	// europe
+	"AT": {callingCode: "43", trunkPrefix: "0", minLength: 4, maxLength: 13},
+	"BE": {callingCode: "32", trunkPrefix: "0", minLength: 8, maxLength: 9},
+	"BG": {callingCode: "359", trunkPrefix: "0", minLength: 8, maxLength: 9},
+	"BY": {callingCode: "375", trunkPrefix: "8", minLength: 9, maxLength: 9},
+	"CH": {callingCode: "41", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	// czech numbers have no trunk prefix and never start with 0, leading 0 written out of habit is dropped
+	"CZ": {callingCode: "420", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"DE": {callingCode: "49", trunkPrefix: "0", minLength: 6, maxLength: 13},
+	"DK": {callingCode: "45", minLength: 8, maxLength: 8},
+	"EE": {callingCode: "372", minLength: 7, maxLength: 8},
+	"ES": {callingCode: "34", minLength: 9, maxLength: 9},
+	"FI": {callingCode: "358", trunkPrefix: "0", minLength: 5, maxLength: 12},
+	"FR": {callingCode: "33", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"GB": {callingCode: "44", trunkPrefix: "0", minLength: 9, maxLength: 10},
+	"GR": {callingCode: "30", minLength: 10, maxLength: 10},
+	"HR": {callingCode: "385", trunkPrefix: "0", minLength: 8, maxLength: 9},
+	"HU": {callingCode: "36", trunkPrefix: "06", minLength: 8, maxLength: 9},
+	"IE": {callingCode: "353", trunkPrefix: "0", minLength: 7, maxLength: 9},
+	"IS": {callingCode: "354", minLength: 7, maxLength: 7},
+	// italian landline numbers start with 0, so there is no trunk prefix to strip
+	"IT": {callingCode: "39", minLength: 6, maxLength: 11},
+	"LT": {callingCode: "370", trunkPrefix: "8", minLength: 8, maxLength: 8},
+	"LU": {callingCode: "352", minLength: 4, maxLength: 11},
+	"LV": {callingCode: "371", minLength: 8, maxLength: 8},
+	"NL": {callingCode: "31", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"NO": {callingCode: "47", minLength: 8, maxLength: 8},
+	"PL": {callingCode: "48", minLength: 9, maxLength: 9},
+	"PT": {callingCode: "351", minLength: 9, maxLength: 9},
+	"RO": {callingCode: "40", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"SE": {callingCode: "46", trunkPrefix: "0", minLength: 7, maxLength: 10},
+	"SI": {callingCode: "386", trunkPrefix: "0", minLength: 8, maxLength: 8},
+	"SK": {callingCode: "421", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"UA": {callingCode: "380", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	// russia and kazakhstan share +7, country is detected by the first digit
+	"RU": {callingCode: "7", trunkPrefix: "8", minLength: 10, maxLength: 10},
+	"KZ": {callingCode: "7", trunkPrefix: "8", minLength: 10, maxLength: 10},
+	"TR": {callingCode: "90", trunkPrefix: "0", minLength: 10, maxLength: 10},
+
+	// middle east and asia
+	"AE": {callingCode: "971", trunkPrefix: "0", minLength: 8, maxLength: 9},
+	"CN": {callingCode: "86", trunkPrefix: "0", minLength: 9, maxLength: 11},
+	"HK": {callingCode: "852", minLength: 8, maxLength: 8},
+	"ID": {callingCode: "62", trunkPrefix: "0", minLength: 8, maxLength: 12},
+	"IL": {callingCode: "972", trunkPrefix: "0", minLength: 8, maxLength: 9},
+	"IN": {callingCode: "91", trunkPrefix: "0", minLength: 10, maxLength: 10},
+	"JP": {callingCode: "81", trunkPrefix: "0", minLength: 9, maxLength: 10},
+	"KR": {callingCode: "82", trunkPrefix: "0", minLength: 8, maxLength: 10},
+	"MY": {callingCode: "60", trunkPrefix: "0", minLength: 8, maxLength: 10},
+	"PH": {callingCode: "63", trunkPrefix: "0", minLength: 8, maxLength: 10},
+	"SA": {callingCode: "966", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"SG": {callingCode: "65", minLength: 8, maxLength: 8},
+	"TH": {callingCode: "66", trunkPrefix: "0", minLength: 8, maxLength: 9},
+	"TW": {callingCode: "886", trunkPrefix: "0", minLength: 8, maxLength: 9},
+	"VN": {callingCode: "84", trunkPrefix: "0", minLength: 9, maxLength: 10},
+
+	// oceania
+	"AU": {callingCode: "61", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"NZ": {callingCode: "64", trunkPrefix: "0", minLength: 8, maxLength: 10},
+
+	// latin america
+	"AR": {callingCode: "54", trunkPrefix: "0", minLength: 10, maxLength: 10},
+	"BR": {callingCode: "55", trunkPrefix: "0", minLength: 10, maxLength: 11},
+	"CL": {callingCode: "56", minLength: 9, maxLength: 9},
+	"CO": {callingCode: "57", minLength: 10, maxLength: 10},
+	"MX": {callingCode: "52", minLength: 10, maxLength: 10},
+	"PE": {callingCode: "51", trunkPrefix: "0", minLength: 8, maxLength: 9},
+
+	// africa
+	"EG": {callingCode: "20", trunkPrefix: "0", minLength: 9, maxLength: 10},
+	"KE": {callingCode: "254", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"MA": {callingCode: "212", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"NG": {callingCode: "234", trunkPrefix: "0", minLength: 8, maxLength: 10},
+	"ZA": {callingCode: "27", trunkPrefix: "0", minLength: 9, maxLength: 9},
+}
+
+// canadian area codes, all other +1 numbers are routed as US
+var canadianAreaCodes = map[string]bool{
+	"204": true, "226": true, "236": true, "249": true, "250": true, "263": true, "289": true, "306": true,
+	"343": true, "354": true, "365": true, "367": true, "368": true, "382": true, "403": true, "416": true,
+	"418": true, "428": true, "431": true, "437": true, "438": true, "450": true, "468": true, "474": true,
+	"506": true, "514": true, "519": true, "548": true, "579": true, "581": true, "584": true, "587": true,
+	"604": true, "613": true, "639": true, "647": true, "672": true, "683": true, "705": true, "709": true,
+	"742": true, "753": true, "778": true, "780": true, "782": true, "807": true, "819": true, "825": true,
+	"867": true, "873": true, "879": true, "902": true, "905": true,
+}
+
+// key is calling code, value are countries using it
+var callingCodes = func() map[string][]string {
+	codes := map[string][]string{}
+	for iso, c := range countries {
+		codes[c.callingCode] = append(codes[c.callingCode], iso)
+	}
+	return codes
+}()
@@ -0,0 +1,96 @@
+package phonenumber
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCountries selects country of national numbers, e.g. '0601 234 567', for the account owning the contact
+type DefaultCountries struct {
+	defaultCountry string
+	// default country of all contacts of the service, key is service ID
+	services map[int]string
+	// key is notification id or contact target
+	contacts map[string]string
+}
+
+// file format of default countries, values are ISO 3166 country codes
+type file struct {
+	Default  string            `json:"default"`
+	Services map[string]string `json:"services"`
+	Contacts map[string]string `json:"contacts"`
+}
+
+// LoadDefaultCountries reads default countries of accounts from json file, defaultCountry is used for the rest
+// empty path means defaultCountry is used for all contacts
+func LoadDefaultCountries(path string, defaultCountry string) (*DefaultCountries, error) {
+	d := &DefaultCountries{
+		services: map[int]string{},
+		contacts: map[string]string{},
+	}
+	if defaultCountry != "" {
+		if !ValidCountry(defaultCountry) {
+			return nil, errors.Wrapf(unknownCountryError, "default country %q is not supported", defaultCountry)
+		}
+		d.defaultCountry = strings.ToUpper(defaultCountry)
+	}
+	if path == "" {
+		return d, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read country file %s", path)
+	}
+	var f file
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse country file %s", path)
+	}
+
+	if f.Default != "" {
+		if !ValidCountry(f.Default) {
+			return nil, errors.Wrapf(unknownCountryError, "default country %q is not supported", f.Default)
+		}
+		d.defaultCountry = strings.ToUpper(f.Default)
+	}
+	for service, iso := range f.Services {
+		serviceID, err := strconv.Atoi(service)
+		if err != nil {
+			return nil, errors.Wrapf(invalidConfigError, "service %q must be service ID", service)
+		}
+		if !ValidCountry(iso) {
+			return nil, errors.Wrapf(unknownCountryError, "service %d has unsupported country %q", serviceID, iso)
+		}
+		d.services[serviceID] = strings.ToUpper(iso)
+	}
+	for contact, iso := range f.Contacts {
+		if !ValidCountry(iso) {
+			return nil, errors.Wrapf(unknownCountryError, "contact %s has unsupported country %q", contact, iso)
+		}
+		d.contacts[contact] = strings.ToUpper(iso)
+	}
+	return d, nil
+}
+
+// Select returns default country for the contact, contact setting wins over service setting
+// nil DefaultCountries has no default country, so only international numbers are accepted
+func (d *DefaultCountries) Select(serviceID int, notificationID int, target string) string {
+	if d == nil {
+		return ""
+	}
+	if iso, ok := d.contacts[strconv.Itoa(notificationID)]; ok {
+		return iso
+	}
+	if iso, ok := d.contacts[target]; ok {
+		return iso
+	}
+	if iso, ok := d.services[serviceID]; ok {
+		return iso
+	}
+	return d.defaultCountry
+}
@@ -0,0 +1,89 @@
+package phonenumber
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func writeCountryFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "countries.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultCountriesSelect(t *testing.T) {
+	path := writeCountryFile(t, `{
+		"default": "cz",
+		"services": {"7": "DE"},
+		"contacts": {"12": "AT", "+421601234567": "SK"}
+	}`)
+	d, err := LoadDefaultCountries(path, "US")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		serviceID      int
+		notificationID int
+		target         string
+		want           string
+	}{
+		{name: "notification id wins over service", serviceID: 7, notificationID: 12, target: "0664 123 4567", want: "AT"},
+		{name: "contact target wins over service", serviceID: 7, notificationID: 13, target: "+421601234567", want: "SK"},
+		{name: "service", serviceID: 7, notificationID: 13, target: "030 1234567", want: "DE"},
+		{name: "file default wins over flag", serviceID: 8, notificationID: 13, target: "601 234 567", want: "CZ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.Select(tt.serviceID, tt.notificationID, tt.target); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDefaultCountriesWithoutFile(t *testing.T) {
+	d, err := LoadDefaultCountries("", "sk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Select(1, 2, "0601 234 567"); got != "SK" {
+		t.Fatalf("expected SK, got %q", got)
+	}
+
+	var none *DefaultCountries
+	if got := none.Select(1, 2, "0601 234 567"); got != "" {
+		t.Fatalf("expected no country for nil DefaultCountries, got %q", got)
+	}
+}
+
+func TestLoadDefaultCountriesRejectsInvalidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr error
+	}{
+		{name: "unknown default", content: `{"default": "XX"}`, wantErr: unknownCountryError},
+		{name: "service is not ID", content: `{"services": {"web": "SK"}}`, wantErr: invalidConfigError},
+		{name: "unknown service country", content: `{"services": {"7": "XX"}}`, wantErr: unknownCountryError},
+		{name: "unknown contact country", content: `{"contacts": {"12": "XX"}}`, wantErr: unknownCountryError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadDefaultCountries(writeCountryFile(t, tt.content), "")
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := LoadDefaultCountries("", "XX"); errors.Cause(err) != unknownCountryError {
+		t.Fatalf("expected unknown country for default flag, got %v", err)
+	}
+}
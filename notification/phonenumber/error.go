@@ -0,0 +1,9 @@
+package phonenumber
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var invalidNumberError error = errors.New("invalid phone number")
+
+var unknownCountryError error = errors.New("unknown country")
@@ -0,0 +1,140 @@
+package phonenumber
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// E.164 number has at most 15 digits including calling code
+	maxE164Length = 15
+	// shortest international numbers, e.g. small island countries
+	minE164Length = 7
+)
+
+// Normalize converts phone number to E.164 format, e.g. '0601 234 567' with default country SK is '+421601234567'
+// numbers without international prefix are read as national numbers of the default country
+func Normalize(number string, defaultCountry string) (string, error) {
+	digits, international, err := clean(number)
+	if err != nil {
+		return "", err
+	}
+	defaultCountry = strings.ToUpper(defaultCountry)
+
+	if !international {
+		switch {
+		case strings.HasPrefix(digits, "00"):
+			digits, international = digits[2:], true
+		case strings.HasPrefix(digits, "011") && (defaultCountry == "US" || defaultCountry == "CA"):
+			digits, international = digits[3:], true
+		}
+	}
+	if international {
+		return normalizeInternational(number, digits)
+	}
+
+	if defaultCountry == "" {
+		return "", errors.Wrapf(invalidNumberError, "%q is not in international format and no default country is set", number)
+	}
+	c, ok := countries[defaultCountry]
+	if !ok {
+		return "", errors.Wrapf(unknownCountryError, "default country %q of %q is not supported", defaultCountry, number)
+	}
+	// trunk prefix is stripped only when the rest is valid number, so numbers starting with the same digit are kept
+	if c.trunkPrefix != "" && strings.HasPrefix(digits, c.trunkPrefix) && validLength(c, len(digits)-len(c.trunkPrefix)) {
+		digits = digits[len(c.trunkPrefix):]
+	}
+	if !validLength(c, len(digits)) {
+		return "", lengthError(number, defaultCountry, c, len(digits))
+	}
+	return "+" + c.callingCode + digits, nil
+}
+
+func normalizeInternational(number string, digits string) (string, error) {
+	if len(digits) < minE164Length || len(digits) > maxE164Length {
+		return "", errors.Wrapf(invalidNumberError, "%q has %d digits, international numbers have %d to %d digits", number, len(digits), minE164Length, maxE164Length)
+	}
+	if digits[0] == '0' {
+		return "", errors.Wrapf(invalidNumberError, "%q has calling code starting with 0", number)
+	}
+	iso := Country("+" + digits)
+	if iso == "" {
+		// numbering plan is not known, E.164 length was already checked
+		return "+" + digits, nil
+	}
+	c := countries[iso]
+	national := digits[len(c.callingCode):]
+	if !validLength(c, len(national)) {
+		return "", lengthError(number, iso, c, len(national))
+	}
+	return "+" + digits, nil
+}
+
+// Country returns ISO 3166 code of the country of E.164 number, empty for unknown countries
+func Country(e164 string) string {
+	digits := strings.TrimPrefix(e164, "+")
+	// calling codes are prefix free and at most 3 digits long
+	for i := 1; i <= 3 && i <= len(digits); i++ {
+		isos, ok := callingCodes[digits[:i]]
+		if !ok {
+			continue
+		}
+		if len(isos) == 1 {
+			return isos[0]
+		}
+		national := digits[i:]
+		switch digits[:i] {
+		case "1":
+			if len(national) >= 3 && canadianAreaCodes[national[:3]] {
+				return "CA"
+			}
+			return "US"
+		case "7":
+			if strings.HasPrefix(national, "6") || strings.HasPrefix(national, "7") {
+				return "KZ"
+			}
+			return "RU"
+		}
+	}
+	return ""
+}
+
+// ValidCountry reports whether the country has known numbering plan and can be used as default country
+func ValidCountry(iso string) bool {
+	_, ok := countries[strings.ToUpper(iso)]
+	return ok
+}
+
+// strip separators people use when writing numbers, anything else makes the number invalid
+func clean(number string) (string, bool, error) {
+	s := strings.TrimSpace(number)
+	international := strings.HasPrefix(s, "+")
+	s = strings.TrimPrefix(s, "+")
+
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')' || r == '/':
+		default:
+			return "", false, errors.Wrapf(invalidNumberError, "%q contains invalid character %q", number, r)
+		}
+	}
+	if digits.Len() == 0 {
+		return "", false, errors.Wrapf(invalidNumberError, "%q contains no digits", number)
+	}
+	return digits.String(), international, nil
+}
+
+func validLength(c country, length int) bool {
+	return length >= c.minLength && length <= c.maxLength
+}
+
+func lengthError(number string, iso string, c country, length int) error {
+	if c.minLength == c.maxLength {
+		return errors.Wrapf(invalidNumberError, "%q has %d digits, %s numbers have %d digits after +%s", number, length, iso, c.minLength, c.callingCode)
+	}
+	return errors.Wrapf(invalidNumberError, "%q has %d digits, %s numbers have %d to %d digits after +%s", number, length, iso, c.minLength, c.maxLength, c.callingCode)
+}
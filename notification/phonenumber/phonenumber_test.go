@@ -0,0 +1,63 @@
+package phonenumber
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name           string
+		number         string
+		defaultCountry string
+		want           string
+		wantErr        error
+	}{
+		{name: "national with trunk prefix", number: "0601 234 567", defaultCountry: "SK", want: "+421601234567"},
+		{name: "lower case default country", number: "0601 234 567", defaultCountry: "sk", want: "+421601234567"},
+		{name: "international", number: "+421 601 234 567", want: "+421601234567"},
+		{name: "international with 00 prefix", number: "00421-601-234-567", want: "+421601234567"},
+		{name: "us 011 prefix", number: "011 44 20 7946 0958", defaultCountry: "US", want: "+442079460958"},
+		{name: "us national with separators", number: "(212) 555-0123", defaultCountry: "US", want: "+12125550123"},
+		{name: "canada detected by area code", number: "+1 416 555 0123", want: "+14165550123"},
+		{name: "unknown numbering plan", number: "+999 1234 5678", want: "+99912345678"},
+		{name: "national without default country", number: "0601 234 567", wantErr: invalidNumberError},
+		{name: "unsupported default country", number: "0601 234 567", defaultCountry: "XX", wantErr: unknownCountryError},
+		{name: "invalid character", number: "+421 601 234 56a", wantErr: invalidNumberError},
+		{name: "no digits", number: " - ", wantErr: invalidNumberError},
+		{name: "too short national", number: "0601 234", defaultCountry: "SK", wantErr: invalidNumberError},
+		{name: "too long international", number: "+421 601 234 567 890 12", wantErr: invalidNumberError},
+		{name: "calling code starting with 0", number: "+0421601234567", wantErr: invalidNumberError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Normalize(tt.number, tt.defaultCountry)
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCountry(t *testing.T) {
+	tests := []struct {
+		e164 string
+		want string
+	}{
+		{e164: "+421601234567", want: "SK"},
+		{e164: "+12125550123", want: "US"},
+		{e164: "+14165550123", want: "CA"},
+		{e164: "+74951234567", want: "RU"},
+		{e164: "+77011234567", want: "KZ"},
+		{e164: "+99912345678", want: ""},
+	}
+	for _, tt := range tests {
+		if got := Country(tt.e164); got != tt.want {
+			t.Errorf("Country(%q): expected %q, got %q", tt.e164, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+// attachment colours, Block Kit itself has no colours, so blocks are wrapped in coloured attachment
+const (
+	colorFailed   = "#E01E5A"
+	colorResolved = "#2EB67D"
+)
+
+type message struct {
+	Channel     string       `json:"channel,omitempty"`
+	TS          string       `json:"ts,omitempty"`
+	ThreadTS    string       `json:"thread_ts,omitempty"`
+	Text        string       `json:"text"`
+	Attachments []attachment `json:"attachments,omitempty"`
+}
+
+type attachment struct {
+	Color  string  `json:"color"`
+	Blocks []block `json:"blocks"`
+}
+
+type block struct {
+	Type     string `json:"type"`
+	Text     *text  `json:"text,omitempty"`
+	Fields   []text `json:"fields,omitempty"`
+	Elements []text `json:"elements,omitempty"`
+}
+
+type text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func markdown(s string) text {
+	return text{Type: "mrkdwn", Text: s}
+}
+
+// Slack markup uses only &, < and > as control characters
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// build message with check details, fallback text is shown in notifications of Slack clients
+func alertMessage(p *payload.Payload, incidentID string, incidentStart time.Time) message {
+	color := colorResolved
+	headline := fmt.Sprintf("*%s* %s check of *%s* has recovered", p.Status, escape(p.ServiceType), escape(p.Host))
+	if p.Failed {
+		color = colorFailed
+		headline = fmt.Sprintf("*%s* %s check of *%s* has failed", p.Status, escape(p.ServiceType), escape(p.Host))
+	}
+
+	fields := []text{markdown("*Target*\n" + escape(p.Target))}
+	for _, f := range p.Fields() {
+		fields = append(fields, markdown("*"+escape(f.Name)+"*\n"+escape(f.Value)))
+	}
+	// section block allows at most 10 fields
+	if len(fields) > 10 {
+		fields = fields[:10]
+	}
+	blocks := []block{
+		{Type: "section", Text: &text{Type: "mrkdwn", Text: headline}},
+		{Type: "section", Fields: fields},
+	}
+	if p.Failed && p.FailMessage != "" {
+		blocks = append(blocks, block{Type: "section", Text: &text{Type: "mrkdwn", Text: "```" + escape(p.FailMessage) + "```"}})
+	}
+	context := []text{markdown("Incident " + escape(incidentID))}
+	if !incidentStart.IsZero() {
+		context = append(context, markdown("Started <!date^"+fmt.Sprint(incidentStart.Unix())+"^{date_short_pretty} {time}|"+incidentStart.UTC().Format(time.RFC1123)+">"))
+	}
+	blocks = append(blocks, block{Type: "context", Elements: context})
+
+	return message{
+		Text:        p.Summary(),
+		Attachments: []attachment{{Color: color, Blocks: blocks}},
+	}
+}
+
+// short reply in the thread of the first message
+func threadReply(p *payload.Payload, incidentStart time.Time, resend int) message {
+	var s string
+	switch {
+	case p.Failed:
+		s = fmt.Sprintf(":red_circle: Still failing, reminder %d", resend)
+		if p.FailMessage != "" {
+			s += ": " + escape(p.FailMessage)
+		}
+	case !incidentStart.IsZero():
+		s = fmt.Sprintf(":large_green_circle: Resolved after %s", time.Since(incidentStart).Round(time.Second))
+	default:
+		s = ":large_green_circle: Resolved"
+	}
+	return message{Text: s}
+}
@@ -0,0 +1,9 @@
+package slack
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var invalidTargetError error = errors.New("invalid Slack target")
+
+var missingTokenError error = errors.New("Slack token is not configured")
@@ -0,0 +1,265 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/internal/retry"
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+const (
+	DefaultAPIURL = "https://slack.com/api"
+
+	maxResponseSize = 64 * 1024
+)
+
+type Config struct {
+	// base URL of Web API, DefaultAPIURL when empty
+	APIURL string
+	// bot token used for chat.postMessage, contacts with incoming webhook URL do not need it
+	Token string
+	// json file with posted messages, they are kept only in memory when empty
+	StorePath string
+	// timeout of single request
+	Timeout time.Duration
+	// how many times is temporary failure retried
+	Retries int
+
+	Logger *exlogger.Logger
+}
+
+// Notification is single notification of Slack contact
+type Notification struct {
+	NotificationID int
+	IncidentID     string
+	IncidentStart  time.Time
+	// number of resend of FAIL notification, 0 for the first one
+	Resend  int
+	Payload *payload.Payload
+}
+
+// Client posts notifications to Slack channels
+//
+// contact target is either channel, e.g. C0123456789 or #alerts, which is posted via chat.postMessage,
+// or incoming webhook URL. Messages posted via chat.postMessage are updated on recovery,
+// incoming webhooks can not update messages, so recovery is posted as a new message
+type Client struct {
+	conf   Config
+	client *http.Client
+	store  *store
+}
+
+func New(conf Config) (*Client, error) {
+	if conf.APIURL == "" {
+		conf.APIURL = DefaultAPIURL
+	}
+	if conf.Timeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Timeout must be positive duration")
+	}
+	if conf.Retries < 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Retries must not be negative")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+	conf.APIURL = strings.TrimSuffix(conf.APIURL, "/")
+
+	s, err := loadStore(conf.StorePath)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout},
+		store:  s,
+	}
+	return c, nil
+}
+
+// Send posts the notification to the contact target
+// first FAIL message of the incident is remembered, resends and recovery are replied in its thread
+// and recovery also turns the original message green
+func (c *Client) Send(target string, n Notification) error {
+	if n.Payload == nil {
+		return errors.Wrap(invalidTargetError, "notification has no payload")
+	}
+	if isWebhook(target) {
+		return c.sendWebhook(target, n)
+	}
+	if target == "" || strings.ContainsAny(target, " /") {
+		return errors.Wrapf(invalidTargetError, "%q must be channel or incoming webhook URL", target)
+	}
+	if c.conf.Token == "" {
+		return missingTokenError
+	}
+
+	posted := c.store.get(n.IncidentID, n.NotificationID)
+	if posted == nil {
+		m := alertMessage(n.Payload, n.IncidentID, n.IncidentStart)
+		m.Channel = target
+		channel, ts, err := c.call("chat.postMessage", m)
+		if err != nil {
+			return err
+		}
+		// only failures are updated later
+		if n.Payload.Failed && n.IncidentID != "" {
+			err = c.store.put(&postedMessage{IncidentID: n.IncidentID, NotificationID: n.NotificationID, Channel: channel, TS: ts, Posted: time.Now()})
+			if err != nil {
+				c.conf.Logger.LogError(err, "failed to save Slack message of incident %s", n.IncidentID)
+			}
+		}
+		return nil
+	}
+
+	if !n.Payload.Failed {
+		update := alertMessage(n.Payload, n.IncidentID, n.IncidentStart)
+		update.Channel, update.TS = posted.Channel, posted.TS
+		if _, _, err := c.call("chat.update", update); err != nil {
+			// reply is still posted, so the recovery is not lost
+			c.conf.Logger.LogError(err, "failed to update Slack message of incident %s", n.IncidentID)
+		}
+	}
+	reply := threadReply(n.Payload, n.IncidentStart, n.Resend)
+	reply.Channel, reply.ThreadTS = posted.Channel, posted.TS
+	if _, _, err := c.call("chat.postMessage", reply); err != nil {
+		return err
+	}
+	if !n.Payload.Failed {
+		if err := c.store.remove(n.IncidentID, n.NotificationID); err != nil {
+			c.conf.Logger.LogError(err, "failed to save Slack message store")
+		}
+	}
+	return nil
+}
+
+func isWebhook(target string) bool {
+	return strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "http://")
+}
+
+func (c *Client) sendWebhook(target string, n Notification) error {
+	if _, err := url.Parse(target); err != nil {
+		return errors.Wrapf(invalidTargetError, "invalid incoming webhook URL: %s", err)
+	}
+	m := alertMessage(n.Payload, n.IncidentID, n.IncidentStart)
+	return c.retry("incoming webhook", func() error {
+		resp, body, err := c.post(target, m, false)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode/100 != 2 {
+			return &APIError{Method: "incoming webhook", StatusCode: resp.StatusCode, Code: strings.TrimSpace(string(body))}
+		}
+		return nil
+	})
+}
+
+// response of Web API methods, HTTP status is 200 also for most errors
+type apiResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// call Web API method, channel id and timestamp of the message are returned
+func (c *Client) call(method string, m message) (string, string, error) {
+	var r apiResponse
+	err := c.retry(method, func() error {
+		resp, body, err := c.post(c.conf.APIURL+"/"+method, m, true)
+		if err != nil {
+			return err
+		}
+		r = apiResponse{}
+		if err := json.Unmarshal(body, &r); err != nil && resp.StatusCode/100 == 2 {
+			return errors.Wrapf(err, "failed to parse response of Slack %s", method)
+		}
+		if resp.StatusCode/100 != 2 || !r.OK {
+			return &APIError{Method: method, StatusCode: resp.StatusCode, Code: r.Error}
+		}
+		return nil
+	})
+	return r.Channel, r.TS, err
+}
+
+func (c *Client) post(endpoint string, m message, auth bool) (*http.Response, []byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode Slack message")
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, errors.Wrapf(invalidTargetError, "failed to create Slack request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if auth {
+		req.Header.Set("Authorization", "Bearer "+c.conf.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Slack request failed")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read Slack response")
+	}
+	return resp, body, nil
+}
+
+// retry temporary failures with backoff
+func (c *Client) retry(method string, f func() error) error {
+	send := func() error {
+		err := f()
+		if err != nil && !isTemporaryError(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	notify := func(err error, next time.Duration) {
+		c.conf.Logger.LogError(err, "Slack %s failed, retrying in %s", method, next.Round(time.Millisecond))
+	}
+	err := backoff.RetryNotify(send, retry.New(c.conf.Retries), notify)
+	if permanent, ok := err.(*backoff.PermanentError); ok {
+		err = permanent.Err
+	}
+	return err
+}
+
+// network failures, rate limiting and server side errors can succeed later
+func isTemporaryError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *APIError:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500 || e.Code == "ratelimited" || e.Code == "internal_error" || e.Code == "service_unavailable"
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// APIError is failure reported by Slack
+type APIError struct {
+	Method     string
+	StatusCode int
+	// Slack error code, e.g. channel_not_found
+	Code string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("Slack %s returned HTTP %d", e.Method, e.StatusCode)
+	}
+	return fmt.Sprintf("Slack %s failed with %s (HTTP %d)", e.Method, e.Code, e.StatusCode)
+}
@@ -0,0 +1,308 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+func testLogger(t *testing.T) *exlogger.Logger {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger
+}
+
+// apiCall is request received by fake Slack
+type apiCall struct {
+	path  string
+	auth  string
+	msg   message
+	reply string
+}
+
+// fakeSlack answers Web API methods, postMessage returns increasing ts
+// errors holds error codes returned by the next calls, empty code means success
+type fakeSlack struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	calls  []apiCall
+	errors []string
+	posted int
+}
+
+func newFakeSlack(t *testing.T, errors ...string) *fakeSlack {
+	f := &fakeSlack{errors: errors}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var m message
+		if err := json.Unmarshal(body, &m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.calls = append(f.calls, apiCall{path: r.URL.Path, auth: r.Header.Get("Authorization"), msg: m})
+		code := ""
+		if len(f.errors) > 0 {
+			code, f.errors = f.errors[0], f.errors[1:]
+		}
+		if strings.HasPrefix(r.URL.Path, "/webhook/") {
+			if code != "" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(code))
+				return
+			}
+			w.Write([]byte("ok"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if code != "" {
+			json.NewEncoder(w).Encode(apiResponse{Error: code})
+			return
+		}
+		ts := m.TS
+		if r.URL.Path == "/chat.postMessage" {
+			f.posted++
+			ts = fmt.Sprintf("1700000000.%06d", f.posted)
+		}
+		json.NewEncoder(w).Encode(apiResponse{OK: true, Channel: "C0123", TS: ts})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSlack) received() []apiCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]apiCall(nil), f.calls...)
+}
+
+func newTestClient(t *testing.T, f *fakeSlack, storePath string) *Client {
+	c, err := New(Config{APIURL: f.URL + "/", Token: "xoxb-test", StorePath: storePath, Timeout: time.Second, Retries: 1, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func failedPayload() *payload.Payload {
+	return &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web<1>", ServiceType: "http", Target: "https://example.com", FailMessage: "HTTP 503 & <b>down</b>"}
+}
+
+func resolvedPayload() *payload.Payload {
+	return &payload.Payload{ServiceID: 7, Status: payload.StatusResolved, Host: "web<1>", ServiceType: "http", Target: "https://example.com"}
+}
+
+func TestSendThreadsIncident(t *testing.T) {
+	f := newFakeSlack(t)
+	storePath := filepath.Join(t.TempDir(), "slack.json")
+	c := newTestClient(t, f, storePath)
+	start := time.Now().Add(-time.Minute)
+
+	err := c.Send("#alerts", Notification{NotificationID: 3, IncidentID: "inc-1", IncidentStart: start, Payload: failedPayload()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := f.received()
+	if len(calls) != 1 || calls[0].path != "/chat.postMessage" {
+		t.Fatalf("expected chat.postMessage, got %+v", calls)
+	}
+	first := calls[0]
+	if first.auth != "Bearer xoxb-test" || first.msg.Channel != "#alerts" || first.msg.ThreadTS != "" {
+		t.Fatalf("unexpected first message %+v with auth %q", first.msg, first.auth)
+	}
+	if len(first.msg.Attachments) != 1 || first.msg.Attachments[0].Color != colorFailed {
+		t.Fatalf("expected failed attachment, got %+v", first.msg.Attachments)
+	}
+	if headline := first.msg.Attachments[0].Blocks[0].Text.Text; !strings.Contains(headline, "*web&lt;1&gt;*") {
+		t.Fatalf("expected escaped host in headline, got %q", headline)
+	}
+
+	// ts of the first message survives restart
+	c = newTestClient(t, f, storePath)
+	posted := c.store.get("inc-1", 3)
+	if posted == nil || posted.Channel != "C0123" || posted.TS != "1700000000.000001" {
+		t.Fatalf("expected stored message, got %+v", posted)
+	}
+
+	err = c.Send("#alerts", Notification{NotificationID: 3, IncidentID: "inc-1", IncidentStart: start, Resend: 1, Payload: failedPayload()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resend := f.received()[1]
+	if resend.path != "/chat.postMessage" || resend.msg.Channel != "C0123" || resend.msg.ThreadTS != "1700000000.000001" {
+		t.Fatalf("expected reply in thread of the first message, got %s %+v", resend.path, resend.msg)
+	}
+	if want := ":red_circle: Still failing, reminder 1: HTTP 503 &amp; &lt;b&gt;down&lt;/b&gt;"; resend.msg.Text != want {
+		t.Fatalf("expected %q, got %q", want, resend.msg.Text)
+	}
+
+	err = c.Send("#alerts", Notification{NotificationID: 3, IncidentID: "inc-1", IncidentStart: start, Payload: resolvedPayload()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls = f.received()
+	if len(calls) != 4 {
+		t.Fatalf("expected update and reply on recovery, got %d calls", len(calls))
+	}
+	update, reply := calls[2], calls[3]
+	if update.path != "/chat.update" || update.msg.Channel != "C0123" || update.msg.TS != "1700000000.000001" {
+		t.Fatalf("expected chat.update of the first message, got %s %+v", update.path, update.msg)
+	}
+	if update.msg.Attachments[0].Color != colorResolved {
+		t.Fatalf("expected resolved colour, got %s", update.msg.Attachments[0].Color)
+	}
+	if reply.path != "/chat.postMessage" || reply.msg.ThreadTS != "1700000000.000001" || !strings.HasPrefix(reply.msg.Text, ":large_green_circle: Resolved after ") {
+		t.Fatalf("expected resolved reply in thread, got %s %+v", reply.path, reply.msg)
+	}
+
+	// resolved incident is forgotten, also in the file
+	if c.store.get("inc-1", 3) != nil {
+		t.Fatal("expected message to be removed from store after recovery")
+	}
+	if newTestClient(t, f, storePath).store.get("inc-1", 3) != nil {
+		t.Fatal("expected message to be removed from store file after recovery")
+	}
+}
+
+func TestSendRecoveryRepliesWhenUpdateFails(t *testing.T) {
+	f := newFakeSlack(t, "", "cant_update_message")
+	c := newTestClient(t, f, "")
+
+	if err := c.Send("C0123", Notification{NotificationID: 3, IncidentID: "inc-1", Payload: failedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send("C0123", Notification{NotificationID: 3, IncidentID: "inc-1", Payload: resolvedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	calls := f.received()
+	if len(calls) != 3 || calls[1].path != "/chat.update" || calls[2].path != "/chat.postMessage" {
+		t.Fatalf("expected failed update followed by reply, got %+v", calls)
+	}
+	if calls[2].msg.Text != ":large_green_circle: Resolved" {
+		t.Fatalf("expected resolved reply without duration, got %q", calls[2].msg.Text)
+	}
+}
+
+func TestSendContactsHaveOwnMessages(t *testing.T) {
+	f := newFakeSlack(t)
+	c := newTestClient(t, f, "")
+
+	for _, id := range []int{3, 4} {
+		if err := c.Send("C0123", Notification{NotificationID: id, IncidentID: "inc-1", Payload: failedPayload()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, second := c.store.get("inc-1", 3), c.store.get("inc-1", 4)
+	if first == nil || second == nil || first.TS == second.TS {
+		t.Fatalf("expected separate messages of both contacts, got %+v and %+v", first, second)
+	}
+}
+
+func TestSendAPIErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		errors    []string
+		wantCalls int
+		wantCode  string
+	}{
+		{name: "rate limit is retried", errors: []string{"ratelimited"}, wantCalls: 2},
+		{name: "unknown channel is not retried", errors: []string{"channel_not_found"}, wantCalls: 1, wantCode: "channel_not_found"},
+		{name: "retries run out", errors: []string{"internal_error", "service_unavailable"}, wantCalls: 2, wantCode: "service_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeSlack(t, tt.errors...)
+			c := newTestClient(t, f, "")
+			err := c.Send("C0123", Notification{NotificationID: 3, IncidentID: "inc-1", Payload: failedPayload()})
+			if got := len(f.received()); got != tt.wantCalls {
+				t.Fatalf("expected %d calls, got %d", tt.wantCalls, got)
+			}
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			e, ok := errors.Cause(err).(*APIError)
+			if !ok || e.Code != tt.wantCode || e.Method != "chat.postMessage" {
+				t.Fatalf("expected %s error, got %v", tt.wantCode, err)
+			}
+			// failed message must not be threaded later
+			if c.store.get("inc-1", 3) != nil {
+				t.Fatal("expected nothing stored for failed post")
+			}
+		})
+	}
+}
+
+func TestSendIncomingWebhook(t *testing.T) {
+	f := newFakeSlack(t)
+	c, err := New(Config{APIURL: f.URL, Timeout: time.Second, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := f.URL + "/webhook/T000/B000/XXXX"
+
+	if err := c.Send(target, Notification{NotificationID: 3, IncidentID: "inc-1", Payload: failedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(target, Notification{NotificationID: 3, IncidentID: "inc-1", Payload: resolvedPayload()}); err != nil {
+		t.Fatal(err)
+	}
+	calls := f.received()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 webhook posts, got %d", len(calls))
+	}
+	for _, call := range calls {
+		if call.auth != "" || call.msg.ThreadTS != "" {
+			t.Fatalf("expected plain webhook post without token, got %+v with auth %q", call.msg, call.auth)
+		}
+	}
+	if calls[1].msg.Attachments[0].Color != colorResolved {
+		t.Fatal("expected recovery posted as new resolved message")
+	}
+
+	f = newFakeSlack(t, "no_service")
+	err = c.Send(f.URL+"/webhook/T000/B000/XXXX", Notification{NotificationID: 3, IncidentID: "inc-1", Payload: failedPayload()})
+	if e, ok := errors.Cause(err).(*APIError); !ok || e.StatusCode != http.StatusNotFound || e.Code != "no_service" {
+		t.Fatalf("expected webhook error, got %v", err)
+	}
+}
+
+func TestSendInvalidTarget(t *testing.T) {
+	f := newFakeSlack(t)
+	c := newTestClient(t, f, "")
+	for _, target := range []string{"", "#ops alerts", "team/alerts"} {
+		err := c.Send(target, Notification{NotificationID: 3, Payload: failedPayload()})
+		if errors.Cause(err) != invalidTargetError {
+			t.Errorf("%q: expected invalid target, got %v", target, err)
+		}
+	}
+
+	c, err := New(Config{APIURL: f.URL, Timeout: time.Second, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send("#alerts", Notification{NotificationID: 3, Payload: failedPayload()}); err != missingTokenError {
+		t.Fatalf("expected missing token, got %v", err)
+	}
+	if len(f.received()) != 0 {
+		t.Fatal("invalid targets must not reach Slack")
+	}
+}
@@ -0,0 +1,113 @@
+package slack
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// messages of incidents which never recovered, e.g. deleted services, are dropped after this time
+const maxMessageAge = time.Hour * 24 * 30
+
+// postedMessage is the first FAIL message of the incident, recovery updates it and replies in its thread
+type postedMessage struct {
+	IncidentID     string    `json:"incidentId"`
+	NotificationID int       `json:"notificationId"`
+	Channel        string    `json:"channel"`
+	TS             string    `json:"ts"`
+	Posted         time.Time `json:"posted"`
+}
+
+// store keeps posted messages, when path is set they are saved in json file, so they survive restart
+type store struct {
+	path     string
+	messages map[string]*postedMessage
+
+	sync.Mutex
+}
+
+func loadStore(path string) (*store, error) {
+	s := &store{
+		path:     path,
+		messages: map[string]*postedMessage{},
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to read Slack message store %s", path)
+	}
+
+	var messages []*postedMessage
+	err = json.Unmarshal(data, &messages)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse Slack message store %s", path)
+	}
+	for _, m := range messages {
+		s.messages[messageKey(m.IncidentID, m.NotificationID)] = m
+	}
+	return s, nil
+}
+
+// every contact has its own message for the incident
+func messageKey(incidentID string, notificationID int) string {
+	return incidentID + "/" + strconv.Itoa(notificationID)
+}
+
+func (s *store) get(incidentID string, notificationID int) *postedMessage {
+	s.Lock()
+	defer s.Unlock()
+	return s.messages[messageKey(incidentID, notificationID)]
+}
+
+func (s *store) put(m *postedMessage) error {
+	s.Lock()
+	s.messages[messageKey(m.IncidentID, m.NotificationID)] = m
+	s.Unlock()
+	return s.save()
+}
+
+func (s *store) remove(incidentID string, notificationID int) error {
+	s.Lock()
+	delete(s.messages, messageKey(incidentID, notificationID))
+	s.Unlock()
+	return s.save()
+}
+
+// save messages into file, file is replaced atomically
+// lock is held while writing, as notifications of different services are sent concurrently
+func (s *store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	var messages []*postedMessage
+	for key, m := range s.messages {
+		if time.Since(m.Posted) > maxMessageAge {
+			delete(s.messages, key)
+			continue
+		}
+		messages = append(messages, m)
+	}
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode Slack message store")
+	}
+
+	tmpPath := s.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0640)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write Slack message store %s", tmpPath)
+	}
+	return os.Rename(tmpPath, s.path)
+}
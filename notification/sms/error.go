@@ -0,0 +1,17 @@
+package sms
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var notConnectedError error = errors.New("SMPP session is not bound")
+
+var connectionLostError error = errors.New("SMPP connection lost")
+
+var requestTimeoutError error = errors.New("SMPP request timed out")
+
+var invalidPDUError error = errors.New("invalid SMPP PDU")
+
+var messageTooLongError error = errors.New("SMS message is too long")
+
+var deliveryFailedError error = errors.New("SMS was not delivered")
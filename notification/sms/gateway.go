@@ -0,0 +1,151 @@
+package sms
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/internal/retry"
+)
+
+const (
+	// only beginning of the provider response is read, it is enough for message id and error code
+	maxGatewayResponseSize = 64 * 1024
+	gatewayRetryMax        = time.Second * 10
+)
+
+// adapter translates SMS into the HTTP request of specific provider API and parses its response
+type gatewayAdapter interface {
+	request(number string, message string) (*http.Request, error)
+	// returns provider message id, error must be *GatewayError when the provider refused the message
+	parseResponse(resp *http.Response, body []byte) (string, error)
+	String() string
+}
+
+// HTTPGateway sends SMS via HTTP API of SMS provider
+type HTTPGateway struct {
+	adapter gatewayAdapter
+	client  *http.Client
+	retries int
+	logger  *exlogger.Logger
+}
+
+func newGateway(adapter gatewayAdapter, timeout time.Duration, retries int, logger *exlogger.Logger) (*HTTPGateway, error) {
+	if timeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Timeout must be positive duration")
+	}
+	if retries < 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Retries must not be negative")
+	}
+	if logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+
+	g := &HTTPGateway{
+		adapter: adapter,
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		logger:  logger,
+	}
+	return g, nil
+}
+
+// Send submits SMS to the provider, temporary failures are retried with backoff
+func (g *HTTPGateway) Send(number string, message string) error {
+	// provider does the splitting, but message which can not be delivered at all is refused right away
+	if _, err := splitMessage(message); err != nil {
+		return err
+	}
+
+	var messageID string
+	send := func() error {
+		var err error
+		messageID, err = g.send(number, message)
+		if err != nil && !isTemporaryError(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	notify := func(err error, next time.Duration) {
+		g.logger.LogError(err, "failed to send SMS to %s via %s, retrying in %s", number, g.adapter.String(), next.Round(time.Millisecond))
+	}
+
+	err := backoff.RetryNotify(send, retry.NewExponential(g.retries, retry.DefaultInterval, gatewayRetryMax), notify)
+	if permanent, ok := err.(*backoff.PermanentError); ok {
+		err = permanent.Err
+	}
+	if err != nil {
+		return err
+	}
+	g.logger.LogDebug("SMS to %s accepted by %s, message id %s", number, g.adapter.String(), messageID)
+	return nil
+}
+
+func (g *HTTPGateway) send(number string, message string) (string, error) {
+	req, err := g.adapter.request(number, message)
+	if err != nil {
+		return "", err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "request to %s failed", g.adapter.String())
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxGatewayResponseSize))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read response of %s", g.adapter.String())
+	}
+	return g.adapter.parseResponse(resp, body)
+}
+
+// network failures and errors marked as temporary by the provider can succeed later,
+// anything else, e.g. invalid number or rejected credentials, is permanent
+func isTemporaryError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *GatewayError:
+		return e.Temporary()
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// GatewayError is failure reported by HTTP SMS provider
+type GatewayError struct {
+	Gateway    string
+	StatusCode int
+	// provider specific error code and message, empty when the provider did not send any
+	Code    string
+	Message string
+
+	temporary bool
+}
+
+func (e *GatewayError) Error() string {
+	msg := fmt.Sprintf("%s returned HTTP %d", e.Gateway, e.StatusCode)
+	if e.Code != "" {
+		msg += fmt.Sprintf(", error code %s", e.Code)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Temporary reports whether the request can succeed later, e.g. when the provider is throttling or down
+func (e *GatewayError) Temporary() bool {
+	return e.temporary
+}
+
+// rate limiting and server side failures are worth retrying
+func isTemporaryHTTPStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
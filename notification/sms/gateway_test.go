@@ -0,0 +1,322 @@
+package sms
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+)
+
+func testLogger(t *testing.T) *exlogger.Logger {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger
+}
+
+// recordedRequest is copy of request received by fake provider
+type recordedRequest struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   string
+}
+
+// fakeProvider answers requests with the responses in order, the last one is repeated
+type fakeProvider struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	requests  []recordedRequest
+	statuses  []int
+	responses []string
+}
+
+func newFakeProvider(t *testing.T, statuses []int, responses []string) *fakeProvider {
+	p := &fakeProvider{statuses: statuses, responses: responses}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		p.mu.Lock()
+		i := len(p.requests)
+		if i >= len(p.statuses) {
+			i = len(p.statuses) - 1
+		}
+		p.requests = append(p.requests, recordedRequest{method: r.Method, path: r.URL.Path, query: r.URL.Query(), header: r.Header, body: string(body)})
+		p.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(p.statuses[i])
+		w.Write([]byte(p.responses[i]))
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeProvider) received() []recordedRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]recordedRequest(nil), p.requests...)
+}
+
+func TestTwilioSend(t *testing.T) {
+	p := newFakeProvider(t, []int{http.StatusCreated}, []string{`{"sid": "SM123", "status": "queued", "error_code": null}`})
+	g, err := NewTwilio(TwilioConfig{APIURL: p.URL + "/", AccountSID: "AC1", AuthToken: "token", From: "+15005550006", Timeout: time.Second, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Send("+420123456789", "web & api are down"); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := p.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+	r := requests[0]
+	if r.method != http.MethodPost || r.path != "/2010-04-01/Accounts/AC1/Messages.json" {
+		t.Fatalf("unexpected request %s %s", r.method, r.path)
+	}
+	if user, pass, ok := (&http.Request{Header: r.header}).BasicAuth(); !ok || user != "AC1" || pass != "token" {
+		t.Fatalf("unexpected basic auth %s:%s", user, pass)
+	}
+	form, err := url.ParseQuery(r.body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("To") != "+420123456789" || form.Get("Body") != "web & api are down" || form.Get("From") != "+15005550006" || form.Get("MessagingServiceSid") != "" {
+		t.Fatalf("unexpected form %v", form)
+	}
+}
+
+func TestTwilioMessagingService(t *testing.T) {
+	p := newFakeProvider(t, []int{http.StatusCreated}, []string{`{"sid": "SM123"}`})
+	g, err := NewTwilio(TwilioConfig{APIURL: p.URL, AccountSID: "AC1", AuthToken: "token", From: "+15005550006", MessagingServiceSID: "MG1", Timeout: time.Second, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Send("+420123456789", "down"); err != nil {
+		t.Fatal(err)
+	}
+	form, _ := url.ParseQuery(p.received()[0].body)
+	if form.Get("MessagingServiceSid") != "MG1" || form.Get("From") != "" {
+		t.Fatalf("expected messaging service instead of sender, got %v", form)
+	}
+}
+
+func TestTwilioErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		responses []string
+		retries   int
+		requests  int
+		code      string
+		temporary bool
+	}{
+		{
+			name:      "invalid number is not retried",
+			statuses:  []int{http.StatusBadRequest},
+			responses: []string{`{"code": 21211, "message": "The 'To' number is not a valid phone number.", "status": 400}`},
+			retries:   2,
+			requests:  1,
+			code:      "21211",
+		},
+		{
+			name:      "failed message resource",
+			statuses:  []int{http.StatusCreated},
+			responses: []string{`{"sid": "SM1", "error_code": 30003, "error_message": "Unreachable destination handset"}`},
+			retries:   2,
+			requests:  1,
+			code:      "30003",
+		},
+		{
+			name:      "throttling is retried",
+			statuses:  []int{http.StatusTooManyRequests, http.StatusCreated},
+			responses: []string{`{"code": 20429, "message": "Too Many Requests"}`, `{"sid": "SM2"}`},
+			retries:   1,
+			requests:  2,
+		},
+		{
+			name:      "proxy error page",
+			statuses:  []int{http.StatusBadGateway},
+			responses: []string{`<html>bad gateway</html>`},
+			retries:   0,
+			requests:  1,
+			temporary: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFakeProvider(t, tt.statuses, tt.responses)
+			g, err := NewTwilio(TwilioConfig{APIURL: p.URL, AccountSID: "AC1", AuthToken: "token", From: "Firefly", Timeout: time.Second, Retries: tt.retries, Logger: testLogger(t)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = g.Send("+420123456789", "down")
+			if n := len(p.received()); n != tt.requests {
+				t.Fatalf("expected %d requests, got %d", tt.requests, n)
+			}
+			if tt.code == "" && !tt.temporary {
+				if err != nil {
+					t.Fatalf("expected success after retry, got %v", err)
+				}
+				return
+			}
+			e, ok := errors.Cause(err).(*GatewayError)
+			if !ok {
+				t.Fatalf("expected gateway error, got %v", err)
+			}
+			if e.Code != tt.code || e.Temporary() != tt.temporary {
+				t.Fatalf("expected code %q temporary %t, got %q %t", tt.code, tt.temporary, e.Code, e.Temporary())
+			}
+		})
+	}
+}
+
+func TestHTTPGatewayJSON(t *testing.T) {
+	p := newFakeProvider(t, []int{http.StatusOK}, []string{`{"messages": [{"status": "0", "message-id": "0A0000001"}]}`})
+	g, err := NewHTTPGateway(HTTPGatewayConfig{
+		URL:            p.URL + "/sms/json?api_key=key",
+		BodyFormat:     BodyFormatJSON,
+		Body:           `{"from": "{{ .From }}", "to": "{{ .Number }}", "text": "{{ .Message }}"}`,
+		Headers:        map[string]string{"X-Recipient": "{{ .Number }}"},
+		From:           "Firefly",
+		MessageIDField: "messages.0.message-id",
+		ErrorCodeField: "messages.0.status",
+		Timeout:        time.Second,
+		Logger:         testLogger(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := "\"api\" is down\n\\o/ ěšč"
+	if err := g.Send("+420123456789", message); err != nil {
+		t.Fatal(err)
+	}
+
+	r := p.received()[0]
+	if r.method != http.MethodPost || r.query.Get("api_key") != "key" {
+		t.Fatalf("unexpected request %s %s?%s", r.method, r.path, r.query.Encode())
+	}
+	if r.header.Get("Content-Type") != "application/json" || r.header.Get("X-Recipient") != "+420123456789" {
+		t.Fatalf("unexpected headers %v", r.header)
+	}
+	var body map[string]string
+	if err := json.Unmarshal([]byte(r.body), &body); err != nil {
+		t.Fatalf("body is not valid json: %v: %s", err, r.body)
+	}
+	if body["text"] != message || body["to"] != "+420123456789" || body["from"] != "Firefly" {
+		t.Fatalf("unexpected body %v", body)
+	}
+}
+
+func TestHTTPGatewayFormAndQuery(t *testing.T) {
+	p := newFakeProvider(t, []int{http.StatusOK}, []string{`OK`})
+	g, err := NewHTTPGateway(HTTPGatewayConfig{
+		URL:        p.URL + "/send?to={{ .Number }}",
+		BodyFormat: BodyFormatForm,
+		Body:       "text={{ .Message }}",
+		Username:   "user",
+		Password:   "pass",
+		Timeout:    time.Second,
+		Logger:     testLogger(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Send("+420123456789", "a&b=c"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := p.received()[0]
+	if r.query.Get("to") != "+420123456789" {
+		t.Fatalf("number is not escaped in URL, got %v", r.query)
+	}
+	form, err := url.ParseQuery(r.body)
+	if err != nil || form.Get("text") != "a&b=c" {
+		t.Fatalf("message is not escaped in form body, got %q", r.body)
+	}
+	if user, pass, ok := (&http.Request{Header: r.header}).BasicAuth(); !ok || user != "user" || pass != "pass" {
+		t.Fatalf("unexpected basic auth %s:%s", user, pass)
+	}
+}
+
+func TestHTTPGatewayErrorCodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		response  string
+		err       bool
+		temporary bool
+	}{
+		{name: "success code", status: http.StatusOK, response: `{"status": "0"}`},
+		{name: "refused", status: http.StatusOK, response: `{"status": "6", "error": "unroutable"}`, err: true},
+		{name: "retryable code", status: http.StatusOK, response: `{"status": "1", "error": "throttled"}`, err: true, temporary: true},
+		{name: "server error", status: http.StatusServiceUnavailable, response: `{}`, err: true, temporary: true},
+		{name: "unauthorized", status: http.StatusUnauthorized, response: `{}`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFakeProvider(t, []int{tt.status}, []string{tt.response})
+			g, err := NewHTTPGateway(HTTPGatewayConfig{
+				URL:               p.URL,
+				BodyFormat:        BodyFormatForm,
+				ErrorCodeField:    "status",
+				ErrorMessageField: "error",
+				RetryableCodes:    []string{"1"},
+				Timeout:           time.Second,
+				Logger:            testLogger(t),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = g.Send("+420123456789", "down")
+			if (err != nil) != tt.err {
+				t.Fatalf("expected error %t, got %v", tt.err, err)
+			}
+			if err != nil && isTemporaryError(err) != tt.temporary {
+				t.Fatalf("expected temporary %t, got %v", tt.temporary, err)
+			}
+		})
+	}
+}
+
+func TestHTTPGatewayInvalidTemplate(t *testing.T) {
+	_, err := NewHTTPGateway(HTTPGatewayConfig{
+		URL:        "https://gw.example.com/send?to={{ .Phone }}",
+		BodyFormat: BodyFormatForm,
+		Timeout:    time.Second,
+		Logger:     testLogger(t),
+	})
+	if errors.Cause(err) != invalidConfigError {
+		t.Fatalf("expected invalid config error for unknown field, got %v", err)
+	}
+}
+
+func TestJSONField(t *testing.T) {
+	var v interface{}
+	json.Unmarshal([]byte(`{"messages": [{"id": "a1", "price": 0.05, "ok": true}], "count": 1}`), &v)
+	tests := map[string]string{
+		"messages.0.id":    "a1",
+		"messages.0.price": "0.05",
+		"messages.0.ok":    "true",
+		"count":            "1",
+		"messages.1.id":    "",
+		"messages.x":       "",
+		"missing":          "",
+		"":                 "",
+	}
+	for path, want := range tests {
+		if got := jsonField(v, path); got != want {
+			t.Errorf("jsonField(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
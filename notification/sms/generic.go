@@ -0,0 +1,262 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+)
+
+const (
+	BodyFormatForm = "form"
+	BodyFormatJSON = "json"
+)
+
+// HTTPGatewayConfig describes HTTP API of SMS provider without dedicated adapter
+//
+// URL, Body and Headers are templates with fields .Number, .Message and .From,
+// the values are escaped for the place where they are used, so the templates look like
+// 'https://gw.example.com/send?to={{ .Number }}', 'to={{ .Number }}&text={{ .Message }}'
+// or '{"to": "{{ .Number }}", "text": "{{ .Message }}"}'
+type HTTPGatewayConfig struct {
+	URL string
+	// POST when empty
+	Method string
+	// one of form, json
+	BodyFormat string
+	Body       string
+	// header name to value template, e.g. Authorization: Bearer xyz
+	Headers map[string]string
+	// basic auth is used when username is set
+	Username string
+	Password string
+	// sender available in templates
+	From string
+
+	// dot separated paths into json response, e.g. messages.0.message-id, empty means the value is not parsed
+	MessageIDField    string
+	ErrorCodeField    string
+	ErrorMessageField string
+	// error codes which mean the message was accepted, 0 when empty
+	SuccessCodes []string
+	// error codes which can succeed later, HTTP 408, 429 and 5xx are always retried
+	RetryableCodes []string
+
+	// timeout of single API request
+	Timeout time.Duration
+	// how many times is temporary failure retried
+	Retries int
+
+	Logger *exlogger.Logger
+}
+
+// NewHTTPGateway creates sender using templated HTTP API
+func NewHTTPGateway(conf HTTPGatewayConfig) (*HTTPGateway, error) {
+	if conf.URL == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.URL must not be empty")
+	}
+	if conf.Method == "" {
+		conf.Method = http.MethodPost
+	}
+	if conf.BodyFormat != BodyFormatForm && conf.BodyFormat != BodyFormatJSON {
+		return nil, errors.Wrapf(invalidConfigError, "conf.BodyFormat %q is not supported", conf.BodyFormat)
+	}
+	if len(conf.SuccessCodes) == 0 {
+		conf.SuccessCodes = []string{"0"}
+	}
+
+	a := &genericAdapter{
+		conf:           conf,
+		headers:        map[string]*template.Template{},
+		successCodes:   map[string]bool{},
+		retryableCodes: map[string]bool{},
+	}
+	var err error
+	a.url, err = parseGatewayTemplate("URL", conf.URL)
+	if err != nil {
+		return nil, err
+	}
+	a.body, err = parseGatewayTemplate("Body", conf.Body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range conf.Headers {
+		a.headers[name], err = parseGatewayTemplate("header "+name, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range conf.SuccessCodes {
+		a.successCodes[c] = true
+	}
+	for _, c := range conf.RetryableCodes {
+		a.retryableCodes[c] = true
+	}
+
+	return newGateway(a, conf.Timeout, conf.Retries, conf.Logger)
+}
+
+// gatewayData is available in URL, body and header templates
+type gatewayData struct {
+	Number  string
+	Message string
+	From    string
+}
+
+// parse template and try it on sample data, so typos in field names are found at startup
+func parseGatewayTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(invalidConfigError, "failed to parse %s template: %s", name, err)
+	}
+	err = tmpl.Execute(ioutil.Discard, gatewayData{})
+	if err != nil {
+		return nil, errors.Wrapf(invalidConfigError, "failed to execute %s template: %s", name, err)
+	}
+	return tmpl, nil
+}
+
+type genericAdapter struct {
+	conf           HTTPGatewayConfig
+	url            *template.Template
+	body           *template.Template
+	headers        map[string]*template.Template
+	successCodes   map[string]bool
+	retryableCodes map[string]bool
+}
+
+func (a *genericAdapter) String() string {
+	// URL template can contain credentials, only host is shown
+	if u, err := url.Parse(a.conf.URL); err == nil && u.Host != "" {
+		return "SMS gateway " + u.Host
+	}
+	return "SMS gateway"
+}
+
+func (a *genericAdapter) request(number string, message string) (*http.Request, error) {
+	data := gatewayData{Number: number, Message: message, From: a.conf.From}
+
+	endpoint, err := execute(a.url, escapeData(data, url.QueryEscape))
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	contentType := "application/x-www-form-urlencoded"
+	if a.conf.BodyFormat == BodyFormatJSON {
+		contentType = "application/json"
+	}
+	if a.conf.Body != "" {
+		escape := url.QueryEscape
+		if a.conf.BodyFormat == BodyFormatJSON {
+			escape = jsonEscape
+		}
+		b, err := execute(a.body, escapeData(data, escape))
+		if err != nil {
+			return nil, err
+		}
+		body = strings.NewReader(b)
+	}
+
+	req, err := http.NewRequest(a.conf.Method, endpoint, body)
+	if err != nil {
+		return nil, errors.Wrapf(invalidConfigError, "failed to create SMS gateway request: %s", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, tmpl := range a.headers {
+		value, err := execute(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, value)
+	}
+	if a.conf.Username != "" {
+		req.SetBasicAuth(a.conf.Username, a.conf.Password)
+	}
+	return req, nil
+}
+
+func (a *genericAdapter) parseResponse(resp *http.Response, body []byte) (string, error) {
+	var r interface{}
+	// non json responses are judged only by the status code
+	_ = json.Unmarshal(body, &r)
+
+	code := jsonField(r, a.conf.ErrorCodeField)
+	if resp.StatusCode/100 == 2 && (code == "" || a.successCodes[code]) {
+		return jsonField(r, a.conf.MessageIDField), nil
+	}
+
+	e := &GatewayError{
+		Gateway:    a.String(),
+		StatusCode: resp.StatusCode,
+		Code:       code,
+		Message:    jsonField(r, a.conf.ErrorMessageField),
+		temporary:  isTemporaryHTTPStatus(resp.StatusCode) || a.retryableCodes[code],
+	}
+	return "", e
+}
+
+func execute(tmpl *template.Template, data gatewayData) (string, error) {
+	var b bytes.Buffer
+	err := tmpl.Execute(&b, data)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to execute %s template", tmpl.Name())
+	}
+	return b.String(), nil
+}
+
+func escapeData(data gatewayData, escape func(string) string) gatewayData {
+	return gatewayData{
+		Number:  escape(data.Number),
+		Message: escape(data.Message),
+		From:    escape(data.From),
+	}
+}
+
+// escape string for use inside json string literal
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// find value in decoded json by dot separated path, numbers index arrays
+func jsonField(v interface{}, path string) string {
+	if path == "" {
+		return ""
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return ""
+			}
+			v = node[i]
+		default:
+			return ""
+		}
+	}
+
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return ""
+}
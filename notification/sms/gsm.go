@@ -0,0 +1,193 @@
+package sms
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// SMPP data_coding values
+	dataCodingDefault = 0x00
+	dataCodingUCS2    = 0x08
+
+	// single message and concatenated message part lengths
+	gsm7SingleLength = 160
+	gsm7PartLength   = 153
+	ucs2SingleLength = 70
+	ucs2PartLength   = 67
+	maxMessageParts  = 255
+	gsm7EscapeSeptet = 0x1b
+)
+
+// GSM 03.38 default alphabet, index is the septet value
+var gsm7Alphabet = []rune("@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà")
+
+// GSM 03.38 extension table, characters are sent as escape septet followed by the value
+var gsm7Extension = map[rune]byte{
+	'\f': 0x0a,
+	'^':  0x14,
+	'{':  0x28,
+	'}':  0x29,
+	'\\': 0x2f,
+	'[':  0x3c,
+	'~':  0x3d,
+	']':  0x3e,
+	'|':  0x40,
+	'€':  0x65,
+}
+
+var gsm7Index = func() map[rune]byte {
+	m := map[rune]byte{}
+	for i, r := range gsm7Alphabet {
+		if r != gsm7EscapeSeptet {
+			m[r] = byte(i)
+		}
+	}
+	return m
+}()
+
+// segment is single submit_sm short message
+type segment struct {
+	dataCoding byte
+	// unpacked septets for default alphabet, UTF-16BE for UCS-2
+	data []byte
+}
+
+// IsGSM7 reports whether the text can be sent in GSM 03.38 default alphabet
+func IsGSM7(text string) bool {
+	for _, r := range text {
+		if _, ok := gsm7Index[r]; ok {
+			continue
+		}
+		if _, ok := gsm7Extension[r]; ok {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// typographic characters common in error messages, single one of them would switch whole SMS to UCS-2
+var gsm7Replacer = strings.NewReplacer(
+	"\u2018", "'", "\u2019", "'", "\u201a", "'", "\u201c", "\"", "\u201d", "\"", "\u201e", "\"",
+	"\u2013", "-", "\u2014", "-", "\u2212", "-", "\u2026", "...", "\u00a0", " ", "\u2009", " ", "\u2022", "*",
+	"\t", " ",
+)
+
+// Normalize replaces typographic punctuation with GSM 03.38 characters, so the text stays in the longer GSM-7 encoding
+func Normalize(text string) string {
+	return gsm7Replacer.Replace(text)
+}
+
+// Parts returns amount of SMS parts needed for the text, it matches how the text is split when sent via SMPP
+func Parts(text string) int {
+	segments, err := splitMessage(text)
+	if err != nil {
+		return maxMessageParts + 1
+	}
+	return len(segments)
+}
+
+// MaxLength returns how many characters fit into the given amount of parts,
+// GSM-7 extension characters count twice and UCS-2 characters outside BMP count twice
+func MaxLength(parts int, gsm7 bool) int {
+	single, part := gsm7SingleLength, gsm7PartLength
+	if !gsm7 {
+		single, part = ucs2SingleLength, ucs2PartLength
+	}
+	if parts <= 1 {
+		return single
+	}
+	return parts * part
+}
+
+// Length returns length of the text in encoding units, septets for GSM-7 and UTF-16 code units for UCS-2
+func Length(text string) int {
+	var chars [][]byte
+	unit := 1
+	if IsGSM7(text) {
+		chars = encodeGSM7Runes(text)
+	} else {
+		chars = encodeUCS2Runes(text)
+		unit = 2
+	}
+	var total int
+	for _, c := range chars {
+		total += len(c)
+	}
+	return total / unit
+}
+
+// encode text into septets, one character is one or two septets
+func encodeGSM7Runes(text string) [][]byte {
+	var chars [][]byte
+	for _, r := range text {
+		if b, ok := gsm7Index[r]; ok {
+			chars = append(chars, []byte{b})
+		} else if b, ok := gsm7Extension[r]; ok {
+			chars = append(chars, []byte{gsm7EscapeSeptet, b})
+		} else {
+			chars = append(chars, []byte{gsm7Index['?']})
+		}
+	}
+	return chars
+}
+
+// encode text into UTF-16 code units, surrogate pairs are kept together
+func encodeUCS2Runes(text string) [][]byte {
+	var chars [][]byte
+	for _, r := range text {
+		var units []byte
+		for _, u := range utf16.Encode([]rune{r}) {
+			units = append(units, byte(u>>8), byte(u))
+		}
+		chars = append(chars, units)
+	}
+	return chars
+}
+
+// split message into segments, characters are never split between segments
+// GSM 03.38 alphabet is used when possible as it allows more than twice longer messages
+func splitMessage(text string) ([]segment, error) {
+	dataCoding := byte(dataCodingDefault)
+	chars := encodeGSM7Runes(text)
+	single, part, unit := gsm7SingleLength, gsm7PartLength, 1
+	if !IsGSM7(text) {
+		dataCoding = dataCodingUCS2
+		chars = encodeUCS2Runes(text)
+		single, part, unit = ucs2SingleLength, ucs2PartLength, 2
+	}
+
+	var total int
+	for _, c := range chars {
+		total += len(c)
+	}
+	if total <= single*unit {
+		return []segment{{dataCoding: dataCoding, data: joinChars(chars)}}, nil
+	}
+
+	var segments []segment
+	var current []byte
+	for _, c := range chars {
+		if len(current)+len(c) > part*unit {
+			segments = append(segments, segment{dataCoding: dataCoding, data: current})
+			current = nil
+		}
+		current = append(current, c...)
+	}
+	if len(current) > 0 {
+		segments = append(segments, segment{dataCoding: dataCoding, data: current})
+	}
+	if len(segments) > maxMessageParts {
+		return nil, messageTooLongError
+	}
+	return segments, nil
+}
+
+func joinChars(chars [][]byte) []byte {
+	var b []byte
+	for _, c := range chars {
+		b = append(b, c...)
+	}
+	return b
+}
@@ -0,0 +1,75 @@
+package sms
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParts(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		gsm7   bool
+		length int
+		parts  int
+	}{
+		{name: "empty", text: "", gsm7: true, length: 0, parts: 1},
+		{name: "single GSM-7", text: strings.Repeat("a", 160), gsm7: true, length: 160, parts: 1},
+		{name: "concatenated GSM-7", text: strings.Repeat("a", 161), gsm7: true, length: 161, parts: 2},
+		{name: "two full GSM-7 parts", text: strings.Repeat("a", 306), gsm7: true, length: 306, parts: 2},
+		{name: "three GSM-7 parts", text: strings.Repeat("a", 307), gsm7: true, length: 307, parts: 3},
+		// extension characters take two septets
+		{name: "extension characters", text: strings.Repeat("€", 80), gsm7: true, length: 160, parts: 1},
+		{name: "extension character over limit", text: strings.Repeat("a", 159) + "[", gsm7: true, length: 161, parts: 2},
+		// escape septet is never split from its character
+		{name: "extension character on part boundary", text: strings.Repeat("a", 152) + "{" + strings.Repeat("a", 152), gsm7: true, length: 306, parts: 3},
+		{name: "single UCS-2", text: strings.Repeat("ž", 70), gsm7: false, length: 70, parts: 1},
+		{name: "concatenated UCS-2", text: strings.Repeat("ž", 71), gsm7: false, length: 71, parts: 2},
+		{name: "one UCS-2 character switches encoding", text: strings.Repeat("a", 100) + "ž", gsm7: false, length: 101, parts: 2},
+		// characters outside BMP are surrogate pairs
+		{name: "surrogate pairs", text: strings.Repeat("🔥", 35), gsm7: false, length: 70, parts: 1},
+		{name: "too long", text: strings.Repeat("a", 153*255+1), gsm7: true, length: 153*255 + 1, parts: maxMessageParts + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if gsm7 := IsGSM7(tt.text); gsm7 != tt.gsm7 {
+				t.Errorf("IsGSM7 = %t, want %t", gsm7, tt.gsm7)
+			}
+			if length := Length(tt.text); length != tt.length {
+				t.Errorf("Length = %d, want %d", length, tt.length)
+			}
+			if parts := Parts(tt.text); parts != tt.parts {
+				t.Errorf("Parts = %d, want %d", parts, tt.parts)
+			}
+		})
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	tests := []struct {
+		parts int
+		gsm7  bool
+		want  int
+	}{
+		{parts: 0, gsm7: true, want: 160},
+		{parts: 1, gsm7: true, want: 160},
+		{parts: 2, gsm7: true, want: 306},
+		{parts: 1, gsm7: false, want: 70},
+		{parts: 3, gsm7: false, want: 201},
+	}
+	for _, tt := range tests {
+		if got := MaxLength(tt.parts, tt.gsm7); got != tt.want {
+			t.Errorf("MaxLength(%d, %t) = %d, want %d", tt.parts, tt.gsm7, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	text := Normalize("“quoted” – ‘single’…\tnbsp •")
+	if want := "\"quoted\" - 'single'... nbsp *"; text != want {
+		t.Fatalf("Normalize = %q, want %q", text, want)
+	}
+	if !IsGSM7(text) {
+		t.Fatal("normalized text must be GSM-7")
+	}
+}
@@ -0,0 +1,238 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// SMPP 3.4 command ids
+const (
+	cmdGenericNack         = 0x80000000
+	cmdBindTransceiver     = 0x00000009
+	cmdBindTransceiverResp = 0x80000009
+	cmdSubmitSM            = 0x00000004
+	cmdSubmitSMResp        = 0x80000004
+	cmdDeliverSM           = 0x00000005
+	cmdDeliverSMResp       = 0x80000005
+	cmdUnbind              = 0x00000006
+	cmdUnbindResp          = 0x80000006
+	cmdEnquireLink         = 0x00000015
+	cmdEnquireLinkResp     = 0x80000015
+
+	// response bit of the command id
+	cmdResponse = 0x80000000
+)
+
+// SMPP 3.4 command statuses used by the client
+const (
+	statusOK            = 0x00000000
+	statusInvalidCmdID  = 0x00000003
+	statusThrottled     = 0x00000058
+	statusMsgQueueFull  = 0x00000014
+	statusSystemError   = 0x00000008
+	statusBindFailed    = 0x0000000d
+	statusInvalidPasswd = 0x0000000e
+)
+
+// optional parameter tags
+const (
+	tagReceiptedMessageID = 0x001e
+	tagMessageState       = 0x0427
+)
+
+const (
+	pduHeaderLength = 16
+	// bigger PDU is never sent by sane SMSC and would only waste memory
+	maxPDULength = 64 * 1024
+
+	smppInterfaceVersion = 0x34
+
+	// esm_class flags
+	esmClassUDHI          = 0x40
+	esmClassReceiptMask   = 0x3c
+	esmClassDeliveryRecpt = 0x04
+)
+
+type pdu struct {
+	commandID uint32
+	status    uint32
+	seq       uint32
+	body      []byte
+}
+
+func readPDU(r io.Reader) (*pdu, error) {
+	var header [pduHeaderLength]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length < pduHeaderLength || length > maxPDULength {
+		return nil, errors.Wrapf(invalidPDUError, "command length %d", length)
+	}
+	p := &pdu{
+		commandID: binary.BigEndian.Uint32(header[4:8]),
+		status:    binary.BigEndian.Uint32(header[8:12]),
+		seq:       binary.BigEndian.Uint32(header[12:16]),
+		body:      make([]byte, length-pduHeaderLength),
+	}
+	_, err = io.ReadFull(r, p.body)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *pdu) bytes() []byte {
+	b := make([]byte, pduHeaderLength, pduHeaderLength+len(p.body))
+	binary.BigEndian.PutUint32(b[0:4], uint32(pduHeaderLength+len(p.body)))
+	binary.BigEndian.PutUint32(b[4:8], p.commandID)
+	binary.BigEndian.PutUint32(b[8:12], p.status)
+	binary.BigEndian.PutUint32(b[12:16], p.seq)
+	return append(b, p.body...)
+}
+
+// pduWriter builds PDU body
+type pduWriter struct {
+	bytes.Buffer
+}
+
+func (w *pduWriter) cstring(s string) {
+	w.WriteString(s)
+	w.WriteByte(0)
+}
+
+func (w *pduWriter) byte(b byte) {
+	w.WriteByte(b)
+}
+
+// pduReader parses PDU body, first error is kept and all following reads return zero values
+type pduReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *pduReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.b[r.pos:], 0)
+	if end < 0 {
+		r.err = errors.Wrap(invalidPDUError, "unterminated c-octet string")
+		return ""
+	}
+	s := string(r.b[r.pos : r.pos+end])
+	r.pos += end + 1
+	return s
+}
+
+func (r *pduReader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *pduReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.pos+n > len(r.b) {
+		r.err = errors.Wrap(invalidPDUError, "body is too short")
+		return nil
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+// read optional parameters until the end of the body
+func (r *pduReader) tlvs() map[uint16][]byte {
+	tlvs := map[uint16][]byte{}
+	for r.err == nil && len(r.b)-r.pos >= 4 {
+		header := r.bytes(4)
+		tag := binary.BigEndian.Uint16(header[0:2])
+		length := int(binary.BigEndian.Uint16(header[2:4]))
+		value := r.bytes(length)
+		if value != nil {
+			tlvs[tag] = value
+		}
+	}
+	return tlvs
+}
+
+// submit_sm and deliver_sm share the same body layout
+type shortMessage struct {
+	serviceType        string
+	sourceTON          byte
+	sourceNPI          byte
+	sourceAddr         string
+	destTON            byte
+	destNPI            byte
+	destAddr           string
+	esmClass           byte
+	registeredDelivery byte
+	dataCoding         byte
+	message            []byte
+	tlvs               map[uint16][]byte
+}
+
+func (m *shortMessage) encode() []byte {
+	var w pduWriter
+	w.cstring(m.serviceType)
+	w.byte(m.sourceTON)
+	w.byte(m.sourceNPI)
+	w.cstring(m.sourceAddr)
+	w.byte(m.destTON)
+	w.byte(m.destNPI)
+	w.cstring(m.destAddr)
+	w.byte(m.esmClass)
+	// protocol_id, priority_flag
+	w.byte(0)
+	w.byte(0)
+	// schedule_delivery_time, validity_period
+	w.cstring("")
+	w.cstring("")
+	w.byte(m.registeredDelivery)
+	// replace_if_present_flag
+	w.byte(0)
+	w.byte(m.dataCoding)
+	// sm_default_msg_id
+	w.byte(0)
+	w.byte(byte(len(m.message)))
+	w.Write(m.message)
+	return w.Bytes()
+}
+
+func decodeShortMessage(body []byte) (*shortMessage, error) {
+	r := &pduReader{b: body}
+	m := &shortMessage{}
+	m.serviceType = r.cstring()
+	m.sourceTON = r.byte()
+	m.sourceNPI = r.byte()
+	m.sourceAddr = r.cstring()
+	m.destTON = r.byte()
+	m.destNPI = r.byte()
+	m.destAddr = r.cstring()
+	m.esmClass = r.byte()
+	r.byte()
+	r.byte()
+	r.cstring()
+	r.cstring()
+	m.registeredDelivery = r.byte()
+	r.byte()
+	m.dataCoding = r.byte()
+	r.byte()
+	length := int(r.byte())
+	m.message = r.bytes(length)
+	m.tlvs = r.tlvs()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return m, nil
+}
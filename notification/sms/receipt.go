@@ -0,0 +1,178 @@
+package sms
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// receipts which never arrived are forgotten after this time
+	receiptTimeout = time.Hour * 72
+	// receipt can arrive before submit_sm_resp is processed, such receipt is kept for a while
+	unmatchedReceiptTimeout = time.Minute * 5
+)
+
+// message states of receipted_message_id TLV
+var messageStates = map[byte]string{
+	1: "ENROUTE",
+	2: "DELIVRD",
+	3: "EXPIRED",
+	4: "DELETED",
+	5: "UNDELIV",
+	6: "ACCEPTD",
+	7: "UNKNOWN",
+	8: "REJECTD",
+}
+
+// submitted part waiting for delivery receipt
+type submittedPart struct {
+	number    string
+	part      int
+	parts     int
+	submitted time.Time
+}
+
+// receipt is parsed delivery receipt
+type receipt struct {
+	MessageID string
+	// DELIVRD, UNDELIV, EXPIRED, ...
+	State string
+	Error string
+
+	received time.Time
+}
+
+// Delivered reports whether the message reached the handset
+func (r *receipt) Delivered() bool {
+	return r.State == "DELIVRD"
+}
+
+// Final reports whether no other receipt will follow
+func (r *receipt) Final() bool {
+	return r.State != "ENROUTE" && r.State != "ACCEPTD" && r.State != ""
+}
+
+func (s *SMPP) trackReceipt(messageID string, number string, part int, parts int) {
+	s.Lock()
+	now := time.Now()
+	for id, p := range s.receipts {
+		if now.Sub(p.submitted) > receiptTimeout {
+			delete(s.receipts, id)
+		}
+	}
+	for id, r := range s.unmatched {
+		if now.Sub(r.received) > unmatchedReceiptTimeout {
+			delete(s.unmatched, id)
+		}
+	}
+	submitted := &submittedPart{number: number, part: part, parts: parts, submitted: now}
+	s.receipts[messageID] = submitted
+
+	var early []*receipt
+	for _, candidate := range receiptIDCandidates(messageID) {
+		if r, ok := s.unmatched[candidate]; ok {
+			early = append(early, r)
+			delete(s.unmatched, candidate)
+		}
+	}
+	s.Unlock()
+
+	for _, r := range early {
+		s.handleReceipt(r)
+	}
+}
+
+func (s *SMPP) handleDeliverSM(conn net.Conn, p *pdu) {
+	m, err := decodeShortMessage(p.body)
+	// deliver_sm must be always acknowledged, otherwise SMSC keeps resending it
+	s.respond(conn, cmdDeliverSMResp, p.seq, statusOK, []byte{0})
+	if err != nil {
+		s.conf.Logger.LogError(err, "failed to parse deliver_sm from %s", s.conf.Server)
+		return
+	}
+	if m.esmClass&esmClassReceiptMask != esmClassDeliveryRecpt {
+		s.conf.Logger.LogDebug("ignoring incoming SMS from %s", m.sourceAddr)
+		return
+	}
+	s.handleReceipt(parseReceipt(m))
+}
+
+func (s *SMPP) handleReceipt(r *receipt) {
+	s.Lock()
+	part, ok := s.lookupReceipt(r.MessageID)
+	if ok && r.Final() {
+		s.deleteReceipt(r.MessageID)
+	}
+	if !ok {
+		s.unmatched[r.MessageID] = r
+	}
+	s.Unlock()
+	if !ok {
+		s.conf.Logger.LogDebug("received SMS delivery receipt for unknown message id %s: %s", r.MessageID, r.State)
+		return
+	}
+
+	switch {
+	case r.Delivered():
+		s.conf.Logger.LogDebug("SMS part %d/%d to %s delivered after %s", part.part, part.parts, part.number, time.Since(part.submitted).Round(time.Second))
+	case r.Final():
+		s.conf.Logger.LogError(deliveryFailedError, "SMS part %d/%d to %s was not delivered: %s, error %s", part.part, part.parts, part.number, r.State, r.Error)
+	}
+}
+
+// SMSC may use hex message id in submit_sm_resp and decimal in receipt or vice versa
+func (s *SMPP) lookupReceipt(id string) (*submittedPart, bool) {
+	for _, candidate := range receiptIDCandidates(id) {
+		if p, ok := s.receipts[candidate]; ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func (s *SMPP) deleteReceipt(id string) {
+	for _, candidate := range receiptIDCandidates(id) {
+		delete(s.receipts, candidate)
+	}
+}
+
+func receiptIDCandidates(id string) []string {
+	candidates := []string{id}
+	if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+		candidates = append(candidates, strconv.FormatUint(n, 16), strings.ToUpper(strconv.FormatUint(n, 16)))
+	}
+	if n, err := strconv.ParseUint(id, 16, 64); err == nil {
+		candidates = append(candidates, strconv.FormatUint(n, 10))
+	}
+	return candidates
+}
+
+var receiptFieldRegexp = regexp.MustCompile(`(?i)(id|stat|err):(\S+)`)
+
+// receipt fields are in TLVs since SMPP 3.4, text in short message is de-facto standard of older SMSCs
+// id:IIIIIIIIII sub:SSS dlvrd:DDD submit date:YYMMDDhhmm done date:YYMMDDhhmm stat:DDDDDDD err:E text:...
+func parseReceipt(m *shortMessage) *receipt {
+	r := &receipt{received: time.Now()}
+	for _, match := range receiptFieldRegexp.FindAllStringSubmatch(string(m.message), -1) {
+		switch strings.ToLower(match[1]) {
+		case "id":
+			r.MessageID = match[2]
+		case "stat":
+			r.State = strings.ToUpper(match[2])
+		case "err":
+			r.Error = match[2]
+		}
+	}
+	if id, ok := m.tlvs[tagReceiptedMessageID]; ok {
+		r.MessageID = strings.TrimRight(string(id), "\x00")
+	}
+	if state, ok := m.tlvs[tagMessageState]; ok && len(state) == 1 {
+		if name, ok := messageStates[state[0]]; ok {
+			r.State = name
+		}
+	}
+	return r
+}
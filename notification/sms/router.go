@@ -0,0 +1,60 @@
+package sms
+
+import (
+	"strings"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/phonenumber"
+)
+
+type RouterConfig struct {
+	// used for countries without own route
+	Default Sender
+	// key is ISO 3166 country code
+	Routes map[string]Sender
+	Logger *exlogger.Logger
+}
+
+// Router sends SMS through provider selected by country of the number, numbers must be in E.164 format
+type Router struct {
+	defaultSender Sender
+	routes        map[string]Sender
+	logger        *exlogger.Logger
+}
+
+func NewRouter(conf RouterConfig) (*Router, error) {
+	if conf.Default == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Default must not be nil")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+	routes := map[string]Sender{}
+	for iso, sender := range conf.Routes {
+		if !phonenumber.ValidCountry(iso) {
+			return nil, errors.Wrapf(invalidConfigError, "conf.Routes has unsupported country %q", iso)
+		}
+		if sender == nil {
+			return nil, errors.Wrapf(invalidConfigError, "conf.Routes sender for %s must not be nil", iso)
+		}
+		routes[strings.ToUpper(iso)] = sender
+	}
+
+	r := &Router{
+		defaultSender: conf.Default,
+		routes:        routes,
+		logger:        conf.Logger,
+	}
+	return r, nil
+}
+
+func (r *Router) Send(number string, message string) error {
+	iso := phonenumber.Country(number)
+	if sender, ok := r.routes[iso]; ok {
+		r.logger.LogDebug("routing SMS to %s via %s route", number, iso)
+		return sender.Send(number, message)
+	}
+	return r.defaultSender.Send(number, message)
+}
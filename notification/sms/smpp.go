@@ -0,0 +1,471 @@
+package sms
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+)
+
+const (
+	smppDialTimeout = time.Second * 10
+	// how long is Send waiting for bound session before it gives up
+	smppBindWaitTimeout = time.Second * 30
+
+	// type of number and numbering plan indicator
+	tonUnknown       = 0x00
+	tonInternational = 0x01
+	tonAlphanumeric  = 0x05
+	npiUnknown       = 0x00
+	npiISDN          = 0x01
+)
+
+type SMPPConfig struct {
+	// host:port of SMSC
+	Server string
+	// use TLS for the connection, plain SMPP is unencrypted
+	TLS        bool
+	SystemID   string
+	Password   string
+	SystemType string
+	// sender shown to the recipient, alphanumeric sender is up to 11 characters
+	SourceAddr string
+	SourceTON  byte
+	SourceNPI  byte
+	// how often is the session checked with enquire_link
+	EnquireLinkInterval time.Duration
+	// how long to wait for response of single request
+	RequestTimeout time.Duration
+	// request delivery receipts from SMSC
+	DeliveryReceipts bool
+
+	Logger *exlogger.Logger
+}
+
+func NewSMPP(conf SMPPConfig) (*SMPP, error) {
+	if conf.Server == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.Server must not be empty")
+	}
+	if _, _, err := net.SplitHostPort(conf.Server); err != nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Server must be in host:port format")
+	}
+	if conf.SystemID == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.SystemID must not be empty")
+	}
+	// SMPP 3.4 limits
+	if len(conf.SystemID) > 15 || len(conf.Password) > 8 || len(conf.SystemType) > 12 {
+		return nil, errors.Wrap(invalidConfigError, "conf.SystemID, conf.Password or conf.SystemType is too long")
+	}
+	if conf.SourceAddr == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.SourceAddr must not be empty")
+	}
+	if conf.EnquireLinkInterval <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.EnquireLinkInterval must be positive duration")
+	}
+	if conf.RequestTimeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.RequestTimeout must be positive duration")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+
+	newSMPP := &SMPP{
+		conf:      conf,
+		pending:   map[uint32]chan *pdu{},
+		bound:     make(chan struct{}),
+		receipts:  map[string]*submittedPart{},
+		unmatched: map[string]*receipt{},
+		stop:      make(chan struct{}),
+	}
+	return newSMPP, nil
+}
+
+// SMPP is SMPP 3.4 transceiver client, single session is kept open and reconnected when it fails
+type SMPP struct {
+	conf SMPPConfig
+
+	// protects all fields below
+	sync.Mutex
+	conn net.Conn
+	// closed when session is bound, replaced when session is lost
+	bound   chan struct{}
+	pending map[uint32]chan *pdu
+	// submitted parts waiting for delivery receipt, key is message id
+	receipts map[string]*submittedPart
+	// receipts for message ids which are not known yet
+	unmatched map[string]*receipt
+	stopped   bool
+
+	writeLock sync.Mutex
+	seq       uint32
+	// reference number of concatenated messages
+	ref  uint32
+	stop chan struct{}
+}
+
+// Start connects to SMSC in background, connection is retried forever
+func (s *SMPP) Start() {
+	go s.run()
+}
+
+// Stop unbinds the session and stops reconnecting
+func (s *SMPP) Stop() {
+	s.Lock()
+	if s.stopped {
+		s.Unlock()
+		return
+	}
+	s.stopped = true
+	close(s.stop)
+	conn := s.conn
+	s.Unlock()
+
+	if conn != nil {
+		s.request(cmdUnbind, nil)
+		conn.Close()
+	}
+}
+
+func (s *SMPP) String() string {
+	return "smpp " + s.conf.Server
+}
+
+func (s *SMPP) run() {
+	b := &backoff.ExponentialBackOff{
+		InitialInterval:     time.Second,
+		RandomizationFactor: backoff.DefaultRandomizationFactor,
+		Multiplier:          backoff.DefaultMultiplier,
+		MaxInterval:         time.Minute * 2,
+		MaxElapsedTime:      0,
+		Clock:               backoff.SystemClock,
+	}
+	b.Reset()
+
+	for {
+		wasBound, err := s.session()
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		if wasBound {
+			b.Reset()
+		}
+		wait := b.NextBackOff()
+		s.conf.Logger.LogError(err, "SMPP session with %s failed, reconnecting in %s", s.conf.Server, wait.Round(time.Second))
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// single SMPP session, it returns when the connection is lost
+func (s *SMPP) session() (bool, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	err = s.bind(conn)
+	if err != nil {
+		return false, err
+	}
+	s.conf.Logger.Log("SMPP session bound to %s as %s", s.conf.Server, s.conf.SystemID)
+
+	s.Lock()
+	s.conn = conn
+	close(s.bound)
+	s.Unlock()
+
+	done := make(chan struct{})
+	go s.enquireLinkLoop(conn, done)
+
+	err = s.readLoop(conn)
+
+	close(done)
+	s.Lock()
+	s.conn = nil
+	s.bound = make(chan struct{})
+	// wake up all requests waiting for response
+	for seq, c := range s.pending {
+		close(c)
+		delete(s.pending, seq)
+	}
+	s.Unlock()
+	return true, err
+}
+
+func (s *SMPP) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: smppDialTimeout, KeepAlive: time.Minute}
+	if s.conf.TLS {
+		host, _, _ := net.SplitHostPort(s.conf.Server)
+		return tls.DialWithDialer(dialer, "tcp", s.conf.Server, &tls.Config{ServerName: host})
+	}
+	return dialer.Dial("tcp", s.conf.Server)
+}
+
+// bind is done synchronously before read loop is started
+func (s *SMPP) bind(conn net.Conn) error {
+	var w pduWriter
+	w.cstring(s.conf.SystemID)
+	w.cstring(s.conf.Password)
+	w.cstring(s.conf.SystemType)
+	w.byte(smppInterfaceVersion)
+	// addr_ton, addr_npi, address_range
+	w.byte(tonUnknown)
+	w.byte(npiUnknown)
+	w.cstring("")
+
+	conn.SetDeadline(time.Now().Add(s.conf.RequestTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	seq := s.nextSeq()
+	_, err := conn.Write((&pdu{commandID: cmdBindTransceiver, seq: seq, body: w.Bytes()}).bytes())
+	if err != nil {
+		return errors.Wrap(err, "failed to send bind_transceiver")
+	}
+	for {
+		resp, err := readPDU(conn)
+		if err != nil {
+			return errors.Wrap(err, "failed to read bind_transceiver_resp")
+		}
+		// SMSC may send enquire_link before bind response, it is answered later by read loop anyway
+		if resp.seq != seq {
+			continue
+		}
+		if resp.commandID != cmdBindTransceiverResp && resp.commandID != cmdGenericNack {
+			return errors.Wrapf(invalidPDUError, "unexpected response 0x%08x to bind_transceiver", resp.commandID)
+		}
+		if resp.status != statusOK {
+			return &StatusError{Command: "bind_transceiver", Status: resp.status}
+		}
+		return nil
+	}
+}
+
+func (s *SMPP) readLoop(conn net.Conn) error {
+	for {
+		p, err := readPDU(conn)
+		if err != nil {
+			return err
+		}
+
+		if p.commandID&cmdResponse != 0 {
+			s.Lock()
+			c, ok := s.pending[p.seq]
+			delete(s.pending, p.seq)
+			s.Unlock()
+			if ok {
+				c <- p
+			}
+			continue
+		}
+
+		switch p.commandID {
+		case cmdEnquireLink:
+			s.respond(conn, cmdEnquireLinkResp, p.seq, statusOK, nil)
+		case cmdDeliverSM:
+			s.handleDeliverSM(conn, p)
+		case cmdUnbind:
+			s.respond(conn, cmdUnbindResp, p.seq, statusOK, nil)
+			return errors.Wrap(connectionLostError, "SMSC unbound the session")
+		default:
+			s.respond(conn, cmdGenericNack, p.seq, statusInvalidCmdID, nil)
+		}
+	}
+}
+
+// connection is closed when enquire_link is not answered, so the read loop ends and session is reconnected
+func (s *SMPP) enquireLinkLoop(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(s.conf.EnquireLinkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, err := s.request(cmdEnquireLink, nil)
+			if err != nil {
+				s.conf.Logger.LogError(err, "SMPP enquire_link to %s failed, closing connection", s.conf.Server)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *SMPP) respond(conn net.Conn, commandID uint32, seq uint32, status uint32, body []byte) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(s.conf.RequestTimeout))
+	_, err := conn.Write((&pdu{commandID: commandID, status: status, seq: seq, body: body}).bytes())
+	if err != nil {
+		s.conf.Logger.LogError(err, "failed to send SMPP response 0x%08x", commandID)
+	}
+}
+
+// send request and wait for its response, request waits for bound session
+func (s *SMPP) request(commandID uint32, body []byte) (*pdu, error) {
+	s.Lock()
+	bound := s.bound
+	s.Unlock()
+	// bound session wins over stop, so Stop can still unbind it
+	select {
+	case <-bound:
+	default:
+		select {
+		case <-bound:
+		case <-s.stop:
+			return nil, notConnectedError
+		case <-time.After(smppBindWaitTimeout):
+			return nil, notConnectedError
+		}
+	}
+
+	seq := s.nextSeq()
+	c := make(chan *pdu, 1)
+	s.Lock()
+	conn := s.conn
+	if conn == nil {
+		s.Unlock()
+		return nil, notConnectedError
+	}
+	s.pending[seq] = c
+	s.Unlock()
+
+	s.writeLock.Lock()
+	conn.SetWriteDeadline(time.Now().Add(s.conf.RequestTimeout))
+	_, err := conn.Write((&pdu{commandID: commandID, seq: seq, body: body}).bytes())
+	s.writeLock.Unlock()
+	if err != nil {
+		s.forget(seq)
+		conn.Close()
+		return nil, errors.Wrap(connectionLostError, err.Error())
+	}
+
+	select {
+	case resp, ok := <-c:
+		if !ok {
+			return nil, connectionLostError
+		}
+		if resp.status != statusOK {
+			return resp, &StatusError{Command: commandName(commandID), Status: resp.status}
+		}
+		return resp, nil
+	case <-time.After(s.conf.RequestTimeout):
+		s.forget(seq)
+		return nil, requestTimeoutError
+	}
+}
+
+func (s *SMPP) forget(seq uint32) {
+	s.Lock()
+	delete(s.pending, seq)
+	s.Unlock()
+}
+
+// sequence numbers are 1..0x7FFFFFFF
+func (s *SMPP) nextSeq() uint32 {
+	return atomic.AddUint32(&s.seq, 1)%0x7fffffff + 1
+}
+
+// Send submits SMS, long messages are split into concatenated parts with UDH
+func (s *SMPP) Send(number string, message string) error {
+	segments, err := splitMessage(message)
+	if err != nil {
+		return err
+	}
+	ton, npi, addr := destinationAddress(number)
+	ref := byte(atomic.AddUint32(&s.ref, 1))
+
+	for i, seg := range segments {
+		m := &shortMessage{
+			serviceType: "",
+			sourceTON:   s.conf.SourceTON,
+			sourceNPI:   s.conf.SourceNPI,
+			sourceAddr:  s.conf.SourceAddr,
+			destTON:     ton,
+			destNPI:     npi,
+			destAddr:    addr,
+			dataCoding:  seg.dataCoding,
+			message:     seg.data,
+		}
+		if s.conf.DeliveryReceipts {
+			m.registeredDelivery = 0x01
+		}
+		if len(segments) > 1 {
+			// 8-bit reference concatenation header
+			udh := []byte{0x05, 0x00, 0x03, ref, byte(len(segments)), byte(i + 1)}
+			m.esmClass |= esmClassUDHI
+			m.message = append(udh, seg.data...)
+		}
+
+		resp, err := s.request(cmdSubmitSM, m.encode())
+		if err != nil {
+			return errors.Wrapf(err, "failed to submit part %d/%d of SMS to %s", i+1, len(segments), number)
+		}
+		messageID := (&pduReader{b: resp.body}).cstring()
+		if s.conf.DeliveryReceipts && messageID != "" {
+			s.trackReceipt(messageID, number, i+1, len(segments))
+		}
+		s.conf.Logger.LogDebug("submitted part %d/%d of SMS to %s, message id %s", i+1, len(segments), number, messageID)
+	}
+	return nil
+}
+
+// international numbers with leading + or 00 are sent as international type of number
+func destinationAddress(number string) (byte, byte, string) {
+	number = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '(' || r == ')' {
+			return -1
+		}
+		return r
+	}, number)
+	switch {
+	case strings.HasPrefix(number, "+"):
+		return tonInternational, npiISDN, number[1:]
+	case strings.HasPrefix(number, "00"):
+		return tonInternational, npiISDN, number[2:]
+	}
+	return tonUnknown, npiISDN, number
+}
+
+// StatusError is non-zero command status returned by SMSC
+type StatusError struct {
+	Command string
+	Status  uint32
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("SMSC returned status 0x%08x to %s", e.Status, e.Command)
+}
+
+// Temporary reports whether the request can succeed later, e.g. when SMSC is throttling
+func (e *StatusError) Temporary() bool {
+	return e.Status == statusThrottled || e.Status == statusMsgQueueFull || e.Status == statusSystemError
+}
+
+func commandName(commandID uint32) string {
+	switch commandID {
+	case cmdSubmitSM:
+		return "submit_sm"
+	case cmdEnquireLink:
+		return "enquire_link"
+	case cmdUnbind:
+		return "unbind"
+	case cmdBindTransceiver:
+		return "bind_transceiver"
+	}
+	return fmt.Sprintf("command 0x%08x", commandID)
+}
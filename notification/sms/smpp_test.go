@@ -0,0 +1,306 @@
+package sms
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+)
+
+// fakeSMSC is SMPP 3.4 server stand-in, it accepts bind_transceiver with password "secret"
+// and answers submit_sm with hex message id, delivery receipts use decimal id
+type fakeSMSC struct {
+	listener net.Listener
+	// send delivery receipt before submit_sm_resp
+	receiptFirst bool
+
+	mu           sync.Mutex
+	conn         net.Conn
+	binds        int
+	submits      []*shortMessage
+	enquireLinks int
+	unbinds      int
+	// command ids of responses sent by the client
+	responses []uint32
+	seq       uint32
+	writeMu   sync.Mutex
+}
+
+func newFakeSMSC(t *testing.T) *fakeSMSC {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMSC{listener: l}
+	go s.serve()
+	t.Cleanup(func() {
+		l.Close()
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		s.mu.Unlock()
+	})
+	return s
+}
+
+func (s *fakeSMSC) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conn = conn
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMSC) write(conn net.Conn, p *pdu) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	conn.Write(p.bytes())
+}
+
+func (s *fakeSMSC) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		p, err := readPDU(conn)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		switch p.commandID {
+		case cmdBindTransceiver:
+			s.binds++
+			r := &pduReader{b: p.body}
+			r.cstring()
+			status := uint32(statusOK)
+			if r.cstring() != "secret" {
+				status = statusInvalidPasswd
+			}
+			var w pduWriter
+			w.cstring("fakesmsc")
+			s.write(conn, &pdu{commandID: cmdBindTransceiverResp, status: status, seq: p.seq, body: w.Bytes()})
+		case cmdSubmitSM:
+			m, err := decodeShortMessage(p.body)
+			if err != nil {
+				s.write(conn, &pdu{commandID: cmdGenericNack, status: statusInvalidCmdID, seq: p.seq})
+				break
+			}
+			s.submits = append(s.submits, m)
+			id := 1000 + len(s.submits)
+			var w pduWriter
+			w.cstring(strconv.FormatInt(int64(id), 16))
+			resp := &pdu{commandID: cmdSubmitSMResp, seq: p.seq, body: w.Bytes()}
+			if !s.receiptFirst {
+				s.write(conn, resp)
+			}
+			if m.registeredDelivery != 0 {
+				s.seq++
+				receipt := &shortMessage{
+					esmClass: esmClassDeliveryRecpt,
+					message:  []byte("id:" + strconv.Itoa(id) + " sub:001 dlvrd:001 submit date:2310011200 done date:2310011201 stat:DELIVRD err:000 text:"),
+				}
+				s.write(conn, &pdu{commandID: cmdDeliverSM, seq: s.seq, body: receipt.encode()})
+			}
+			if s.receiptFirst {
+				s.write(conn, resp)
+			}
+		case cmdEnquireLink:
+			s.enquireLinks++
+			s.write(conn, &pdu{commandID: cmdEnquireLinkResp, seq: p.seq})
+		case cmdUnbind:
+			s.unbinds++
+			s.write(conn, &pdu{commandID: cmdUnbindResp, seq: p.seq})
+		default:
+			if p.commandID&cmdResponse != 0 {
+				s.responses = append(s.responses, p.commandID)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// send enquire_link to the client
+func (s *fakeSMSC) enquireLink() {
+	s.mu.Lock()
+	conn := s.conn
+	s.seq++
+	seq := s.seq
+	s.mu.Unlock()
+	s.write(conn, &pdu{commandID: cmdEnquireLink, seq: seq})
+}
+
+func newTestSMPP(t *testing.T, smsc *fakeSMSC, password string) *SMPP {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSMPP(SMPPConfig{
+		Server:              smsc.listener.Addr().String(),
+		SystemID:            "firefly",
+		Password:            password,
+		SourceAddr:          "Firefly",
+		SourceTON:           tonAlphanumeric,
+		EnquireLinkInterval: 50 * time.Millisecond,
+		RequestTimeout:      2 * time.Second,
+		DeliveryReceipts:    true,
+		Logger:              logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSMPPSubmitConcatenatedMessage(t *testing.T) {
+	smsc := newFakeSMSC(t)
+	s := newTestSMPP(t, smsc, "secret")
+
+	message := strings.Repeat("web server is down [500] ", 8)
+	if err := s.Send("+420 123-456-789", message); err != nil {
+		t.Fatal(err)
+	}
+
+	smsc.mu.Lock()
+	submits := smsc.submits
+	smsc.mu.Unlock()
+	if len(submits) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(submits))
+	}
+	var text []byte
+	for i, m := range submits {
+		if m.sourceAddr != "Firefly" || m.sourceTON != tonAlphanumeric {
+			t.Errorf("unexpected source %s ton %d", m.sourceAddr, m.sourceTON)
+		}
+		if m.destAddr != "420123456789" || m.destTON != tonInternational || m.destNPI != npiISDN {
+			t.Errorf("unexpected destination %s ton %d npi %d", m.destAddr, m.destTON, m.destNPI)
+		}
+		if m.esmClass&esmClassUDHI == 0 || m.registeredDelivery != 1 || m.dataCoding != dataCodingDefault {
+			t.Errorf("unexpected esm_class 0x%02x, registered_delivery %d, data_coding %d", m.esmClass, m.registeredDelivery, m.dataCoding)
+		}
+		udh := m.message[:6]
+		if udh[0] != 0x05 || udh[1] != 0x00 || udh[2] != 0x03 || udh[3] != submits[0].message[3] || udh[4] != 2 || udh[5] != byte(i+1) {
+			t.Errorf("unexpected UDH % x of part %d", udh, i+1)
+		}
+		if i == 0 && len(m.message)-6 != gsm7PartLength {
+			t.Errorf("expected first part with %d septets, got %d", gsm7PartLength, len(m.message)-6)
+		}
+		text = append(text, m.message[6:]...)
+	}
+	// extension characters are escaped
+	if want := joinChars(encodeGSM7Runes(message)); string(text) != string(want) {
+		t.Errorf("parts do not add up to the message")
+	}
+
+	// receipts for both parts are matched despite hex and decimal ids
+	waitFor(t, "delivery receipts", func() bool {
+		s.Lock()
+		defer s.Unlock()
+		return len(s.receipts) == 0 && len(s.unmatched) == 0
+	})
+	waitFor(t, "deliver_sm_resp", func() bool {
+		smsc.mu.Lock()
+		defer smsc.mu.Unlock()
+		return len(smsc.responses) == 2 && smsc.responses[0] == cmdDeliverSMResp
+	})
+}
+
+func TestSMPPReceiptBeforeSubmitResponse(t *testing.T) {
+	smsc := newFakeSMSC(t)
+	smsc.receiptFirst = true
+	s := newTestSMPP(t, smsc, "secret")
+
+	if err := s.Send("00420123456789", "čau"); err != nil {
+		t.Fatal(err)
+	}
+	smsc.mu.Lock()
+	m := smsc.submits[0]
+	smsc.mu.Unlock()
+	if m.dataCoding != dataCodingUCS2 || string(m.message) != "\x01\x0d\x00a\x00u" || m.esmClass&esmClassUDHI != 0 {
+		t.Fatalf("unexpected UCS-2 message % x with data_coding %d", m.message, m.dataCoding)
+	}
+	if m.destAddr != "420123456789" || m.destTON != tonInternational {
+		t.Fatalf("unexpected destination %s ton %d", m.destAddr, m.destTON)
+	}
+	waitFor(t, "early receipt to be matched", func() bool {
+		s.Lock()
+		defer s.Unlock()
+		return len(s.receipts) == 0 && len(s.unmatched) == 0
+	})
+}
+
+func TestSMPPEnquireLink(t *testing.T) {
+	smsc := newFakeSMSC(t)
+	newTestSMPP(t, smsc, "secret")
+
+	// client keeps the session alive
+	waitFor(t, "enquire_link from client", func() bool {
+		smsc.mu.Lock()
+		defer smsc.mu.Unlock()
+		return smsc.enquireLinks >= 2
+	})
+	// and answers enquire_link of SMSC
+	smsc.enquireLink()
+	waitFor(t, "enquire_link_resp", func() bool {
+		smsc.mu.Lock()
+		defer smsc.mu.Unlock()
+		return len(smsc.responses) == 1 && smsc.responses[0] == cmdEnquireLinkResp
+	})
+}
+
+func TestSMPPStopUnbinds(t *testing.T) {
+	smsc := newFakeSMSC(t)
+	s := newTestSMPP(t, smsc, "secret")
+	waitFor(t, "bound session", func() bool {
+		s.Lock()
+		defer s.Unlock()
+		return s.conn != nil
+	})
+	s.Stop()
+	smsc.mu.Lock()
+	defer smsc.mu.Unlock()
+	if smsc.unbinds != 1 {
+		t.Fatalf("expected unbind, got %d", smsc.unbinds)
+	}
+	waitFor(t, "closed session", func() bool {
+		s.Lock()
+		defer s.Unlock()
+		return s.conn == nil
+	})
+	if err := s.Send("+420123456789", "down"); errors.Cause(err) != notConnectedError {
+		t.Fatalf("expected not connected error after stop, got %v", err)
+	}
+}
+
+func TestSMPPBindFailure(t *testing.T) {
+	smsc := newFakeSMSC(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	go smsc.handle(server)
+
+	s := &SMPP{conf: SMPPConfig{SystemID: "firefly", Password: "wrong", RequestTimeout: time.Second}}
+	err := s.bind(client)
+	if e, ok := err.(*StatusError); !ok || e.Status != statusInvalidPasswd {
+		t.Fatalf("expected invalid password status, got %v", err)
+	}
+}
@@ -2,8 +2,20 @@ package sms
 
 import "fmt"
 
+// Sender delivers SMS to the phone number
+type Sender interface {
+	Send(number string, message string) error
+}
+
+// Send only prints the SMS, it is used when no SMS provider is configured
 func Send(number string, message string) error {
-	// TODO
-	fmt.Printf("<< Fake sms notification sent to %s\n", number)
+	fmt.Printf("<< Fake sms notification sent to %s\n %s\n", number, message)
 	return nil
 }
+
+// FakeSender prints SMS instead of sending them
+type FakeSender struct{}
+
+func (FakeSender) Send(number string, message string) error {
+	return Send(number, message)
+}
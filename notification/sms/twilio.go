@@ -0,0 +1,129 @@
+package sms
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+)
+
+const DefaultTwilioAPIURL = "https://api.twilio.com"
+
+// twilio error codes which can succeed later
+var twilioTemporaryCodes = map[int]bool{
+	// too many requests
+	20429: true,
+	// service unavailable
+	20503: true,
+	// queue overflow
+	30001: true,
+}
+
+type TwilioConfig struct {
+	// base URL of Twilio compatible Messages API
+	APIURL     string
+	AccountSID string
+	AuthToken  string
+	// sender number or alphanumeric sender, either From or MessagingServiceSID must be set
+	From                string
+	MessagingServiceSID string
+	// timeout of single API request
+	Timeout time.Duration
+	// how many times is temporary failure retried
+	Retries int
+
+	Logger *exlogger.Logger
+}
+
+// NewTwilio creates sender using Twilio compatible Messages API
+func NewTwilio(conf TwilioConfig) (*HTTPGateway, error) {
+	if conf.APIURL == "" {
+		conf.APIURL = DefaultTwilioAPIURL
+	}
+	if _, err := url.Parse(conf.APIURL); err != nil {
+		return nil, errors.Wrapf(invalidConfigError, "conf.APIURL is not valid URL: %s", err)
+	}
+	if conf.AccountSID == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.AccountSID must not be empty")
+	}
+	if conf.AuthToken == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.AuthToken must not be empty")
+	}
+	if conf.From == "" && conf.MessagingServiceSID == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.From or conf.MessagingServiceSID must be set")
+	}
+
+	return newGateway(&twilioAdapter{conf: conf}, conf.Timeout, conf.Retries, conf.Logger)
+}
+
+type twilioAdapter struct {
+	conf TwilioConfig
+}
+
+func (a *twilioAdapter) String() string {
+	return "Twilio API " + a.conf.APIURL
+}
+
+func (a *twilioAdapter) request(number string, message string) (*http.Request, error) {
+	form := url.Values{}
+	form.Set("To", number)
+	form.Set("Body", message)
+	if a.conf.MessagingServiceSID != "" {
+		form.Set("MessagingServiceSid", a.conf.MessagingServiceSID)
+	} else {
+		form.Set("From", a.conf.From)
+	}
+
+	endpoint := strings.TrimRight(a.conf.APIURL, "/") + "/2010-04-01/Accounts/" + url.PathEscape(a.conf.AccountSID) + "/Messages.json"
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(a.conf.AccountSID, a.conf.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+type twilioResponse struct {
+	SID string `json:"sid"`
+	// error response
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// message resource can carry error when it failed right away
+	ErrorCode    *int   `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+}
+
+func (a *twilioAdapter) parseResponse(resp *http.Response, body []byte) (string, error) {
+	var r twilioResponse
+	// error pages of proxies in front of the API are not json, status code is enough then
+	_ = json.Unmarshal(body, &r)
+
+	code, message := r.Code, r.Message
+	if r.ErrorCode != nil && *r.ErrorCode != 0 {
+		code, message = *r.ErrorCode, r.ErrorMessage
+	}
+	if resp.StatusCode/100 == 2 && code == 0 && r.SID != "" {
+		return r.SID, nil
+	}
+
+	e := &GatewayError{
+		Gateway:    a.String(),
+		StatusCode: resp.StatusCode,
+		Message:    message,
+		temporary:  isTemporaryHTTPStatus(resp.StatusCode) || twilioTemporaryCodes[code],
+	}
+	if code != 0 {
+		e.Code = strconv.Itoa(code)
+	}
+	if e.Message == "" && resp.StatusCode/100 == 2 {
+		e.Message = "response does not contain message sid"
+	}
+	return "", e
+}
@@ -0,0 +1,173 @@
+package teams
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/exmonitor/firefly/notification/action"
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+// version supported by Teams clients on all platforms
+const adaptiveCardVersion = "1.4"
+
+// message is accepted by incoming webhooks and by Workflows webhook triggers
+type message struct {
+	Type        string       `json:"type"`
+	Attachments []attachment `json:"attachments"`
+}
+
+type attachment struct {
+	ContentType string       `json:"contentType"`
+	ContentURL  *string      `json:"contentUrl"`
+	Content     adaptiveCard `json:"content"`
+}
+
+type adaptiveCard struct {
+	Schema  string        `json:"$schema"`
+	Type    string        `json:"type"`
+	Version string        `json:"version"`
+	Body    []element     `json:"body"`
+	Actions []cardAction  `json:"actions,omitempty"`
+	MSTeams *msTeamsProps `json:"msteams,omitempty"`
+}
+
+type msTeamsProps struct {
+	Width string `json:"width"`
+}
+
+// element is TextBlock or FactSet
+type element struct {
+	Type   string `json:"type"`
+	Text   string `json:"text,omitempty"`
+	Size   string `json:"size,omitempty"`
+	Weight string `json:"weight,omitempty"`
+	Color  string `json:"color,omitempty"`
+	Wrap   bool   `json:"wrap,omitempty"`
+	Facts  []fact `json:"facts,omitempty"`
+	// muted text
+	IsSubtle bool `json:"isSubtle,omitempty"`
+}
+
+type fact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+type cardAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// Card holds everything shown in the card
+type Card struct {
+	Payload       *payload.Payload
+	IncidentID    string
+	IncidentStart time.Time
+	// buttons are shown only when links are set
+	AckURL          string
+	SilenceURL      string
+	SilenceDuration time.Duration
+}
+
+// Render returns json body of the webhook request with Adaptive Card
+func Render(c Card) ([]byte, error) {
+	return json.Marshal(newMessage(c))
+}
+
+func newMessage(c Card) message {
+	p := c.Payload
+	color := "good"
+	title := fmt.Sprintf("%s: %s check of %s has recovered", p.Status, p.ServiceType, p.Host)
+	if p.Failed {
+		color = "attention"
+		title = fmt.Sprintf("%s: %s check of %s has failed", p.Status, p.ServiceType, p.Host)
+	}
+
+	var facts []fact
+	add := func(title string, value string) {
+		if value != "" {
+			facts = append(facts, fact{Title: title, Value: value})
+		}
+	}
+	add("Host", p.Host)
+	add("Target", target(p))
+	add("Type", p.ServiceType)
+	add("Port", port(p))
+	if p.Failed {
+		add("Reason", reason(p))
+	} else {
+		add("Response time", payload.FormatDuration(p.ResponseTime))
+	}
+	if !c.IncidentStart.IsZero() {
+		add("Started", c.IncidentStart.UTC().Format("2006-01-02 15:04:05 MST"))
+	}
+
+	card := adaptiveCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: adaptiveCardVersion,
+		Body: []element{
+			{Type: "TextBlock", Text: title, Size: "Medium", Weight: "Bolder", Color: color, Wrap: true},
+			{Type: "FactSet", Facts: facts},
+		},
+		MSTeams: &msTeamsProps{Width: "Full"},
+	}
+	if c.IncidentID != "" {
+		card.Body = append(card.Body, element{Type: "TextBlock", Text: "Incident " + c.IncidentID, IsSubtle: true, Size: "Small", Wrap: true})
+	}
+	// resolved incident has nothing to acknowledge
+	if p.Failed {
+		if c.AckURL != "" {
+			card.Actions = append(card.Actions, cardAction{Type: "Action.OpenUrl", Title: "Acknowledge", URL: c.AckURL})
+		}
+		if c.SilenceURL != "" {
+			card.Actions = append(card.Actions, cardAction{Type: "Action.OpenUrl", Title: "Silence for " + action.FormatDuration(c.SilenceDuration), URL: c.SilenceURL})
+		}
+	}
+
+	return message{
+		Type: "message",
+		Attachments: []attachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content:     card,
+		}},
+	}
+}
+
+func target(p *payload.Payload) string {
+	switch {
+	case p.HTTP != nil:
+		return p.HTTP.URL
+	case p.TCP != nil:
+		return p.TCP.Address
+	}
+	return p.Target
+}
+
+// port of TCP checks or explicit port of HTTP checks
+func port(p *payload.Payload) string {
+	if p.HTTP != nil {
+		if u, err := url.Parse(p.HTTP.URL); err == nil {
+			return u.Port()
+		}
+	}
+	return p.Port()
+}
+
+func reason(p *payload.Payload) string {
+	switch {
+	case p.HTTP != nil && p.HTTP.ActualStatus > 0:
+		s := fmt.Sprintf("status %d", p.HTTP.ActualStatus)
+		if p.HTTP.ExpectedStatus != "" {
+			s += fmt.Sprintf(", expected %s", p.HTTP.ExpectedStatus)
+		}
+		return s
+	case p.ICMP != nil && p.ICMP.PacketLoss >= 0:
+		return fmt.Sprintf("packet loss %g%%", p.ICMP.PacketLoss)
+	}
+	return p.FailMessage
+}
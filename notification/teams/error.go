@@ -0,0 +1,7 @@
+package teams
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var invalidTargetError error = errors.New("invalid Teams webhook URL")
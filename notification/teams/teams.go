@@ -0,0 +1,155 @@
+package teams
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/action"
+	"github.com/exmonitor/firefly/notification/internal/retry"
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+const (
+	maxResponseSize = 4 * 1024
+)
+
+type Config struct {
+	// timeout of single request
+	Timeout time.Duration
+	// how many times is temporary failure retried
+	Retries int
+	// acknowledge and silence buttons are added when set
+	ActionLinks *action.Links
+
+	Logger *exlogger.Logger
+}
+
+// Notification is single notification of Teams contact
+type Notification struct {
+	NotificationID int
+	IncidentID     string
+	IncidentStart  time.Time
+	Payload        *payload.Payload
+}
+
+// Client posts Adaptive Cards to Teams incoming webhook or Workflows URL, which is the contact target
+type Client struct {
+	conf   Config
+	client *http.Client
+}
+
+func New(conf Config) (*Client, error) {
+	if conf.Timeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Timeout must be positive duration")
+	}
+	if conf.Retries < 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Retries must not be negative")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+
+	c := &Client{
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout},
+	}
+	return c, nil
+}
+
+func (c *Client) Send(target string, n Notification) error {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.Wrap(invalidTargetError, "webhook URL must be absolute https URL")
+	}
+	if n.Payload == nil {
+		return errors.Wrap(invalidTargetError, "notification has no payload")
+	}
+	card := Card{
+		Payload:         n.Payload,
+		IncidentID:      n.IncidentID,
+		IncidentStart:   n.IncidentStart,
+		SilenceDuration: c.conf.ActionLinks.SilenceDuration(),
+	}
+	card.AckURL, card.SilenceURL = c.conf.ActionLinks.URLs(n.Payload.ServiceID, n.NotificationID, n.IncidentID)
+	body, err := Render(card)
+	if err != nil {
+		return errors.Wrap(err, "failed to render Teams card")
+	}
+
+	send := func() error {
+		err := c.send(target, body)
+		if err != nil && !isTemporaryError(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	notify := func(err error, next time.Duration) {
+		c.conf.Logger.LogError(err, "failed to send Teams card to %s, retrying in %s", u.Host, next.Round(time.Millisecond))
+	}
+	err = backoff.RetryNotify(send, retry.New(c.conf.Retries), notify)
+	if permanent, ok := err.(*backoff.PermanentError); ok {
+		err = permanent.Err
+	}
+	return err
+}
+
+func (c *Client) send(target string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(invalidTargetError, "failed to create Teams request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Teams request failed")
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	text := strings.TrimSpace(string(respBody))
+
+	// legacy connectors answer 200 also when Teams throttled the message, the error is only in the body
+	if resp.StatusCode/100 == 2 && !strings.Contains(text, "HTTP error 429") {
+		return nil
+	}
+	e := &StatusError{StatusCode: resp.StatusCode, Body: text}
+	if resp.StatusCode/100 == 2 {
+		e.StatusCode = http.StatusTooManyRequests
+	}
+	return e
+}
+
+// network failures, throttling and server side errors can succeed later
+func isTemporaryError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *StatusError:
+		return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// StatusError is failure returned by Teams
+type StatusError struct {
+	StatusCode int
+	// beginning of the response body
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("Teams returned HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Teams returned HTTP %d: %s", e.StatusCode, e.Body)
+}
@@ -0,0 +1,237 @@
+package teams
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/action"
+	"github.com/exmonitor/firefly/notification/payload"
+	"github.com/exmonitor/firefly/service/state"
+)
+
+// go test ./notification/teams/ -update rewrites golden files after intended card changes
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+func testLogger(t *testing.T) *exlogger.Logger {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger
+}
+
+var testIncidentStart = time.Date(2024, 3, 5, 14, 30, 0, 0, time.FixedZone("CET", 3600))
+
+func TestRenderGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		card Card
+	}{
+		{
+			name: "http_failed",
+			card: Card{
+				Payload: &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http", Target: "example.com",
+					FailMessage: "unexpected status 503", HTTP: &payload.HTTP{URL: "https://example.com:8443/health", ActualStatus: 503, ExpectedStatus: "200-299"}},
+				IncidentID:      "7-1709645400",
+				IncidentStart:   testIncidentStart,
+				AckURL:          "https://firefly.example.com/incident/ack?sig=a",
+				SilenceURL:      "https://firefly.example.com/incident/silence?sig=s",
+				SilenceDuration: time.Hour,
+			},
+		},
+		{
+			name: "http_resolved",
+			card: Card{
+				Payload: &payload.Payload{ServiceID: 7, Status: payload.StatusResolved, Host: "web1", ServiceType: "http", Target: "example.com",
+					ResponseTime: 180 * time.Millisecond, HTTP: &payload.HTTP{URL: "https://example.com/health"}},
+				IncidentID:    "7-1709645400",
+				IncidentStart: testIncidentStart,
+				// resolved incident has no buttons
+				AckURL:          "https://firefly.example.com/incident/ack?sig=a",
+				SilenceURL:      "https://firefly.example.com/incident/silence?sig=s",
+				SilenceDuration: time.Hour,
+			},
+		},
+		{
+			name: "tcp_failed_without_links",
+			card: Card{
+				Payload: &payload.Payload{ServiceID: 8, Failed: true, Status: payload.StatusFailed, Host: "db1", ServiceType: "tcp", Target: "10.0.0.5",
+					FailMessage: "dial tcp 10.0.0.5:5432: connect: connection refused", TCP: &payload.TCP{Address: "10.0.0.5:5432", ErrorClass: payload.ErrorClassRefused}},
+				IncidentID: "8-1709645400",
+			},
+		},
+		{
+			name: "icmp_failed",
+			card: Card{
+				Payload: &payload.Payload{ServiceID: 9, Failed: true, Status: payload.StatusFailed, Host: "gw1", ServiceType: "icmp", Target: "192.0.2.1",
+					FailMessage: "packet loss", ICMP: &payload.ICMP{PacketCount: 5, PacketLoss: 60}},
+				IncidentStart:   testIncidentStart,
+				AckURL:          "https://firefly.example.com/incident/ack?sig=a",
+				SilenceDuration: 30 * time.Minute,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := Render(tt.card)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			if err := json.Indent(&got, body, "", "  "); err != nil {
+				t.Fatal(err)
+			}
+			got.WriteByte('\n')
+
+			golden := filepath.Join("testdata", tt.name+".json")
+			if *update {
+				if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Fatalf("card differs from %s, run with -update if the change is intended\ngot:\n%s", golden, got.Bytes())
+			}
+		})
+	}
+}
+
+// fakeWebhook answers with the statuses and bodies in order, the last one is repeated
+type fakeWebhook struct {
+	mu       sync.Mutex
+	bodies   [][]byte
+	statuses []int
+	replies  []string
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := len(f.bodies)
+	if i >= len(f.statuses) {
+		i = len(f.statuses) - 1
+	}
+	f.bodies = append(f.bodies, body)
+	w.WriteHeader(f.statuses[i])
+	w.Write([]byte(f.replies[i]))
+}
+
+func (f *fakeWebhook) received() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]byte(nil), f.bodies...)
+}
+
+func newFakeWebhook(t *testing.T, statuses []int, replies []string) (*fakeWebhook, *httptest.Server) {
+	f := &fakeWebhook{statuses: statuses, replies: replies}
+	srv := httptest.NewTLSServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func newTestClient(t *testing.T, srv *httptest.Server, retries int, links *action.Links) *Client {
+	c, err := New(Config{Timeout: time.Second, Retries: retries, ActionLinks: links, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.client = srv.Client()
+	return c
+}
+
+func TestSendDeliversCard(t *testing.T) {
+	f, srv := newFakeWebhook(t, []int{http.StatusAccepted}, []string{""})
+	links, err := action.New(action.Config{PublicURL: "https://firefly.example.com", Secret: "0123456789abcdef", SilenceDuration: time.Hour,
+		IncidentActionChan: make(chan state.IncidentAction, 1), Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, srv, 0, links)
+
+	p := &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http", HTTP: &payload.HTTP{URL: "https://example.com"}}
+	if err := c.Send(srv.URL+"/webhookb2/abc", Notification{NotificationID: 3, IncidentID: "7-1", Payload: p}); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := f.received()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(bodies))
+	}
+	var m message
+	if err := json.Unmarshal(bodies[0], &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != "message" || len(m.Attachments) != 1 || m.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" {
+		t.Fatalf("unexpected message %+v", m)
+	}
+	ackURL, silenceURL := links.URLs(7, 3, "7-1")
+	actions := m.Attachments[0].Content.Actions
+	if len(actions) != 2 || actions[0].URL != ackURL || actions[1].URL != silenceURL || actions[1].Title != "Silence for 1h" {
+		t.Fatalf("expected signed action links, got %+v", actions)
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		replies      []string
+		retries      int
+		wantRequests int
+		wantStatus   int
+	}{
+		{name: "throttled connector with HTTP 200 is retried", statuses: []int{http.StatusOK, http.StatusOK}, replies: []string{"Microsoft Teams endpoint returned HTTP error 429", "1"}, retries: 1, wantRequests: 2},
+		{name: "server error is retried", statuses: []int{http.StatusBadGateway, http.StatusOK}, replies: []string{"", "1"}, retries: 2, wantRequests: 2},
+		{name: "retries run out", statuses: []int{http.StatusOK}, replies: []string{"HTTP error 429"}, retries: 1, wantRequests: 2, wantStatus: http.StatusTooManyRequests},
+		{name: "removed webhook is not retried", statuses: []int{http.StatusNotFound}, replies: []string{"Webhook not found"}, retries: 3, wantRequests: 1, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, srv := newFakeWebhook(t, tt.statuses, tt.replies)
+			c := newTestClient(t, srv, tt.retries, nil)
+			p := &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http"}
+			err := c.Send(srv.URL, Notification{NotificationID: 3, IncidentID: "7-1", Payload: p})
+			if got := len(f.received()); got != tt.wantRequests {
+				t.Fatalf("expected %d requests, got %d", tt.wantRequests, got)
+			}
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if e, ok := errors.Cause(err).(*StatusError); !ok || e.StatusCode != tt.wantStatus {
+				t.Fatalf("expected HTTP %d, got %v", tt.wantStatus, err)
+			}
+		})
+	}
+}
+
+func TestSendRequiresHTTPS(t *testing.T) {
+	f, srv := newFakeWebhook(t, []int{http.StatusOK}, []string{"1"})
+	c := newTestClient(t, srv, 0, nil)
+	p := &payload.Payload{ServiceID: 7, Failed: true}
+	for _, target := range []string{"http://example.com/webhook", "example.com/webhook", ""} {
+		if err := c.Send(target, Notification{Payload: p}); errors.Cause(err) != invalidTargetError {
+			t.Errorf("%q: expected invalid target, got %v", target, err)
+		}
+	}
+	if len(f.received()) != 0 {
+		t.Fatal("invalid targets must not be requested")
+	}
+}
@@ -0,0 +1,11 @@
+package telegram
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var invalidChatError error = errors.New("invalid Telegram chat ID")
+
+var invalidCallbackError error = errors.New("invalid Telegram callback data")
+
+var incidentActionDroppedError error = errors.New("incident action queue is full")
@@ -0,0 +1,62 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+// characters with special meaning in MarkdownV2, they must be escaped everywhere outside of code
+const markdownSpecial = "_*[]()~`>#+-=|{}.!\\"
+
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(markdownSpecial, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// inside code blocks only backtick and backslash are escaped
+func escapeCode(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(s)
+}
+
+// alert text in MarkdownV2, e.g.
+// *CRITICAL* tcp check of *myhost* has failed
+// Address: myhost:22
+func alertText(p *payload.Payload, incidentID string, incidentStart time.Time) string {
+	var lines []string
+	if p.Failed {
+		lines = append(lines, fmt.Sprintf("\U0001F534 *%s* %s check of *%s* has failed", escape(p.Status), escape(p.ServiceType), escape(p.Host)))
+	} else {
+		lines = append(lines, fmt.Sprintf("\U0001F7E2 *%s* %s check of *%s* has recovered", escape(p.Status), escape(p.ServiceType), escape(p.Host)))
+	}
+	lines = append(lines, "")
+	if p.Target != "" && p.Target != p.Host {
+		lines = append(lines, "Target: "+escape(p.Target))
+	}
+	for _, f := range p.Fields() {
+		lines = append(lines, escape(f.Name)+": "+escape(f.Value))
+	}
+	if p.Failed && p.FailMessage != "" {
+		lines = append(lines, "```\n"+escapeCode(p.FailMessage)+"\n```")
+	}
+	if incidentID != "" {
+		info := "Incident " + incidentID
+		if !incidentStart.IsZero() {
+			if p.Failed {
+				info += ", started " + incidentStart.UTC().Format("2006-01-02 15:04 MST")
+			} else {
+				info += ", lasted " + time.Since(incidentStart).Round(time.Second).String()
+			}
+		}
+		lines = append(lines, "_"+escape(info)+"_")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,334 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/action"
+	"github.com/exmonitor/firefly/notification/internal/retry"
+	"github.com/exmonitor/firefly/notification/payload"
+	"github.com/exmonitor/firefly/service/state"
+)
+
+const (
+	DefaultAPIURL = "https://api.telegram.org"
+
+	maxResponseSize = 1024 * 1024
+	// pause after failed getUpdates, so broken network does not spin the poller
+	pollErrorPause = time.Second * 5
+
+	callbackAck     = "a"
+	callbackSilence = "s"
+)
+
+type Config struct {
+	// base URL of Bot API, DefaultAPIURL when empty
+	APIURL string
+	Token  string
+	// timeout of single request, long polling requests take PollTimeout longer
+	Timeout time.Duration
+	// how many times is temporary failure of sendMessage retried
+	Retries int
+	// how long is getUpdates request held by Telegram when there are no updates
+	PollTimeout time.Duration
+	// how long are FAIL notifications suppressed after Silence button is pressed
+	SilenceDuration time.Duration
+	// button presses are sent to services, buttons are not shown when nil
+	IncidentActionChan chan state.IncidentAction
+
+	Logger *exlogger.Logger
+}
+
+// Notification is single notification of Telegram contact
+type Notification struct {
+	IncidentID    string
+	IncidentStart time.Time
+	Payload       *payload.Payload
+}
+
+// Bot sends alerts via Bot API sendMessage, contact target is chat ID
+// presses of inline buttons are received by long polling getUpdates
+type Bot struct {
+	conf   Config
+	client *http.Client
+	// getUpdates is held by Telegram, so it has longer timeout
+	pollClient *http.Client
+	offset     int64
+	// cancels requests on Stop
+	ctx    context.Context
+	cancel context.CancelFunc
+	// closed when poller exits
+	done chan struct{}
+}
+
+func New(conf Config) (*Bot, error) {
+	if conf.APIURL == "" {
+		conf.APIURL = DefaultAPIURL
+	}
+	if conf.Token == "" {
+		return nil, errors.Wrap(invalidConfigError, "conf.Token must not be empty")
+	}
+	if conf.Timeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Timeout must be positive duration")
+	}
+	if conf.Retries < 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Retries must not be negative")
+	}
+	if conf.IncidentActionChan != nil && conf.PollTimeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.PollTimeout must be positive duration")
+	}
+	if conf.IncidentActionChan != nil && conf.SilenceDuration <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.SilenceDuration must be positive duration")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+	conf.APIURL = strings.TrimSuffix(conf.APIURL, "/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bot{
+		conf:       conf,
+		client:     &http.Client{Timeout: conf.Timeout},
+		pollClient: &http.Client{Timeout: conf.Timeout + conf.PollTimeout},
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	return b, nil
+}
+
+// Start polls button presses in background, it does nothing when buttons are disabled
+func (b *Bot) Start() {
+	if b.conf.IncidentActionChan == nil {
+		close(b.done)
+		return
+	}
+	go b.poll()
+}
+
+// Stop cancels running requests and waits until the poller exits
+func (b *Bot) Stop() {
+	b.cancel()
+	<-b.done
+}
+
+// Send posts the alert to the chat, FAIL alerts get Acknowledge and Silence buttons
+func (b *Bot) Send(chatID string, n Notification) error {
+	if _, err := strconv.ParseInt(chatID, 10, 64); err != nil && !strings.HasPrefix(chatID, "@") {
+		return errors.Wrapf(invalidChatError, "%q must be numeric chat ID or @channel", chatID)
+	}
+	if n.Payload == nil {
+		return errors.Wrap(invalidChatError, "notification has no payload")
+	}
+	m := sendMessage{
+		ChatID:    chatID,
+		Text:      alertText(n.Payload, n.IncidentID, n.IncidentStart),
+		ParseMode: "MarkdownV2",
+	}
+	if n.Payload.Failed && n.IncidentID != "" && b.conf.IncidentActionChan != nil {
+		m.ReplyMarkup = &inlineKeyboard{Buttons: [][]button{{
+			{Text: "Acknowledge", CallbackData: callbackData(callbackAck, n.Payload.ServiceID, n.IncidentID)},
+			{Text: "Silence " + action.FormatDuration(b.conf.SilenceDuration), CallbackData: callbackData(callbackSilence, n.Payload.ServiceID, n.IncidentID)},
+		}}}
+	}
+
+	send := func() error {
+		err := b.call(b.client, "sendMessage", m, nil)
+		if err != nil && !isTemporaryError(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	notify := func(err error, next time.Duration) {
+		b.conf.Logger.LogError(err, "failed to send Telegram message to chat %s, retrying in %s", chatID, next.Round(time.Millisecond))
+	}
+	err := backoff.RetryNotify(send, retry.New(b.conf.Retries), notify)
+	if permanent, ok := err.(*backoff.PermanentError); ok {
+		err = permanent.Err
+	}
+	return err
+}
+
+// callback data is limited to 64 bytes, incident id is short enough
+func callbackData(kind string, serviceID int, incidentID string) string {
+	return kind + "|" + strconv.Itoa(serviceID) + "|" + incidentID
+}
+
+func parseCallbackData(data string) (string, int, string, error) {
+	parts := strings.SplitN(data, "|", 3)
+	if len(parts) != 3 || (parts[0] != callbackAck && parts[0] != callbackSilence) {
+		return "", 0, "", errors.Wrapf(invalidCallbackError, "%q", data)
+	}
+	serviceID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, "", errors.Wrapf(invalidCallbackError, "%q has invalid service id", data)
+	}
+	return parts[0], serviceID, parts[2], nil
+}
+
+func (b *Bot) poll() {
+	defer close(b.done)
+	b.conf.Logger.Log("Telegram bot started polling button presses")
+	for {
+		if b.ctx.Err() != nil {
+			return
+		}
+
+		var updates []update
+		req := getUpdates{
+			Offset:         b.offset,
+			Timeout:        int(b.conf.PollTimeout / time.Second),
+			AllowedUpdates: []string{"callback_query"},
+		}
+		err := b.call(b.pollClient, "getUpdates", req, &updates)
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			b.conf.Logger.LogError(err, "failed to poll Telegram updates")
+			select {
+			case <-b.ctx.Done():
+				return
+			case <-time.After(pollErrorPause):
+			}
+			continue
+		}
+		for _, u := range updates {
+			// confirmed updates are not returned again
+			if u.UpdateID >= b.offset {
+				b.offset = u.UpdateID + 1
+			}
+			if u.CallbackQuery != nil {
+				b.handleCallback(u.CallbackQuery)
+			}
+		}
+	}
+}
+
+func (b *Bot) handleCallback(q *callbackQuery) {
+	kind, serviceID, incidentID, err := parseCallbackData(q.Data)
+	if err != nil {
+		b.conf.Logger.LogError(err, "ignoring Telegram button press of %s", q.From.name())
+		b.answer(q.ID, "Unknown button")
+		return
+	}
+	incidentAction := state.IncidentAction{
+		ServiceID:  serviceID,
+		IncidentID: incidentID,
+		By:         "Telegram " + q.From.name(),
+	}
+	var reply string
+	if kind == callbackAck {
+		incidentAction.Acknowledge = true
+		reply = "Acknowledged by " + q.From.name()
+	} else {
+		incidentAction.SilenceUntil = time.Now().Add(b.conf.SilenceDuration)
+		reply = fmt.Sprintf("Silenced for %s by %s", action.FormatDuration(b.conf.SilenceDuration), q.From.name())
+	}
+	if !state.SendIncidentAction(b.conf.IncidentActionChan, incidentAction) {
+		b.conf.Logger.LogError(incidentActionDroppedError, "dropped Telegram button %s pressed by %s for incident %s", kind, q.From.name(), incidentID)
+		// buttons are kept, so the press can be repeated
+		b.answer(q.ID, "Busy, please try again")
+		return
+	}
+	b.conf.Logger.Log("Telegram button %s pressed by %s for incident %s", kind, q.From.name(), incidentID)
+	b.answer(q.ID, reply)
+
+	if q.Message == nil {
+		return
+	}
+	// buttons are removed, so the incident is not acknowledged twice, and the chat sees who acted
+	edit := editMessageReplyMarkup{ChatID: q.Message.Chat.ID, MessageID: q.Message.MessageID, ReplyMarkup: &inlineKeyboard{Buttons: [][]button{}}}
+	if err := b.call(b.client, "editMessageReplyMarkup", edit, nil); err != nil {
+		b.conf.Logger.LogError(err, "failed to remove Telegram buttons of incident %s", incidentID)
+	}
+	m := sendMessage{ChatID: strconv.FormatInt(q.Message.Chat.ID, 10), Text: reply, ReplyToMessageID: q.Message.MessageID}
+	if err := b.call(b.client, "sendMessage", m, nil); err != nil {
+		b.conf.Logger.LogError(err, "failed to confirm Telegram button press of incident %s", incidentID)
+	}
+}
+
+// answer stops the loading indicator of the button and shows the text to the user
+func (b *Bot) answer(queryID string, text string) {
+	err := b.call(b.client, "answerCallbackQuery", answerCallbackQuery{CallbackQueryID: queryID, Text: text}, nil)
+	if err != nil {
+		b.conf.Logger.LogError(err, "failed to answer Telegram callback query")
+	}
+}
+
+// call Bot API method, result is decoded into result when it is not nil
+func (b *Bot) call(client *http.Client, method string, params interface{}, result interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode Telegram %s", method)
+	}
+	req, err := http.NewRequest(http.MethodPost, b.conf.APIURL+"/bot"+b.conf.Token+"/"+method, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrapf(invalidConfigError, "failed to create Telegram request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req.WithContext(b.ctx))
+	if err != nil {
+		// url error contains the token
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return errors.Wrapf(err, "Telegram %s failed", method)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return errors.Wrapf(err, "failed to read Telegram %s response", method)
+	}
+
+	var r apiResponse
+	if err := json.Unmarshal(body, &r); err != nil || !r.OK {
+		return &APIError{Method: method, StatusCode: resp.StatusCode, Description: r.Description}
+	}
+	if result != nil {
+		if err := json.Unmarshal(r.Result, result); err != nil {
+			return errors.Wrapf(err, "failed to parse Telegram %s result", method)
+		}
+	}
+	return nil
+}
+
+// network failures, flood limits and server side errors can succeed later
+func isTemporaryError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *APIError:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// APIError is failure reported by Bot API
+type APIError struct {
+	Method      string
+	StatusCode  int
+	Description string
+}
+
+func (e *APIError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("Telegram %s returned HTTP %d", e.Method, e.StatusCode)
+	}
+	return fmt.Sprintf("Telegram %s failed: %s (HTTP %d)", e.Method, e.Description, e.StatusCode)
+}
@@ -0,0 +1,338 @@
+package telegram
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/payload"
+	"github.com/exmonitor/firefly/service/state"
+)
+
+const testToken = "123456:test-token"
+
+// botCall is request received by fake Bot API
+type botCall struct {
+	method string
+	params map[string]interface{}
+}
+
+// fakeBotAPI answers Bot API methods, getUpdates returns queued batches and then empty results
+// errors holds HTTP statuses of failures returned by the next sendMessage calls
+type fakeBotAPI struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	calls   []botCall
+	offsets []int64
+	batches [][]update
+	errors  []int
+}
+
+func newFakeBotAPI(t *testing.T, batches ...[]update) *fakeBotAPI {
+	f := &fakeBotAPI{batches: batches}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := "/bot" + testToken + "/"
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(apiResponse{Description: "Not Found"})
+			return
+		}
+		method := strings.TrimPrefix(r.URL.Path, prefix)
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if method == "getUpdates" {
+			var req getUpdates
+			json.Unmarshal(body, &req)
+			f.mu.Lock()
+			f.offsets = append(f.offsets, req.Offset)
+			var batch []update
+			if len(f.batches) > 0 {
+				batch, f.batches = f.batches[0], f.batches[1:]
+			}
+			f.mu.Unlock()
+			if batch == nil {
+				// long poll without updates
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(20 * time.Millisecond):
+				}
+				batch = []update{}
+			}
+			result, _ := json.Marshal(batch)
+			json.NewEncoder(w).Encode(apiResponse{OK: true, Result: result})
+			return
+		}
+
+		params := map[string]interface{}{}
+		json.Unmarshal(body, &params)
+		f.mu.Lock()
+		f.calls = append(f.calls, botCall{method: method, params: params})
+		status := 0
+		if method == "sendMessage" && len(f.errors) > 0 {
+			status, f.errors = f.errors[0], f.errors[1:]
+		}
+		f.mu.Unlock()
+		if status != 0 {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(apiResponse{Description: http.StatusText(status)})
+			return
+		}
+		json.NewEncoder(w).Encode(apiResponse{OK: true, Result: json.RawMessage(`true`)})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeBotAPI) received() ([]botCall, []int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]botCall(nil), f.calls...), append([]int64(nil), f.offsets...)
+}
+
+func newTestBot(t *testing.T, f *fakeBotAPI, actions chan state.IncidentAction) *Bot {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(Config{APIURL: f.URL + "/", Token: testToken, Timeout: time.Second, Retries: 1, PollTimeout: time.Second,
+		SilenceDuration: time.Hour, IncidentActionChan: actions, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "plain text", want: "plain text"},
+		{in: "web-1.example.com", want: `web\-1\.example\.com`},
+		{in: "_*[]()~`>#+-=|{}.!\\", want: "\\_\\*\\[\\]\\(\\)\\~\\`\\>\\#\\+\\-\\=\\|\\{\\}\\.\\!\\\\"},
+		{in: "čerešňa 100%", want: "čerešňa 100%"},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q): expected %q, got %q", tt.in, tt.want, got)
+		}
+	}
+	// code blocks escape only backtick and backslash
+	if got := escapeCode("a_b `c` \\d"); got != "a_b \\`c\\` \\\\d" {
+		t.Errorf("unexpected code escape %q", got)
+	}
+}
+
+func TestAlertText(t *testing.T) {
+	p := &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web-1.example.com", ServiceType: "http",
+		Target: "https://web-1.example.com/health?x=1", FailMessage: "HTTP 503 `Service Unavailable`"}
+	start := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+	got := alertText(p, "7-1", start)
+	want := "\U0001F534 *CRITICAL* http check of *web\\-1\\.example\\.com* has failed\n" +
+		"\n" +
+		"Target: https://web\\-1\\.example\\.com/health?x\\=1\n" +
+		"```\nHTTP 503 \\`Service Unavailable\\`\n```\n" +
+		"_Incident 7\\-1, started 2024\\-03\\-05 14:30 UTC_"
+	if got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestParseCallbackData(t *testing.T) {
+	tests := []struct {
+		data        string
+		wantKind    string
+		wantService int
+		wantInc     string
+		wantErr     bool
+	}{
+		{data: callbackData(callbackAck, 7, "7-1700000000"), wantKind: callbackAck, wantService: 7, wantInc: "7-1700000000"},
+		{data: callbackData(callbackSilence, 12, "12-1"), wantKind: callbackSilence, wantService: 12, wantInc: "12-1"},
+		// incident id may contain the separator
+		{data: "a|7|7|1", wantKind: callbackAck, wantService: 7, wantInc: "7|1"},
+		{data: "x|7|7-1", wantErr: true},
+		{data: "a|seven|7-1", wantErr: true},
+		{data: "a|7", wantErr: true},
+		{data: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		kind, serviceID, incidentID, err := parseCallbackData(tt.data)
+		if tt.wantErr {
+			if errors.Cause(err) != invalidCallbackError {
+				t.Errorf("%q: expected invalid callback, got %v", tt.data, err)
+			}
+			continue
+		}
+		if err != nil || kind != tt.wantKind || serviceID != tt.wantService || incidentID != tt.wantInc {
+			t.Errorf("%q: unexpected %q %d %q %v", tt.data, kind, serviceID, incidentID, err)
+		}
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	f := newFakeBotAPI(t)
+	b := newTestBot(t, f, make(chan state.IncidentAction, 1))
+	failed := &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http"}
+	resolved := &payload.Payload{ServiceID: 7, Status: payload.StatusResolved, Host: "web1", ServiceType: "http"}
+
+	if err := b.Send("-1001234567890", Notification{IncidentID: "7-1", Payload: failed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Send("@alerts", Notification{IncidentID: "7-1", Payload: resolved}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls, _ := f.received()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(calls))
+	}
+	first := calls[0].params
+	if first["chat_id"] != "-1001234567890" || first["parse_mode"] != "MarkdownV2" {
+		t.Fatalf("unexpected message %v", first)
+	}
+	var keyboard inlineKeyboard
+	data, _ := json.Marshal(first["reply_markup"])
+	json.Unmarshal(data, &keyboard)
+	if len(keyboard.Buttons) != 1 || len(keyboard.Buttons[0]) != 2 {
+		t.Fatalf("expected acknowledge and silence buttons, got %v", first["reply_markup"])
+	}
+	if ack, silence := keyboard.Buttons[0][0], keyboard.Buttons[0][1]; ack.CallbackData != "a|7|7-1" || silence.CallbackData != "s|7|7-1" || silence.Text != "Silence 1h" {
+		t.Fatalf("unexpected buttons %+v", keyboard.Buttons[0])
+	}
+	if _, ok := calls[1].params["reply_markup"]; ok {
+		t.Fatal("resolved alert must not have buttons")
+	}
+}
+
+func TestSendMessageErrors(t *testing.T) {
+	f := newFakeBotAPI(t)
+	f.errors = []int{http.StatusTooManyRequests, http.StatusBadRequest}
+	b := newTestBot(t, f, nil)
+	p := &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http"}
+
+	// flood limit is retried, bad request is not
+	err := b.Send("42", Notification{IncidentID: "7-1", Payload: p})
+	if e, ok := errors.Cause(err).(*APIError); !ok || e.StatusCode != http.StatusBadRequest || e.Method != "sendMessage" {
+		t.Fatalf("expected bad request, got %v", err)
+	}
+	if calls, _ := f.received(); len(calls) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(calls))
+	}
+	if err := b.Send("alerts", Notification{Payload: p}); errors.Cause(err) != invalidChatError {
+		t.Fatalf("expected invalid chat, got %v", err)
+	}
+}
+
+func callbackUpdate(updateID int64, data string) update {
+	return update{UpdateID: updateID, CallbackQuery: &callbackQuery{
+		ID:      "q" + strconv.FormatInt(updateID, 10),
+		From:    user{ID: 1, FirstName: "Jane", Username: "jane"},
+		Message: &message{MessageID: 55, Chat: chat{ID: -100}},
+		Data:    data,
+	}}
+}
+
+func TestPollButtonPresses(t *testing.T) {
+	f := newFakeBotAPI(t,
+		[]update{callbackUpdate(10, "a|7|7-1"), callbackUpdate(11, "broken")},
+		[]update{callbackUpdate(12, "s|8|8-1")},
+	)
+	actions := make(chan state.IncidentAction, 2)
+	b := newTestBot(t, f, actions)
+	b.Start()
+	defer b.Stop()
+
+	var got []state.IncidentAction
+	for len(got) < 2 {
+		select {
+		case a := <-actions:
+			got = append(got, a)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected 2 actions, got %d", len(got))
+		}
+	}
+	if a := got[0]; a.ServiceID != 7 || a.IncidentID != "7-1" || !a.Acknowledge || a.By != "Telegram @jane" {
+		t.Fatalf("unexpected acknowledgement %+v", a)
+	}
+	if a := got[1]; a.ServiceID != 8 || a.Acknowledge || time.Until(a.SilenceUntil) < 59*time.Minute {
+		t.Fatalf("unexpected silence %+v", a)
+	}
+
+	// wait for the poll after the last batch, so the offset confirms all updates
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, offsets := f.received()
+		if len(offsets) >= 3 {
+			if offsets[0] != 0 || offsets[1] != 12 || offsets[2] != 13 {
+				t.Fatalf("expected offsets 0, 12, 13, got %v", offsets)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 polls, got offsets %v", offsets)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	calls, _ := f.received()
+	var answers []string
+	edits := 0
+	for _, c := range calls {
+		switch c.method {
+		case "answerCallbackQuery":
+			answers = append(answers, c.params["text"].(string))
+		case "editMessageReplyMarkup":
+			edits++
+		}
+	}
+	want := []string{"Acknowledged by @jane", "Unknown button", "Silenced for 1h by @jane"}
+	if strings.Join(answers, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected answers %q, got %q", want, answers)
+	}
+	if edits != 2 {
+		t.Fatalf("expected buttons removed from 2 messages, got %d", edits)
+	}
+}
+
+func TestCallbackDoesNotBlockOnFullChannel(t *testing.T) {
+	f := newFakeBotAPI(t)
+	// nobody reads the actions
+	b := newTestBot(t, f, make(chan state.IncidentAction))
+
+	done := make(chan struct{})
+	go func() {
+		b.handleCallback(callbackUpdate(10, "a|7|7-1").CallbackQuery)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("button press blocked on incident action channel")
+	}
+
+	calls, _ := f.received()
+	if len(calls) != 1 || calls[0].method != "answerCallbackQuery" || calls[0].params["text"] != "Busy, please try again" {
+		t.Fatalf("expected only busy answer with buttons kept, got %+v", calls)
+	}
+}
+
+func TestStopWithoutButtons(t *testing.T) {
+	f := newFakeBotAPI(t)
+	b := newTestBot(t, f, nil)
+	b.Start()
+	b.Stop()
+	if _, offsets := f.received(); len(offsets) != 0 {
+		t.Fatalf("expected no polling without buttons, got %d polls", len(offsets))
+	}
+}
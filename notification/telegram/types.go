@@ -0,0 +1,85 @@
+package telegram
+
+import "encoding/json"
+
+// Bot API request and response objects, only used fields are declared
+
+type apiResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+type sendMessage struct {
+	ChatID           string          `json:"chat_id"`
+	Text             string          `json:"text"`
+	ParseMode        string          `json:"parse_mode,omitempty"`
+	ReplyToMessageID int64           `json:"reply_to_message_id,omitempty"`
+	ReplyMarkup      *inlineKeyboard `json:"reply_markup,omitempty"`
+}
+
+type inlineKeyboard struct {
+	Buttons [][]button `json:"inline_keyboard"`
+}
+
+type button struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type editMessageReplyMarkup struct {
+	ChatID      int64           `json:"chat_id"`
+	MessageID   int64           `json:"message_id"`
+	ReplyMarkup *inlineKeyboard `json:"reply_markup"`
+}
+
+type answerCallbackQuery struct {
+	CallbackQueryID string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+}
+
+type getUpdates struct {
+	Offset int64 `json:"offset,omitempty"`
+	// long polling timeout in seconds
+	Timeout        int      `json:"timeout"`
+	AllowedUpdates []string `json:"allowed_updates"`
+}
+
+type update struct {
+	UpdateID      int64          `json:"update_id"`
+	CallbackQuery *callbackQuery `json:"callback_query"`
+}
+
+type callbackQuery struct {
+	ID      string   `json:"id"`
+	From    user     `json:"from"`
+	Message *message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+type user struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+}
+
+// name shown in logs and confirmations
+func (u user) name() string {
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	if u.LastName != "" {
+		return u.FirstName + " " + u.LastName
+	}
+	return u.FirstName
+}
+
+type message struct {
+	MessageID int64 `json:"message_id"`
+	Chat      chat  `json:"chat"`
+}
+
+type chat struct {
+	ID int64 `json:"id"`
+}
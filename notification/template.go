@@ -1,27 +1,288 @@
 package notification
 
 import (
-	"github.com/exmonitor/exclient/database/spec/service"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/exmonitor/firefly/notification/payload"
+	"github.com/exmonitor/firefly/notification/sms"
 )
 
-func SMSTemplate(failed bool, service *service.Service) string {
-	var s string
-	if failed {
-		s = "TODO / NOT IMPLEMENTED"
-	} else {
-		s = "TODO / NOT IMPLEMENTED"
+const (
+	smsEllipsis = "..."
+	// shorter failure reason says nothing, it is dropped instead
+	smsMinReasonLength = 12
+	// host and address are never truncated below these lengths
+	smsMinAddressLength = 16
+	smsMinHostLength    = 10
+)
+
+// SMSTemplate returns text of the notification which fits into maxParts SMS,
+// address, failure reason and host are truncated in this order when the text is too long
+// example 'CRITICAL: myhost tcp 84.12.34.54:22 - connection refused'
+func SMSTemplate(p *payload.Payload, maxParts int) string {
+	if p == nil {
+		return ""
+	}
+	if maxParts < 1 {
+		maxParts = 1
+	}
+	fits := func(text string) bool {
+		return sms.Parts(text) <= maxParts
+	}
+
+	host := sms.Normalize(p.Host)
+	address := sms.Normalize(smsAddress(p))
+	reason := sms.Normalize(smsReason(p))
+	compose := func(host string, address string, reason string) string {
+		s := p.Status + ": " + host + " " + p.ServiceType
+		if address != "" {
+			s += " " + address
+		}
+		if reason != "" {
+			s += " - " + reason
+		}
+		return s
+	}
+
+	if text := compose(host, address, reason); fits(text) {
+		return text
+	}
+	var ok bool
+	// address mostly repeats the host, so it goes first
+	address, ok = shrink(address, smsMinAddressLength, func(a string) bool { return fits(compose(host, a, reason)) })
+	if ok {
+		return compose(host, address, reason)
+	}
+	if r, ok := shrink(reason, smsMinReasonLength, func(r string) bool { return fits(compose(host, address, r)) }); ok {
+		return compose(host, address, r)
+	}
+	// long host leaves no room for the reason, host is shortened to keep at least beginning of the reason
+	minReason := truncate([]rune(reason), smsMinReasonLength)
+	if h, ok := shrink(host, smsMinHostLength, func(h string) bool { return fits(compose(h, address, minReason)) }); ok {
+		r, _ := shrink(reason, smsMinReasonLength, func(r string) bool { return fits(compose(h, address, r)) })
+		return compose(h, address, r)
+	}
+	reason = ""
+	host, ok = shrink(host, smsMinHostLength, func(h string) bool { return fits(compose(h, address, reason)) })
+	if ok {
+		return compose(host, address, reason)
 	}
+	// only status and service type are left, they always fit
+	text := []rune(compose(host, address, reason))
+	for len(text) > 0 && !fits(string(text)) {
+		text = text[:len(text)-1]
+	}
+	return string(text)
+}
+
+// shrink value to the longest truncation for which fits returns true, but not below minLength characters
+// minimal truncation is returned with false when nothing fits
+func shrink(value string, minLength int, fits func(string) bool) (string, bool) {
+	runes := []rune(value)
+	if fits(value) {
+		return value, true
+	}
+	if len(runes) <= minLength {
+		return value, false
+	}
+	// binary search for the longest fitting length in [minLength, len-1]
+	lo, hi := minLength, len(runes)-1
+	if !fits(truncate(runes, lo)) {
+		return truncate(runes, lo), false
+	}
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if fits(truncate(runes, mid)) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return truncate(runes, lo), true
+}
 
-	return s
+// truncate to n characters including ellipsis
+func truncate(runes []rune, n int) string {
+	if len(runes) <= n {
+		return string(runes)
+	}
+	if n <= len(smsEllipsis) {
+		return string(runes[:n])
+	}
+	return strings.TrimRightFunc(string(runes[:n-len(smsEllipsis)]), unicode.IsSpace) + smsEllipsis
+}
+
+// address of the check, scheme is left out as it is clear from the service type
+func smsAddress(p *payload.Payload) string {
+	var address string
+	switch {
+	case p.HTTP != nil:
+		address = p.HTTP.URL
+		for _, scheme := range []string{"https://", "http://"} {
+			address = strings.TrimPrefix(address, scheme)
+		}
+		address = strings.TrimSuffix(address, "/")
+	case p.TCP != nil:
+		address = p.TCP.Address
+	default:
+		address = p.Target
+	}
+	if address == p.Host {
+		return ""
+	}
+	return address
 }
 
-func CallTemplate(failed bool, service *service.Service) string {
-	var s string
-	if failed {
-		s = "TODO / NOT IMPLEMENTED"
+// why the check failed, response time for resolved checks
+func smsReason(p *payload.Payload) string {
+	if !p.Failed {
+		if rt := payload.FormatDuration(p.ResponseTime); rt != "" {
+			return "response " + rt
+		}
+		return ""
+	}
+
+	switch {
+	case p.HTTP != nil && p.HTTP.ActualStatus > 0:
+		s := fmt.Sprintf("status %d", p.HTTP.ActualStatus)
+		if p.HTTP.ExpectedStatus != "" {
+			s += fmt.Sprintf(" (expected %s)", p.HTTP.ExpectedStatus)
+		}
+		return s
+	case p.TCP != nil && p.TCP.ErrorClass != "" && p.TCP.ErrorClass != payload.ErrorClassOther:
+		return connectErrorText(p.TCP.ErrorClass)
+	case p.ICMP != nil && p.ICMP.PacketLoss >= 0:
+		return fmt.Sprintf("packet loss %s%%", strconv.FormatFloat(p.ICMP.PacketLoss, 'f', -1, 64))
+	}
+	return strings.Join(strings.Fields(p.FailMessage), " ")
+}
+
+func connectErrorText(errorClass string) string {
+	switch errorClass {
+	case payload.ErrorClassTimeout:
+		return "connection timed out"
+	case payload.ErrorClassRefused:
+		return "connection refused"
+	case payload.ErrorClassDNS:
+		return "DNS lookup failed"
+	case payload.ErrorClassReset:
+		return "connection reset"
+	case payload.ErrorClassUnreachable:
+		return "host unreachable"
+	case payload.ErrorClassTLS:
+		return "TLS handshake failed"
+	}
+	return "connection failed"
+}
+
+// call message is read by text to speech, so it contains only words and no urls,
+// addresses and numbers are spelled out so they are understandable over the phone
+func CallTemplate(p *payload.Payload) string {
+	if p == nil {
+		return ""
+	}
+	sentences := []string{"This is a monitoring alert."}
+	if p.Failed {
+		sentences = append(sentences, fmt.Sprintf("Your %s check of host %s has failed.", spokenServiceType(p.ServiceType), spokenHost(p.Host)))
 	} else {
-		s = "TODO / NOT IMPLEMENTED"
+		sentences = append(sentences, fmt.Sprintf("Your %s check of host %s has recovered.", spokenServiceType(p.ServiceType), spokenHost(p.Host)))
 	}
 
-	return s
+	if target := spokenTarget(p); target != "" {
+		sentences = append(sentences, "The checked address is "+target+".")
+	}
+	if p.Failed {
+		switch {
+		case p.HTTP != nil && p.HTTP.ActualStatus > 0:
+			sentences = append(sentences, fmt.Sprintf("The server returned status %s.", spellDigits(strconv.Itoa(p.HTTP.ActualStatus))))
+		case p.TCP != nil && p.TCP.ErrorClass != "":
+			sentences = append(sentences, fmt.Sprintf("The reason is %s.", connectErrorText(p.TCP.ErrorClass)))
+		case p.ICMP != nil && p.ICMP.PacketLoss >= 0:
+			sentences = append(sentences, fmt.Sprintf("Packet loss was %.0f percent.", p.ICMP.PacketLoss))
+		}
+	}
+	return strings.Join(sentences, " ")
+}
+
+func spokenServiceType(serviceType string) string {
+	if strings.EqualFold(serviceType, "icmp") {
+		return "ping"
+	}
+	return strings.ToUpper(serviceType)
+}
+
+// target of the check when it differs from the host, port is always spoken separately
+func spokenTarget(p *payload.Payload) string {
+	var host, port string
+	switch {
+	case p.HTTP != nil:
+		u, err := url.Parse(p.HTTP.URL)
+		if err != nil || u.Host == "" {
+			return ""
+		}
+		host, port = u.Hostname(), u.Port()
+	case p.TCP != nil:
+		var err error
+		host, port, err = net.SplitHostPort(p.TCP.Address)
+		if err != nil {
+			host = p.TCP.Address
+		}
+	default:
+		host = p.Target
+	}
+
+	var parts []string
+	if host != "" && host != p.Host {
+		parts = append(parts, spokenHost(host))
+	}
+	if port != "" {
+		parts = append(parts, "port "+spellDigits(port))
+	}
+	return strings.Join(parts, ", ")
+}
+
+var spokenDigits = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine"}
+
+// spell every digit as a word, e.g. 443 is 'four four three'
+func spellDigits(s string) string {
+	var words []string
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			words = append(words, spokenDigits[r-'0'])
+		} else {
+			words = append(words, string(r))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// IP addresses are spelled digit by digit, dots and dashes of host names are spoken as words
+func spokenHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			var octets []string
+			for _, o := range strings.Split(host, ".") {
+				octets = append(octets, spellDigits(o))
+			}
+			return strings.Join(octets, ", dot, ")
+		}
+		var halves []string
+		for _, half := range strings.Split(host, "::") {
+			var groups []string
+			for _, g := range strings.Split(half, ":") {
+				if g != "" {
+					groups = append(groups, spellDigits(strings.ToUpper(g)))
+				}
+			}
+			halves = append(halves, strings.Join(groups, ", colon, "))
+		}
+		return strings.TrimSpace(strings.Join(halves, ", double colon, "))
+	}
+	r := strings.NewReplacer(".", " dot ", "-", " dash ", "_", " underscore ")
+	return r.Replace(host)
 }
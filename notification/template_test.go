@@ -0,0 +1,182 @@
+package notification
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/exmonitor/firefly/notification/payload"
+	"github.com/exmonitor/firefly/notification/sms"
+)
+
+func TestSMSTemplate(t *testing.T) {
+	longPath := "/" + strings.Repeat("very-long-path-segment/", 8)
+	longHost := strings.Repeat("subdomain.", 20) + "example.com"
+	longReason := "unexpected response body " + strings.Repeat("error ", 30)
+
+	tests := []struct {
+		name     string
+		payload  *payload.Payload
+		maxParts int
+		want     string
+	}{
+		{
+			name: "fits",
+			payload: &payload.Payload{Failed: true, Status: payload.StatusFailed, Host: "myhost", ServiceType: "tcp",
+				TCP: &payload.TCP{Address: "84.12.34.54:22", ErrorClass: payload.ErrorClassRefused}},
+			maxParts: 1,
+			want:     "CRITICAL: myhost tcp 84.12.34.54:22 - connection refused",
+		},
+		{
+			name: "address same as host is left out",
+			payload: &payload.Payload{Failed: true, Status: payload.StatusFailed, Host: "example.com", ServiceType: "http",
+				HTTP: &payload.HTTP{URL: "https://example.com/", ExpectedStatus: "200", ActualStatus: 503}},
+			maxParts: 1,
+			want:     "CRITICAL: example.com http - status 503 (expected 200)",
+		},
+		{
+			name: "resolved with response time",
+			payload: &payload.Payload{Status: payload.StatusResolved, Host: "myhost", ServiceType: "icmp",
+				Target: "10.0.0.1", ICMP: &payload.ICMP{PacketLoss: 0}, ResponseTime: 12 * 1e6},
+			maxParts: 1,
+			want:     "Resolved: myhost icmp 10.0.0.1 - response 12ms",
+		},
+		{
+			name: "typographic characters keep GSM-7",
+			payload: &payload.Payload{Failed: true, Status: payload.StatusFailed, Host: "myhost", ServiceType: "tcp",
+				FailMessage: "backend “api” – connection\tlost…"},
+			maxParts: 1,
+			want:     "CRITICAL: myhost tcp - backend \"api\" - connection lost...",
+		},
+		{
+			name: "address is truncated first",
+			payload: &payload.Payload{Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http",
+				HTTP: &payload.HTTP{URL: "https://shop.example.com" + longPath, ExpectedStatus: "200", ActualStatus: 500}},
+			maxParts: 1,
+			want:     "CRITICAL: web1 http shop.example.com/very-long-path-segment/very-long-path-segment/very-long-path-segment/very-long-path-segment/... - status 500 (expected 200)",
+		},
+		{
+			name: "reason is truncated after address",
+			payload: &payload.Payload{Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http",
+				HTTP: &payload.HTTP{URL: "https://shop.example.com" + longPath}, FailMessage: longReason},
+			maxParts: 1,
+			want:     "CRITICAL: web1 http shop.example.... - unexpected response body error error error error error error error error error error error error error error error err...",
+		},
+		{
+			// host keeps room for the beginning of the reason
+			name: "host is truncated last",
+			payload: &payload.Payload{Failed: true, Status: payload.StatusFailed, Host: longHost, ServiceType: "tcp",
+				TCP: &payload.TCP{Address: longHost + ":443", ErrorClass: payload.ErrorClassTimeout}},
+			maxParts: 1,
+			want:     "CRITICAL: subdomain.subdomain.subdomain.subdomain.subdomain.subdomain.subdomain.subdomain.subdomain.subdomain.subdomain.s... tcp subdomain.sub... - connectio...",
+		},
+		{
+			name: "more parts keep everything",
+			payload: &payload.Payload{Failed: true, Status: payload.StatusFailed, Host: "web1", ServiceType: "http",
+				HTTP: &payload.HTTP{URL: "https://shop.example.com" + longPath, ExpectedStatus: "200", ActualStatus: 500}},
+			maxParts: 2,
+			want:     "CRITICAL: web1 http shop.example.com" + strings.TrimSuffix(longPath, "/") + " - status 500 (expected 200)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SMSTemplate(tt.payload, tt.maxParts)
+			if got != tt.want {
+				t.Errorf("unexpected text\n got: %s\nwant: %s", got, tt.want)
+			}
+			if parts := sms.Parts(got); parts > tt.maxParts {
+				t.Errorf("text needs %d parts, limit is %d", parts, tt.maxParts)
+			}
+		})
+	}
+}
+
+func TestSMSTemplateUCS2(t *testing.T) {
+	p := &payload.Payload{Failed: true, Status: payload.StatusFailed, Host: "myhost", ServiceType: "tcp",
+		FailMessage: "spojení odmítnuto serverem " + strings.Repeat("znovu ", 20)}
+	got := SMSTemplate(p, 1)
+	if sms.IsGSM7(got) {
+		t.Fatalf("expected UCS-2 text, got %q", got)
+	}
+	if sms.Length(got) > 70 || sms.Parts(got) != 1 {
+		t.Fatalf("UCS-2 text must fit into 70 characters, got %d: %q", sms.Length(got), got)
+	}
+	if !strings.HasPrefix(got, "CRITICAL: myhost tcp - spojení odmítnuto") || !strings.HasSuffix(got, smsEllipsis) {
+		t.Fatalf("expected truncated reason, got %q", got)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	maxLength := func(n int) func(string) bool {
+		return func(s string) bool { return len([]rune(s)) <= n }
+	}
+	tests := []struct {
+		name      string
+		value     string
+		minLength int
+		fits      func(string) bool
+		want      string
+		ok        bool
+	}{
+		{name: "fits", value: "connection refused", minLength: 5, fits: maxLength(20), want: "connection refused", ok: true},
+		{name: "longest fitting", value: "connection refused", minLength: 5, fits: maxLength(12), want: "connectio...", ok: true},
+		{name: "trailing space is trimmed", value: "connection refused", minLength: 5, fits: maxLength(14), want: "connection...", ok: true},
+		{name: "minimal length does not fit", value: "connection refused", minLength: 10, fits: maxLength(8), want: "connect...", ok: false},
+		{name: "short value does not fit", value: "refused", minLength: 10, fits: maxLength(3), want: "refused", ok: false},
+		{name: "multibyte characters", value: "spojení odmítnuto", minLength: 5, fits: maxLength(10), want: "spojení...", ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := shrink(tt.value, tt.minLength, tt.fits)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("shrink(%q) = %q, %t, want %q, %t", tt.value, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCallTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload *payload.Payload
+		want    string
+	}{
+		{
+			name: "http failure",
+			payload: &payload.Payload{Failed: true, Host: "shop.example.com", ServiceType: "http",
+				HTTP: &payload.HTTP{URL: "https://shop.example.com:8443/health", ActualStatus: 503}},
+			want: "This is a monitoring alert. Your HTTP check of host shop dot example dot com has failed. " +
+				"The checked address is port eight four four three. The server returned status five zero three.",
+		},
+		{
+			name: "tcp failure on ip address",
+			payload: &payload.Payload{Failed: true, Host: "db-1", ServiceType: "tcp",
+				TCP: &payload.TCP{Address: "10.0.12.5:5432", ErrorClass: payload.ErrorClassRefused}},
+			want: "This is a monitoring alert. Your TCP check of host db dash 1 has failed. " +
+				"The checked address is one zero, dot, zero, dot, one two, dot, five, port five four three two. The reason is connection refused.",
+		},
+		{
+			name: "ping recovery",
+			payload: &payload.Payload{Host: "router", Target: "2001:db8::1", ServiceType: "icmp",
+				ICMP: &payload.ICMP{PacketLoss: 0}},
+			want: "This is a monitoring alert. Your ping check of host router has recovered. " +
+				"The checked address is two zero zero one, colon, D B eight, double colon, one.",
+		},
+		{
+			name: "packet loss",
+			payload: &payload.Payload{Failed: true, Host: "router", Target: "router", ServiceType: "icmp",
+				ICMP: &payload.ICMP{PacketLoss: 66.7}},
+			want: "This is a monitoring alert. Your ping check of host router has failed. Packet loss was 67 percent.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CallTemplate(tt.payload)
+			if got != tt.want {
+				t.Errorf("unexpected text\n got: %s\nwant: %s", got, tt.want)
+			}
+			if strings.Contains(got, "://") || strings.Contains(got, ":") {
+				t.Errorf("spoken text must not contain urls or colons: %s", got)
+			}
+		})
+	}
+}
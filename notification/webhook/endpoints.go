@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Endpoint holds settings of single webhook contact, empty values fall back to the global settings
+type Endpoint struct {
+	// key of HMAC signature
+	Secret string `json:"secret"`
+	// extra headers, they are added to the global headers
+	Headers map[string]string `json:"headers"`
+	// body template, the event is available as template data
+	Body string `json:"body"`
+
+	body *template.Template
+}
+
+// Endpoints selects settings of the webhook contact
+type Endpoints struct {
+	// key is notification id or contact URL
+	contacts map[string]*Endpoint
+}
+
+// file format of webhook endpoints
+type file struct {
+	Contacts map[string]Endpoint `json:"contacts"`
+}
+
+// LoadEndpoints reads settings of webhook contacts from json file
+// empty path means all contacts use the global settings
+func LoadEndpoints(path string) (*Endpoints, error) {
+	e := &Endpoints{
+		contacts: map[string]*Endpoint{},
+	}
+	if path == "" {
+		return e, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read webhook file %s", path)
+	}
+	var f file
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse webhook file %s", path)
+	}
+
+	for contact, endpoint := range f.Contacts {
+		endpoint := endpoint
+		if endpoint.Body != "" {
+			endpoint.body, err = parseBodyTemplate(contact, endpoint.Body)
+			if err != nil {
+				return nil, err
+			}
+		}
+		e.contacts[contact] = &endpoint
+	}
+	return e, nil
+}
+
+// Select returns settings of the contact, notification id is preferred over URL
+// nil is returned when the contact has no own settings
+func (e *Endpoints) Select(notificationID int, url string) *Endpoint {
+	if e == nil {
+		return nil
+	}
+	if endpoint, ok := e.contacts[strconv.Itoa(notificationID)]; ok {
+		return endpoint
+	}
+	return e.contacts[url]
+}
@@ -0,0 +1,7 @@
+package webhook
+
+import "errors"
+
+var invalidConfigError error = errors.New("invalid config")
+
+var invalidURLError error = errors.New("invalid webhook URL")
@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+// version of the event format, it is increased only on incompatible changes
+const EventVersion = 1
+
+const (
+	EventIncidentFailed   = "incident.failed"
+	EventIncidentResolved = "incident.resolved"
+)
+
+// Event is json body of the webhook request
+type Event struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	// unique for every delivery, retries of the same delivery keep it, so receivers can deduplicate
+	DeliveryID string `json:"deliveryId"`
+	// time of the delivery, all timestamps are UTC in RFC 3339 format
+	Timestamp time.Time `json:"timestamp"`
+
+	Incident Incident `json:"incident"`
+	Service  Service  `json:"service"`
+	State    State    `json:"state"`
+}
+
+type Incident struct {
+	ID    string    `json:"id"`
+	Start time.Time `json:"start"`
+	// id of the contact receiving the notification
+	NotificationID int `json:"notificationId"`
+	// number of resend of FAIL notification, 0 for the first one
+	Resend int `json:"resend"`
+}
+
+type Service struct {
+	ID     int    `json:"id"`
+	Host   string `json:"host"`
+	Target string `json:"target"`
+	Type   string `json:"type"`
+	// type specific details, e.g. URL or packet loss
+	Details map[string]string `json:"details,omitempty"`
+}
+
+type State struct {
+	Failed bool   `json:"failed"`
+	Status string `json:"status"`
+	// message of the failed check, empty for resolved incidents
+	FailMessage string `json:"failMessage,omitempty"`
+	// response time of the last check in milliseconds, 0 when unknown
+	ResponseTimeMs float64 `json:"responseTimeMs,omitempty"`
+	Summary        string  `json:"summary"`
+}
+
+// EventInfo holds incident details which are not part of the payload
+type EventInfo struct {
+	IncidentID     string
+	IncidentStart  time.Time
+	NotificationID int
+	Resend         int
+}
+
+// NewEvent builds webhook event from channel independent payload
+func NewEvent(p *payload.Payload, info EventInfo) *Event {
+	e := &Event{
+		Version:    EventVersion,
+		Type:       EventIncidentResolved,
+		DeliveryID: newID(),
+		Timestamp:  time.Now().UTC(),
+		Incident: Incident{
+			ID:             info.IncidentID,
+			Start:          info.IncidentStart.UTC(),
+			NotificationID: info.NotificationID,
+			Resend:         info.Resend,
+		},
+		Service: Service{
+			ID:     p.ServiceID,
+			Host:   p.Host,
+			Target: p.Target,
+			Type:   p.ServiceType,
+		},
+		State: State{
+			Failed:         p.Failed,
+			Status:         p.Status,
+			ResponseTimeMs: float64(p.ResponseTime) / float64(time.Millisecond),
+			Summary:        p.Summary(),
+		},
+	}
+	if p.Failed {
+		e.Type = EventIncidentFailed
+		e.State.FailMessage = p.FailMessage
+	}
+	if fields := p.Fields(); len(fields) > 0 {
+		e.Service.Details = map[string]string{}
+		for _, f := range fields {
+			e.Service.Details[f.Name] = f.Value
+		}
+	}
+	return e
+}
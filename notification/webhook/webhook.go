@@ -0,0 +1,292 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/internal/retry"
+)
+
+const (
+	HeaderEvent      = "X-Firefly-Event"
+	HeaderDelivery   = "X-Firefly-Delivery"
+	HeaderTimestamp  = "X-Firefly-Timestamp"
+	HeaderSignature  = "X-Firefly-Signature"
+	signatureVersion = "v1"
+
+	userAgent = "firefly-webhook/1"
+	// response is read only to allow connection reuse and to log beginning of the error
+	maxResponseSize = 4 * 1024
+)
+
+type Config struct {
+	// timeout of single request
+	Timeout time.Duration
+	// how many times is temporary failure retried
+	Retries int
+	// key of HMAC signature, requests are not signed when empty
+	Secret string
+	// body template, the event is available as template data, json encoded event is sent when empty
+	Body string
+	// headers added to every request
+	Headers map[string]string
+	// client certificate for mTLS, optional
+	ClientCertFile string
+	ClientKeyFile  string
+	// CA used to verify webhook servers instead of system pool, optional
+	CAFile string
+	// per contact settings, optional
+	Endpoints *Endpoints
+
+	Logger *exlogger.Logger
+}
+
+// Sender posts notification events to webhook contacts
+type Sender struct {
+	conf   Config
+	body   *template.Template
+	client *http.Client
+}
+
+func New(conf Config) (*Sender, error) {
+	if conf.Timeout <= 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Timeout must be positive duration")
+	}
+	if conf.Retries < 0 {
+		return nil, errors.Wrap(invalidConfigError, "conf.Retries must not be negative")
+	}
+	if (conf.ClientCertFile == "") != (conf.ClientKeyFile == "") {
+		return nil, errors.Wrap(invalidConfigError, "conf.ClientCertFile and conf.ClientKeyFile must be set together")
+	}
+	if conf.Logger == nil {
+		return nil, errors.Wrap(invalidConfigError, "conf.Logger must not be nil")
+	}
+
+	s := &Sender{
+		conf: conf,
+	}
+	if conf.Body != "" {
+		var err error
+		s.body, err = parseBodyTemplate("default", conf.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig, err := buildTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	s.client = &http.Client{
+		Timeout:   conf.Timeout,
+		Transport: transport,
+		// redirect would resend the signed event to another place
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return s, nil
+}
+
+// Send posts the event to the contact URL, temporary failures are retried with backoff
+func (s *Sender) Send(notificationID int, target string, event *Event) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.Wrapf(invalidURLError, "%q must be absolute http or https URL", target)
+	}
+	endpoint := s.conf.Endpoints.Select(notificationID, target)
+	body, err := s.render(endpoint, event)
+	if err != nil {
+		return err
+	}
+
+	send := func() error {
+		err := s.send(target, endpoint, event, body)
+		if err != nil && !isTemporaryError(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	notify := func(err error, next time.Duration) {
+		s.conf.Logger.LogError(err, "failed to deliver webhook %s to %s, retrying in %s", event.DeliveryID, u.Host, next.Round(time.Millisecond))
+	}
+	err = backoff.RetryNotify(send, retry.New(s.conf.Retries), notify)
+	if permanent, ok := err.(*backoff.PermanentError); ok {
+		err = permanent.Err
+	}
+	if err != nil {
+		return err
+	}
+	s.conf.Logger.LogDebug("webhook %s %s delivered to %s", event.Type, event.DeliveryID, u.Host)
+	return nil
+}
+
+func (s *Sender) render(endpoint *Endpoint, event *Event) ([]byte, error) {
+	tmpl := s.body
+	if endpoint != nil && endpoint.body != nil {
+		tmpl = endpoint.body
+	}
+	if tmpl == nil {
+		body, err := json.Marshal(event)
+		return body, errors.Wrap(err, "failed to encode webhook event")
+	}
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, event)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute webhook body template")
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Sender) send(target string, endpoint *Endpoint, event *Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(invalidURLError, "failed to create webhook request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	for name, value := range s.conf.Headers {
+		req.Header.Set(name, value)
+	}
+	secret := s.conf.Secret
+	if endpoint != nil {
+		for name, value := range endpoint.Headers {
+			req.Header.Set(name, value)
+		}
+		if endpoint.Secret != "" {
+			secret = endpoint.Secret
+		}
+	}
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderDelivery, event.DeliveryID)
+	// timestamp is part of the signature, so receivers can refuse replayed requests
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if secret != "" {
+		req.Header.Set(HeaderSignature, signatureVersion+"="+Sign(secret, timestamp, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "webhook request failed")
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Body:       string(bytes.TrimSpace(respBody)),
+	}
+}
+
+// Sign returns hex encoded HMAC-SHA256 of 'timestamp.body', receivers compute the same value
+// and compare it with the X-Firefly-Signature header without the 'v1=' prefix
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// body templates can use json function to encode any value, e.g. '{"text": {{ json .State.Summary }}}'
+func parseBodyTemplate(name string, text string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(invalidConfigError, "failed to parse %s webhook body template: %s", name, err)
+	}
+	// try it on empty event, so typos in field names are found at startup
+	err = tmpl.Execute(ioutil.Discard, &Event{})
+	if err != nil {
+		return nil, errors.Wrapf(invalidConfigError, "failed to execute %s webhook body template: %s", name, err)
+	}
+	return tmpl, nil
+}
+
+func buildTLSConfig(conf Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if conf.CAFile != "" {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read CA file %s", conf.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Wrapf(invalidConfigError, "CA file %s does not contain any PEM certificate", conf.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if conf.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.ClientCertFile, conf.ClientKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load webhook client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		// still unique enough for deliveries in flight
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// network failures, throttling and server side errors can succeed later,
+// other responses mean the receiver refused the event
+func isTemporaryError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *StatusError:
+		return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// StatusError is non 2xx response of the webhook receiver
+type StatusError struct {
+	StatusCode int
+	// beginning of the response body
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("webhook returned HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("webhook returned HTTP %d: %s", e.StatusCode, e.Body)
+}
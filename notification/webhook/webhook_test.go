@@ -0,0 +1,408 @@
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+
+	"github.com/exmonitor/firefly/notification/payload"
+)
+
+func testLogger(t *testing.T) *exlogger.Logger {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger
+}
+
+// recordedRequest is copy of request received by fake receiver
+type recordedRequest struct {
+	header http.Header
+	body   string
+}
+
+// fakeReceiver answers with the statuses in order, the last one is repeated
+type fakeReceiver struct {
+	mu       sync.Mutex
+	requests []recordedRequest
+	statuses []int
+}
+
+func (f *fakeReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	f.mu.Lock()
+	i := len(f.requests)
+	if i >= len(f.statuses) {
+		i = len(f.statuses) - 1
+	}
+	f.requests = append(f.requests, recordedRequest{header: r.Header, body: string(body)})
+	f.mu.Unlock()
+	w.WriteHeader(f.statuses[i])
+	w.Write([]byte("receiver says no"))
+}
+
+func (f *fakeReceiver) received() []recordedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]recordedRequest(nil), f.requests...)
+}
+
+func newFakeReceiver(t *testing.T, statuses ...int) (*fakeReceiver, *httptest.Server) {
+	f := &fakeReceiver{statuses: statuses}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func testEvent() *Event {
+	p := &payload.Payload{ServiceID: 7, Failed: true, Status: payload.StatusFailed, Host: "myhost", ServiceType: "tcp", FailMessage: "connection refused",
+		TCP: &payload.TCP{Address: "84.12.34.54:22", ErrorClass: payload.ErrorClassRefused}}
+	return NewEvent(p, EventInfo{IncidentID: "inc-1", IncidentStart: time.Now(), NotificationID: 3})
+}
+
+func TestSendSignsEvent(t *testing.T) {
+	f, srv := newFakeReceiver(t, http.StatusOK)
+	s, err := New(Config{Timeout: time.Second, Secret: "s3cret", Headers: map[string]string{"X-Team": "ops"}, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := testEvent()
+	if err := s.Send(3, srv.URL, event); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := f.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+	r := requests[0]
+	if r.header.Get(HeaderEvent) != EventIncidentFailed || r.header.Get(HeaderDelivery) != event.DeliveryID || r.header.Get("X-Team") != "ops" {
+		t.Fatalf("unexpected headers %v", r.header)
+	}
+	timestamp := r.header.Get(HeaderTimestamp)
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		t.Fatalf("expected unix timestamp, got %q", timestamp)
+	}
+	// receiver side verification
+	if want := "v1=" + Sign("s3cret", timestamp, []byte(r.body)); r.header.Get(HeaderSignature) != want {
+		t.Fatalf("expected signature %q, got %q", want, r.header.Get(HeaderSignature))
+	}
+	var decoded Event
+	if err := json.Unmarshal([]byte(r.body), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Version != EventVersion || decoded.Incident.ID != "inc-1" || decoded.Service.ID != 7 || decoded.State.FailMessage != "connection refused" {
+		t.Fatalf("unexpected event %+v", decoded)
+	}
+}
+
+func TestSendWithoutSecretIsNotSigned(t *testing.T) {
+	f, srv := newFakeReceiver(t, http.StatusNoContent)
+	s, err := New(Config{Timeout: time.Second, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(3, srv.URL, testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if sig := f.received()[0].header.Get(HeaderSignature); sig != "" {
+		t.Fatalf("expected no signature, got %q", sig)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// printf '1700000000.{}' | openssl dgst -sha256 -hmac secret
+	got := Sign("secret", "1700000000", []byte("{}"))
+	if want := "b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163"; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	if got == Sign("secret", "1700000001", []byte("{}")) {
+		t.Fatal("timestamp must be part of the signature")
+	}
+	if got == Sign("other", "1700000000", []byte("{}")) {
+		t.Fatal("secret must be part of the signature")
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retries      int
+		wantRequests int
+		wantStatus   int
+	}{
+		{name: "server error is retried", statuses: []int{http.StatusBadGateway, http.StatusOK}, retries: 2, wantRequests: 2},
+		{name: "throttling is retried", statuses: []int{http.StatusTooManyRequests, http.StatusOK}, retries: 1, wantRequests: 2},
+		{name: "retries run out", statuses: []int{http.StatusServiceUnavailable}, retries: 1, wantRequests: 2, wantStatus: http.StatusServiceUnavailable},
+		{name: "refused event is not retried", statuses: []int{http.StatusBadRequest}, retries: 3, wantRequests: 1, wantStatus: http.StatusBadRequest},
+		{name: "redirect is not followed", statuses: []int{http.StatusFound}, retries: 3, wantRequests: 1, wantStatus: http.StatusFound},
+		{name: "zero retries is single attempt", statuses: []int{http.StatusInternalServerError}, retries: 0, wantRequests: 1, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, srv := newFakeReceiver(t, tt.statuses...)
+			s, err := New(Config{Timeout: time.Second, Retries: tt.retries, Logger: testLogger(t)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			event := testEvent()
+			err = s.Send(3, srv.URL, event)
+
+			requests := f.received()
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("expected %d requests, got %d", tt.wantRequests, len(requests))
+			}
+			// retries are the same delivery
+			for _, r := range requests {
+				if r.header.Get(HeaderDelivery) != event.DeliveryID {
+					t.Fatalf("expected delivery id %s in every attempt, got %s", event.DeliveryID, r.header.Get(HeaderDelivery))
+				}
+			}
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("expected delivery, got %v", err)
+				}
+				return
+			}
+			e, ok := errors.Cause(err).(*StatusError)
+			if !ok || e.StatusCode != tt.wantStatus {
+				t.Fatalf("expected HTTP %d, got %v", tt.wantStatus, err)
+			}
+			if e.Body != "receiver says no" {
+				t.Fatalf("expected response body in error, got %q", e.Body)
+			}
+		})
+	}
+}
+
+func TestSendInvalidURL(t *testing.T) {
+	s, err := New(Config{Timeout: time.Second, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"", "example.com/hook", "ftp://example.com/hook"} {
+		if err := s.Send(3, target, testEvent()); errors.Cause(err) != invalidURLError {
+			t.Errorf("%q: expected invalid URL error, got %v", target, err)
+		}
+	}
+}
+
+func TestSendBodyTemplates(t *testing.T) {
+	f, srv := newFakeReceiver(t, http.StatusOK)
+	endpointsFile := filepath.Join(t.TempDir(), "webhooks.json")
+	err := ioutil.WriteFile(endpointsFile, []byte(`{"contacts": {
+		"5": {"body": "{\"text\": {{ json .State.Summary }}}", "secret": "own", "headers": {"X-Contact": "5"}}
+	}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints, err := LoadEndpoints(endpointsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{Timeout: time.Second, Secret: "global", Body: `{"id": {{ json .Incident.ID }}, "type": "{{ .Type }}"}`, Endpoints: endpoints, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := testEvent()
+	if err := s.Send(3, srv.URL, event); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(5, srv.URL, event); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := f.received()
+	if want := `{"id": "inc-1", "type": "incident.failed"}`; requests[0].body != want {
+		t.Fatalf("expected global template %s, got %s", want, requests[0].body)
+	}
+	text, _ := json.Marshal(event.State.Summary)
+	if want := `{"text": ` + string(text) + `}`; requests[1].body != want {
+		t.Fatalf("expected contact template %s, got %s", want, requests[1].body)
+	}
+	r := requests[1]
+	if r.header.Get("X-Contact") != "5" {
+		t.Fatalf("expected contact header, got %v", r.header)
+	}
+	if want := "v1=" + Sign("own", r.header.Get(HeaderTimestamp), []byte(r.body)); r.header.Get(HeaderSignature) != want {
+		t.Fatal("expected request signed with the contact secret")
+	}
+}
+
+func TestInvalidBodyTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "syntax", body: `{"id": {{ .Incident.ID }`},
+		{name: "unknown field", body: `{"id": {{ .Incident.Name }}}`},
+		{name: "unknown function", body: `{"id": {{ yaml .Incident.ID }}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(Config{Timeout: time.Second, Body: tt.body, Logger: testLogger(t)})
+			if errors.Cause(err) != invalidConfigError {
+				t.Fatalf("expected invalid config, got %v", err)
+			}
+		})
+	}
+}
+
+// testPKI holds CA signed certificates of webhook server and firefly as PEM files
+type testPKI struct {
+	caFile     string
+	serverCert tls.Certificate
+	clientCert string
+	clientKey  string
+	pool       *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "127.0.0.1"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	p := &testPKI{pool: x509.NewCertPool()}
+	p.pool.AddCert(ca)
+	p.caFile = write("ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}))
+	serverCert, serverKey := issue(2, x509.ExtKeyUsageServerAuth)
+	p.serverCert, err = tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+	p.clientCert = write("client.pem", clientCert)
+	p.clientKey = write("client-key.pem", clientKey)
+	return p
+}
+
+func TestSendMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	f := &fakeReceiver{statuses: []int{http.StatusOK}}
+	srv := httptest.NewUnstartedServer(f)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.serverCert},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	s, err := New(Config{Timeout: time.Second, CAFile: pki.caFile, ClientCertFile: pki.clientCert, ClientKeyFile: pki.clientKey, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(3, srv.URL, testEvent()); err != nil {
+		t.Fatalf("expected delivery with client certificate, got %v", err)
+	}
+
+	// server refuses handshake without client certificate
+	s, err = New(Config{Timeout: time.Second, CAFile: pki.caFile, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(3, srv.URL, testEvent()); err == nil {
+		t.Fatal("expected failure without client certificate")
+	}
+	// server certificate is not trusted without the CA
+	s, err = New(Config{Timeout: time.Second, ClientCertFile: pki.clientCert, ClientKeyFile: pki.clientKey, Logger: testLogger(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(3, srv.URL, testEvent()); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected certificate verification failure, got %v", err)
+	}
+	if got := len(f.received()); got != 1 {
+		t.Fatalf("expected only the authenticated request to reach the handler, got %d", got)
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	pki := newTestPKI(t)
+	notPEM := filepath.Join(t.TempDir(), "ca.txt")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(Config{Timeout: time.Second, ClientCertFile: pki.clientCert, Logger: testLogger(t)}); errors.Cause(err) != invalidConfigError {
+		t.Fatalf("expected invalid config for certificate without key, got %v", err)
+	}
+	if _, err := New(Config{Timeout: time.Second, CAFile: notPEM, Logger: testLogger(t)}); errors.Cause(err) != invalidConfigError {
+		t.Fatalf("expected invalid config for CA file without certificate, got %v", err)
+	}
+	if _, err := New(Config{Timeout: time.Second, ClientCertFile: pki.clientCert, ClientKeyFile: pki.caFile, Logger: testLogger(t)}); err == nil {
+		t.Fatal("expected error for mismatched client key")
+	}
+}
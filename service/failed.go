@@ -1,23 +1,55 @@
 package service
 
 import (
+	"fmt"
 	"sync"
 	"time"
 )
 
 type FailedService struct {
-	Id            int
+	Id int
+	// identifies single outage of the service, from first failure until recovery
+	IncidentID    string
+	IncidentStart time.Time
 	FailCounter   int
 	FailThreshold int
 	LastFailedMsg string
 
 	NotificationSentTimestamps map[int]time.Time
+	// how many times was FAIL notification resent, int is holder for notification ID
+	NotificationResendCounts map[int]int
 	sync.Mutex
 }
 
+// build new incident id, it is unique for the service and readable in logs
+func newIncidentID(serviceID int) string {
+	return fmt.Sprintf("%d-%d", serviceID, time.Now().UnixNano())
+}
+
+// incidentResponse holds responses of contacts to single incident
+// it is kept apart from FailedService, so copies saved back by the interval loop never overwrite it
+type incidentResponse struct {
+	IncidentID string
+	// set when contact acknowledged the incident, no more FAIL notifications are sent
+	AcknowledgedBy string
+	// FAIL notifications are suppressed until this time
+	SilencedUntil time.Time
+}
+
+// FAIL notifications are not sent for acknowledged or silenced incident
+func (r incidentResponse) Suppressed(incidentID string) bool {
+	if r.IncidentID != incidentID {
+		return false
+	}
+	return r.AcknowledgedBy != "" || time.Now().Before(r.SilencedUntil)
+}
+
 // atomic save into map
 func (f *FailedService) SaveNewTimeStamp(id int, t time.Time) {
 	f.Lock()
+	if _, ok := f.NotificationSentTimestamps[id]; ok {
+		f.NotificationResendCounts[id]++
+	}
 	f.NotificationSentTimestamps[id] = t
 	f.Unlock()
 }
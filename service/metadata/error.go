@@ -0,0 +1,7 @@
+package metadata
+
+import "errors"
+
+var invalidMetadataError error = errors.New("invalid service metadata")
+
+var unknownServiceTypeError error = errors.New("unknown service type")
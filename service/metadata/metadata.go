@@ -0,0 +1,201 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/exmonitor/exclient/database/spec/service"
+	"github.com/pkg/errors"
+)
+
+const (
+	TypeHTTP = "http"
+	TypeTCP  = "tcp"
+	TypeICMP = "icmp"
+)
+
+// Metadata is decoded metadata of the service, exactly one of HTTP, TCP and ICMP is set
+type Metadata struct {
+	// http, tcp or icmp
+	Type string
+
+	HTTP *HTTP
+	TCP  *TCP
+	ICMP *ICMP
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HTTP struct {
+	Target string `json:"target"`
+	// 0 means default port of the scheme
+	Port int `json:"port"`
+	// seconds
+	Timeout      int         `json:"timeout"`
+	Method       string      `json:"method"`
+	Query        string      `json:"query"`
+	PostData     []NameValue `json:"postData"`
+	ExtraHeaders []NameValue `json:"extraHeaders"`
+	// credentials are never decoded, notifications must not leak them
+	AuthEnabled            bool   `json:"authEnabled"`
+	ContentCheckEnabled    bool   `json:"contentCheckEnabled"`
+	ContentCheckString     string `json:"contentCheckString"`
+	AllowedHTTPStatusCodes []int  `json:"allowedHttpStatusCodes"`
+
+	TLSSkipVerify              bool `json:"tlsSkipVerify"`
+	TLSCheckCertificates       bool `json:"tlsCheckCertificates"`
+	TLSCertExpirationThreshold int  `json:"tlsCertExpirationThreshold"`
+}
+
+type TCP struct {
+	Target string `json:"target"`
+	Port   int    `json:"port"`
+	// seconds
+	Timeout int `json:"timeout"`
+}
+
+type ICMP struct {
+	Target string `json:"target"`
+	// seconds
+	Timeout     int `json:"timeout"`
+	PacketCount int `json:"packetCount"`
+}
+
+// Decode decodes json metadata of the service into struct of the service type
+// empty metadata is decoded into empty struct
+func Decode(s *service.Service) (*Metadata, error) {
+	if s == nil {
+		return nil, errors.Wrap(invalidMetadataError, "service must not be nil")
+	}
+	m := &Metadata{Type: s.ServiceTypeString()}
+	raw := []byte(s.Metadata)
+	if strings.TrimSpace(s.Metadata) == "" {
+		raw = []byte("{}")
+	}
+
+	var err error
+	switch m.Type {
+	case TypeHTTP:
+		m.HTTP = &HTTP{}
+		err = json.Unmarshal(raw, m.HTTP)
+	case TypeTCP:
+		m.TCP = &TCP{}
+		err = json.Unmarshal(raw, m.TCP)
+	case TypeICMP:
+		m.ICMP = &ICMP{}
+		err = json.Unmarshal(raw, m.ICMP)
+	default:
+		return nil, errors.Wrapf(unknownServiceTypeError, "service %d has type %d", s.ID, s.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(invalidMetadataError, "failed to decode metadata of service %d: %s", s.ID, err)
+	}
+	return m, nil
+}
+
+// Port returns port of the check, 0 when the check has no port
+func (m *Metadata) Port() int {
+	switch {
+	case m == nil:
+		return 0
+	case m.HTTP != nil:
+		return m.HTTP.EffectivePort()
+	case m.TCP != nil:
+		return m.TCP.Port
+	}
+	return 0
+}
+
+// PortString returns port of the check, empty string when the check has no port
+func (m *Metadata) PortString() string {
+	if port := m.Port(); port > 0 {
+		return strconv.Itoa(port)
+	}
+	return ""
+}
+
+// Target returns target of the check as stored in metadata
+func (m *Metadata) Target() string {
+	switch {
+	case m == nil:
+		return ""
+	case m.HTTP != nil:
+		return m.HTTP.Target
+	case m.TCP != nil:
+		return m.TCP.Target
+	case m.ICMP != nil:
+		return m.ICMP.Target
+	}
+	return ""
+}
+
+// EffectivePort returns configured port, port from target URL or default port of the URL scheme
+func (h *HTTP) EffectivePort() int {
+	if h.Port > 0 {
+		return h.Port
+	}
+	u, err := h.parseTarget()
+	if err == nil && u.Port() != "" {
+		port, _ := strconv.Atoi(u.Port())
+		return port
+	}
+	if err == nil && u.Scheme == "http" {
+		return 80
+	}
+	return 443
+}
+
+// target without scheme is checked over https
+func (h *HTTP) parseTarget() (*url.URL, error) {
+	target := h.Target
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+	return url.Parse(target)
+}
+
+// URL returns checked URL including non-default port and query
+func (h *HTTP) URL() string {
+	u, err := h.parseTarget()
+	if err != nil {
+		return h.Target + h.Query
+	}
+	if h.Port > 0 && u.Port() == "" {
+		defaultPort := map[string]int{"http": 80, "https": 443}[u.Scheme]
+		if h.Port != defaultPort {
+			u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(h.Port))
+		}
+	}
+	if h.Query != "" {
+		u.RawQuery = strings.TrimPrefix(h.Query, "?")
+	}
+	return u.String()
+}
+
+// ExpectedStatus returns allowed HTTP status codes as readable list
+func (h *HTTP) ExpectedStatus() string {
+	var codes []string
+	for _, c := range h.AllowedHTTPStatusCodes {
+		codes = append(codes, strconv.Itoa(c))
+	}
+	return strings.Join(codes, ", ")
+}
+
+// MethodOrDefault returns HTTP method, GET when it is not set
+func (h *HTTP) MethodOrDefault() string {
+	if h.Method == "" {
+		return "GET"
+	}
+	return strings.ToUpper(h.Method)
+}
+
+func (h *HTTP) String() string {
+	return fmt.Sprintf("%s %s", h.MethodOrDefault(), h.URL())
+}
@@ -0,0 +1,224 @@
+package metadata
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/exmonitor/exclient/database/dummydb"
+	"github.com/exmonitor/exclient/database/spec/service"
+	"github.com/exmonitor/exlogger"
+	"github.com/pkg/errors"
+)
+
+// services of dummydb, so the decoder is tested against the same metadata as the checker
+func dummyServices(t *testing.T) map[int]*service.Service {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := dummydb.GetClient(dummydb.Config{Logger: logger})
+	services := map[int]*service.Service{}
+	for _, interval := range []int{30, 60} {
+		list, err := client.SQL_GetServices(interval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range list {
+			services[s.ID] = s
+		}
+	}
+	return services
+}
+
+func TestDecodeDummyDB(t *testing.T) {
+	services := dummyServices(t)
+
+	testCases := []struct {
+		name       string
+		serviceID  int
+		want       *Metadata
+		wantPort   string
+		wantTarget string
+	}{
+		{
+			name:       "tcp",
+			serviceID:  3,
+			want:       &Metadata{Type: TypeTCP, TCP: &TCP{Target: "seznam.cz", Port: 1234, Timeout: 5}},
+			wantPort:   "1234",
+			wantTarget: "seznam.cz",
+		},
+		{
+			name:       "icmp",
+			serviceID:  4,
+			want:       &Metadata{Type: TypeICMP, ICMP: &ICMP{Target: "seznam.cz", Timeout: 5}},
+			wantPort:   "",
+			wantTarget: "seznam.cz",
+		},
+		{
+			name:      "http",
+			serviceID: 5,
+			want: &Metadata{Type: TypeHTTP, HTTP: &HTTP{
+				Target:                     "https://master.cz",
+				Port:                       443,
+				Timeout:                    5,
+				Method:                     "GET",
+				Query:                      "?var1=value1&var2=value2",
+				PostData:                   []NameValue{{Name: "var1", Value: "value1"}},
+				ExtraHeaders:               []NameValue{{Name: "MyHeader", Value: "My Value"}},
+				ContentCheckString:         "my_string",
+				AllowedHTTPStatusCodes:     []int{200, 201, 403, 404},
+				TLSCheckCertificates:       true,
+				TLSCertExpirationThreshold: 10,
+			}},
+			wantPort:   "443",
+			wantTarget: "https://master.cz",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, ok := services[tc.serviceID]
+			if !ok {
+				t.Fatalf("dummydb has no service %d", tc.serviceID)
+			}
+			m, err := Decode(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m, tc.want) {
+				t.Fatalf("expected %+v, got %+v", tc.want, m)
+			}
+			if m.PortString() != tc.wantPort || m.Target() != tc.wantTarget {
+				t.Errorf("expected port %q and target %q, got %q and %q", tc.wantPort, tc.wantTarget, m.PortString(), m.Target())
+			}
+		})
+	}
+
+	m, err := Decode(services[5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.HTTP.String(); got != "GET https://master.cz?var1=value1&var2=value2" {
+		t.Errorf("unexpected http check description %q", got)
+	}
+	if got := m.HTTP.ExpectedStatus(); got != "200, 201, 403, 404" {
+		t.Errorf("unexpected expected status %q", got)
+	}
+}
+
+func TestDecodeFormatting(t *testing.T) {
+	testCases := []struct {
+		name        string
+		serviceType int
+		metadata    string
+		want        *Metadata
+	}{
+		{
+			name:        "port as the last field",
+			serviceType: 2,
+			metadata:    `{"target": "db.example.com", "timeout": 3, "port": 5432}`,
+			want:        &Metadata{Type: TypeTCP, TCP: &TCP{Target: "db.example.com", Port: 5432, Timeout: 3}},
+		},
+		{
+			name:        "whitespace and reordered keys",
+			serviceType: 1,
+			metadata:    "\n\t{ \"method\" : \"post\" ,\n  \"allowedHttpStatusCodes\" : [ 200 ] ,\r\n \"target\":\"example.com/health\",\"port\" :8443 }\n",
+			want:        &Metadata{Type: TypeHTTP, HTTP: &HTTP{Target: "example.com/health", Port: 8443, Method: "post", AllowedHTTPStatusCodes: []int{200}}},
+		},
+		{
+			name:        "unknown fields are ignored",
+			serviceType: 3,
+			metadata:    `{"id": 9, "target": "8.8.8.8", "packetCount": 3, "authPassword": "secret"}`,
+			want:        &Metadata{Type: TypeICMP, ICMP: &ICMP{Target: "8.8.8.8", PacketCount: 3}},
+		},
+		{
+			name:        "empty metadata",
+			serviceType: 2,
+			metadata:    " \n",
+			want:        &Metadata{Type: TypeTCP, TCP: &TCP{}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := Decode(&service.Service{ID: 9, Type: tc.serviceType, Metadata: tc.metadata})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m, tc.want) {
+				t.Fatalf("expected %+v, got %+v", tc.want, m)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		service *service.Service
+		wantErr error
+	}{
+		{name: "nil service", wantErr: invalidMetadataError},
+		{name: "unknown type", service: &service.Service{ID: 9, Type: 7, Metadata: `{}`}, wantErr: unknownServiceTypeError},
+		{name: "malformed json", service: &service.Service{ID: 9, Type: 2, Metadata: `{"target": "example.com",`}, wantErr: invalidMetadataError},
+		{name: "port as string", service: &service.Service{ID: 9, Type: 2, Metadata: `{"target": "example.com", "port": "22"}`}, wantErr: invalidMetadataError},
+		{name: "status codes as object", service: &service.Service{ID: 9, Type: 1, Metadata: `{"allowedHttpStatusCodes": {"200": true}}`}, wantErr: invalidMetadataError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Decode(tc.service); errors.Cause(err) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestHTTPURL(t *testing.T) {
+	testCases := []struct {
+		name     string
+		http     HTTP
+		wantURL  string
+		wantPort int
+	}{
+		{name: "https default port", http: HTTP{Target: "https://example.com", Port: 443}, wantURL: "https://example.com", wantPort: 443},
+		{name: "http default port", http: HTTP{Target: "http://example.com", Port: 80}, wantURL: "http://example.com", wantPort: 80},
+		{name: "no port with http scheme", http: HTTP{Target: "http://example.com/status"}, wantURL: "http://example.com/status", wantPort: 80},
+		{name: "target without scheme is https", http: HTTP{Target: "example.com"}, wantURL: "https://example.com", wantPort: 443},
+		{name: "non-default port", http: HTTP{Target: "https://example.com/api", Port: 8443}, wantURL: "https://example.com:8443/api", wantPort: 8443},
+		{name: "https port on http scheme", http: HTTP{Target: "http://example.com", Port: 443}, wantURL: "http://example.com:443", wantPort: 443},
+		{name: "port only in target", http: HTTP{Target: "http://example.com:8080"}, wantURL: "http://example.com:8080", wantPort: 8080},
+		{name: "query with question mark", http: HTTP{Target: "https://example.com/search", Query: "?q=firefly&lang=en"}, wantURL: "https://example.com/search?q=firefly&lang=en", wantPort: 443},
+		{name: "query without question mark", http: HTTP{Target: "https://example.com", Query: "q=1"}, wantURL: "https://example.com?q=1", wantPort: 443},
+		{name: "query replaces query in target", http: HTTP{Target: "https://example.com/?old=1", Query: "new=2"}, wantURL: "https://example.com/?new=2", wantPort: 443},
+		{name: "ipv6 non-default port", http: HTTP{Target: "http://[2001:db8::1]/", Port: 8080}, wantURL: "http://[2001:db8::1]:8080/", wantPort: 8080},
+		{name: "unparsable target", http: HTTP{Target: "http://exa mple.com:port", Query: "?a=1"}, wantURL: "http://exa mple.com:port?a=1", wantPort: 443},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.http.URL(); got != tc.wantURL {
+				t.Errorf("expected URL %q, got %q", tc.wantURL, got)
+			}
+			if got := tc.http.EffectivePort(); got != tc.wantPort {
+				t.Errorf("expected port %d, got %d", tc.wantPort, got)
+			}
+		})
+	}
+}
+
+func TestHTTPMethodOrDefault(t *testing.T) {
+	for method, want := range map[string]string{"": "GET", "head": "HEAD", "POST": "POST"} {
+		h := HTTP{Method: method}
+		if got := h.MethodOrDefault(); got != want {
+			t.Errorf("method %q expected %q, got %q", method, want, got)
+		}
+	}
+}
+
+func TestNilMetadata(t *testing.T) {
+	var m *Metadata
+	if m.Port() != 0 || m.PortString() != "" || m.Target() != "" {
+		t.Errorf("expected empty values for nil metadata")
+	}
+}
@@ -10,8 +10,20 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/exmonitor/firefly/notification"
+	"github.com/exmonitor/firefly/notification/branding"
+	"github.com/exmonitor/firefly/notification/discord"
+	"github.com/exmonitor/firefly/notification/email"
+	"github.com/exmonitor/firefly/notification/matrix"
+	"github.com/exmonitor/firefly/notification/opsgenie"
+	"github.com/exmonitor/firefly/notification/pagerduty"
+	"github.com/exmonitor/firefly/notification/phone"
+	"github.com/exmonitor/firefly/notification/phonenumber"
+	"github.com/exmonitor/firefly/notification/slack"
+	"github.com/exmonitor/firefly/notification/sms"
+	"github.com/exmonitor/firefly/notification/teams"
+	"github.com/exmonitor/firefly/notification/telegram"
+	"github.com/exmonitor/firefly/notification/webhook"
 	"github.com/exmonitor/firefly/service/state"
-	"gopkg.in/gomail.v2"
 	"sync"
 )
 
@@ -24,25 +36,61 @@ type Config struct {
 	DBClient      database.ClientInterface
 	FetchInterval time.Duration
 	SMTPEnabled   bool
-	SMTPEmailChan chan *gomail.Message
-	TimeProfiling bool
-	Logger        *exlogger.Logger
+	SMTPEmailChan chan *email.Envelope
+	EmailHistory  email.HistoryConfig
+	EmailSubjects *email.SubjectTemplates
+	Branding      *branding.Profiles
+	SMSSender     sms.Sender
+	SMSMaxParts   int
+	PhoneCaller   phone.Caller
+	// default countries of national SMS and phone numbers
+	PhoneCountries *phonenumber.DefaultCountries
+	WebhookSender  *webhook.Sender
+	SlackClient    *slack.Client
+	TeamsClient    *teams.Client
+	TelegramBot    *telegram.Bot
+	PagerDuty      *pagerduty.Client
+	Opsgenie       *opsgenie.Client
+	DiscordClient  *discord.Client
+	MatrixClient   *matrix.Client
+	// acknowledgements and silences sent by contacts, nil when no channel supports them
+	IncidentActionChan chan state.IncidentAction
+	TimeProfiling      bool
+	Logger             *exlogger.Logger
 }
 
 type Service struct {
-	dbClient      database.ClientInterface
-	fetchInterval time.Duration
-	smtpEnabled   bool
-	smtpEmailChan chan *gomail.Message
-	timeProfiling bool
+	dbClient       database.ClientInterface
+	fetchInterval  time.Duration
+	smtpEnabled    bool
+	smtpEmailChan  chan *email.Envelope
+	emailHistory   email.HistoryConfig
+	emailSubjects  *email.SubjectTemplates
+	branding       *branding.Profiles
+	smsSender      sms.Sender
+	smsMaxParts    int
+	phoneCaller    phone.Caller
+	phoneCountries *phonenumber.DefaultCountries
+	webhookSender  *webhook.Sender
+	slackClient    *slack.Client
+	teamsClient    *teams.Client
+	telegramBot    *telegram.Bot
+	pagerDuty      *pagerduty.Client
+	opsgenie       *opsgenie.Client
+	discordClient  *discord.Client
+	matrixClient   *matrix.Client
+	timeProfiling  bool
 
 	logger *exlogger.Logger
 	// internals
 	failedServiceDB  map[int]FailedService // int is holder for check ID
 	lastFetchTime    time.Time
 	notificationChan chan state.NotificationChange
-	jitterSec int
+	incidentActions  chan state.IncidentAction
+	jitterSec        int
 
+	// acknowledgements and silences of current incidents, int is holder for check ID
+	incidentResponses map[int]incidentResponse
 	sync.Mutex
 }
 
@@ -62,17 +110,35 @@ func New(conf Config) (*Service, error) {
 	}
 
 	newService := &Service{
-		dbClient:      conf.DBClient,
-		logger:        conf.Logger,
-		fetchInterval: conf.FetchInterval,
-		smtpEnabled:   conf.SMTPEnabled,
-		smtpEmailChan: conf.SMTPEmailChan,
-		timeProfiling: conf.TimeProfiling,
+		dbClient:       conf.DBClient,
+		logger:         conf.Logger,
+		fetchInterval:  conf.FetchInterval,
+		smtpEnabled:    conf.SMTPEnabled,
+		smtpEmailChan:  conf.SMTPEmailChan,
+		emailHistory:   conf.EmailHistory,
+		emailSubjects:  conf.EmailSubjects,
+		branding:       conf.Branding,
+		smsSender:      conf.SMSSender,
+		smsMaxParts:    conf.SMSMaxParts,
+		phoneCaller:    conf.PhoneCaller,
+		phoneCountries: conf.PhoneCountries,
+		webhookSender:  conf.WebhookSender,
+		slackClient:    conf.SlackClient,
+		teamsClient:    conf.TeamsClient,
+		telegramBot:    conf.TelegramBot,
+		pagerDuty:      conf.PagerDuty,
+		opsgenie:       conf.Opsgenie,
+		discordClient:  conf.DiscordClient,
+		matrixClient:   conf.MatrixClient,
+		timeProfiling:  conf.TimeProfiling,
 
 		failedServiceDB:  map[int]FailedService{},
 		lastFetchTime:    time.Now().Add(-conf.FetchInterval),
 		notificationChan: make(chan state.NotificationChange),
-		jitterSec: rand.Intn(10),
+		incidentActions:  conf.IncidentActionChan,
+		jitterSec:        rand.Intn(10),
+
+		incidentResponses: map[int]incidentResponse{},
 	}
 
 	return newService, nil
@@ -84,8 +150,11 @@ func (s *Service) Boot() {
 	// run tick goroutine
 	tickChan := make(chan bool)
 	s.logger.LogDebug("booting loop for interval %ds", int(s.fetchInterval.Seconds()))
-	go intervalTick(int(s.fetchInterval.Seconds()), s.jitterSec,  tickChan)
+	go intervalTick(int(s.fetchInterval.Seconds()), s.jitterSec, tickChan)
 	go s.notificationSentTimestampOperator()
+	if s.incidentActions != nil {
+		go s.incidentActionOperator()
+	}
 
 	// run infinite loop
 	for {
@@ -133,10 +202,13 @@ func (s *Service) mainLoop() error {
 		} else {
 			newFailedService := &FailedService{
 				Id:                         c.Id,
+				IncidentID:                 newIncidentID(c.Id),
+				IncidentStart:              time.Now(),
 				FailCounter:                1,
 				FailThreshold:              c.FailThreshold,
 				LastFailedMsg:              c.Message,
 				NotificationSentTimestamps: map[int]time.Time{},
+				NotificationResendCounts:   map[int]int{},
 			}
 			s.SaveNewFailedService(c.Id, newFailedService)
 			s.logger.LogDebug("adding new failedService with ID:%d to localDB", c.Id)
@@ -152,7 +224,10 @@ func (s *Service) mainLoop() error {
 			// if failed counter drops to zero, than remove it from the failedCheckDb and send OK notification
 			if failedService.FailCounter <= 0 {
 				// remove check from db
+				s.Lock()
 				delete(s.failedServiceDB, id)
+				delete(s.incidentResponses, id)
+				s.Unlock()
 				// send OK notification, only if we already sent any FAIL notification
 				if len(failedService.NotificationSentTimestamps) > 0 {
 					s.sendNotification(failedService, ok)
@@ -171,14 +246,37 @@ func (s *Service) mainLoop() error {
 
 // send FAIL notification
 func (s *Service) sendNotification(f FailedService, failed bool) {
+	// recovery is always sent, so contacts know the incident is over
+	if failed && s.suppressed(f.Id, f.IncidentID) {
+		s.logger.LogDebug("skipping FAIL notification for service ID %d, incident %s is acknowledged or silenced", f.Id, f.IncidentID)
+		return
+	}
 	// init notification settings
 	notificationConfig := notification.Config{
 		DBClient:                   s.dbClient,
 		ServiceID:                  f.Id,
+		IncidentID:                 f.IncidentID,
+		IncidentStart:              f.IncidentStart,
 		NotificationChangeChannel:  s.notificationChan,
 		NotificationSentTimestamps: f.NotificationSentTimestamps,
+		NotificationResendCounts:   f.NotificationResendCounts,
 		SMTPEnabled:                s.smtpEnabled,
 		SMTPEmailChan:              s.smtpEmailChan,
+		EmailHistory:               s.emailHistory,
+		EmailSubjects:              s.emailSubjects,
+		Branding:                   s.branding,
+		SMSSender:                  s.smsSender,
+		SMSMaxParts:                s.smsMaxParts,
+		PhoneCaller:                s.phoneCaller,
+		PhoneCountries:             s.phoneCountries,
+		WebhookSender:              s.webhookSender,
+		SlackClient:                s.slackClient,
+		TeamsClient:                s.teamsClient,
+		TelegramBot:                s.telegramBot,
+		PagerDuty:                  s.pagerDuty,
+		Opsgenie:                   s.opsgenie,
+		DiscordClient:              s.discordClient,
+		MatrixClient:               s.matrixClient,
 		Failed:                     failed,
 		FailedMsg:                  f.LastFailedMsg,
 		Logger:                     s.logger,
@@ -228,6 +326,51 @@ func (s *Service) notificationSentTimestampOperator() {
 	}
 }
 
+// this function waits for responses of contacts to alerts and applies them to the incident
+func (s *Service) incidentActionOperator() {
+	for {
+		action := <-s.incidentActions
+		s.applyIncidentAction(action)
+	}
+}
+
+// read and update of the incident response is done under single lock, so concurrent responses are never lost
+func (s *Service) applyIncidentAction(action state.IncidentAction) {
+	s.Lock()
+	defer s.Unlock()
+	// only the incident id is read, FailedService holds a mutex and must not be copied
+	incidentID := s.failedServiceDB[action.ServiceID].IncidentID
+	if incidentID == "" {
+		// service is checked in another interval loop or already recovered
+		return
+	}
+	if incidentID != action.IncidentID {
+		s.logger.Log("ignoring response of %s for incident %s of service ID %d, the incident is already over", action.By, action.IncidentID, action.ServiceID)
+		return
+	}
+
+	response := s.incidentResponses[action.ServiceID]
+	if response.IncidentID != incidentID {
+		response = incidentResponse{IncidentID: incidentID}
+	}
+	if action.Acknowledge {
+		response.AcknowledgedBy = action.By
+		s.logger.Log("incident %s of service ID %d acknowledged by %s", action.IncidentID, action.ServiceID, action.By)
+	}
+	if action.SilenceUntil.After(response.SilencedUntil) {
+		response.SilencedUntil = action.SilenceUntil
+		s.logger.Log("incident %s of service ID %d silenced by %s until %s", action.IncidentID, action.ServiceID, action.By, action.SilenceUntil.Format(time.RFC3339))
+	}
+	s.incidentResponses[action.ServiceID] = response
+}
+
+// FAIL notifications are not sent for acknowledged or silenced incident
+func (s *Service) suppressed(serviceID int, incidentID string) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.incidentResponses[serviceID].Suppressed(incidentID)
+}
+
 // atomic save into map
 func (s *Service) SaveNewFailedService(id int, failedService *FailedService) {
 	s.Lock()
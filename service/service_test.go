@@ -0,0 +1,79 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exmonitor/exlogger"
+
+	"github.com/exmonitor/firefly/service/state"
+)
+
+func newTestService(t *testing.T) *Service {
+	logger, err := exlogger.New(exlogger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Service{
+		logger:            logger,
+		failedServiceDB:   map[int]FailedService{},
+		incidentResponses: map[int]incidentResponse{},
+	}
+}
+
+func TestApplyIncidentAction(t *testing.T) {
+	s := newTestService(t)
+	s.SaveNewFailedService(12, &FailedService{Id: 12, IncidentID: "12-1"})
+
+	// response to previous incident and unknown service are ignored
+	s.applyIncidentAction(state.IncidentAction{ServiceID: 12, IncidentID: "12-0", Acknowledge: true, By: "late"})
+	s.applyIncidentAction(state.IncidentAction{ServiceID: 13, IncidentID: "13-1", Acknowledge: true, By: "other"})
+	if s.suppressed(12, "12-1") || len(s.incidentResponses) != 0 {
+		t.Fatalf("unexpected incident responses %v", s.incidentResponses)
+	}
+
+	silence := time.Now().Add(time.Hour)
+	s.applyIncidentAction(state.IncidentAction{ServiceID: 12, IncidentID: "12-1", SilenceUntil: silence, By: "+420123456789"})
+	if !s.suppressed(12, "12-1") {
+		t.Fatal("silenced incident must be suppressed")
+	}
+	// shorter silence does not shorten the longer one
+	s.applyIncidentAction(state.IncidentAction{ServiceID: 12, IncidentID: "12-1", SilenceUntil: time.Now().Add(time.Minute), By: "telegram"})
+	s.applyIncidentAction(state.IncidentAction{ServiceID: 12, IncidentID: "12-1", Acknowledge: true, By: "Opsgenie ops"})
+	r := s.incidentResponses[12]
+	if !r.SilencedUntil.Equal(silence) || r.AcknowledgedBy != "Opsgenie ops" {
+		t.Fatalf("unexpected incident response %+v", r)
+	}
+
+	// the response belongs only to its incident
+	if s.suppressed(12, "12-2") {
+		t.Fatal("new incident must not be suppressed")
+	}
+}
+
+func TestApplyIncidentActionSurvivesFailedServiceUpdate(t *testing.T) {
+	s := newTestService(t)
+	s.SaveNewFailedService(12, &FailedService{Id: 12, IncidentID: "12-1", FailThreshold: 100})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.applyIncidentAction(state.IncidentAction{ServiceID: 12, IncidentID: "12-1", Acknowledge: true, By: "ops"})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		// interval loop saves copies of the failed service back
+		for i := 0; i < 100; i++ {
+			s.SaveNewFailedService(12, &FailedService{Id: 12, IncidentID: "12-1", FailCounter: i, FailThreshold: 100})
+		}
+	}()
+	wg.Wait()
+
+	if !s.suppressed(12, "12-1") {
+		t.Fatal("acknowledgement was lost")
+	}
+}
@@ -1,6 +1,32 @@
 package state
 
+import "time"
+
 type NotificationChange struct {
 	ServiceID      int
 	NotificationID int
 }
+
+// IncidentAction is response of the contact to the alert, e.g. keypress during phone call
+// it applies only to the incident it was sent for, so late responses never affect a new outage
+type IncidentAction struct {
+	ServiceID  int
+	IncidentID string
+	// stop all further FAIL notifications of the incident
+	Acknowledge bool
+	// no FAIL notifications are sent until this time
+	SilenceUntil time.Time
+	// contact who responded, e.g. phone number
+	By string
+}
+
+// SendIncidentAction hands the action over without blocking the caller, e.g. HTTP handler or poll loop,
+// false is returned when the channel is full
+func SendIncidentAction(c chan<- IncidentAction, action IncidentAction) bool {
+	select {
+	case c <- action:
+		return true
+	default:
+		return false
+	}
+}